@@ -0,0 +1,269 @@
+package messageapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterSMS("aliyun", new(aliyun))
+}
+
+const aliyunEndpoint = "https://dysmsapi.aliyuncs.com"
+
+// aliyun is the SMS provider by Aliyun (Alibaba Cloud) Dysms, the
+// configuration options of which are as follows:
+//
+//	access_key_id:     the access key id, which is required.
+//	access_key_secret: the access key secret, which is required.
+//	sign_name:         the approved signature, such as "阿里云", which is required.
+//	template_code:     the approved template, such as "SMS_123456789", which
+//	                   is required.
+//	template_param:    the name of the template parameter of the content,
+//	                   which is "content" by default.
+//	region_id:         the region, which is "cn-hangzhou" by default.
+//	endpoint:          the endpoint of Dysms, which is
+//	                   "https://dysmsapi.aliyuncs.com" by default.
+//	timeout:           the timeout in seconds of the request, which is 30 by default.
+//	http_proxy:        the proxy url of the requests, which is optional.
+//	socks5:            the SOCKS5 proxy, such as "host:1080", which is optional.
+//
+// Since Dysms only sends the approved templates, the content is the
+// parameters of the template if it is a JSON object, such as
+// {"code": "123456"}, or the value of the parameter template_param.
+// And the sender id given by WithSMSOptions is used as the signature.
+type aliyun struct {
+	sync.Mutex
+
+	accessKeyID     string
+	accessKeySecret string
+	signName        string
+	templateCode    string
+	templateParam   string
+	regionID        string
+	endpoint        string
+	client          *http.Client
+}
+
+func (a *aliyun) Load(m map[string]string) error {
+	accessKeyID, accessKeySecret := m["access_key_id"], m["access_key_secret"]
+	if accessKeyID == "" {
+		return fmt.Errorf("no the access_key_id configuration")
+	} else if accessKeySecret == "" {
+		return fmt.Errorf("no the access_key_secret configuration")
+	}
+
+	signName, templateCode := m["sign_name"], m["template_code"]
+	if signName == "" {
+		return fmt.Errorf("no the sign_name configuration")
+	} else if templateCode == "" {
+		return fmt.Errorf("no the template_code configuration")
+	}
+
+	templateParam := m["template_param"]
+	if templateParam == "" {
+		templateParam = "content"
+	}
+	regionID := m["region_id"]
+	if regionID == "" {
+		regionID = "cn-hangzhou"
+	}
+	endpoint := strings.TrimSuffix(m["endpoint"], "/")
+	if endpoint == "" {
+		endpoint = aliyunEndpoint
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	a.accessKeyID = accessKeyID
+	a.accessKeySecret = accessKeySecret
+	a.signName = signName
+	a.templateCode = templateCode
+	a.templateParam = templateParam
+	a.regionID = regionID
+	a.endpoint = endpoint
+	a.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (a *aliyun) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "access_key_id", Type: OptionString, Required: true,
+			Description: "the access key id"},
+		{Name: "access_key_secret", Type: OptionString, Required: true, Secret: true,
+			Description: "the access key secret"},
+		{Name: "sign_name", Type: OptionString, Required: true,
+			Description: "the approved signature"},
+		{Name: "template_code", Type: OptionString, Required: true,
+			Description: "the approved template, such as SMS_123456789"},
+		{Name: "template_param", Type: OptionString, Default: "content",
+			Description: "the name of the template parameter of the content"},
+		{Name: "region_id", Type: OptionString, Default: "cn-hangzhou",
+			Description: "the region"},
+		{Name: "endpoint", Type: OptionURL,
+			Description: "the endpoint of Dysms"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+// aliyunPhone returns the phone in the format of Dysms, that's, the mainland
+// number without the country code, or the international one with the
+// country code but without "+" or "00".
+func aliyunPhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	if strings.HasPrefix(phone, "+") {
+		phone = phone[1:]
+	} else if strings.HasPrefix(phone, "00") {
+		phone = phone[2:]
+	} else {
+		return phone
+	}
+
+	if strings.HasPrefix(phone, "86") && len(phone) == 13 {
+		phone = phone[2:]
+	}
+	return phone
+}
+
+// aliyunTemplateParam returns the parameters of the template by the content.
+func aliyunTemplateParam(name, content string) (string, error) {
+	if trimmed := strings.TrimSpace(content); strings.HasPrefix(trimmed, "{") {
+		var params map[string]interface{}
+		if json.Unmarshal([]byte(trimmed), &params) == nil {
+			return trimmed, nil
+		}
+	}
+
+	data, err := json.Marshal(map[string]string{name: content})
+	return string(data), err
+}
+
+// aliyunEscape escapes the string by the RPC signature of Aliyun,
+// which is the same as the url encoding but for " ", "*" and "~".
+func aliyunEscape(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.Replace(s, "+", "%20", -1)
+	s = strings.Replace(s, "*", "%2A", -1)
+	return strings.Replace(s, "%7E", "~", -1)
+}
+
+// aliyunSign returns the RPC signature of the query by HMAC-SHA1.
+func aliyunSign(method, secret string, query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = aliyunEscape(key) + "=" + aliyunEscape(query.Get(key))
+	}
+
+	mac := hmac.New(sha1.New, []byte(secret+"&"))
+	io.WriteString(mac, method+"&"+aliyunEscape("/")+"&"+aliyunEscape(strings.Join(pairs, "&")))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IsTemplateSMS implements the interface TemplateSMS.
+func (a *aliyun) IsTemplateSMS() bool { return true }
+
+func (a *aliyun) SendSMS(cxt context.Context, phone, content string) error {
+	a.Lock()
+	accessKeyID, accessKeySecret, signName, templateCode, templateParam, regionID,
+		endpoint, client := a.accessKeyID, a.accessKeySecret, a.signName, a.templateCode,
+		a.templateParam, a.regionID, a.endpoint, a.client
+	a.Unlock()
+
+	if opts := GetSMSOptions(cxt); opts.SenderID != "" {
+		signName = opts.SenderID
+	}
+
+	params, err := aliyunTemplateParam(templateParam, content)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	query := url.Values{
+		"AccessKeyId":      {accessKeyID},
+		"Action":           {"SendSms"},
+		"Format":           {"JSON"},
+		"PhoneNumbers":     {aliyunPhone(phone)},
+		"RegionId":         {regionID},
+		"SignName":         {signName},
+		"SignatureMethod":  {"HMAC-SHA1"},
+		"SignatureNonce":   {hex.EncodeToString(nonce)},
+		"SignatureVersion": {"1.0"},
+		"TemplateCode":     {templateCode},
+		"TemplateParam":    {params},
+		"Timestamp":        {time.Now().UTC().Format("2006-01-02T15:04:05Z")},
+		"Version":          {"2017-05-25"},
+	}
+	query.Set("Signature", aliyunSign("POST", accessKeySecret, query))
+
+	req, err := http.NewRequest("POST", endpoint+"/", strings.NewReader(query.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(cxt)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code      string `json:"Code"`
+		Message   string `json:"Message"`
+		BizID     string `json:"BizId"`
+		RequestID string `json:"RequestId"`
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	} else if err = json.Unmarshal(body, &result); err != nil && resp.StatusCode < 300 {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		return TranslateError("aliyun", httpError(resp.StatusCode, resp.Header, result.Code,
+			fmt.Sprintf("aliyun: %d %s", resp.StatusCode, result.Message)))
+	} else if result.Code != "OK" {
+		// Dysms responds with 200 and the error code for the business errors.
+		return TranslateError("aliyun", NewError(ClassPermanent, result.Code,
+			fmt.Sprintf("aliyun: %s %s", result.Code, result.Message)))
+	}
+
+	SetResult(cxt, ResultMessageID, result.BizID)
+	return nil
+}
@@ -0,0 +1,236 @@
+package app
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ScopeAck is the scope of the API key to acknowledge the messages.
+const ScopeAck = "ack"
+
+// ackLinkTTL is the duration during which the signed ack link is valid.
+const ackLinkTTL = 7 * 24 * time.Hour
+
+type ackState struct {
+	acked  chan struct{}
+	done   bool
+	expire time.Time
+}
+
+var (
+	ackLocker = new(sync.Mutex)
+	acks      = make(map[string]*ackState)
+
+	// The number of the messages waiting for the acknowledgement
+	// to be escalated.
+	pendingEscalations int64
+)
+
+// getAckState returns the ack state of the message, which is created if not
+// exist. The caller must hold the lock.
+func getAckState(id string, now time.Time) *ackState {
+	s, ok := acks[id]
+	if !ok {
+		for k, s := range acks {
+			if now.After(s.expire) {
+				delete(acks, k)
+			}
+		}
+
+		s = &ackState{acked: make(chan struct{}), expire: now.Add(ackLinkTTL)}
+		acks[id] = s
+	}
+	return s
+}
+
+// expectAck returns the channel which is closed when the message is acknowledged.
+func expectAck(id string) <-chan struct{} {
+	ackLocker.Lock()
+	defer ackLocker.Unlock()
+	return getAckState(id, time.Now()).acked
+}
+
+// acknowledge records that the message is acknowledged by who.
+// It returns false if the message does not exist.
+func acknowledge(id, by string) bool {
+	now := time.Now()
+	if !messageHistory.ack(id, by, now) {
+		return false
+	}
+
+	ackLocker.Lock()
+	if s := getAckState(id, now); !s.done {
+		s.done = true
+		close(s.acked)
+	}
+	ackLocker.Unlock()
+	return true
+}
+
+func ackSignature(secret, id, by, expires string) string {
+	return signToken(secret, []byte(id+"\n"+by+"\n"+expires))
+}
+
+// ackLink returns the signed link to acknowledge the message by who,
+// which can be embedded in the message, or "" if the public base url
+// or the token secret is not configured.
+func ackLink(c *Config, id, by string) string {
+	if c.MediaBaseURL == "" || c.tokenSecret == "" {
+		return ""
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(ackLinkTTL).Unix(), 10)
+	query := url.Values{
+		"by":      {by},
+		"expires": {expires},
+		"sig":     {ackSignature(c.tokenSecret, id, by, expires)},
+	}
+	return c.MediaBaseURL + "/v1/messages/" + id + "/ack?" + query.Encode()
+}
+
+// ackPage is the confirmation page of the signed ack link, which acknowledges
+// the message only by submitting the form, so that the link scanners of the
+// mail servers, which only GET the links, never acknowledge it.
+var ackPage = template.Must(template.New("ack").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Acknowledge</title></head>
+<body>
+<form method="post" action="{{.}}">
+<p>Acknowledge the message?</p>
+<button type="submit">Acknowledge</button>
+</form>
+</body>
+</html>
+`))
+
+// verifyAckLink reports whether the query of the ack link is valid.
+func verifyAckLink(c *Config, id string, query url.Values) bool {
+	if c.tokenSecret == "" {
+		return false
+	}
+
+	expires := query.Get("expires")
+	n, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().After(time.Unix(n, 0).Add(c.clockSkew())) {
+		return false
+	}
+
+	sig := ackSignature(c.tokenSecret, id, query.Get("by"), expires)
+	return hmac.Equal([]byte(sig), []byte(query.Get("sig")))
+}
+
+// handleMessages handles "/v1/messages/MESSAGE_ID/ack", which acknowledges
+// the message by "POST" with the scope "ack" and the body {"by": "WHO"}, or
+// by the signed link embedded in the message, "GET" of which only returns the
+// confirmation page to "POST" it, see ackPage. It also handles the signed
+// tracking links, see handleTrack.
+func handleMessages(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/messages/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	id := parts[0]
+	switch parts[1] {
+	case "ack":
+	case trackOpen, trackClick:
+		handleTrack(_config, w, r, id, parts[1])
+		return
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var by string
+	signed := r.URL.Query().Get("sig") != ""
+	switch {
+	case signed && (r.Method == "GET" || r.Method == "POST"):
+		if !verifyAckLink(_config, id, r.URL.Query()) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("the link is invalid or expired"))
+			return
+		} else if r.Method == "GET" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			if err := ackPage.Execute(w, r.URL.RequestURI()); err != nil {
+				glog.Errorf("failed to render the ack page: %s", err)
+			}
+			return
+		}
+		by = r.URL.Query().Get("by")
+
+	case r.Method == "POST":
+		if !authorize(_config, ScopeAck, w, r) {
+			return
+		}
+
+		var body struct {
+			By string `json:"by"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		by = body.By
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !acknowledge(id, by) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if signed {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("The message has been acknowledged."))
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// escalateIfUnacked runs the fallback steps of the request if the message
+// is not acknowledged within the ack timeout.
+func escalateIfUnacked(id string, args *Request) {
+	atomic.AddInt64(&pendingEscalations, 1)
+	defer atomic.AddInt64(&pendingEscalations, -1)
+
+	timeout := time.Duration(args.AckTimeout) * time.Second
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-expectAck(id):
+	case <-timer.C:
+		glog.Errorf("the message %s is not acknowledged in %s, escalate to %s",
+			id, timeout, args.Fallback)
+		if _, err := dispatchFallback(args); err != nil {
+			glog.Errorf("failed to escalate the message %s: %s", id, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xgfone/messageapi"
+)
+
+// isPhonePattern reports whether the pattern of the allowlist is the prefix
+// of the phone numbers, such as "+86", not the email domain or address.
+func isPhonePattern(pattern string) bool {
+	pattern = strings.TrimPrefix(pattern, "+")
+	if pattern == "" {
+		return false
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] < '0' || pattern[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRecipient reports whether the recipient matches the pattern,
+// which is one of
+//
+//	the email address, such as "alice@example.com";
+//	the email domain, such as "example.com" or "@example.com";
+//	the email domain and its subdomains, such as "*.example.com";
+//	the prefix of the phone numbers, such as "+8613" or "+1".
+func matchRecipient(pattern, channel, recipient string) bool {
+	phone := isPhonePattern(strings.NewReplacer(" ", "", "-", "").Replace(
+		strings.TrimSpace(pattern)))
+	pattern = normalizeRecipient(channel, pattern)
+	recipient = normalizeRecipient(channel, recipient)
+	if channel != "email" {
+		return phone && strings.HasPrefix(recipient, pattern)
+	} else if phone {
+		return false
+	}
+
+	index := strings.LastIndexByte(recipient, '@')
+	if index < 0 {
+		return false
+	}
+
+	domain := recipient[index+1:]
+	switch {
+	case strings.HasPrefix(pattern, "*."):
+		return domain == pattern[2:] || strings.HasSuffix(domain, pattern[1:])
+	case strings.HasPrefix(pattern, "@"):
+		return domain == pattern[1:]
+	case strings.Contains(pattern, "@"):
+		return recipient == pattern
+	default:
+		return domain == pattern
+	}
+}
+
+// allowRecipient reports whether the recipient matches any pattern of the
+// allowlist. The nil allowlist allows all, but the allowlist without any
+// pattern of the channel allows none, such as the email domains for the sms.
+func allowRecipient(allowlist []string, channel, recipient string) bool {
+	if allowlist == nil {
+		return true
+	}
+
+	for _, pattern := range allowlist {
+		if matchRecipient(pattern, channel, recipient) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAllowlists checks the recipients of the request, including those of
+// the fallback steps, against the allowlists of the API key and the identity
+// of the request, and returns the error if any recipient is not allowed.
+func (r *Request) checkAllowlists(c *Config, channel, key string) error {
+	recipients := r.envelope()
+	if channel != "email" {
+		recipients = []string{r.Phone}
+	}
+	if err := r.checkRecipients(c, channel, key, recipients); err != nil {
+		return err
+	}
+
+	// The fallback steps send the message to the to or the phone of the request
+	// by the other channels, see dispatchFallback.
+	for _, step := range strings.Split(r.Fallback, ",") {
+		step = strings.TrimSpace(step)
+		if index := strings.IndexByte(step, ':'); index >= 0 {
+			step = step[:index]
+		}
+
+		var err error
+		switch {
+		case step == "email" && r.To != "":
+			err = r.checkRecipients(c, step, key, strings.Split(r.To, ","))
+		case step == "sms" && r.Phone != "":
+			err = r.checkRecipients(c, step, key, []string{r.Phone})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Request) checkRecipients(c *Config, channel, key string, recipients []string) error {
+	for _, recipient := range recipients {
+		if !allowRecipient(c.KeyAllowlists[key], channel, recipient) {
+			return fmt.Errorf("the api key is not allowed to send to %s", recipient)
+		}
+		if r.Identity != "" && !allowRecipient(c.Identities[r.Identity].Allowlist,
+			channel, recipient) {
+			return fmt.Errorf("the identity[%s] is not allowed to send to %s",
+				r.Identity, recipient)
+		}
+	}
+	return nil
+}
+
+// checkProviderAllowlist returns the permanent error if any recipient
+// is not allowed by the allowlist of the provider, so the message is sent
+// by the next provider in the chain.
+func checkProviderAllowlist(channel, provider string, recipients []string) error {
+	configLocker.Lock()
+	allowlist := config.ProviderAllowlists[channel+":"+provider]
+	configLocker.Unlock()
+
+	for _, recipient := range recipients {
+		if !allowRecipient(allowlist, channel, recipient) {
+			return messageapi.NewError(messageapi.ClassPermanent, "",
+				fmt.Sprintf("the provider %s is not allowed to send to %s", provider, recipient))
+		}
+	}
+	return nil
+}
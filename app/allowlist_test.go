@@ -0,0 +1,52 @@
+package app
+
+import "testing"
+
+func TestMatchRecipient(t *testing.T) {
+	for _, c := range []struct {
+		pattern   string
+		channel   string
+		recipient string
+		match     bool
+	}{
+		{"alice@example.com", "email", "Alice@Example.com", true},
+		{"example.com", "email", "bob@example.com", true},
+		{"@example.com", "email", "bob@sub.example.com", false},
+		{"*.example.com", "email", "bob@sub.example.com", true},
+		{"*.example.com", "email", "bob@example.com", true},
+		{"*.example.com", "email", "bob@badexample.com", false},
+		{"+86", "email", "bob@example.com", false},
+		{"+8613", "sms", "+86 138 0000 0000", true},
+		{"+8613", "sms", "+8615000000000", false},
+		{"example.com", "sms", "+8613800000000", false},
+	} {
+		if match := matchRecipient(c.pattern, c.channel, c.recipient); match != c.match {
+			t.Errorf("%s, %s: expect %v, but got %v", c.pattern, c.recipient, c.match, match)
+		}
+	}
+}
+
+func TestCheckAllowlistsFallback(t *testing.T) {
+	c := &Config{KeyAllowlists: map[string][]string{"key": {"example.com", "+86"}}}
+
+	args := &Request{To: "alice@example.com", tos: []string{"alice@example.com"},
+		Phone: "+15555550100", Fallback: "sms:twilio"}
+	if err := args.checkAllowlists(c, "email", "key"); err == nil {
+		t.Error("expect the error of the fallback sms, but got nil")
+	}
+
+	args.Phone = "+8613800000000"
+	if err := args.checkAllowlists(c, "email", "key"); err != nil {
+		t.Error(err)
+	}
+
+	args = &Request{Phone: "+8613800000000", To: "mallory@evil.com", Fallback: "email:plain"}
+	if err := args.checkAllowlists(c, "sms", "key"); err == nil {
+		t.Error("expect the error of the fallback email, but got nil")
+	}
+
+	args.Fallback = "messenger:pagerduty"
+	if err := args.checkAllowlists(c, "sms", "key"); err != nil {
+		t.Error(err)
+	}
+}
@@ -6,24 +6,225 @@
 // to true.
 //
 // For POST, the arguments are in body, type of which is "application/json".
+// For the email with the large attachments, the body may be "multipart/form-data",
+// the field "request" of which is the JSON arguments and the files of which are
+// the attachments, which are streamed instead of being buffered in memory,
+// see Config.AttachmentLimits.
+//
+// When the message is sent successfully, the response is the JSON like
+// {"id": "MESSAGE_ID", "provider": "PROVIDER", "metadata": {...}}, the metadata
+// of which is the provider-specific response data, such as the message id of
+// the vendor, see messageapi.Result. Or, the response is the error text.
+// For both, the message id is also in the header "X-Message-ID".
+//
+// The latest messages are recorded in the history, which can be got by
+// "GET /v1/history" or "GET /v1/history/MESSAGE_ID" with the scope "read:history".
+// The messages of a recipient are searched by the index of the recipients and
+// the time buckets by "GET /v1/history/search?recipient=RECIPIENT", and the
+// size of the history is set by SetHistorySize. Both only cover the latest
+// messages kept in memory, which are lost on restart.
 //
 // For GET, the arguments above are in the url query, but not "attachments".
 //
 // About the arguments, see the struct Request.
 //
+// If the API keys are configured, see Config.Keys, each API needs the key
+// with the corresponding scope: "send:email" for "/v1/email", "send:sms" for
+// "/v1/sms", and "admin:config" for "/v1/config".
+//
+// The package also registers "/v1/stats" to get the per-minute time series of
+// the sends, failures and latency of each provider in the last 24 hours by
+// "GET", which needs the scope "read:stats". The query argument "provider",
+// such as "email:plain", selects a provider, and "minutes" is the number of
+// the last minutes, which is 60 by default. And "/v1/stats/variants" returns
+// the sends, failures, deliveries, opens and clicks of the A/B variants of
+// the templates, see Template.
+// "/v1/stats/clock" returns the clock skew between the clients and the server
+// estimated by the signed requests, see Config.ClockDriftWarning.
+// "/v1/stats/canaries" returns the results of the last canary messages
+// sent periodically by the providers to the test recipients, see Canary.
+// "/v1/stats/latency" returns the p50, p95 and p99 of the send latency of
+// the providers, which are checked against the SLOs, see LatencySLO. All the
+// metrics are also exported by "/v1/metrics" in the Prometheus text format.
+//
+// The template may be localized by Template.Locales, which is selected by the
+// template variable "locale", the preferred locale of the recipient, or the
+// locale detected by the country code of the phone, see Config.CountryLocales.
+// If none matches, such as "fr-CA" when neither "fr-CA" nor "fr" is defined,
+// the default subject and content of the template are used.
+//
+// The characters of the sms forcing the UCS-2 encoding, such as the emojis,
+// are transliterated or stripped by Config.SMSEncoding unless the request
+// allows them by Request.Unicode, and the encoding and the number of the
+// segments are reported as "encoding" in the response. For the providers
+// not handling the concatenation, the long sms is split into the numbered
+// parts like "(1/3) ...", which are sent in order, the first one by the
+// request and the rest in background, see Config.SMSSplits.
+//
+// The same email or sms is sent to many recipients, each of whom receives
+// a separate message, by "POST /v1/email/bulk" or "POST /v1/sms/bulk", which
+// uses the native bulk API of the provider if supported, see BulkRequest.
+//
+// The MMS with the media, such as the images or the contact card, is sent by
+// "POST /v1/mms" with the scope "send:mms", see Config.MMSes. The media
+// generated by the server, such as the vCard, is served by "/v1/media/"
+// for the carriers, see Config.MediaBaseURL.
+//
+// The message of the messengers, such as WhatsApp, is sent by
+// "POST /v1/message" with the scope "send:message", see MessageRequest.
+// The delivery reports of WhatsApp and Viber are posted by the providers to
+// "/v1/status/whatsapp" and "/v1/status/viber" with the scope "inbound".
+//
+// The message is acknowledged by "POST /v1/messages/MESSAGE_ID/ack" with the
+// scope "ack", or by the signed link, which is the template variable "ack_url"
+// if Config.MediaBaseURL and Config.TokenSecret are configured. "GET" of the
+// link only shows the page to confirm it by "POST", so the link scanners of
+// the mail servers never acknowledge the message. The unacknowledged
+// message may be escalated, see Request.AckTimeout. Likewise, the opens and
+// the clicks of the variants of the templates are tracked by the signed links
+// "/v1/messages/MESSAGE_ID/open" and "/v1/messages/MESSAGE_ID/click", which
+// are the template variables "open_url" and "click_url".
+//
+// The events of the monitoring tools are posted to "/v1/integrations/NAME"
+// with the scope "integration", such as the Prometheus Alertmanager webhook
+// to "/v1/integrations/alertmanager" and the Grafana alerting webhook to
+// "/v1/integrations/grafana", which are rendered by the templates and sent by
+// the routes matching the labels, see Config.Integrations. The other tools may
+// be integrated by mapping the fields of the JSON payload, see Integration.
+// The repository events of GitHub and GitLab, such as the failed pipelines,
+// the new releases and the issues, are posted to "/v1/integrations/github"
+// and "/v1/integrations/gitlab", which are verified by Integration.Secret.
+//
+// The sending may be paused by "POST /v1/admin/pause" with the scope
+// "admin:pause" per channel, provider or API key, or for all as the kill
+// switch, and resumed by "DELETE /v1/admin/pause", see Pause.
+//
+// For the rolling deployment, "POST /v1/admin/drain" with the scope
+// "admin:drain" stops accepting the new messages with the status code 503
+// and the header "Retry-After", and sends the queued ones at once. Then
+// "GET /v1/admin/drain" returns the number of the in-flight requests,
+// see Drain.
+//
+// For troubleshooting, the requests to the vendors and their responses are
+// logged with the redaction, see Config.PayloadLogging, which may be also
+// enabled per provider at runtime by "POST /v1/admin/payloads" with the scope
+// "admin:debug" and disabled by "DELETE /v1/admin/payloads", see PayloadToggle.
+// Without restarting, the verbosity of the logs is changed by
+// "POST /v1/admin/loglevel", see LogLevel, and the feature flags, such as
+// FeaturePayloadLogging, are overridden by "POST /v1/admin/features", both
+// with the scope "admin:debug".
+//
+// The lifecycle events of the messages, that's, accepted, sent, failed,
+// delivered and bounced, are published to the event bus, such as Redis
+// streams, NATS or Kafka, see Config.EventBus and SetEventPublisher.
+//
+// For the long-term compliance storage, the sent messages are archived to
+// the S3-compatible object storage, such as AWS S3 and Aliyun OSS, in the
+// daily partitions, see Archive. The content and the attachments may be
+// encrypted at rest, which are decrypted only when read by
+// "GET /v1/history/MESSAGE_ID/content" with the scope "read:content".
+//
+// The consent and the preferences of the recipients, such as the allowed
+// channels, the categories and the quiet hours, are managed by
+// "/v1/preferences" with the scope "admin:preference", which are checked
+// before sending the messages with the category, see Preference. Each
+// recipient may also get and update the own preferences, such as opting out
+// of "marketing" but keeping "security", by "/v1/preferences/RECIPIENT" with
+// the signed link, which is the template variable "preferences_url" if
+// Config.MediaBaseURL and Config.TokenSecret are configured.
+//
+// In the privacy mode, the recipients are logged and published only as the
+// salted hashes, and may be encrypted in the history, see Config.Privacy.
+//
+// When overloaded, the messages of the priority "low", and then "normal", are
+// rejected with the status code 429 while the ones of "high", such as OTP and
+// alerts, are still accepted, see Config.LoadShedding and Request.Priority.
+//
+// For the data subject requests of GDPR, "DELETE /v1/recipients/ADDRESS" with
+// the scope "admin:erasure" purges or anonymizes the messages in the history,
+// the held messages, the suppressions, the preferences and the archives of
+// the email address or the phone, and returns what are erased, see ErasureReport.
+// The suppressions and the opt-outs are kept as the hashed tombstones, so the
+// recipient is never messaged again after STOP.
+//
+// For the legacy applications which can only send the emails by SMTP,
+// see SMTPRelay.
+//
+// The inbound emails, which are received by SMTPRelay in the inbound mode or
+// posted as the raw MIME message to "/v1/inbound/email" with the scope
+// "inbound", such as by the inbound routes of Mailgun or SendGrid, are parsed
+// and forwarded as JSON to the configured webhooks, see InboundEmail.
+// Similarly, the inbound sms posted by the providers to
+// "/v1/inbound/sms/PROVIDER", the PROVIDER of which is one of "twilio",
+// "vonage" and "aliyun", are normalized and forwarded, see InboundSMS,
+// and published to the event bus as the "received" events.
+// The requests of the vendors are verified by their signatures instead of the
+// API key if the secrets are configured, see Config.WebhookSecrets.
+// The outbound webhooks are signed and retried, see WebhookOptions, and the
+// last attempts to post to them are returned by "GET /v1/webhooks/deliveries"
+// with the scope "read:history".
+// If the content is a STOP keyword, such as "STOP" or "TD", the sender is added
+// to the suppression list, to which no messages are sent any more, and removed
+// by a START keyword. The suppression list is managed by "/v1/suppressions"
+// with the scope "admin:suppression", see SuppressionStore.
+//
+// For the untrusted clients, such as the mobile apps, the trusted backend can
+// mint a short-lived one-time send token by "POST /v1/token" with the scope
+// "mint:token", see TokenRequest. Then the client sends the message by the
+// token with the header "X-Send-Token" instead of the API key, which only
+// authorizes the send to the recipient by the provider, the identity and the
+// template of the token.
+//
 // Besides, the package also registers a url by default: "/v1/config". You can
 // visit it to get the configuration information by "GET", or modify it by "POST".
 // The format is json. When resetting the configuration, it's necessary to give
 // the whole configuration options.
+//
+// The configuration options of the provider, such as the types, the defaults
+// and the secrets, are returned by "GET /v1/providers/PROVIDER/schema" with
+// the scope "admin:config", see messageapi.ConfigSchema. They are also used
+// to validate the options and redact the secrets. The reserved option
+// "enabled" of any provider, which is true by default, disables the provider
+// if false without removing its options, see Config.Emails.
+//
+// If a cipher is set by SetCipher, the secret options of the providers, such as
+// the password, are encrypted with the prefix "enc:" when getting the
+// configuration, and they are decrypted before being loaded by the providers.
+// So the configuration got by "GET" can be stored and reset by "POST" safely.
+//
+// The configuration may be also managed by a file, such as a mounted Kubernetes
+// ConfigMap, see WatchConfig. In this case, it cannot be reset by "POST".
+//
+// For the backup or cloning the gateway to a new region, the configuration,
+// the templates, the routing rules and the suppressions are exported as one
+// bundle, which may be encrypted by a passphrase, by "GET /v1/admin/export",
+// and imported by "POST /v1/admin/import", see Bundle.
+//
+// One configuration may drive all the stages, such as "dev", "staging" and
+// "prod", by the option "environments", from which the environment selected
+// by SetEnvironment or $MESSAGEAPI_ENV is merged into the configuration.
+//
+// For the multi-tenancy, the global admin delegates the providers to the
+// tenants by the option "tenants", and the admin keys of each tenant manage
+// the options of the delegated providers, the templates, the API keys and
+// the suppressions of the tenant by "/v1/tenants/NAME/" with the scope
+// "admin:tenant", see Tenant. The API keys of the tenant may only send the
+// messages by the delegated providers, the options replaced by the tenant of
+// which are loaded into the instances of the tenant.
+//
+// The instance may be a read-only replica for the support tooling, which
+// serves only the read endpoints backed by the configuration and the shared
+// stores but refuses to send the messages, see SetReadOnly.
 package app
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"net/http"
+	"net/mail"
 	"strconv"
 	"strings"
 	"sync"
@@ -46,59 +247,74 @@ var (
 func init() {
 	configLocker = new(sync.Mutex)
 	ResetConfig(NewDefaultConfig(""))
-	http.HandleFunc("/v1/email", sendEmail)
-	http.HandleFunc("/v1/sms", sendSMS)
-	http.HandleFunc("/v1/config", resetConfig)
+	http.HandleFunc("/v1/email", writable(drainable(sendEmail)))
+	http.HandleFunc("/v1/sms", writable(drainable(sendSMS)))
+	http.HandleFunc("/v1/email/bulk", writable(drainable(sendEmailBulk)))
+	http.HandleFunc("/v1/sms/bulk", writable(drainable(sendSMSBulk)))
+	http.HandleFunc("/v1/mms", writable(drainable(sendMMS)))
+	http.HandleFunc("/v1/message", writable(drainable(sendMessage)))
+	http.HandleFunc("/v1/status/", writable(handleDeliveryStatus))
+	http.HandleFunc("/v1/messages/", writable(handleMessages))
+	http.HandleFunc("/v1/media/", readable(local(handleMedia)))
+	http.HandleFunc("/v1/config", readable(resetConfig))
+	http.HandleFunc("/v1/providers/", readable(handleProviderSchema))
+	http.HandleFunc("/v1/token", writable(handleToken))
+	http.HandleFunc("/v1/stats", readable(local(handleStats)))
+	http.HandleFunc("/v1/stats/variants", readable(local(handleVariantStats)))
+	http.HandleFunc("/v1/stats/clock", readable(local(handleClockStats)))
+	http.HandleFunc("/v1/stats/canaries", readable(local(handleCanaryStats)))
+	http.HandleFunc("/v1/stats/latency", readable(local(handleLatencyStats)))
+	http.HandleFunc("/v1/metrics", readable(local(handleMetrics)))
+	http.HandleFunc("/v1/integrations/", writable(drainable(handleIntegration)))
+	http.HandleFunc("/v1/inbound/email", writable(drainable(handleInboundEmail)))
+	http.HandleFunc("/v1/inbound/sms/", writable(drainable(handleInboundSMS)))
+	http.HandleFunc("/v1/suppressions", readable(handleSuppressions))
+	http.HandleFunc("/v1/recipients/", readable(handleRecipients))
+	http.HandleFunc("/v1/preferences", readable(handlePreferences))
+	http.HandleFunc("/v1/preferences/", readable(handlePreferences))
+	http.HandleFunc("/v1/admin/pause", readable(handlePause))
+	http.HandleFunc("/v1/admin/drain", readable(handleDrain))
+	http.HandleFunc("/v1/admin/payloads", readable(handlePayloadLogging))
+	http.HandleFunc("/v1/admin/features", readable(handleFeatures))
+	http.HandleFunc("/v1/admin/loglevel", readable(handleLogLevel))
+	http.HandleFunc("/v1/admin/export", readable(handleExport))
+	http.HandleFunc("/v1/admin/import", writable(handleImport))
+	http.HandleFunc("/v1/tenants/", readable(handleTenants))
+	http.HandleFunc("/v1/webhooks/deliveries", readable(local(handleWebhookDeliveries)))
+	http.HandleFunc("/v1/history", readable(local(handleHistory)))
+	http.HandleFunc("/v1/history/", readable(local(handleHistory)))
 }
 
 // Start starts the app.
 //
-// If certFile and keyFile are not empty, it will start the app with TLS.
+// If certFile and keyFile are not empty, it will start the app with TLS,
+// and HTTP/2 by default. The timeouts, the keep-alive and HTTP/2 of
+// the server are configured by Config.Server.
+//
+// The app also listens on Config.Listeners, such as a Unix socket,
+// and addr may be empty if they are given.
 func Start(c *Config, addr, certFile, keyFile string) error {
 	if err := ResetConfig(c); err != nil {
 		return err
 	}
 
-	glog.Infof("listening on %s", addr)
-
-	if certFile == "" || keyFile == "" {
-		return http.ListenAndServe(addr, nil)
-	}
-	return http.ListenAndServeTLS(addr, certFile, keyFile, nil)
-}
-
-func getEmail(name string) []messageapi.Email {
 	configLocker.Lock()
 	_config := config
 	configLocker.Unlock()
 
-	if name == "all" {
-		results := make([]messageapi.Email, 0, len(_config.emails))
-		for _, v := range _config.emails {
-			results = append(results, v)
+	listeners := _config.Listeners
+	if addr != "" || len(listeners) == 0 {
+		if addr == "" {
+			addr = ":http"
+			if certFile != "" && keyFile != "" {
+				addr = ":https"
+			}
 		}
-		return results
-	} else if e, ok := _config.emails[name]; ok {
-		return []messageapi.Email{e}
-	}
-	return nil
-}
 
-func getSMS(name string) []messageapi.SMS {
-	configLocker.Lock()
-	_config := config
-	configLocker.Unlock()
-
-	if name == "all" {
-		results := make([]messageapi.SMS, 0, len(_config.smses))
-		for _, v := range _config.smses {
-			results = append(results, v)
-		}
-		return results
-	} else if s, ok := _config.smses[name]; ok {
-		return []messageapi.SMS{s}
+		l := Listener{Addr: addr, CertFile: certFile, KeyFile: keyFile}
+		listeners = append([]Listener{l}, listeners...)
 	}
-	return nil
+	return _config.Server.serve(listeners)
 }
 
 func resetConfig(w http.ResponseWriter, r *http.Request) {
@@ -114,7 +330,18 @@ func resetConfig(w http.ResponseWriter, r *http.Request) {
 	configLocker.Unlock()
 
 	if r.Method == "GET" {
-		if content, err := json.Marshal(_config); err != nil {
+		if !authorize(_config, ScopeAdminConfig, w, r) {
+			return
+		}
+
+		_conf, err := exportConfig(_config)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if content, err := json.Marshal(_conf); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
 		} else {
@@ -122,12 +349,20 @@ func resetConfig(w http.ResponseWriter, r *http.Request) {
 			w.Write(content)
 		}
 	} else if r.Method == "POST" {
+		if isConfigWatched() {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("the configuration is managed by the file"))
+			return
+		}
+
 		buf := bytes.NewBuffer(nil)
 		if _, err := buf.ReadFrom(r.Body); err != nil {
 			glog.Error(err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		// Put the body back for the signature verified by authorize.
+		r.Body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
 
 		_conf := make(map[string]interface{})
 		if err := json.Unmarshal(buf.Bytes(), &_conf); err != nil {
@@ -136,7 +371,11 @@ func resetConfig(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if _config.key != "" {
+		if len(_config.keys) != 0 && getAPIKey(r) != "" {
+			if !authorize(_config, ScopeAdminConfig, w, r) {
+				return
+			}
+		} else if _config.key != "" {
 			if !validation.VerifyMapValueType(_conf, "key", "string") {
 				w.WriteHeader(http.StatusUnauthorized)
 				w.Write([]byte("have no key, or the key type is not a string"))
@@ -147,6 +386,18 @@ func resetConfig(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte("The key is invalid"))
 				return
 			}
+		} else if !authorize(_config, ScopeAdminConfig, w, r) {
+			return
+		}
+
+		_conf, err := applyEnvironment(_conf)
+		if err != nil {
+			writeConfigError(w, err)
+			return
+		}
+		if err := validateConfig(buf.Bytes(), _conf); err != nil {
+			writeConfigError(w, err)
+			return
 		}
 
 		conf, err := parseConfig(_conf)
@@ -157,8 +408,7 @@ func resetConfig(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err := ResetConfig(conf); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			writeConfigError(w, err)
 		}
 	} else {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -169,9 +419,13 @@ func resetConfig(w http.ResponseWriter, r *http.Request) {
 type Request struct {
 	// If the provider is "all", try to send the message by the all providers
 	// in order until a certain provider sent successfully or all the providers
-	// have tried.
+	// have tried. The provider may also be the comma-separated provider names
+	// as a chain, which are tried in the given order. For both, the providers
+	// known to be down are skipped, see Config.BreakerThreshold.
 	//
 	// If the option is not given, use the default in the server configuration.
+	// For sms, it is selected by the country code of the phone if the routing
+	// table is configured, see Config.SMSRoutes.
 	Provider string `json:"provider"`
 
 	// When sending the sms, use this option, which must be given out.
@@ -187,14 +441,112 @@ type Request struct {
 	To          string            `json:"to"`
 	Attachments map[string]string `json:"attachments"`
 
-	// Try to send the message for N times until a certain time is successful.
-	// The default is not to retry.
+	// The comma-separated carbon-copied and blind recipients of the email,
+	// which are checked by the allowlists like "to", and the suppressed or
+	// opted-out ones of which are skipped. The blind ones are not in the
+	// headers of the email.
+	Cc  string `json:"cc,omitempty"`
+	Bcc string `json:"bcc,omitempty"`
+
+	// The HTML body of the email, which is sent with the content as the
+	// plain-text alternative. If the content is empty, it is converted
+	// from the HTML.
+	HTML string `json:"html,omitempty"`
+
+	// The attachments of the email fetched from the URLs, the key of which
+	// is the file name, and the hosts of which must be in the allowlist,
+	// see Config.AttachmentLimits.
+	AttachmentURLs map[string]string `json:"attachment_urls,omitempty"`
+
+	// The name of the template to render the subject and the content, which
+	// override the options above, with the variables, see Config.Templates.
+	Template string                 `json:"template,omitempty"`
+	Vars     map[string]interface{} `json:"vars,omitempty"`
+
+	// The attachments of the email generated by the server,
+	// such as the calendar invitation or the PDF rendered by a template.
+	Generate []GeneratedAttachment `json:"generate,omitempty"`
+
+	// The meeting invitation sent with the email, which the calendar clients
+	// show with the Accept/Decline buttons. The method is "REQUEST" by default,
+	// or "CANCEL" to cancel the meeting with the same uid. The organizer
+	// is required. If the attendees are empty, they are the recipients.
+	Invite       *messageapi.Event `json:"invite,omitempty"`
+	InviteMethod string            `json:"invite_method,omitempty"`
+
+	// The comma-separated fallback steps, such as "sms:aliyun,messenger:pagerduty",
+	// each of which is "CHANNEL:PROVIDER" and the CHANNEL is "email", "sms" or
+	// "messenger". If the message fails on the channel of the request,
+	// the subject and the content are sent by the steps in order until one
+	// succeeds, to the recipient "to" for the email, "phone" for the sms,
+	// and the default recipient of the provider for the messenger, such as
+	// the routing key of PagerDuty. So the on-call escalation is a chain like
+	// email -> sms -> PagerDuty.
+	Fallback string `json:"fallback,omitempty"`
+
+	// The digest key, see Config.Digests. If given, the message is held and
+	// combined with the others of the same key and recipient into a summary
+	// message, which is sent at the end of the window of the digest.
+	Digest string `json:"digest,omitempty"`
+
+	// The deduplication key, such as the incident id. The messages with the
+	// same key to the same recipient in the window of the seconds, which is
+	// 300 by default, are collapsed into the first one, and the latest one is
+	// sent at the end of the window with the counter of the duplicates, that's,
+	// the template variable "dedup_count", or appended to the content.
+	DedupKey    string `json:"dedup_key,omitempty"`
+	DedupWindow int    `json:"dedup_window,omitempty"`
+
+	// The name of the sender identity, see Config.Identities.
+	Identity string `json:"identity,omitempty"`
+
+	// The tag of the message, such as "disk-full", the messages of which are
+	// capped by Config.TagLimits across all the recipients.
+	Tag string `json:"tag,omitempty"`
+
+	// The category of the message, such as "marketing" or "security",
+	// which is checked against the preferences of the recipients before
+	// sending, see Preference. The message without the category is not
+	// checked.
+	Category string `json:"category,omitempty"`
+
+	// The priority of the message, "high", "normal" or "low", by which the
+	// messages are rejected when overloaded, see Config.LoadShedding.
+	// If empty, it is decided by the category.
+	Priority string `json:"priority,omitempty"`
+
+	// If greater than 0, the fallback steps above are also run as the
+	// escalation if the message sent successfully is not acknowledged
+	// within the seconds, see "/v1/messages/ID/ack".
+	AckTimeout int `json:"ack_timeout,omitempty"`
+
+	// If true, allow the characters of the sms forcing the UCS-2 encoding,
+	// such as the emojis, regardless of Config.SMSEncoding.
+	Unicode bool `json:"unicode,omitempty"`
+
+	// The public URLs of the media and the contact card sent by the MMS.
+	Media []string          `json:"media,omitempty"`
+	VCard *messageapi.VCard `json:"vcard,omitempty"`
+
+	// Retry to send the message for N times until a certain time is successful.
+	// The default is not to retry. The permanent errors, such as the SMTP 5xx
+	// reply "user unknown", are not retried, see messageapi.Error.
 	//
-	// If the provider is "all", ignore the option.
+	// If the provider is "all" or a chain, ignore the option.
 	Retry int `json:"retry"`
 
-	tos         []string
-	attachments map[string]io.Reader
+	id           string
+	tos          []string
+	originalTos  []string // The recipients before redirected, see redirectEmail.
+	attachments  map[string]attachmentSource
+	budget       *attachmentBudget
+	variant      string
+	tenant       string // The tenant of the API key, see Tenant.
+	emailOptions messageapi.EmailOptions
+	smsOptions   messageapi.SMSOptions
+	media        []messageapi.Media
+	smsRest      *smsRest // The rest parts of the split sms, see sendSMSParts.
+	rawContent   string   // The sms content before applySMSEncoding.
 }
 
 func (r *Request) validate() error {
@@ -205,6 +557,9 @@ func (r *Request) validate() error {
 	if r.Retry < 0 {
 		r.Retry = 0
 	}
+	if !validPriority(r.Priority) {
+		return fmt.Errorf("invalid priority %s", r.Priority)
+	}
 
 	return nil
 }
@@ -219,17 +574,49 @@ func (r *Request) validateEmail() error {
 	}
 
 	r.tos = strings.Split(r.To, ",")
-	var attachments map[string]io.Reader
-	if len(r.Attachments) != 0 {
-		attachments = make(map[string]io.Reader, len(r.Attachments))
-		for f, c := range r.Attachments {
-			attachments[f] = bytes.NewBufferString(c)
+	r.emailOptions.HTML = r.HTML
+
+	var err error
+	if r.emailOptions.Cc, err = splitAddresses("cc", r.Cc); err != nil {
+		return err
+	} else if r.emailOptions.Bcc, err = splitAddresses("bcc", r.Bcc); err != nil {
+		return err
+	}
+	for f, c := range r.Attachments {
+		if err := r.addAttachment(f, stringAttachment(c)); err != nil {
+			return err
 		}
 	}
-	r.attachments = attachments
 	return nil
 }
 
+// splitAddresses splits the comma-separated email addresses of the option,
+// which are trimmed and validated, and the empty ones are skipped.
+func splitAddresses(option, s string) ([]string, error) {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s address %s", option, addr)
+		}
+		addrs = append(addrs, a.Address)
+	}
+	return addrs, nil
+}
+
+// envelope returns all the recipients of the email, that's, the recipients
+// with the carbon-copied and the blind ones, even if the envelope is narrowed
+// to the failed ones by resendFailed.
+func (r *Request) envelope() []string {
+	opts := r.emailOptions
+	opts.Envelope = nil
+	return messageapi.EmailRecipients(r.tos, opts)
+}
+
 func (r *Request) validateSMS() error {
 	if err := r.validate(); err != nil {
 		return err
@@ -253,37 +640,67 @@ func sendEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	emails := getEmail(args.Provider)
-	if emails == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("have no the email provider[%s]", args.Provider)))
+	// The digest summary is sent without the attachments.
+	if args.Digest != "" {
+		holdDigest(w, r, "email", args)
+		args.closeAttachments()
+		return
+	}
+	if args.DedupKey != "" && checkDedup(w, "email", args) {
+		return
+	}
+	if args.Tag != "" && !allowTag(w, true, args) {
+		args.closeAttachments()
+		return
+	}
+	if checkPaused(w, "email", args.Provider, getAPIKey(r), func() {
+		defer args.closeAttachments()
+		if _, err := deliver(true, args); err != nil {
+			glog.Errorf("failed to send the held email: %s", err)
+		}
+	}, args.closeAttachments) {
 		return
 	}
 
-	var err error
-	if args.Provider == "all" {
-		for _, email := range emails {
-			if err = email.SendEmail(context.TODO(), args.tos, args.Subject,
-				args.Content, args.attachments); err == nil {
-				return
+	result, err := deliver(true, args)
+	args.closeAttachments()
+	writeResult(w, r, result, err)
+}
+
+// writeResult writes the result of sending the message as JSON,
+// or the error as the text.
+func writeResult(w http.ResponseWriter, r *http.Request, result sendResult, err error) {
+	w.Header().Set("X-Message-ID", result.ID)
+	if err != nil {
+		glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+		var e *messageapi.Error
+		if errors.As(err, &e) && errorStatus(err) >= 500 {
+			w.Header().Set("X-Error-Class", string(e.Class))
+			if e.Code != "" {
+				w.Header().Set("X-Error-Code", e.Code)
+			}
+			if e.Reason != "" {
+				w.Header().Set("X-Error-Reason", e.Reason)
 			}
-			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
 		}
-	} else if args.Retry >= 0 {
-		if err = emails[0].SendEmail(context.TODO(), args.tos, args.Subject,
-			args.Content, args.attachments); err == nil {
-			return
+		if d := messageapi.GetRetryAfter(err); d > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(int(d.Seconds())+1))
 		}
-		args.Retry--
-		glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+		w.WriteHeader(errorStatus(err))
+		if _, err = w.Write([]byte(err.Error())); err != nil {
+			glog.Error(err)
+		}
+		return
 	}
 
+	content, err := json.Marshal(result)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		if _, err = w.Write([]byte(err.Error())); err != nil {
-			glog.Error(err)
-		}
+		w.Write([]byte(err.Error()))
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
 }
 
 func sendSMS(w http.ResponseWriter, r *http.Request) {
@@ -299,35 +716,40 @@ func sendSMS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	smses := getSMS(args.Provider)
-	if smses == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("have no the sms provider[%s]", args.Provider)))
+	if args.Digest != "" {
+		holdDigest(w, r, "sms", args)
 		return
 	}
-
-	var err error
-	if args.Provider == "all" {
-		for _, sms := range smses {
-			if err = sms.SendSMS(context.TODO(), args.Phone, args.Content); err == nil {
-				return
-			}
-			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
-		}
-	} else if args.Retry >= 0 {
-		if err = smses[0].SendSMS(context.TODO(), args.Phone, args.Content); err == nil {
-			return
+	if args.DedupKey != "" && checkDedup(w, "sms", args) {
+		return
+	}
+	if args.Tag != "" && !allowTag(w, false, args) {
+		return
+	}
+	if checkPaused(w, "sms", args.Provider, getAPIKey(r), func() {
+		if _, err := deliver(false, args); err != nil {
+			glog.Errorf("failed to send the held sms: %s", err)
 		}
-		args.Retry--
-		glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+	}, nil) {
+		return
 	}
 
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		if _, err = w.Write([]byte(err.Error())); err != nil {
-			glog.Error(err)
+	result, err := deliver(false, args)
+	writeResult(w, r, result, err)
+}
+
+func getDefaultProvider(_config *Config, isEmail bool) string {
+	if isEmail {
+		if _config.DefaultEmailProvider != "" {
+			return _config.DefaultEmailProvider
 		}
+		return defaultEmailProvider
+	}
+
+	if _config.DefaultSMSProvider != "" {
+		return _config.DefaultSMSProvider
 	}
+	return defaultSMSProvider
 }
 
 func handleRequestArgs(isEmail bool, w http.ResponseWriter, r *http.Request) (args *Request) {
@@ -346,8 +768,38 @@ func handleRequestArgs(isEmail bool, w http.ResponseWriter, r *http.Request) (ar
 		return
 	}
 
-	if r.Method == "POST" {
-		buf := bytes.NewBuffer(nil)
+	channel, scope := "sms", ScopeSendSMS
+	if isEmail {
+		channel, scope = "email", ScopeSendEmail
+	}
+	// The send token replaces the API key, the restrictions of which
+	// are checked when minting the token.
+	var token *sendToken
+	key := getAPIKey(r)
+	if t := getSendToken(r); t != "" {
+		var err error
+		if token, err = parseToken(_config.tokenSecret, t); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		key = ""
+	} else if !authorize(_config, scope, w, r) {
+		return
+	}
+
+	if r.Method == "POST" && isEmail && isMultipartForm(r) {
+		args = new(Request)
+		if err := args.parseMultipart(_config, r); err != nil {
+			args.closeAttachments()
+			glog.Errorf("the path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+	} else if r.Method == "POST" {
+		buf := getBuffer()
+		defer putBuffer(buf)
 		if n, err := buf.ReadFrom(r.Body); err != nil || n != r.ContentLength {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(fmt.Sprintf("cannot read the body, err=%s", err)))
@@ -371,8 +823,16 @@ func handleRequestArgs(isEmail bool, w http.ResponseWriter, r *http.Request) (ar
 		args.Provider = r.FormValue("provider")
 		args.Subject = r.FormValue("subject")
 		args.Content = r.FormValue("content")
+		args.HTML = r.FormValue("html")
 		args.To = r.FormValue("to")
+		args.Cc = r.FormValue("cc")
+		args.Bcc = r.FormValue("bcc")
 		args.Phone = r.FormValue("phone")
+		args.Template = r.FormValue("template")
+		args.Identity = r.FormValue("identity")
+		args.Category = r.FormValue("category")
+		args.Priority = r.FormValue("priority")
+		args.Unicode = r.FormValue("unicode") == "true"
 
 		retry := r.FormValue("retry")
 		if retry != "" {
@@ -389,25 +849,65 @@ func handleRequestArgs(isEmail bool, w http.ResponseWriter, r *http.Request) (ar
 		return
 	}
 
-	if args.Provider == "" {
-		if isEmail {
-			if _config.DefaultEmailProvider != "" {
-				args.Provider = _config.DefaultEmailProvider
-			} else {
-				args.Provider = defaultEmailProvider
-			}
-		} else {
-			if _config.DefaultSMSProvider != "" {
-				args.Provider = _config.DefaultSMSProvider
-			} else {
-				args.Provider = defaultSMSProvider
-			}
+	// Close the attachments of the refused request.
+	defer func(req *Request) {
+		if args == nil {
+			req.closeAttachments()
 		}
+	}(args)
+
+	if token != nil {
+		if err := token.bind(channel, args); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+	}
+
+	if args.Provider == "" && !isEmail {
+		args.Provider = routeSMS(_config, args.Phone)
+	}
+	if args.Provider == "" {
+		args.Provider = getDefaultProvider(_config, isEmail)
+	}
+
+	args.tenant = _config.keyTenants[key]
+	if token != nil {
+		args.tenant = token.Tenant
+	}
+	if err := args.applyIdentity(_config, key); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return nil
+	}
+
+	// Shed the load before rendering the template and fetching the attachments.
+	recipients := []string{args.Phone}
+	if isEmail {
+		recipients = strings.Split(args.To, ",")
+	}
+	if shedLoad(_config, w, channel, recipients, args.Priority, args.Category) {
+		return nil
+	}
+
+	if args.DedupKey != "" && args.Template != "" {
+		args.Vars = setVar(args.Vars, "dedup_count", 1)
+	}
+	if err := args.applyTemplate(_config); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return nil
 	}
 
 	var err error
 	if isEmail {
-		err = args.validateEmail()
+		if err = args.validateEmail(); err == nil {
+			if err = args.fetchAttachments(_config); err == nil {
+				if err = args.generateAttachments(_config); err == nil {
+					err = args.buildInvite()
+				}
+			}
+		}
 	} else {
 		err = args.validateSMS()
 	}
@@ -417,5 +917,19 @@ func handleRequestArgs(isEmail bool, w http.ResponseWriter, r *http.Request) (ar
 		return nil
 	}
 
+	if err = args.checkAllowlists(_config, channel, key); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return nil
+	}
+
+	if token != nil {
+		if err = token.consume(args); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+	}
+
 	return
 }
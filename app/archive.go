@@ -0,0 +1,426 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+	"github.com/xgfone/messageapi/internal/s3"
+	"github.com/xgfone/messageapi/internal/sigv4"
+)
+
+// Archive is the options to archive the sent messages to the S3-compatible
+// object storage, such as AWS S3 and Aliyun OSS, for the long-term
+// compliance storage beyond the retention of the history.
+//
+// Each message is archived as the JSON of ArchivedMessage with the key
+// "PREFIX/CHANNEL/dt=YYYY-MM-DD/ID.json" by the UTC date, so that the
+// lifecycle rules may expire or transition them by the prefix. If MIME is
+// true, the email is also archived as the MIME message with the key
+// ending with ".eml".
+//
+// If EncryptContent is true, the content of the message is encrypted in the
+// JSON, and the MIME message with the attachments is encrypted chunk by chunk
+// with the key ending with ".eml.enc", both of which are decrypted only when read
+// by "GET /v1/history/ID/content" with the scope "read:content".
+type Archive struct {
+	// The endpoint of the object storage, such as
+	// "https://s3.us-east-1.amazonaws.com" or
+	// "https://oss-cn-hangzhou.aliyuncs.com".
+	Endpoint string `json:"endpoint"`
+
+	// The region of the bucket, which is "us-east-1" by default.
+	Region string `json:"region,omitempty"`
+	Bucket string `json:"bucket"`
+
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+
+	// If true, the bucket is in the path instead of the host, such as MinIO.
+	PathStyle bool `json:"path_style,omitempty"`
+
+	// The prefix of the keys, which is "messages" by default.
+	Prefix string `json:"prefix,omitempty"`
+
+	// If true, the emails are also archived as the MIME messages.
+	MIME bool `json:"mime,omitempty"`
+
+	// If true, the content and the attachments are encrypted by the AEAD
+	// cipher, such as AES-GCM by the local key or the one based on KMS,
+	// see SetCipher.
+	EncryptContent bool `json:"encrypt_content,omitempty"`
+}
+
+// mimeExt returns the extension of the key of the archived MIME message.
+func (a Archive) mimeExt() string {
+	if a.EncryptContent {
+		return ".eml.enc"
+	}
+	return ".eml"
+}
+
+func (a Archive) key(r Record, ext string) string {
+	prefix := strings.Trim(a.Prefix, "/")
+	if prefix == "" {
+		prefix = "messages"
+	}
+	return prefix + "/" + r.Channel + "/dt=" + r.CreatedAt.UTC().Format("2006-01-02") +
+		"/" + r.ID + ext
+}
+
+// ArchivedMessage is the message archived to the object storage.
+type ArchivedMessage struct {
+	Record
+	Content string `json:"content,omitempty"`
+}
+
+type archiveItem struct {
+	key         string
+	contentType string
+	data        *messageapi.Spool
+}
+
+// archiveQueueSize is the maximum number of the objects to be uploaded,
+// the new ones beyond which are dropped.
+const archiveQueueSize = 1000
+
+var archiveQueue = make(chan archiveItem, archiveQueueSize)
+
+func init() { go runArchiveQueue() }
+
+func getArchive() (*Archive, string) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+	return _config.Archive, _config.archiveSecret
+}
+
+// archiveRecord archives the sent message with its content. If render is not
+// nil and Archive.MIME is true, it renders the MIME message to be archived,
+// which is called before returning, so it may read the attachments.
+func archiveRecord(r Record, content string, render func(w io.Writer) error) {
+	archive, _ := getArchive()
+	if archive == nil || r.Status != StatusSent {
+		return
+	}
+
+	// Encrypt the content even if it looks like a secret, such as "enc:...",
+	// since it is always decrypted when read.
+	if archive.EncryptContent {
+		c := getCipher()
+		if c == nil {
+			glog.Errorf("no cipher to encrypt the message %s to archive", r.ID)
+			return
+		}
+
+		data, err := c.Encrypt([]byte(content))
+		if err != nil {
+			glog.Errorf("failed to encrypt the message %s to archive: %s", r.ID, err)
+			return
+		}
+		content = SecretPrefix + base64.StdEncoding.EncodeToString(data)
+	}
+
+	data, err := json.Marshal(ArchivedMessage{Record: r, Content: content})
+	if err != nil {
+		glog.Errorf("failed to archive the message %s: %s", r.ID, err)
+		return
+	}
+	spool := messageapi.NewSpool(0, 0)
+	spool.Write(data)
+	enqueueArchive(archiveItem{key: archive.key(r, ".json"),
+		contentType: "application/json", data: spool})
+
+	if archive.MIME && render != nil {
+		spool := messageapi.NewSpool(0, 0)
+		if err := render(spool); err != nil {
+			glog.Errorf("failed to render the message %s to archive: %s", r.ID, err)
+			spool.Close()
+			return
+		}
+
+		var err error
+		contentType := "message/rfc822"
+		if archive.EncryptContent {
+			if spool, err = encryptSpool(spool); err != nil {
+				glog.Errorf("failed to encrypt the message %s to archive: %s", r.ID, err)
+				return
+			}
+			contentType = "application/octet-stream"
+		}
+		enqueueArchive(archiveItem{key: archive.key(r, archive.mimeExt()),
+			contentType: contentType, data: spool})
+	}
+}
+
+// archiveChunkSize is the size of the plaintext of each chunk of the
+// encrypted MIME message, see encryptSpool.
+const archiveChunkSize = 64 * 1024
+
+// encryptSpool encrypts the data of the spool chunk by chunk into a new spool,
+// which spills to the temporary file like the original, and closes it, so that
+// the large message is never buffered in memory as a whole.
+//
+// Each chunk is written as the 4-byte big-endian length of its ciphertext
+// followed by the ciphertext of its 8-byte index, the byte 1 if it is the
+// last one or else 0, and the data, so that the chunks are neither reordered
+// nor truncated, see decryptChunks.
+func encryptSpool(spool *messageapi.Spool) (*messageapi.Spool, error) {
+	defer spool.Close()
+	c := getCipher()
+	if c == nil {
+		return nil, fmt.Errorf("no cipher to encrypt the message")
+	}
+
+	encrypted := messageapi.NewSpool(0, 0)
+	reader, remaining := spool.Reader(), spool.Size()
+	chunk := make([]byte, 9+archiveChunkSize)
+	for index := uint64(0); ; index++ {
+		n := int64(archiveChunkSize)
+		if remaining < n {
+			n = remaining
+		}
+		remaining -= n
+
+		binary.BigEndian.PutUint64(chunk, index)
+		if chunk[8] = 0; remaining == 0 {
+			chunk[8] = 1
+		}
+		_, err := io.ReadFull(reader, chunk[9:9+n])
+		if err == nil {
+			var data []byte
+			if data, err = c.Encrypt(chunk[:9+n]); err == nil {
+				var size [4]byte
+				binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+				if _, err = encrypted.Write(size[:]); err == nil {
+					_, err = encrypted.Write(data)
+				}
+			}
+		}
+		if err != nil {
+			encrypted.Close()
+			return nil, err
+		} else if remaining == 0 {
+			return encrypted, nil
+		}
+	}
+}
+
+// decryptChunks decrypts the chunks encrypted by encryptSpool from r to w.
+func decryptChunks(c Cipher, r io.Reader, w io.Writer) error {
+	var size [4]byte
+	for index := uint64(0); ; index++ {
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return fmt.Errorf("the encrypted message is truncated: %s", err)
+		}
+
+		data := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("the encrypted message is truncated: %s", err)
+		}
+
+		data, err := c.Decrypt(data)
+		if err != nil {
+			return err
+		} else if len(data) < 9 || binary.BigEndian.Uint64(data) != index {
+			return fmt.Errorf("the chunk %d of the encrypted message is invalid", index)
+		} else if _, err = w.Write(data[9:]); err != nil {
+			return err
+		} else if data[8] == 1 {
+			return nil
+		}
+	}
+}
+
+func enqueueArchive(item archiveItem) {
+	select {
+	case archiveQueue <- item:
+	default:
+		glog.Errorf("the archive queue is full, drop the object %s", item.key)
+		archiveWorker.drop()
+		item.data.Close()
+	}
+}
+
+var archiveWorker = &worker{name: "archive"}
+
+func runArchiveQueue() {
+	for item := range archiveQueue {
+		archiveWorker.run(func() {
+			for attempt := 0; ; attempt++ {
+				err := putArchive(item)
+				if err == nil {
+					break
+				} else if attempt >= 2 {
+					glog.Errorf("failed to archive the object %s: %s", item.key, err)
+					archiveWorker.drop()
+					break
+				}
+				time.Sleep(time.Duration(1<<uint(attempt)) * time.Second)
+			}
+			item.data.Close()
+		})
+	}
+}
+
+var archiveClient = &http.Client{Timeout: time.Minute}
+
+func putArchive(item archiveItem) error {
+	archive, secret := getArchive()
+	if archive == nil {
+		return nil
+	}
+
+	return archive.client(secret).PutObject(context.Background(), item.key,
+		item.contentType, item.data.Size(), item.data.Reader)
+}
+
+func (a Archive) client(secret string) *s3.Client {
+	return &s3.Client{
+		Endpoint:  a.Endpoint,
+		Region:    a.Region,
+		Bucket:    a.Bucket,
+		PathStyle: a.PathStyle,
+		Credentials: sigv4.Credentials{
+			AccessKeyID:     a.AccessKeyID,
+			SecretAccessKey: secret,
+		},
+		HTTPClient: archiveClient,
+	}
+}
+
+// deleteArchives deletes the archived objects of the message, and returns
+// the keys of the deleted objects.
+func deleteArchives(r Record) (keys []string, err error) {
+	archive, secret := getArchive()
+	if archive == nil {
+		return nil, nil
+	}
+
+	exts := []string{".json"}
+	if archive.MIME && r.Channel == "email" {
+		exts = append(exts, archive.mimeExt())
+	}
+
+	client := archive.client(secret)
+	for _, ext := range exts {
+		key := archive.key(r, ext)
+		if err = client.DeleteObject(context.Background(), key); err != nil {
+			return
+		}
+		keys = append(keys, key)
+	}
+	return
+}
+
+// ScopeReadContent is the scope of the API key to read the archived content
+// of the messages.
+const ScopeReadContent = "read:content"
+
+// readArchive returns the archived message of the record, the content of
+// which is decrypted. If mime is true, it returns the MIME message instead.
+func readArchive(r Record, mime bool) (ArchivedMessage, []byte, error) {
+	archive, secret := getArchive()
+	if archive == nil {
+		return ArchivedMessage{}, nil, fmt.Errorf("the archive is not configured")
+	}
+
+	key := archive.key(r, ".json")
+	if mime {
+		key = archive.key(r, archive.mimeExt())
+	}
+
+	body, err := archive.client(secret).GetObject(context.Background(), key)
+	if err != nil {
+		return ArchivedMessage{}, nil, err
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return ArchivedMessage{}, nil, err
+	}
+
+	if mime {
+		if archive.EncryptContent {
+			if c := getCipher(); c == nil {
+				err = fmt.Errorf("no cipher to decrypt the message")
+			} else {
+				buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+				err = decryptChunks(c, bytes.NewReader(data), buf)
+				data = buf.Bytes()
+			}
+		}
+		return ArchivedMessage{}, data, err
+	}
+
+	var msg ArchivedMessage
+	if err = json.Unmarshal(data, &msg); err == nil {
+		msg.Content, err = decryptValue(getCipher(), msg.Content)
+	}
+	return msg, nil, err
+}
+
+// handleHistoryContent returns the archived message of the record, the
+// content of which is decrypted, or the MIME message of the email if the
+// query argument "format" is "eml":
+//
+//	GET /v1/history/ID/content?format=eml
+func handleHistoryContent(c *Config, w http.ResponseWriter, r *http.Request, id string) {
+	if !authorize(c, ScopeReadContent, w, r) {
+		return
+	}
+
+	record, ok := messageHistory.get(id)
+	if tenant := c.keyTenants[getAPIKey(r)]; !ok || (tenant != "" && record.Tenant != tenant) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	mime := r.URL.Query().Get("format") == "eml"
+	msg, data, err := readArchive(openRecord(record), mime)
+	if err == nil && !mime {
+		msg.Record = openRecord(msg.Record)
+		data, err = json.Marshal(msg)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if mime {
+		w.Header().Set("Content-Type", "message/rfc822")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Write(data)
+}
+
+// writeEmail writes the email sent by the provider as the MIME message.
+func (r *Request) writeEmail(w io.Writer, provider string) error {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	options := _config.Emails[provider]
+	if o, ok := _config.Tenants[r.tenant].Options["email:"+provider]; ok {
+		options = o
+	}
+	from := mail.Address{Address: _config.providerOptions("email", options)["from"]}
+	if from.Address == "" {
+		from.Address = "unknown@localhost"
+	}
+	return messageapi.WriteEmail(w, from, r.tos, r.Subject, r.Content,
+		r.openAttachments(), r.emailOptions)
+}
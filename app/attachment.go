@@ -0,0 +1,187 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/xgfone/messageapi"
+)
+
+// The types of the generated attachments.
+const (
+	AttachmentICS = "ics"
+	AttachmentPDF = "pdf"
+)
+
+// GeneratedAttachment is the attachment generated by the server.
+type GeneratedAttachment struct {
+	// The file name of the attachment.
+	Name string `json:"name"`
+
+	// The type of the attachment, that's, "ics" or "pdf".
+	Type string `json:"type"`
+
+	// For "pdf", the name of the template, which is rendered as HTML
+	// with the variables of the request and converted to PDF. The images
+	// and the styles should be inlined, see SetPDFRenderer.
+	Template string `json:"template,omitempty"`
+
+	// For "ics", the event and the method of the calendar,
+	// which is "PUBLISH" by default. For "REQUEST" and "CANCEL",
+	// the organizer of the event is required.
+	Event  *messageapi.Event `json:"event,omitempty"`
+	Method string            `json:"method,omitempty"`
+}
+
+// PDFRenderer converts the HTML document to PDF.
+type PDFRenderer interface {
+	RenderPDF(html []byte) ([]byte, error)
+}
+
+// CommandPDFRenderer is the PDF renderer by the external command, which reads
+// the HTML from the stdin and writes the PDF to the stdout.
+type CommandPDFRenderer struct {
+	Path string
+	Args []string
+}
+
+// RenderPDF implements the interface PDFRenderer.
+func (r CommandPDFRenderer) RenderPDF(html []byte) ([]byte, error) {
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	cmd := exec.Command(r.Path, r.Args...)
+	cmd.Stdin = bytes.NewReader(html)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+var (
+	pdfLocker               = new(sync.Mutex)
+	pdfRenderer PDFRenderer = CommandPDFRenderer{
+		Path: "wkhtmltopdf",
+		Args: []string{
+			"--quiet",
+			"--disable-local-file-access",
+			"--disable-javascript",
+			// Send all the requests to the closed port, so the document
+			// cannot fetch the resources of the internal network.
+			"--proxy", "http://127.0.0.1:1",
+			"-", "-",
+		},
+	}
+)
+
+// SetPDFRenderer sets the PDF renderer, which is wkhtmltopdf by default,
+// which neither reads the local files nor fetches the network resources,
+// such as the images by URL, so the templates should inline them.
+func SetPDFRenderer(r PDFRenderer) {
+	pdfLocker.Lock()
+	pdfRenderer = r
+	pdfLocker.Unlock()
+}
+
+func getPDFRenderer() PDFRenderer {
+	pdfLocker.Lock()
+	r := pdfRenderer
+	pdfLocker.Unlock()
+	return r
+}
+
+// generate generates the content of the attachment.
+func (a GeneratedAttachment) generate(c *Config, vars map[string]interface{}) ([]byte, error) {
+	switch a.Type {
+	case AttachmentICS:
+		if a.Event == nil {
+			return nil, fmt.Errorf("the event is empty")
+		}
+
+		method := strings.ToUpper(a.Method)
+		switch method {
+		case messageapi.MethodRequest, messageapi.MethodCancel:
+			if a.Event.Organizer == "" {
+				return nil, fmt.Errorf("the organizer of the event is empty")
+			}
+		}
+		return a.Event.ICS(method), nil
+
+	case AttachmentPDF:
+		t, ok := c.Templates[a.Template]
+		if !ok {
+			return nil, fmt.Errorf("have no the template[%s]", a.Template)
+		} else if err := t.checkVars(vars); err != nil {
+			return nil, err
+		}
+
+		html, err := t.renderContent(c, t.Content, true, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		pdf, err := getPDFRenderer().RenderPDF([]byte(html))
+		if err != nil {
+			return nil, fmt.Errorf("failed to render the pdf: %s", err)
+		}
+		return pdf, nil
+
+	default:
+		return nil, fmt.Errorf("the type of the attachment is unknown")
+	}
+}
+
+// generateAttachments generates the attachments and adds them
+// into the attachments of the request.
+func (r *Request) generateAttachments(c *Config) error {
+	for _, a := range r.Generate {
+		if a.Name == "" {
+			return fmt.Errorf("the name of the generated attachment is empty")
+		} else if _, ok := r.attachments[a.Name]; ok {
+			return fmt.Errorf("the attachment[%s] is duplicated", a.Name)
+		}
+
+		content, err := a.generate(c, r.Vars)
+		if err != nil {
+			return fmt.Errorf("failed to generate the attachment[%s]: %s", a.Name, err)
+		}
+		r.addAttachment(a.Name, bytesAttachment(content))
+	}
+	return nil
+}
+
+// buildInvite builds the calendar of the meeting invitation if given.
+func (r *Request) buildInvite() error {
+	if r.Invite == nil {
+		return nil
+	}
+
+	method := strings.ToUpper(r.InviteMethod)
+	switch method {
+	case "":
+		method = messageapi.MethodRequest
+	case messageapi.MethodRequest, messageapi.MethodCancel:
+	default:
+		return fmt.Errorf("the invite method is unknown")
+	}
+
+	event := *r.Invite
+	if event.Organizer == "" {
+		return fmt.Errorf("the organizer of the invite is empty")
+	} else if event.Start.IsZero() || event.End.IsZero() {
+		return fmt.Errorf("the start or end of the invite is empty")
+	} else if event.End.Before(event.Start) {
+		return fmt.Errorf("the end of the invite is before the start")
+	}
+	if len(event.Attendees) == 0 {
+		event.Attendees = r.tos
+	}
+
+	r.emailOptions.Calendar = event.ICS(method)
+	r.emailOptions.CalendarMethod = method
+	return nil
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"net/http"
+)
+
+// The scopes of the API keys.
+const (
+	ScopeAll         = "*"
+	ScopeSendSMS     = "send:sms"
+	ScopeSendEmail   = "send:email"
+	ScopeAdminConfig = "admin:config"
+)
+
+// getAPIKey returns the API key of the request, which is from the header
+// "X-API-Key" or the query argument "key".
+func getAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("key")
+}
+
+// hasScope reports whether the API key has the scope.
+func (c *Config) hasScope(key, scope string) bool {
+	scopes, ok := c.keys[key]
+	if !ok || key == "" {
+		return false
+	}
+
+	for _, s := range scopes {
+		if s == scope || s == ScopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks whether the request is allowed to access the API
+// with the scope, and writes the error response if not.
+//
+// If no API keys are configured, all the requests are allowed.
+//
+// If the API key has a secret, the request must be signed by it,
+// see verifySignature.
+func authorize(c *Config, scope string, w http.ResponseWriter, r *http.Request) bool {
+	if len(c.keys) == 0 {
+		return true
+	}
+
+	key := getAPIKey(r)
+	if key == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("have no the api key"))
+		return false
+	} else if !c.hasScope(key, scope) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("the api key has no the scope " + scope))
+		return false
+	}
+
+	if secret, ok := c.secrets[key]; ok {
+		if err := verifySignature(c, secret, r); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return false
+		}
+	} else if r.Header.Get("X-Signature") != "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("the api key has no the secret to sign"))
+		return false
+	}
+	return true
+}
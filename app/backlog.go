@@ -0,0 +1,155 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// worker is the statistics of a background worker consuming a queue.
+type worker struct {
+	name    string
+	busy    int32 // 1 if it is handling an item.
+	busyNs  int64 // The total duration of handling the items.
+	dropped int64 // The number of the items dropped or given up.
+}
+
+func (w *worker) run(handle func()) {
+	atomic.StoreInt32(&w.busy, 1)
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&w.busyNs, int64(time.Since(start)))
+		atomic.StoreInt32(&w.busy, 0)
+	}()
+	handle()
+}
+
+func (w *worker) drop() { atomic.AddInt64(&w.dropped, 1) }
+
+// pendingStats is the number of the messages held to be sent later of a kind,
+// and the time when the oldest one is held.
+type pendingStats struct {
+	kind   string
+	count  int
+	oldest time.Time
+}
+
+func (s *pendingStats) add(count int, since time.Time) {
+	s.count += count
+	if count > 0 && (s.oldest.IsZero() || since.Before(s.oldest)) {
+		s.oldest = since
+	}
+}
+
+// getPendingStats returns the statistics of the messages held to be sent
+// later, that's, the digests, the duplicates, the paused messages, the
+// messages waiting for the acknowledgement to be escalated and the payloads
+// waiting to be posted to the webhooks again.
+func getPendingStats() []pendingStats {
+	digests := pendingStats{kind: "digest"}
+	digestLocker.Lock()
+	for _, batch := range digestBatches {
+		digests.add(len(batch.items), batch.since)
+	}
+	digestLocker.Unlock()
+
+	// Only the latest duplicate is sent at the end of the window.
+	dedups := pendingStats{kind: "dedup"}
+	dedupLocker.Lock()
+	for _, state := range dedupStates {
+		if state.count > 0 {
+			dedups.add(1, state.since)
+		}
+	}
+	dedupLocker.Unlock()
+
+	paused := pendingStats{kind: "paused"}
+	pauseLocker.Lock()
+	for _, p := range pauses {
+		paused.add(len(p.held), p.heldAt)
+	}
+	pauseLocker.Unlock()
+
+	escalations := pendingStats{kind: "escalation",
+		count: int(atomic.LoadInt64(&pendingEscalations))}
+	webhooks := pendingStats{kind: "webhook_retry",
+		count: int(atomic.LoadInt64(&pendingWebhooks))}
+	return []pendingStats{digests, dedups, paused, escalations, webhooks}
+}
+
+// writeBacklogMetrics writes the metrics of the queues and the scheduled
+// messages in the Prometheus text format, so that the capacity issues are
+// visible before the messages go stale.
+func writeBacklogMetrics(buf *bytes.Buffer) {
+	queues := []struct {
+		worker   *worker
+		depth    int
+		capacity int
+	}{
+		{archiveWorker, len(archiveQueue), cap(archiveQueue)},
+		{eventWorker, len(eventQueue), cap(eventQueue)},
+	}
+
+	buf.WriteString("# HELP messageapi_queue_depth The number of the items in the background queue.\n")
+	buf.WriteString("# TYPE messageapi_queue_depth gauge\n")
+	for _, q := range queues {
+		fmt.Fprintf(buf, "messageapi_queue_depth{queue=%q} %d\n", q.worker.name, q.depth)
+	}
+
+	buf.WriteString("# HELP messageapi_queue_capacity The capacity of the background queue.\n")
+	buf.WriteString("# TYPE messageapi_queue_capacity gauge\n")
+	for _, q := range queues {
+		fmt.Fprintf(buf, "messageapi_queue_capacity{queue=%q} %d\n", q.worker.name, q.capacity)
+	}
+
+	buf.WriteString("# HELP messageapi_worker_busy Whether the worker of the queue is handling an item.\n")
+	buf.WriteString("# TYPE messageapi_worker_busy gauge\n")
+	for _, q := range queues {
+		fmt.Fprintf(buf, "messageapi_worker_busy{queue=%q} %d\n", q.worker.name,
+			atomic.LoadInt32(&q.worker.busy))
+	}
+
+	buf.WriteString("# HELP messageapi_worker_busy_seconds_total The time of the worker handling the items, the rate of which is the utilization.\n")
+	buf.WriteString("# TYPE messageapi_worker_busy_seconds_total counter\n")
+	for _, q := range queues {
+		fmt.Fprintf(buf, "messageapi_worker_busy_seconds_total{queue=%q} %g\n", q.worker.name,
+			time.Duration(atomic.LoadInt64(&q.worker.busyNs)).Seconds())
+	}
+
+	buf.WriteString("# HELP messageapi_dead_letters_total The number of the items dropped as the queue is full or given up after the retries.\n")
+	buf.WriteString("# TYPE messageapi_dead_letters_total counter\n")
+	for _, q := range queues {
+		fmt.Fprintf(buf, "messageapi_dead_letters_total{queue=%q} %d\n", q.worker.name,
+			atomic.LoadInt64(&q.worker.dropped))
+	}
+	fmt.Fprintf(buf, "messageapi_dead_letters_total{queue=\"webhook\"} %d\n",
+		atomic.LoadInt64(&webhookDeadLetters))
+
+	buf.WriteString("# HELP messageapi_shed_total The number of the messages rejected by the load shedding.\n")
+	buf.WriteString("# TYPE messageapi_shed_total counter\n")
+	fmt.Fprintf(buf, "messageapi_shed_total{priority=\"low\"} %d\n", atomic.LoadInt64(&shedLow))
+	fmt.Fprintf(buf, "messageapi_shed_total{priority=\"normal\"} %d\n", atomic.LoadInt64(&shedNormal))
+
+	buf.WriteString("# HELP messageapi_inflight_requests The number of the requests to send the messages in flight.\n")
+	buf.WriteString("# TYPE messageapi_inflight_requests gauge\n")
+	fmt.Fprintf(buf, "messageapi_inflight_requests %d\n", atomic.LoadInt64(&inflight))
+
+	now := time.Now()
+	pendings := getPendingStats()
+	buf.WriteString("# HELP messageapi_scheduled_messages The number of the messages held to be sent later.\n")
+	buf.WriteString("# TYPE messageapi_scheduled_messages gauge\n")
+	for _, p := range pendings {
+		fmt.Fprintf(buf, "messageapi_scheduled_messages{kind=%q} %d\n", p.kind, p.count)
+	}
+
+	buf.WriteString("# HELP messageapi_oldest_pending_seconds The age of the oldest message held to be sent later.\n")
+	buf.WriteString("# TYPE messageapi_oldest_pending_seconds gauge\n")
+	for _, p := range pendings {
+		var age float64
+		if !p.oldest.IsZero() {
+			age = now.Sub(p.oldest).Seconds()
+		}
+		fmt.Fprintf(buf, "messageapi_oldest_pending_seconds{kind=%q} %g\n", p.kind, age)
+	}
+}
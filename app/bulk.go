@@ -0,0 +1,533 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// maxBulkRecipients is the maximum number of the recipients of a bulk request.
+const maxBulkRecipients = 1000
+
+// BulkRequest is the arguments to send the same email or sms to many
+// recipients by "POST /v1/email/bulk" or "POST /v1/sms/bulk" with the same
+// scope as "/v1/email" or "/v1/sms", each of whom receives a separate message.
+//
+// If the provider implements messageapi.BulkEmail or messageapi.BulkSMS, such
+// as by the batch endpoint of the vendor, the messages are sent by the native
+// bulk API in one request. Or, they are sent one by one.
+//
+// The response is the JSON like {"id": "MESSAGE_ID", "provider": "PROVIDER",
+// "sent": 99, "failed": {"RECIPIENT": "ERROR"}}, and the status code is 500
+// only if all the recipients failed.
+type BulkRequest struct {
+	// The same as Request.Provider, but the sms routing table is not used.
+	Provider string `json:"provider"`
+
+	// The email addresses or the phones, at most 1000.
+	To []string `json:"to"`
+
+	Subject string `json:"subject"`
+	Content string `json:"content"`
+
+	// The HTML body of the email, see Request.HTML.
+	HTML string `json:"html,omitempty"`
+
+	// The template rendered only once for all the recipients, or once for
+	// each of its variants, which are sent to the recipients by the variants
+	// as the separate bulks, see Template.Variants.
+	Template string                 `json:"template,omitempty"`
+	Vars     map[string]interface{} `json:"vars,omitempty"`
+
+	Identity string `json:"identity,omitempty"`
+
+	// The category and the priority of the message, see Request.
+	Category string `json:"category,omitempty"`
+	Priority string `json:"priority,omitempty"`
+
+	// Allow the characters of the sms forcing the UCS-2 encoding, see Request.
+	Unicode bool `json:"unicode,omitempty"`
+
+	// Retry to send to the failed recipients for N times, see Request.Retry.
+	Retry int `json:"retry"`
+}
+
+// bulkResult is the result of sending a bulk request.
+type bulkResult struct {
+	ID       string            `json:"id"`
+	Provider string            `json:"provider,omitempty"`
+	Sent     int               `json:"sent"`
+	Failed   map[string]string `json:"failed,omitempty"`
+
+	// The encoding of the sms, see Config.SMSEncoding.
+	Encoding *smsEncoding `json:"encoding,omitempty"`
+
+	// The ids of the bulks of the variants of the template, the first of
+	// which is the id above, see dispatchBulkVariants.
+	Variants map[string]string `json:"variants,omitempty"`
+}
+
+func sendEmailBulk(w http.ResponseWriter, r *http.Request) { handleBulk(true, w, r) }
+func sendSMSBulk(w http.ResponseWriter, r *http.Request)   { handleBulk(false, w, r) }
+
+func handleBulk(isEmail bool, w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	channel, scope := "sms", ScopeSendSMS
+	if isEmail {
+		channel, scope = "email", ScopeSendEmail
+	}
+	if !authorize(_config, scope, w, r) {
+		return
+	} else if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var bulk BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&bulk); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	} else if len(bulk.To) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("the to is empty"))
+		return
+	} else if len(bulk.To) > maxBulkRecipients {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("the recipients are more than %d", maxBulkRecipients)))
+		return
+	} else if !validPriority(bulk.Priority) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid priority " + bulk.Priority))
+		return
+	} else if shedLoad(_config, w, channel, bulk.To, bulk.Priority, bulk.Category) {
+		return
+	}
+
+	key := getAPIKey(r)
+	args := &Request{Provider: bulk.Provider, Subject: bulk.Subject, Content: bulk.Content,
+		HTML: bulk.HTML, Template: bulk.Template, Vars: bulk.Vars, Identity: bulk.Identity,
+		Retry: bulk.Retry, Category: bulk.Category, Priority: bulk.Priority,
+		Unicode: bulk.Unicode, tenant: _config.keyTenants[key]}
+	if args.Provider == "" {
+		args.Provider = getDefaultProvider(_config, isEmail)
+	}
+	if err := args.applyIdentity(_config, key); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	raw := *args
+	if err := args.applyTemplate(_config); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err := args.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	} else if isEmail && args.Subject == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("the subject is empty"))
+		return
+	}
+	args.emailOptions.HTML = args.HTML
+
+	variants, err := raw.renderVariants(_config, isEmail)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Check each recipient alone, so that the others are still sent.
+	failed := make(map[string]string)
+	recipients := make([]string, 0, len(bulk.To))
+	for _, to := range bulk.To {
+		if to = strings.TrimSpace(to); to == "" {
+			continue
+		}
+
+		one := *args
+		one.tos, one.Phone = []string{to}, to
+		if err := one.checkAllowlists(_config, channel, key); err != nil {
+			failed[to] = err.Error()
+		} else if isSuppressed(channel, to) {
+			failed[to] = "the recipient is suppressed"
+		} else if err := checkPreference(channel, to, args.Category); err != nil {
+			failed[to] = err.Error()
+		} else {
+			recipients = append(recipients, to)
+		}
+	}
+
+	if len(recipients) == 0 {
+		content, _ := json.Marshal(map[string]interface{}{"failed": failed})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(content)
+		return
+	}
+
+	if checkPaused(w, channel, args.Provider, key, func() {
+		if _, err := dispatchBulkVariants(isEmail, args, variants, recipients); err != nil {
+			glog.Errorf("failed to send the held bulk %s: %s", channel, err)
+		}
+	}, nil) {
+		return
+	}
+
+	result, err := dispatchBulkVariants(isEmail, args, variants, recipients)
+	for to, e := range failed {
+		result.Failed = setFailed(result.Failed, to, e)
+	}
+
+	w.Header().Set("X-Message-ID", result.ID)
+	if err != nil && result.Sent == 0 {
+		glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+		if _, ok := err.(noProviderError); ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	content, _ := json.Marshal(result)
+	w.Write(content)
+}
+
+func setFailed(failed map[string]string, recipient, err string) map[string]string {
+	if failed == nil {
+		failed = make(map[string]string)
+	}
+	failed[recipient] = err
+	return failed
+}
+
+// bulkVariants is the requests of the bulk rendered by each variant of the
+// template, so that each recipient gets its own variant.
+type bulkVariants struct {
+	template Template
+	requests map[string]*Request
+}
+
+// renderVariants renders the request by each variant of its template,
+// or returns nil if the template has no variants.
+func (r Request) renderVariants(c *Config, isEmail bool) (*bulkVariants, error) {
+	t, ok := c.lookupTemplate(r.tenant, r.Template)
+	if r.Template == "" || !ok || len(t.Variants) == 0 {
+		return nil, nil
+	}
+
+	variants := &bulkVariants{template: t, requests: make(map[string]*Request, len(t.Variants))}
+	for _, v := range t.Variants {
+		one := r
+		one.variant = v.Name
+		if err := one.applyTemplate(c); err != nil {
+			return nil, err
+		} else if err := one.validate(); err != nil {
+			return nil, err
+		} else if isEmail && one.Subject == "" {
+			return nil, fmt.Errorf("the subject of the variant[%s] is empty", v.Name)
+		}
+		one.emailOptions.HTML = one.HTML
+		variants.requests[v.Name] = &one
+	}
+	return variants, nil
+}
+
+// dispatchBulkVariants dispatches the bulk by dispatchBulk, or groups the
+// recipients by their variants of the template and dispatches each group
+// as a bulk if any. The results of the groups are merged, the id of which
+// is the first one, and the ids of all are in bulkResult.Variants.
+func dispatchBulkVariants(isEmail bool, args *Request, variants *bulkVariants,
+	recipients []string) (result bulkResult, err error) {
+	if variants == nil {
+		return dispatchBulk(isEmail, args, recipients)
+	}
+
+	groups := make(map[string][]string)
+	for _, to := range recipients {
+		if v := variants.template.pickVariant(to); v != nil {
+			groups[v.Name] = append(groups[v.Name], to)
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result.Variants = make(map[string]string, len(names))
+	for _, name := range names {
+		r, e := dispatchBulk(isEmail, variants.requests[name], groups[name])
+		if result.ID == "" {
+			result.ID, result.Encoding = r.ID, r.Encoding
+		}
+		if r.Provider != "" {
+			result.Provider = r.Provider
+		}
+		result.Variants[name] = r.ID
+		result.Sent += r.Sent
+		for to, reason := range r.Failed {
+			result.Failed = setFailed(result.Failed, to, reason)
+		}
+		if e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// dispatchBulk sends the message to the recipients by the provider or the
+// providers in the request, and records it into the history. For the chain,
+// the recipients failed by a provider are sent by the next one.
+func dispatchBulk(isEmail bool, args *Request, recipients []string) (result bulkResult, err error) {
+	if result.ID = args.id; result.ID == "" {
+		result.ID = newMessageID()
+	}
+
+	channel := "sms"
+	if isEmail {
+		channel = "email"
+	}
+	publishAccepted(result.ID, channel, args.Provider, recipients, args.Template)
+	if !isEmail {
+		result.Encoding = applySMSEncoding(args)
+	}
+
+	var sent []string
+	defer func() {
+		record := Record{
+			ID:         result.ID,
+			Channel:    channel,
+			Provider:   result.Provider,
+			Tenant:     args.tenant,
+			Recipients: sent,
+			Subject:    args.Subject,
+			Template:   args.Template,
+			Variant:    args.variant,
+			Status:     StatusSent,
+			CreatedAt:  time.Now(),
+		}
+		if len(sent) == 0 {
+			record.Status = StatusFailed
+			record.Recipients = recipients
+		}
+		if err != nil {
+			record.Error = err.Error()
+		} else if len(result.Failed) > 0 {
+			record.Error = fmt.Sprintf("%d recipients failed", len(result.Failed))
+		}
+		recordHistory(record)
+		archiveRecord(record, args.Content, nil)
+	}()
+
+	if len(recipients) == 0 {
+		return result, noProviderError("have no the recipients to send")
+	}
+
+	var names []string
+	var providers []interface{}
+	var chain bool
+	if isEmail {
+		var emails []messageapi.Email
+		names, emails, chain = getEmail(args.tenant, args.Provider)
+		for _, e := range emails {
+			providers = append(providers, e)
+		}
+	} else {
+		var smses []messageapi.SMS
+		names, smses, chain = getSMS(args.tenant, args.Provider)
+		for _, s := range smses {
+			providers = append(providers, s)
+		}
+	}
+	if len(providers) == 0 {
+		return result, noProviderError("have no the " + channel + " provider[" + args.Provider + "]")
+	}
+
+	retry := args.Retry
+	if chain {
+		retry = 0
+	}
+
+	failed := make(map[string]error)
+	pending := recipients
+	for i, provider := range providers {
+		result.Provider = names[i]
+		for attempt := 0; ; attempt++ {
+			errs := sendBulkBy(channel, names[i], provider, args, pending)
+
+			// Retry only the recipients failed by the temporary errors,
+			// or try them by the next provider in the chain.
+			var next []string
+			for _, to := range pending {
+				if e, ok := errs[to]; !ok {
+					sent = append(sent, to)
+					delete(failed, to)
+				} else if failed[to] = e; chain || !messageapi.IsPermanent(e) {
+					next = append(next, to)
+				}
+			}
+			if pending = next; len(pending) == 0 || attempt >= retry {
+				break
+			}
+			delay, ok := retryDelay(attempt, failed[pending[0]])
+			if !ok {
+				break
+			}
+			glog.Errorf("failed to send the bulk %s to %d recipients by %s, retry",
+				channel, len(pending), names[i])
+			time.Sleep(delay)
+		}
+
+		if len(pending) == 0 {
+			break
+		}
+	}
+
+	result.Sent = len(sent)
+	for to, e := range failed {
+		result.Failed = setFailed(result.Failed, to, e.Error())
+	}
+	if result.Sent == 0 {
+		for _, e := range failed {
+			err = e
+			break
+		}
+	}
+	return
+}
+
+// sendBulkBy sends the message to the recipients by the provider, and returns
+// the errors of the recipients failed to send.
+//
+// The native bulk request takes only one token of the rate limit, but the
+// messages sent one by one take one token each.
+func sendBulkBy(channel, name string, provider interface{}, args *Request,
+	recipients []string) map[string]error {
+	failAll := func(err error) map[string]error {
+		failed := make(map[string]error, len(recipients))
+		for _, to := range recipients {
+			failed[to] = err
+		}
+		return failed
+	}
+
+	if isProviderPaused(channel, name) {
+		return failAll(errProviderPaused)
+	}
+
+	failed := make(map[string]error)
+	allowed := make([]string, 0, len(recipients))
+	for _, to := range recipients {
+		if err := checkProviderAllowlist(channel, name, []string{to}); err != nil {
+			failed[to] = err
+		} else if channel != "email" && isSandboxed(channel, name, to) {
+			dryRun(channel, name, to)
+		} else {
+			allowed = append(allowed, to)
+		}
+	}
+	if len(allowed) == 0 {
+		return failed
+	}
+
+	if channel == "email" {
+		if err := reserveWarmup(name, len(allowed)); err != nil {
+			return failAll(err)
+		}
+	}
+
+	ctx := messageapi.WithEmailOptions(context.TODO(), args.emailOptions)
+	ctx = messageapi.WithSMSOptions(ctx, args.smsOptions)
+	ctx = withPayloadLog(ctx, channel, name, args, allowed, args.Subject, args.Content)
+
+	// The redirected emails are sent one by one, each of which has
+	// the header "X-Original-To" of its original recipient.
+	var redirect string
+	if channel == "email" {
+		redirect = getEmailRedirect()
+	}
+
+	var errs map[string]error
+	start := time.Now()
+	bulkEmail, isBulkEmail := provider.(messageapi.BulkEmail)
+	bulkSMS, isBulkSMS := provider.(messageapi.BulkSMS)
+	switch {
+	case channel == "email" && isBulkEmail && redirect == "", channel == "sms" && isBulkSMS:
+		if err := waitRateLimit(channel, name); err != nil {
+			errs = failAll(err)
+		} else if channel == "email" {
+			errs = messageapi.SendBulkEmail(ctx, bulkEmail.(messageapi.Email), allowed,
+				args.Subject, args.Content, nil)
+		} else {
+			errs = messageapi.SendBulkSMS(ctx, bulkSMS.(messageapi.SMS), allowed,
+				args.smsContent(bulkSMS.(messageapi.SMS)))
+		}
+
+	default:
+		errs = make(map[string]error)
+		for _, to := range allowed {
+			var err error
+			if err = waitRateLimit(channel, name); err == nil {
+				if channel == "email" && redirect != "" {
+					opts := args.emailOptions
+					opts.Headers = withHeader(opts.Headers, headerOriginalTo, to)
+					err = provider.(messageapi.Email).SendEmail(
+						messageapi.WithEmailOptions(ctx, opts), []string{redirect},
+						args.Subject, args.Content, nil)
+				} else if channel == "email" {
+					err = provider.(messageapi.Email).SendEmail(ctx, []string{to},
+						args.Subject, args.Content, nil)
+				} else {
+					sms := provider.(messageapi.SMS)
+					err = sms.SendSMS(ctx, to, args.smsContent(sms))
+				}
+			}
+			if err != nil {
+				errs[to] = err
+			}
+		}
+	}
+
+	var err error
+	if len(errs) == len(allowed) {
+		for _, e := range errs {
+			err = e
+			break
+		}
+	}
+	reportResult(channel, name, time.Since(start), err)
+
+	if channel == "email" && len(errs) > 0 {
+		releaseWarmup(name, len(errs))
+	}
+	for to, e := range errs {
+		failed[to] = e
+	}
+	return failed
+}
@@ -0,0 +1,487 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi/internal/scrypt"
+)
+
+// bundleVersion is the version of the format of Bundle.
+const bundleVersion = 1
+
+// The scrypt parameters to derive the key of the bundle from the passphrase.
+const (
+	bundleScryptN = 1 << 15
+	bundleScryptR = 8
+	bundleScryptP = 1
+)
+
+// The sections of Bundle.
+const (
+	SectionConfig       = "config"
+	SectionTemplates    = "templates"
+	SectionRouting      = "routing"
+	SectionSuppressions = "suppressions"
+)
+
+var bundleSections = []string{SectionConfig, SectionTemplates, SectionRouting, SectionSuppressions}
+
+// The options of the configuration in the sections of Bundle
+// other than SectionConfig.
+var (
+	templateOptions = []string{"templates", "partials"}
+	routingOptions  = []string{"sms_routes", "sms_routing", "sms_prices"}
+)
+
+// Bundle is the whole state of the gateway, which is exported by
+// "GET /v1/admin/export" and imported by "POST /v1/admin/import" with the
+// scope "admin:config", such as to back up the gateway or to clone it to
+// a new region.
+//
+// If the header "X-Bundle-Passphrase" is given, the bundle is encrypted by
+// the passphrase as a whole, and only Version, ExportedAt, Salt and Encrypted
+// are set. The key is derived from the passphrase and the random salt by
+// scrypt, so the passphrase is hard to be brute-forced. The secret options
+// in the bundle are not encrypted by the cipher of the gateway, see SetCipher,
+// so it can be imported by the gateway with another master key. The same
+// passphrase is required to import it.
+//
+// The query argument "sections", such as "templates,routing", selects the
+// comma-separated sections to export or import, which are all by default.
+// When importing, the sections not selected or not in the bundle keep the
+// current state, and the suppressions are added to the current ones.
+type Bundle struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at,omitempty"`
+
+	// The configuration as "/v1/config", but without the templates and
+	// the routing rules, which are in the sections below.
+	Config map[string]interface{} `json:"config,omitempty"`
+
+	Templates map[string]Template `json:"templates,omitempty"`
+	Partials  map[string]string   `json:"partials,omitempty"`
+
+	Routing *BundleRouting `json:"routing,omitempty"`
+
+	Suppressions []Suppression `json:"suppressions,omitempty"`
+
+	// The base64 of the salt to derive the key from the passphrase,
+	// and the base64 of the bundle encrypted by the key.
+	Salt      string `json:"salt,omitempty"`
+	Encrypted string `json:"encrypted,omitempty"`
+}
+
+// BundleRouting is the routing rules of the sms in Bundle,
+// see Config.SMSRoutes.
+type BundleRouting struct {
+	SMSRoutes  map[string][]string           `json:"sms_routes,omitempty"`
+	SMSRouting string                        `json:"sms_routing,omitempty"`
+	SMSPrices  map[string]map[string]float64 `json:"sms_prices,omitempty"`
+}
+
+// parseSections parses the comma-separated sections, which are all if empty.
+func parseSections(s string) (map[string]bool, error) {
+	sections := make(map[string]bool, len(bundleSections))
+	if s = strings.TrimSpace(s); s == "" {
+		for _, section := range bundleSections {
+			sections[section] = true
+		}
+		return sections, nil
+	}
+
+	for _, section := range strings.Split(s, ",") {
+		switch section = strings.TrimSpace(section); section {
+		case SectionConfig, SectionTemplates, SectionRouting, SectionSuppressions:
+			sections[section] = true
+		default:
+			return nil, fmt.Errorf("unknown section %s", section)
+		}
+	}
+	return sections, nil
+}
+
+// configMap returns the configuration as the raw options.
+func configMap(conf *Config) (map[string]interface{}, error) {
+	_conf := make(map[string]interface{})
+	if err := decodeJSON(conf, &_conf); err != nil {
+		return nil, err
+	}
+	return _conf, nil
+}
+
+// decryptRaw returns the copy of the raw options, the values of which
+// encrypted by the cipher of the gateway are decrypted.
+func decryptRaw(c Cipher, v interface{}) (interface{}, error) {
+	switch _v := v.(type) {
+	case string:
+		return decryptValue(c, _v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(_v))
+		for k, e := range _v {
+			s, err := decryptRaw(c, e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", k, err)
+			}
+			m[k] = s
+		}
+		return m, nil
+	case []interface{}:
+		vs := make([]interface{}, len(_v))
+		for i, e := range _v {
+			s, err := decryptRaw(c, e)
+			if err != nil {
+				return nil, err
+			}
+			vs[i] = s
+		}
+		return vs, nil
+	default:
+		return v, nil
+	}
+}
+
+// exportBundle returns the bundle of the sections. If encrypted, the secret
+// options are decrypted instead of encrypted by the cipher of the gateway,
+// since the whole bundle will be encrypted, so that it can be imported by
+// the gateway with another master key.
+func exportBundle(conf *Config, sections map[string]bool, encrypted bool) (*Bundle, error) {
+	var err error
+	if !encrypted {
+		if conf, err = exportConfig(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	bundle := &Bundle{Version: bundleVersion, ExportedAt: time.Now().UTC()}
+	if sections[SectionConfig] {
+		if bundle.Config, err = configMap(conf); err != nil {
+			return nil, err
+		}
+		for _, option := range append(templateOptions, routingOptions...) {
+			delete(bundle.Config, option)
+		}
+		if encrypted {
+			v, err := decryptRaw(getCipher(), bundle.Config)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt the configuration: %s", err)
+			}
+			bundle.Config = v.(map[string]interface{})
+		}
+	}
+	if sections[SectionTemplates] {
+		bundle.Templates = conf.Templates
+		bundle.Partials = conf.Partials
+	}
+	if sections[SectionRouting] {
+		bundle.Routing = &BundleRouting{
+			SMSRoutes:  conf.SMSRoutes,
+			SMSRouting: conf.SMSRouting,
+			SMSPrices:  conf.SMSPrices,
+		}
+	}
+	if sections[SectionSuppressions] {
+		if bundle.Suppressions, err = getSuppressionStore().List(); err != nil {
+			return nil, err
+		}
+	}
+	return bundle, nil
+}
+
+// bundleCipher returns the cipher by the key derived from the passphrase
+// and the salt by scrypt.
+func bundleCipher(passphrase string, salt []byte) (Cipher, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, bundleScryptN, bundleScryptR, bundleScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	return NewAESCipher(key)
+}
+
+// sealBundle encrypts the bundle by the passphrase.
+func sealBundle(bundle *Bundle, passphrase string) (*Bundle, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	c, err := bundleCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = c.Encrypt(data); err != nil {
+		return nil, err
+	}
+	return &Bundle{
+		Version:    bundleVersion,
+		ExportedAt: bundle.ExportedAt,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Encrypted:  base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// openBundle decrypts the bundle encrypted by the passphrase.
+func openBundle(bundle *Bundle, passphrase string) (*Bundle, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("the bundle is encrypted, but have no the passphrase")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(bundle.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("the bundle has no the salt of the passphrase")
+	}
+
+	c, err := bundleCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(bundle.Encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = c.Decrypt(data); err != nil {
+		return nil, fmt.Errorf("failed to decrypt the bundle, the passphrase may be wrong")
+	}
+
+	_bundle := new(Bundle)
+	if err = json.Unmarshal(data, _bundle); err != nil {
+		return nil, err
+	}
+	return _bundle, nil
+}
+
+// importConfig returns the configuration, the sections of which are replaced
+// by the ones of the bundle, and the names of the replaced sections.
+func importConfig(current *Config, bundle *Bundle, sections map[string]bool) (
+	conf *Config, replaced []string, err error) {
+	_current, err := configMap(current)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_conf := _current
+	if sections[SectionConfig] && bundle.Config != nil {
+		_conf = make(map[string]interface{}, len(bundle.Config))
+		for k, v := range bundle.Config {
+			_conf[k] = v
+		}
+		for _, option := range append(templateOptions, routingOptions...) {
+			if v, ok := _current[option]; ok {
+				_conf[option] = v
+			} else {
+				delete(_conf, option)
+			}
+		}
+		replaced = append(replaced, SectionConfig)
+	}
+
+	replace := func(section string, options []string, v interface{}) error {
+		m := make(map[string]interface{})
+		if err := decodeJSON(v, &m); err != nil {
+			return err
+		}
+		for _, option := range options {
+			delete(_conf, option)
+			if v := m[option]; v != nil {
+				_conf[option] = v
+			}
+		}
+		replaced = append(replaced, section)
+		return nil
+	}
+	if sections[SectionTemplates] && (bundle.Templates != nil || bundle.Partials != nil) {
+		v := map[string]interface{}{"templates": bundle.Templates, "partials": bundle.Partials}
+		if err = replace(SectionTemplates, templateOptions, v); err != nil {
+			return nil, nil, err
+		}
+	}
+	if sections[SectionRouting] && bundle.Routing != nil {
+		if err = replace(SectionRouting, routingOptions, bundle.Routing); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(replaced) == 0 {
+		return current, nil, nil
+	}
+
+	if conf, err = reparseConfig(current, _conf); err != nil {
+		return nil, nil, err
+	}
+	return conf, replaced, nil
+}
+
+// importBundle resets the configuration, the sections of which are replaced
+// by the ones of the bundle, and returns the names of the replaced sections.
+// It is serialized with the other updates of the configuration.
+func importBundle(bundle *Bundle, sections map[string]bool) ([]string, error) {
+	updateLocker.Lock()
+	defer updateLocker.Unlock()
+
+	configLocker.Lock()
+	current := config
+	configLocker.Unlock()
+
+	conf, replaced, err := importConfig(current, bundle, sections)
+	if err != nil || len(replaced) == 0 {
+		return replaced, err
+	} else if isConfigWatched() {
+		return nil, errConfigWatched
+	}
+	return replaced, ResetConfig(conf)
+}
+
+// handleExport exports the bundle of the gateway, see Bundle.
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if !authorize(_config, ScopeAdminConfig, w, r) {
+		return
+	} else if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	sections, err := parseSections(r.URL.Query().Get("sections"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	passphrase := r.Header.Get("X-Bundle-Passphrase")
+	bundle, err := exportBundle(_config, sections, passphrase != "")
+	if err == nil && passphrase != "" {
+		bundle, err = sealBundle(bundle, passphrase)
+	}
+
+	var content []byte
+	if err == nil {
+		content, err = json.Marshal(bundle)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	filename := "messageapi-" + time.Now().UTC().Format("20060102T150405Z") + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Write(content)
+}
+
+// handleImport imports the bundle exported by handleExport, and responds
+// with the JSON like {"sections": ["config", ...], "suppressions": 10}.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if !authorize(_config, ScopeAdminConfig, w, r) {
+		return
+	} else if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	sections, err := parseSections(r.URL.Query().Get("sections"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	bundle := new(Bundle)
+	if err = json.Unmarshal(buf.Bytes(), bundle); err == nil && bundle.Encrypted != "" {
+		bundle, err = openBundle(bundle, r.Header.Get("X-Bundle-Passphrase"))
+	}
+	if err == nil && bundle.Version != bundleVersion {
+		err = fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	replaced, err := importBundle(bundle, sections)
+	if err == errConfigWatched {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	} else if err != nil {
+		writeConfigError(w, err)
+		return
+	}
+
+	var suppressions int
+	if sections[SectionSuppressions] {
+		store := getSuppressionStore()
+		for _, sp := range bundle.Suppressions {
+			if sp.Channel != "sms" && sp.Channel != "email" || sp.Recipient == "" {
+				continue
+			}
+
+			// Keep the tombstones of the erased recipients, see tombstoneKey.
+			if !strings.HasPrefix(sp.Recipient, "t:") {
+				sp.Recipient = normalizeRecipient(sp.Channel, sp.Recipient)
+			}
+			if sp.CreatedAt.IsZero() {
+				sp.CreatedAt = time.Now()
+			}
+			if err = store.Add(sp); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			suppressions++
+		}
+	}
+
+	glog.Infof("import the bundle exported at %s: sections=%v, suppressions=%d",
+		bundle.ExportedAt.Format(time.RFC3339), replaced, suppressions)
+	if replaced == nil {
+		replaced = []string{}
+	}
+	content, _ := json.Marshal(map[string]interface{}{
+		"sections":     replaced,
+		"suppressions": suppressions,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
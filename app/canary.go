@@ -0,0 +1,274 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// Canary is the self-test of a provider, which periodically sends a canary
+// message to the test recipient to catch the silent breakage of the provider,
+// such as the expired credentials or the suspended account, before the users.
+type Canary struct {
+	// The test recipient, such as the email address, the phone number
+	// or the user id of the messenger.
+	Recipient string `json:"recipient"`
+
+	// The content of the canary message, which is "messageapi canary" with
+	// the time by default. It is also the subject of the email.
+	Content string `json:"content,omitempty"`
+
+	// The number of the seconds between the canary messages,
+	// which is 3600 by default.
+	Interval int `json:"interval,omitempty"`
+
+	// If greater than 0, the canary message must be reported to be delivered
+	// within the seconds, or it fails. It's only for the providers which
+	// report the delivery status, such as "messenger:whatsapp".
+	DeliveryTimeout int `json:"delivery_timeout,omitempty"`
+}
+
+func (c Canary) interval() time.Duration {
+	if c.Interval > 0 {
+		return time.Duration(c.Interval) * time.Second
+	}
+	return time.Hour
+}
+
+// CanaryResult is the result of the last canary message of a provider.
+type CanaryResult struct {
+	// The provider, such as "email:plain" or "sms:NAME".
+	Provider  string `json:"provider"`
+	Recipient string `json:"recipient"`
+
+	// Whether the last canary message succeeded, and whether it was reported
+	// to be delivered if Canary.DeliveryTimeout is set.
+	OK        bool  `json:"ok"`
+	Delivered *bool `json:"delivered,omitempty"`
+
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+
+	// The number of the continuous failures of the canary messages.
+	Failures int `json:"failures"`
+
+	LastRun     time.Time  `json:"last_run"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+var (
+	canaryLocker  = new(sync.Mutex)
+	canaryResults = make(map[string]*CanaryResult)
+	stopCanaries  context.CancelFunc
+)
+
+// startCanaries restarts the canaries of the configuration,
+// and stops the previous ones.
+func startCanaries(c *Config) {
+	canaryLocker.Lock()
+	defer canaryLocker.Unlock()
+
+	if stopCanaries != nil {
+		stopCanaries()
+		stopCanaries = nil
+	}
+	for provider := range canaryResults {
+		if _, ok := c.Canaries[provider]; !ok {
+			delete(canaryResults, provider)
+		}
+	}
+	if len(c.Canaries) == 0 || IsReadOnly() {
+		return
+	}
+
+	var ctx context.Context
+	ctx, stopCanaries = context.WithCancel(context.Background())
+	for provider, canary := range c.Canaries {
+		// Not send the canary again at once when the configuration is reset.
+		var delay time.Duration
+		if last, ok := canaryResults[provider]; ok {
+			delay = canary.interval() - time.Since(last.LastRun)
+		}
+		go runCanary(ctx, provider, canary, delay)
+	}
+}
+
+func runCanary(ctx context.Context, provider string, canary Canary, delay time.Duration) {
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	ticker := time.NewTicker(canary.interval())
+	defer ticker.Stop()
+
+	for {
+		sendCanary(ctx, provider, canary)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendCanary sends the canary message by the provider, and waits for
+// the delivery report if required.
+func sendCanary(ctx context.Context, provider string, canary Canary) {
+	if !featureEnabled(FeatureCanaries) {
+		return
+	}
+
+	result := CanaryResult{Provider: provider, Recipient: canary.Recipient,
+		LastRun: time.Now()}
+
+	content := canary.Content
+	if content == "" {
+		content = "messageapi canary " + result.LastRun.Format(time.RFC3339)
+	}
+
+	start := time.Now()
+	metadata, err := sendCanaryBy(provider, canary.Recipient, content)
+	result.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+
+	if err == nil && canary.DeliveryTimeout > 0 {
+		err = waitCanaryDelivery(ctx, provider, metadata,
+			time.Duration(canary.DeliveryTimeout)*time.Second)
+		delivered := err == nil
+		result.Delivered = &delivered
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	canaryLocker.Lock()
+	defer canaryLocker.Unlock()
+
+	last := canaryResults[provider]
+	if err == nil {
+		result.OK = true
+		result.LastSuccess = &result.LastRun
+	} else {
+		result.Error = err.Error()
+		if last != nil {
+			result.Failures = last.Failures + 1
+			result.LastSuccess = last.LastSuccess
+		} else {
+			result.Failures = 1
+		}
+		glog.Errorf("the canary of the provider %s failed: %s", provider, err)
+	}
+	canaryResults[provider] = &result
+}
+
+// sendCanaryBy sends the canary message by the provider "CHANNEL:NAME".
+func sendCanaryBy(provider, recipient, content string) (map[string]string, error) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	index := strings.IndexByte(provider, ':')
+	if index < 0 {
+		return nil, fmt.Errorf("the provider %s is not like CHANNEL:NAME", provider)
+	}
+
+	channel, name := provider[:index], provider[index+1:]
+	switch channel {
+	case "email":
+		if email, ok := _config.emails[name]; ok {
+			return sendEmailBy(name, email, &Request{Subject: content,
+				Content: content, tos: []string{recipient}})
+		}
+	case "sms":
+		if sms, ok := _config.smses[name]; ok {
+			return sendSMSBy(name, sms, &Request{Phone: recipient, Content: content})
+		}
+	case "messenger":
+		if messenger, ok := _config.messengers[name]; ok {
+			return sendMessageBy(name, messenger, &MessageRequest{
+				Message: messageapi.Message{To: recipient, Content: content}})
+		}
+	default:
+		return nil, fmt.Errorf("the channel %s does not support the canary", channel)
+	}
+	return nil, fmt.Errorf("have no the %s provider[%s]", channel, name)
+}
+
+// waitCanaryDelivery waits for the delivery report of the canary message.
+func waitCanaryDelivery(ctx context.Context, provider string,
+	metadata map[string]string, timeout time.Duration) error {
+	name := strings.TrimPrefix(provider, "messenger:")
+	vendorID := metadata[messageapi.ResultMessageID]
+	if !deliveryReported[name] || name == provider {
+		return fmt.Errorf("the provider %s does not report the delivery", provider)
+	} else if vendorID == "" {
+		return fmt.Errorf("the provider %s returns no message id", provider)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-expectDelivery(name, vendorID, ""):
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("not reported to be delivered within %s", timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func getCanaryResults() []CanaryResult {
+	canaryLocker.Lock()
+	results := make([]CanaryResult, 0, len(canaryResults))
+	for _, r := range canaryResults {
+		results = append(results, *r)
+	}
+	canaryLocker.Unlock()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Provider < results[j].Provider })
+	return results
+}
+
+// handleCanaryStats returns the results of the canaries by "GET",
+// which needs the scope "read:stats".
+func handleCanaryStats(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeReadStats, w, r) || rejectTenant(_config, w, r) {
+		return
+	}
+
+	content, err := json.Marshal(getCanaryResults())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
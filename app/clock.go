@@ -0,0 +1,135 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const defaultClockDriftWarning = 60
+
+// clockWarnInterval is the minimum interval between the warnings of the drift.
+const clockWarnInterval = time.Minute
+
+// ClockStats is the statistics of the clock skew between the clients and
+// the server, which is estimated by the timestamps of the signed requests
+// whose signatures are verified.
+//
+// The skews are in seconds, which are positive if the clock of the client
+// is ahead of the server.
+type ClockStats struct {
+	Samples  int64   `json:"samples"`
+	Drifted  int64   `json:"drifted"`  // Beyond Config.ClockDriftWarning
+	MaxSkew  float64 `json:"max_skew"` // The largest in the absolute value
+	LastSkew float64 `json:"last_skew"`
+	LastTime int64   `json:"last_time,omitempty"` // The unix time of the last drift
+}
+
+var (
+	clockLocker   = new(sync.Mutex)
+	clockStats    ClockStats
+	clockLastWarn time.Time
+)
+
+// getClockSkew returns the tolerated clock skew of the configuration.
+func getClockSkew() time.Duration {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+	return _config.clockSkew()
+}
+
+func (c *Config) clockSkew() time.Duration {
+	if c.ClockSkew <= 0 {
+		return 0
+	}
+	return time.Duration(c.ClockSkew) * time.Second
+}
+
+// observeClockSkew records the skew of the timestamp of the request from
+// the client, and warns if it drifts beyond Config.ClockDriftWarning, which
+// suggests that the clock of the client or the server is wrong.
+func observeClockSkew(c *Config, client string, skew time.Duration) {
+	threshold := time.Duration(defaultClockDriftWarning) * time.Second
+	if c.ClockDriftWarning < 0 {
+		threshold = 0
+	} else if c.ClockDriftWarning > 0 {
+		threshold = time.Duration(c.ClockDriftWarning) * time.Second
+	}
+
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+
+	now := time.Now()
+	clockLocker.Lock()
+	clockStats.Samples++
+	clockStats.LastSkew = skew.Seconds()
+	if abs.Seconds() > absFloat(clockStats.MaxSkew) {
+		clockStats.MaxSkew = skew.Seconds()
+	}
+
+	var warn bool
+	if threshold > 0 && abs > threshold {
+		clockStats.Drifted++
+		clockStats.LastTime = now.Unix()
+		if now.Sub(clockLastWarn) >= clockWarnInterval {
+			clockLastWarn = now
+			warn = true
+		}
+	}
+	clockLocker.Unlock()
+
+	if warn {
+		glog.Warningf("the clock of the client %s differs from the server by %s, "+
+			"please check NTP of both", client, skew)
+	}
+}
+
+func absFloat(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+func getClockStats() ClockStats {
+	clockLocker.Lock()
+	defer clockLocker.Unlock()
+	return clockStats
+}
+
+// handleClockStats returns the statistics of the clock skew by "GET",
+// which needs the scope "read:stats".
+func handleClockStats(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeReadStats, w, r) || rejectTenant(_config, w, r) {
+		return
+	}
+
+	content, err := json.Marshal(getClockStats())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
@@ -2,6 +2,8 @@ package app
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/xgfone/go-tools/validation"
 	"github.com/xgfone/messageapi"
@@ -26,15 +28,336 @@ type Config struct {
 
 	// The configuration of all the email providers. The key is the name of the
 	// provider, and the value is its configuration information.
+	//
+	// The reserved option "enabled" of the provider, which is true by default,
+	// disables the provider if false, which is kept in the configuration but
+	// not loaded, and is skipped by the routing, such as the chains, "all" and
+	// the routes. So it is turned off and on again without removing and
+	// re-adding its credentials. It is the same for the providers below.
 	Emails map[string]map[string]string `json:"emails,omitempty"`
 
 	// The configuration of all the sms providers. The key is the name of the
 	// provider, and the value is its configuration information.
 	SMSes map[string]map[string]string `json:"smses,omitempty"`
 
-	key    string
-	emails map[string]messageapi.Email
-	smses  map[string]messageapi.SMS
+	// The configuration of all the mms providers. The key is the name of the
+	// provider, and the value is its configuration information.
+	MMSes map[string]map[string]string `json:"mmses,omitempty"`
+
+	// The configuration of all the messenger providers, such as "whatsapp".
+	// The key is the name of the provider, and the value is its configuration
+	// information.
+	Messengers map[string]map[string]string `json:"messengers,omitempty"`
+
+	// The default options merged into the configuration of every provider
+	// above, such as the shared "timeout" and "http_proxy", which are
+	// overridden by the options of the provider.
+	//
+	// The option "from_domain" is the domain appended to the option "from"
+	// of the email providers without "@", such as "noreply".
+	Defaults map[string]string `json:"defaults,omitempty"`
+
+	// The options of the HTTP server started by Start, such as the timeouts.
+	Server ServerOptions `json:"server,omitempty"`
+
+	// The extra addresses on which the server started by Start listens,
+	// such as a Unix socket or the localhost HTTP only for the admin APIs.
+	Listeners []Listener `json:"listeners,omitempty"`
+
+	// The options of the HTTP transport shared by all the HTTP API providers,
+	// such as the proxy and the connection pool. If nil, keep the current one.
+	HTTP *messageapi.HTTPOptions `json:"http,omitempty"`
+
+	// The cache of the DNS lookups of the providers. If nil, keep the current one.
+	DNSCache *messageapi.DNSCacheOptions `json:"dns_cache,omitempty"`
+
+	// The limits of the attachments of the email uploaded or fetched from
+	// the URLs by the request.
+	AttachmentLimits AttachmentLimits `json:"attachment_limits,omitempty"`
+
+	// The public base URL of the server, such as "https://gw.example.com",
+	// by which the carriers fetch the media generated by the server,
+	// such as the vCard of the MMS.
+	//
+	// If TokenSecret is configured, the small media, such as the vCard, is
+	// carried by the signed link, which is served by any instance and valid
+	// for 24 hours. Or, the media is kept in the memory of the instance that
+	// generates it, which keeps at most 1000 media.
+	MediaBaseURL string `json:"media_base_url,omitempty"`
+
+	// The public base URL of the server called by the vendors, such as
+	// "https://hooks.example.com", by which the signature of the webhooks
+	// over the full URL, such as Twilio, is verified. If empty, use the host
+	// and the scheme of the request, which may be wrong behind the proxy.
+	WebhookBaseURL string `json:"webhook_base_url,omitempty"`
+
+	// The number of the continuous failures, after which the provider is
+	// considered to be down and skipped by "all" for BreakerTimeout seconds.
+	// The default is 3, and a negative number disables it.
+	BreakerThreshold int `json:"breaker_threshold,omitempty"`
+
+	// The number of the seconds during which a down provider is skipped.
+	// The default is 60.
+	BreakerTimeout int `json:"breaker_timeout,omitempty"`
+
+	// The latency SLOs of the providers. The key is the provider like
+	// "CHANNEL:NAME", such as "sms:twilio", or "*" for the others.
+	// The degraded providers are tried after the others, see LatencySLO.
+	LatencySLOs map[string]LatencySLO `json:"latency_slos,omitempty"`
+
+	// The number of the seconds between the rotations of the short-lived
+	// credentials of the providers implementing messageapi.Rotator, such as
+	// the OAuth access tokens. The default is 300. They are also rotated
+	// when the configuration is reset.
+	RotateInterval int `json:"rotate_interval,omitempty"`
+
+	// The API keys and their scopes, such as "send:sms", "send:email" and
+	// "admin:config", or "*" for all. The key is the API key, which is given
+	// by the header "X-API-Key" or the query argument "key" in the request.
+	//
+	// If it is empty, the APIs to send the message need no key.
+	Keys map[string][]string `json:"keys,omitempty"`
+
+	// The sender identities. The key is the name of the identity,
+	// which is referred by the option "identity" in the request.
+	Identities map[string]Identity `json:"identities,omitempty"`
+
+	// The identities which the API key may use. The key is the API key.
+	// The API key without the entry may use any identity.
+	KeyIdentities map[string][]string `json:"key_identities,omitempty"`
+
+	// The email domains and the phone prefixes to which the API key may send,
+	// such as ["@mycompany.com", "*.mycompany.com", "+8613800000000"], so that
+	// the key of the test environment cannot message the real customers.
+	// The key is the API key, and the key without the entry may send to all.
+	// The recipients of the fallback steps of the request are also checked.
+	KeyAllowlists map[string][]string `json:"key_allowlists,omitempty"`
+
+	// The allowlists of the providers like KeyAllowlists. The key is
+	// "CHANNEL:PROVIDER", such as "email:plain" and "sms:twilio". If the
+	// recipient is not allowed, the message is sent by the next provider
+	// in the chain, or fails.
+	ProviderAllowlists map[string][]string `json:"provider_allowlists,omitempty"`
+
+	// The sandbox numbers of the sms and mms providers. The key is
+	// "CHANNEL:PROVIDER", such as "sms:twilio". If the provider has the entry,
+	// it is in the sandbox mode, that's, only the messages to the listed
+	// numbers are sent, and the others are not sent but recorded with the
+	// status "dry_run" and the metadata "dry_run", such as in the staging
+	// environment with the real vendor account.
+	SandboxNumbers map[string][]string `json:"sandbox_numbers,omitempty"`
+
+	// If not empty, all the recipients of the emails are rewritten to the
+	// address, such as the catch-all mailbox of the staging environment,
+	// and the original ones are recorded by the header "X-Original-To" of
+	// the email and the history. The environment variable
+	// MESSAGEAPI_EMAIL_REDIRECT overrides it.
+	EmailRedirect string `json:"email_redirect,omitempty"`
+
+	// The secrets of the API keys. The key is the API key, and the value is
+	// its secret. If an API key has a secret, the API key is only used as the
+	// key id, and the requests with it must be signed by HMAC-SHA256 with the
+	// secret, which carry the headers "X-Timestamp", "X-Nonce" and "X-Signature".
+	//
+	// The signed string is "METHOD\nREQUEST_URI\nTIMESTAMP\nNONCE\nHEX(SHA256(BODY))",
+	// and the signature is the hex-encoded HMAC-SHA256 of it.
+	Secrets map[string]string `json:"secrets,omitempty"`
+
+	// The number of the seconds that the timestamp of the signed request is
+	// allowed to differ from the server time. The default is 300. A nonce can
+	// be used only once within it.
+	SignTolerance int `json:"sign_tolerance,omitempty"`
+
+	// The number of the seconds of the clock skew tolerated between the hosts,
+	// which is added to SignTolerance, and to the expiry of the send tokens
+	// and the ack links minted by the other instances. The default is 0.
+	ClockSkew int `json:"clock_skew,omitempty"`
+
+	// If the timestamp of the signed request differs from the server time
+	// by more than the seconds, which is 60 by default, warn that the clock
+	// of the client or the server drifts, see "/v1/stats/clock".
+	// A negative number disables it.
+	ClockDriftWarning int `json:"clock_drift_warning,omitempty"`
+
+	// The secret to sign the one-time send tokens, which are minted by the
+	// "/v1/token" API. If it is empty, the send tokens are not supported.
+	TokenSecret string `json:"token_secret,omitempty"`
+
+	// The webhook urls to which the inbound emails are forwarded as JSON,
+	// which are received by SMTPRelay in the inbound mode or "/v1/inbound/email".
+	InboundEmailWebhooks []string `json:"inbound_email_webhooks,omitempty"`
+
+	// The webhook urls to which the inbound sms are forwarded as JSON,
+	// which are received by "/v1/inbound/sms/PROVIDER", see InboundSMS.
+	// They are also published to EventBus as the "received" events.
+	InboundSMSWebhooks []string `json:"inbound_sms_webhooks,omitempty"`
+
+	// The secrets to verify the signatures of the inbound webhooks of the
+	// vendors, by which the requests are authorized instead of the API key.
+	// The key is the vendor: "twilio" with the auth token for
+	// "/v1/inbound/sms/twilio", "mailgun" with the HTTP webhook signing key
+	// and "sendgrid" with the public key of the signed webhook for
+	// "/v1/inbound/email". Slack is verified by Integration.Secret.
+	//
+	// The signature of Twilio covers the full URL, see WebhookBaseURL, and
+	// the token of Mailgun is used only once, see SetNonceStore.
+	WebhookSecrets map[string]string `json:"webhook_secrets,omitempty"`
+
+	// The signing and the retry of the outbound webhooks, such as
+	// InboundEmailWebhooks and InboundSMSWebhooks, see WebhookOptions.
+	Webhooks WebhookOptions `json:"webhooks,omitempty"`
+
+	// The event bus, to which the lifecycle events of the messages are
+	// published, such as Redis streams, NATS or Kafka, see Event.
+	EventBus *EventBus `json:"event_bus,omitempty"`
+
+	// The archive of the sent messages to the object storage, see Archive.
+	Archive *Archive `json:"archive,omitempty"`
+
+	// The privacy mode, in which the recipients are logged and published
+	// only as the salted hashes, see Privacy.
+	Privacy *Privacy `json:"privacy,omitempty"`
+
+	// The policy to reject the low priority messages when overloaded,
+	// see LoadShedding.
+	LoadShedding *LoadShedding `json:"load_shedding,omitempty"`
+
+	// The tenants, the resources of which are managed by the admin keys of
+	// the tenants themselves, see Tenant.
+	Tenants map[string]Tenant `json:"tenants,omitempty"`
+
+	// The name of the environment, such as "dev", "staging" or "prod", the
+	// configuration of which is selected, see SetEnvironment.
+	Environment string `json:"environment,omitempty"`
+
+	// The keywords of the inbound sms, by which the sender is added to or
+	// removed from the suppression list. They are matched with the whole
+	// content case-insensitively. If empty, use the standard keywords, that's,
+	// "STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT", "OPTOUT",
+	// "REVOKE", "TD" and "退订" to stop, and "START" and "UNSTOP" to start.
+	StopKeywords  []string `json:"stop_keywords,omitempty"`
+	StartKeywords []string `json:"start_keywords,omitempty"`
+
+	// If true, don't handle the STOP and START keywords of the inbound sms.
+	DisableSMSKeywords bool `json:"disable_sms_keywords,omitempty"`
+
+	// The policy of the characters of the sms out of the GSM 7-bit alphabet,
+	// such as the emojis and the smart quotes, which force the UCS-2 encoding
+	// and triple the number of the segments. It is "allow" by default to send
+	// them as they are, "transliterate" to replace them with the look-alikes
+	// if any, or "strip" to replace them and remove the others, which falls
+	// back to UCS-2 if it would remove most of the content. The request
+	// may allow them explicitly by Request.Unicode.
+	SMSEncoding string `json:"sms_encoding,omitempty"`
+
+	// The splitting of the long sms into the numbered parts like "(1/3) ...",
+	// which are sent sequentially, for the sms providers which don't handle
+	// the concatenation. The key is the name of the sms provider.
+	SMSSplits map[string]SMSSplit `json:"sms_splits,omitempty"`
+
+	// The routing table of the sms, which maps the country code of the E.164
+	// phone, such as "86" or "1", to the provider chain tried in order.
+	// The key "default" is used if no country code matches. The longest
+	// country code matches first, so you may use the longer prefix, such as
+	// "1876", for a region.
+	//
+	// It is used only if the provider is not given in the request.
+	SMSRoutes map[string][]string `json:"sms_routes,omitempty"`
+
+	// The routing strategy of the sms. If it is "least_cost", the providers
+	// are tried by the price to the phone in ascending order, see SMSPrices.
+	// The default is to try them in the order of the routing table.
+	SMSRouting string `json:"sms_routing,omitempty"`
+
+	// The prices of the sms providers. The key is the name of the provider,
+	// and the value is the price table, which maps the country code to the
+	// price of a message. The key "default" is the price of the other countries.
+	SMSPrices map[string]map[string]float64 `json:"sms_prices,omitempty"`
+
+	// The locales of the country codes of the E.164 phone, such as
+	// {"86": "zh-CN", "33": "fr"}, which detect the locale of the template
+	// of the sms if the request has no variable "locale" and the recipient
+	// has no preferred locale, see Template.Locales. The longest country code
+	// matches first, and the key "default" is used if none matches.
+	CountryLocales map[string]string `json:"country_locales,omitempty"`
+
+	// The outbound rate limits of the providers, that's, the maximum number
+	// of the messages per second. The key is the provider like "CHANNEL:NAME",
+	// such as "email:plain" or "sms:NAME".
+	//
+	// The excess messages wait for at most RateLimitWait seconds, or they are
+	// deferred by the temporary error, or sent by the next provider in the chain.
+	RateLimits map[string]float64 `json:"rate_limits,omitempty"`
+
+	// The maximum number of the seconds to wait for the rate limit.
+	// The default is 10.
+	//
+	// When the provider asks to retry later, such as by the header "Retry-After"
+	// of the response 429, the provider is paused until then, and the retry
+	// waits for it instead of the generic backoff. If it is longer than
+	// RateLimitWait, the message is not retried by the provider but sent by
+	// the next one in the chain, or fails with the header "Retry-After".
+	RateLimitWait int `json:"rate_limit_wait,omitempty"`
+
+	// The IP warm-up schedules of the email providers. The key is the name
+	// of the email provider. When the daily limit is reached, the emails are
+	// sent by the next provider in the chain, such as "plain,secondary".
+	Warmups map[string]Warmup `json:"warmups,omitempty"`
+
+	// The templates of the messages. The key is the name of the template,
+	// which is referred by the option "template" in the request.
+	Templates map[string]Template `json:"templates,omitempty"`
+
+	// The digests of the messages. The key is the digest key,
+	// which is referred by the option "digest" in the request.
+	Digests map[string]Digest `json:"digests,omitempty"`
+
+	// The canaries of the providers, which periodically send the canary
+	// messages to the test recipients, see "/v1/stats/canaries". The key is
+	// the provider like "CHANNEL:NAME", the CHANNEL of which is one of "email",
+	// "sms" and "messenger", such as "sms:twilio".
+	Canaries map[string]Canary `json:"canaries,omitempty"`
+
+	// The debug logging of the requests to the vendors and their responses,
+	// which may be also enabled at runtime by "/v1/admin/payloads".
+	PayloadLogging PayloadLogging `json:"payload_logging,omitempty"`
+
+	// The feature flags, such as {"payload_logging": true}, which may be
+	// overridden at runtime by "/v1/admin/features", see FeaturePayloadLogging.
+	Features map[string]bool `json:"features,omitempty"`
+
+	// The caps of the messages by the tag. The key is the tag,
+	// which is referred by the option "tag" in the request.
+	TagLimits map[string]TagLimit `json:"tag_limits,omitempty"`
+
+	// The inbound integrations, such as "alertmanager". The key is the name
+	// of the integration, the events of which are posted to
+	// "/v1/integrations/NAME" and sent by the routes.
+	Integrations map[string]Integration `json:"integrations,omitempty"`
+
+	// The partials shared by the templates, such as the header, the footer
+	// and the layouts. The key is the name of the partial.
+	Partials map[string]string `json:"partials,omitempty"`
+
+	key              string
+	keys             map[string][]string // Keys and the keys of Tenants.
+	keyTenants       map[string]string
+	routeCodes       countryCodes
+	localeCodes      countryCodes
+	priceCodes       map[string]countryCodes
+	tokenSecret      string
+	secrets          map[string]string
+	webhookSecrets   map[string]string
+	webhookSecret    string
+	eventBusPassword string
+	archiveSecret    string
+	privacySalt      string
+	emails           map[string]messageapi.Email
+	smses            map[string]messageapi.SMS
+	mmses            map[string]messageapi.MMS
+	messengers       map[string]messageapi.Messenger
+	disabled         map[string]bool // The key is like "sms:NAME".
+	tenantProviders  map[string]map[string]messageapi.Config
 }
 
 // NewDefaultConfig returns a default configuration.
@@ -50,6 +373,54 @@ func NewDefaultConfig(key string) *Config {
 	}
 }
 
+// providerOptions returns a copy of the options of the provider of the
+// channel, which are merged with Config.Defaults.
+func (c *Config) providerOptions(channel string, options map[string]string) map[string]string {
+	if len(c.Defaults) == 0 {
+		return options
+	}
+
+	results := make(map[string]string, len(options)+len(c.Defaults))
+	for k, v := range c.Defaults {
+		results[k] = v
+	}
+	for k, v := range options {
+		results[k] = v
+	}
+
+	if domain := results["from_domain"]; channel == "email" && domain != "" {
+		if from := results["from"]; from != "" && !strings.Contains(from, "@") {
+			results["from"] = from + "@" + strings.TrimPrefix(domain, "@")
+		}
+	}
+	return results
+}
+
+// providerEnabled reports whether the provider is enabled by its reserved
+// option "enabled", which is true by default.
+func providerEnabled(options map[string]string) (bool, error) {
+	if v := options["enabled"]; v != "" {
+		return strconv.ParseBool(v)
+	}
+	return true, nil
+}
+
+// skipDisabled returns the names of the providers of the channel
+// without the disabled ones.
+func (c *Config) skipDisabled(channel string, names []string) []string {
+	if len(c.disabled) == 0 {
+		return names
+	}
+
+	enabled := make([]string, 0, len(names))
+	for _, name := range names {
+		if !c.disabled[channel+":"+name] {
+			enabled = append(enabled, name)
+		}
+	}
+	return enabled
+}
+
 // ResetConfig resets the global default configuration.
 //
 // Only use this function when you don't call Start and implement it youself.
@@ -57,48 +428,222 @@ func NewDefaultConfig(key string) *Config {
 // Notice: You can call this function to change the configuration at any time.
 // And it's necessary to give the whole configuration options When resetting
 // the configuration.
+//
+// If any provider fails to be loaded, the error is ConfigErrors of all the
+// providers failed.
 func ResetConfig(conf *Config) error {
 	if conf == nil {
 		return nil
 	}
 
+	// Load all the providers, and report all the errors at once.
+	var errs ConfigErrors
+	disabled := make(map[string]bool)
 	_emails := make(map[string]messageapi.Email)
 	for n, c := range conf.Emails {
+		if enabled, _ := providerEnabled(c); !enabled {
+			disabled["email:"+n] = true
+			continue
+		}
+
 		provider := messageapi.GetEmail(n)
 		if provider == nil {
 			if conf.IgnoreNotSupportedProvider {
 				continue
 			}
-			return fmt.Errorf("have no the email provider[%s]", n)
+			errs.add("emails."+n, "have no the email provider[%s]", n)
+			continue
 		}
 
-		if err := provider.Load(c); err != nil {
-			return fmt.Errorf("Failed to load the email configuration, err=%s", err)
+		c, err := decryptOptions(conf.providerOptions("email", c))
+		if err == nil {
+			c, err = messageapi.ApplySchema(messageapi.GetConfigSchema(provider), c)
+		}
+		if err == nil {
+			err = provider.Load(c)
+		}
+		if err != nil {
+			errs.add("emails."+n, "Failed to load the email configuration, err=%s", err)
+			continue
 		}
 		_emails[n] = provider
 	}
 
 	_smses := make(map[string]messageapi.SMS)
 	for n, c := range conf.SMSes {
+		if enabled, _ := providerEnabled(c); !enabled {
+			disabled["sms:"+n] = true
+			continue
+		}
+
 		provider := messageapi.GetSMS(n)
 		if provider == nil {
 			if conf.IgnoreNotSupportedProvider {
 				continue
 			}
-			return fmt.Errorf("have no the sms provider[%s]", n)
+			errs.add("smses."+n, "have no the sms provider[%s]", n)
+			continue
 		}
 
-		if err := provider.Load(c); err != nil {
-			return fmt.Errorf("Failed to load the sms configuration, err=%s", err)
+		c, err := decryptOptions(conf.providerOptions("sms", c))
+		if err == nil {
+			c, err = messageapi.ApplySchema(messageapi.GetConfigSchema(provider), c)
+		}
+		if err == nil {
+			err = provider.Load(c)
+		}
+		if err != nil {
+			errs.add("smses."+n, "Failed to load the sms configuration, err=%s", err)
+			continue
 		}
 		_smses[n] = provider
 	}
 
-	conf.emails = _emails
-	conf.smses = _smses
+	_mmses := make(map[string]messageapi.MMS)
+	for n, c := range conf.MMSes {
+		if enabled, _ := providerEnabled(c); !enabled {
+			disabled["mms:"+n] = true
+			continue
+		}
+
+		provider := messageapi.GetMMS(n)
+		if provider == nil {
+			if conf.IgnoreNotSupportedProvider {
+				continue
+			}
+			errs.add("mmses."+n, "have no the mms provider[%s]", n)
+			continue
+		}
+
+		c, err := decryptOptions(conf.providerOptions("mms", c))
+		if err == nil {
+			c, err = messageapi.ApplySchema(messageapi.GetConfigSchema(provider), c)
+		}
+		if err == nil {
+			err = provider.Load(c)
+		}
+		if err != nil {
+			errs.add("mmses."+n, "Failed to load the mms configuration, err=%s", err)
+			continue
+		}
+		_mmses[n] = provider
+	}
+
+	_messengers := make(map[string]messageapi.Messenger)
+	for n, c := range conf.Messengers {
+		if enabled, _ := providerEnabled(c); !enabled {
+			disabled["messenger:"+n] = true
+			continue
+		}
+
+		provider := messageapi.GetMessenger(n)
+		if provider == nil {
+			if conf.IgnoreNotSupportedProvider {
+				continue
+			}
+			errs.add("messengers."+n, "have no the messenger provider[%s]", n)
+			continue
+		}
+
+		c, err := decryptOptions(conf.providerOptions("messenger", c))
+		if err == nil {
+			c, err = messageapi.ApplySchema(messageapi.GetConfigSchema(provider), c)
+		}
+		if err == nil {
+			err = provider.Load(c)
+		}
+		if err != nil {
+			errs.add("messengers."+n, "Failed to load the messenger configuration, err=%s", err)
+			continue
+		}
+		_messengers[n] = provider
+	}
+
+	conf.emails, conf.smses, conf.mmses, conf.messengers = _emails, _smses, _mmses, _messengers
+	tenantProviders, tenantErrs := conf.loadTenantProviders()
+	keys, keyTenants, keyErrs := conf.buildKeys()
+	errs = append(errs, tenantErrs...)
+	if errs = append(errs, keyErrs...); len(errs) > 0 {
+		return errs
+	}
+
+	if conf.HTTP != nil {
+		if err := messageapi.SetHTTPOptions(*conf.HTTP); err != nil {
+			return ConfigErrors{{Path: "http", Message: err.Error()}}
+		}
+	}
+	if conf.DNSCache != nil {
+		messageapi.SetDNSCache(*conf.DNSCache)
+	}
+
+	tokenSecret, err := decryptValue(getCipher(), conf.TokenSecret)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt the token secret, err=%s", err)
+	}
+
+	secrets, err := decryptOptions(conf.Secrets)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt the secrets, err=%s", err)
+	}
+
+	webhookSecrets, err := decryptOptions(conf.WebhookSecrets)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt the webhook secrets, err=%s", err)
+	}
+
+	webhookSecret, err := decryptValue(getCipher(), conf.Webhooks.Secret)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt the webhook secret, err=%s", err)
+	}
+
+	var eventBusPassword string
+	if conf.EventBus != nil {
+		eventBusPassword, err = decryptValue(getCipher(), conf.EventBus.Password)
+		if err != nil {
+			return fmt.Errorf("Failed to decrypt the password of the event bus, err=%s", err)
+		}
+	}
+
+	var archiveSecret string
+	if conf.Archive != nil {
+		if conf.Archive.EncryptContent && getCipher() == nil {
+			return fmt.Errorf("Failed to encrypt the archived content, err=no cipher")
+		}
+		archiveSecret, err = decryptValue(getCipher(), conf.Archive.SecretAccessKey)
+		if err != nil {
+			return fmt.Errorf("Failed to decrypt the secret key of the archive, err=%s", err)
+		}
+	}
+
+	var privacySalt string
+	if conf.Privacy != nil {
+		if conf.Privacy.EncryptHistory && getCipher() == nil {
+			return fmt.Errorf("Failed to encrypt the history, err=no cipher")
+		}
+		privacySalt, err = decryptValue(getCipher(), conf.Privacy.Salt)
+		if err != nil {
+			return fmt.Errorf("Failed to decrypt the salt of the privacy, err=%s", err)
+		}
+	}
+
+	conf.prepareRoutes()
+	conf.keys = keys
+	conf.keyTenants = keyTenants
+	conf.secrets = secrets
+	conf.webhookSecrets = webhookSecrets
+	conf.webhookSecret = webhookSecret
+	conf.eventBusPassword = eventBusPassword
+	conf.archiveSecret = archiveSecret
+	conf.privacySalt = privacySalt
+	conf.tokenSecret = tokenSecret
+	conf.tenantProviders = tenantProviders
+	conf.disabled = disabled
 	configLocker.Lock()
 	config = conf
 	configLocker.Unlock()
+	startRotation(conf)
+	startCanaries(conf)
+	resetEventBus(conf)
 	return nil
 }
 
@@ -137,6 +682,615 @@ func parseConfig(_conf map[string]interface{}) (conf *Config, err error) {
 		conf.DefaultSMSProvider = _v.(string)
 	}
 
+	// Parse the option of breaker_threshold.
+	if _v, ok := _conf["breaker_threshold"]; ok {
+		if !validation.VerifyType(_v, "float64") {
+			return nil, fmt.Errorf("the type of breaker_threshold is not int")
+		}
+		conf.BreakerThreshold = int(_v.(float64))
+	}
+
+	// Parse the option of breaker_timeout.
+	if _v, ok := _conf["breaker_timeout"]; ok {
+		if !validation.VerifyType(_v, "float64") {
+			return nil, fmt.Errorf("the type of breaker_timeout is not int")
+		}
+		conf.BreakerTimeout = int(_v.(float64))
+	}
+
+	// Parse the option of latency_slos.
+	if _v, ok := _conf["latency_slos"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of latency_slos is not json")
+		}
+		if err := decodeJSON(_v, &conf.LatencySLOs); err != nil {
+			return nil, fmt.Errorf("the type of latency_slos is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of rotate_interval.
+	if _v, ok := _conf["rotate_interval"]; ok {
+		if !validation.VerifyType(_v, "float64") {
+			return nil, fmt.Errorf("the type of rotate_interval is not int")
+		}
+		conf.RotateInterval = int(_v.(float64))
+	}
+
+	// Parse the option of keys.
+	if _v, ok := _conf["keys"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of keys is not json")
+		}
+		m := _v.(map[string]interface{})
+		conf.Keys = make(map[string][]string, len(m))
+
+		for key, value := range m {
+			scopes, ok := toStringSlice(value)
+			if !ok {
+				return nil, fmt.Errorf("the scopes of the key are not a string array")
+			}
+			conf.Keys[key] = scopes
+		}
+	}
+
+	// Parse the option of identities.
+	if _v, ok := _conf["identities"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of identities is not json")
+		}
+		if err := decodeJSON(_v, &conf.Identities); err != nil {
+			return nil, fmt.Errorf("the type of identities is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of key_identities.
+	if _v, ok := _conf["key_identities"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of key_identities is not json")
+		}
+		m := _v.(map[string]interface{})
+		conf.KeyIdentities = make(map[string][]string, len(m))
+
+		for key, value := range m {
+			identities, ok := toStringSlice(value)
+			if !ok {
+				return nil, fmt.Errorf("the identities of the key are not a string array")
+			}
+			for _, name := range identities {
+				if _, ok := conf.Identities[name]; !ok {
+					return nil, fmt.Errorf("have no the identity[%s] of the key", name)
+				}
+			}
+			conf.KeyIdentities[key] = identities
+		}
+	}
+
+	// Parse the option of key_allowlists.
+	if _v, ok := _conf["key_allowlists"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of key_allowlists is not json")
+		}
+		if err := decodeJSON(_v, &conf.KeyAllowlists); err != nil {
+			return nil, fmt.Errorf("the type of key_allowlists is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of provider_allowlists.
+	if _v, ok := _conf["provider_allowlists"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of provider_allowlists is not json")
+		}
+		if err := decodeJSON(_v, &conf.ProviderAllowlists); err != nil {
+			return nil, fmt.Errorf("the type of provider_allowlists is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of sandbox_numbers.
+	if _v, ok := _conf["sandbox_numbers"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of sandbox_numbers is not json")
+		}
+		if err := decodeJSON(_v, &conf.SandboxNumbers); err != nil {
+			return nil, fmt.Errorf("the type of sandbox_numbers is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of email_redirect.
+	if _v, ok := _conf["email_redirect"]; ok {
+		if !validation.VerifyType(_v, "string") {
+			return nil, fmt.Errorf("the type of email_redirect is not string")
+		}
+		conf.EmailRedirect = _v.(string)
+	}
+
+	// Parse the option of secrets.
+	if _v, ok := _conf["secrets"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of secrets is not json")
+		}
+		v, ok := toStringMap(_v.(map[string]interface{}))
+		if !ok {
+			return nil, fmt.Errorf("the type of the value of secrets is wrong")
+		}
+		conf.Secrets = v
+	}
+
+	// Parse the option of sign_tolerance.
+	if _v, ok := _conf["sign_tolerance"]; ok {
+		if !validation.VerifyType(_v, "float64") {
+			return nil, fmt.Errorf("the type of sign_tolerance is not int")
+		}
+		conf.SignTolerance = int(_v.(float64))
+	}
+
+	// Parse the option of clock_skew.
+	if _v, ok := _conf["clock_skew"]; ok {
+		if !validation.VerifyType(_v, "float64") {
+			return nil, fmt.Errorf("the type of clock_skew is not int")
+		}
+		conf.ClockSkew = int(_v.(float64))
+	}
+
+	// Parse the option of clock_drift_warning.
+	if _v, ok := _conf["clock_drift_warning"]; ok {
+		if !validation.VerifyType(_v, "float64") {
+			return nil, fmt.Errorf("the type of clock_drift_warning is not int")
+		}
+		conf.ClockDriftWarning = int(_v.(float64))
+	}
+
+	// Parse the option of token_secret.
+	if _v, ok := _conf["token_secret"]; ok {
+		if !validation.VerifyType(_v, "string") {
+			return nil, fmt.Errorf("the type of token_secret is not string")
+		}
+		conf.TokenSecret = _v.(string)
+	}
+
+	// Parse the option of inbound_email_webhooks.
+	if _v, ok := _conf["inbound_email_webhooks"]; ok {
+		v, ok := toStringSlice(_v)
+		if !ok {
+			return nil, fmt.Errorf("the type of inbound_email_webhooks is not a string array")
+		}
+		conf.InboundEmailWebhooks = v
+	}
+
+	// Parse the option of webhook_secrets.
+	if _v, ok := _conf["webhook_secrets"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of webhook_secrets is not json")
+		}
+		v, ok := toStringMap(_v.(map[string]interface{}))
+		if !ok {
+			return nil, fmt.Errorf("the type of the value of webhook_secrets is wrong")
+		}
+		for vendor := range v {
+			if vendor != "twilio" && vendor != "mailgun" && vendor != "sendgrid" {
+				return nil, fmt.Errorf("the webhook secret of %s is not supported", vendor)
+			}
+		}
+		conf.WebhookSecrets = v
+	}
+
+	// Parse the option of webhooks.
+	if _v, ok := _conf["webhooks"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of webhooks is not json")
+		}
+		if err := decodeJSON(_v, &conf.Webhooks); err != nil {
+			return nil, fmt.Errorf("the type of webhooks is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of event_bus.
+	if _v, ok := _conf["event_bus"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of event_bus is not json")
+		}
+		if err := decodeJSON(_v, &conf.EventBus); err != nil {
+			return nil, fmt.Errorf("the type of event_bus is wrong: %s", err)
+		} else if conf.EventBus != nil {
+			switch conf.EventBus.Type {
+			case "redis", "nats", "kafka":
+			default:
+				return nil, fmt.Errorf("the event bus %s is not supported", conf.EventBus.Type)
+			}
+		}
+	}
+
+	// Parse the option of archive.
+	if _v, ok := _conf["archive"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of archive is not json")
+		}
+		if err := decodeJSON(_v, &conf.Archive); err != nil {
+			return nil, fmt.Errorf("the type of archive is wrong: %s", err)
+		} else if conf.Archive != nil && (conf.Archive.Endpoint == "" || conf.Archive.Bucket == "") {
+			return nil, fmt.Errorf("the archive: the endpoint or the bucket is empty")
+		}
+	}
+
+	// Parse the option of privacy.
+	if _v, ok := _conf["privacy"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of privacy is not json")
+		}
+		if err := decodeJSON(_v, &conf.Privacy); err != nil {
+			return nil, fmt.Errorf("the type of privacy is wrong: %s", err)
+		} else if conf.Privacy != nil && conf.Privacy.Salt == "" {
+			return nil, fmt.Errorf("the privacy: the salt is empty")
+		}
+	}
+
+	// Parse the option of environment.
+	if _v, ok := _conf["environment"]; ok {
+		if !validation.VerifyType(_v, "string") {
+			return nil, fmt.Errorf("the type of environment is not string")
+		}
+		conf.Environment = _v.(string)
+	}
+
+	// Parse the option of load_shedding.
+	if _v, ok := _conf["load_shedding"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of load_shedding is not json")
+		}
+		if err := decodeJSON(_v, &conf.LoadShedding); err != nil {
+			return nil, fmt.Errorf("the type of load_shedding is wrong: %s", err)
+		} else if conf.LoadShedding != nil {
+			for category, priority := range conf.LoadShedding.Priorities {
+				if priority == "" || !validPriority(priority) {
+					return nil, fmt.Errorf("the load_shedding: invalid priority %s of the category %s",
+						priority, category)
+				}
+			}
+		}
+	}
+
+	// Parse the option of inbound_sms_webhooks.
+	if _v, ok := _conf["inbound_sms_webhooks"]; ok {
+		v, ok := toStringSlice(_v)
+		if !ok {
+			return nil, fmt.Errorf("the type of inbound_sms_webhooks is not a string array")
+		}
+		conf.InboundSMSWebhooks = v
+	}
+
+	// Parse the option of stop_keywords.
+	if _v, ok := _conf["stop_keywords"]; ok {
+		v, ok := toStringSlice(_v)
+		if !ok {
+			return nil, fmt.Errorf("the type of stop_keywords is not a string array")
+		}
+		conf.StopKeywords = v
+	}
+
+	// Parse the option of start_keywords.
+	if _v, ok := _conf["start_keywords"]; ok {
+		v, ok := toStringSlice(_v)
+		if !ok {
+			return nil, fmt.Errorf("the type of start_keywords is not a string array")
+		}
+		conf.StartKeywords = v
+	}
+
+	// Parse the option of disable_sms_keywords.
+	if _v, ok := _conf["disable_sms_keywords"]; ok {
+		if !validation.VerifyType(_v, "bool") {
+			return nil, fmt.Errorf("the type of disable_sms_keywords is not bool")
+		}
+		conf.DisableSMSKeywords = _v.(bool)
+	}
+
+	// Parse the option of sms_encoding.
+	if _v, ok := _conf["sms_encoding"]; ok {
+		if !validation.VerifyType(_v, "string") {
+			return nil, fmt.Errorf("the type of sms_encoding is not string")
+		}
+		switch conf.SMSEncoding = _v.(string); conf.SMSEncoding {
+		case "", SMSEncodingAllow, SMSEncodingTransliterate, SMSEncodingStrip:
+		default:
+			return nil, fmt.Errorf("unknown sms_encoding %s", conf.SMSEncoding)
+		}
+	}
+
+	// Parse the option of sms_splits.
+	if _v, ok := _conf["sms_splits"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of sms_splits is not json")
+		}
+		if err := decodeJSON(_v, &conf.SMSSplits); err != nil {
+			return nil, fmt.Errorf("the type of sms_splits is wrong: %s", err)
+		}
+		for name, s := range conf.SMSSplits {
+			if err := s.validate(); err != nil {
+				return nil, fmt.Errorf("the splitting of the sms provider[%s]: %s", name, err)
+			}
+		}
+	}
+
+	// Parse the option of sms_routes.
+	if _v, ok := _conf["sms_routes"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of sms_routes is not json")
+		}
+		m := _v.(map[string]interface{})
+		conf.SMSRoutes = make(map[string][]string, len(m))
+
+		for code, value := range m {
+			chain, ok := toStringSlice(value)
+			if !ok {
+				return nil, fmt.Errorf("the route[%s] of sms_routes is not a string array", code)
+			}
+			conf.SMSRoutes[code] = chain
+		}
+	}
+
+	// Parse the option of sms_routing.
+	if _v, ok := _conf["sms_routing"]; ok {
+		if !validation.VerifyType(_v, "string") {
+			return nil, fmt.Errorf("the type of sms_routing is not string")
+		}
+		conf.SMSRouting = _v.(string)
+		if conf.SMSRouting != "" && conf.SMSRouting != RoutingLeastCost {
+			return nil, fmt.Errorf("unknown sms_routing %s", conf.SMSRouting)
+		}
+	}
+
+	// Parse the option of sms_prices.
+	if _v, ok := _conf["sms_prices"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of sms_prices is not json")
+		}
+		m := _v.(map[string]interface{})
+		conf.SMSPrices = make(map[string]map[string]float64, len(m))
+
+		for name, value := range m {
+			table, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("the prices of the sms provider[%s] are not json", name)
+			}
+			prices, ok := toFloatMap(table)
+			if !ok {
+				return nil, fmt.Errorf("the prices of the sms provider[%s] are not numbers", name)
+			}
+			conf.SMSPrices[name] = prices
+		}
+	}
+
+	// Parse the option of country_locales.
+	if _v, ok := _conf["country_locales"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of country_locales is not json")
+		}
+		if _v, ok := toStringMap(_v.(map[string]interface{})); ok {
+			conf.CountryLocales = _v
+		} else {
+			return nil, fmt.Errorf("the type of the value of country_locales is wrong")
+		}
+	}
+
+	// Parse the option of rate_limits.
+	if _v, ok := _conf["rate_limits"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of rate_limits is not json")
+		}
+		v, ok := toFloatMap(_v.(map[string]interface{}))
+		if !ok {
+			return nil, fmt.Errorf("the value of rate_limits is not a number")
+		}
+		conf.RateLimits = v
+	}
+
+	// Parse the option of rate_limit_wait.
+	if _v, ok := _conf["rate_limit_wait"]; ok {
+		if !validation.VerifyType(_v, "float64") {
+			return nil, fmt.Errorf("the type of rate_limit_wait is not int")
+		}
+		conf.RateLimitWait = int(_v.(float64))
+	}
+
+	// Parse the option of http.
+	if _v, ok := _conf["http"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of http is not json")
+		}
+		if err := decodeJSON(_v, &conf.HTTP); err != nil {
+			return nil, fmt.Errorf("the type of http is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of server.
+	if _v, ok := _conf["server"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of server is not json")
+		}
+		if err := decodeJSON(_v, &conf.Server); err != nil {
+			return nil, fmt.Errorf("the type of server is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of listeners.
+	if _v, ok := _conf["listeners"]; ok {
+		if _, ok := _v.([]interface{}); !ok {
+			return nil, fmt.Errorf("the type of listeners is not an array")
+		}
+		if err := decodeJSON(_v, &conf.Listeners); err != nil {
+			return nil, fmt.Errorf("the type of listeners is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of dns_cache.
+	if _v, ok := _conf["dns_cache"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of dns_cache is not json")
+		}
+		if err := decodeJSON(_v, &conf.DNSCache); err != nil {
+			return nil, fmt.Errorf("the type of dns_cache is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of attachment_limits.
+	if _v, ok := _conf["attachment_limits"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of attachment_limits is not json")
+		}
+		if err := decodeJSON(_v, &conf.AttachmentLimits); err != nil {
+			return nil, fmt.Errorf("the type of attachment_limits is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of warmups.
+	if _v, ok := _conf["warmups"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of warmups is not json")
+		}
+		if err := decodeJSON(_v, &conf.Warmups); err != nil {
+			return nil, fmt.Errorf("the type of warmups is wrong: %s", err)
+		}
+		for name, w := range conf.Warmups {
+			if err := w.validate(); err != nil {
+				return nil, fmt.Errorf("the warm-up of the email provider[%s]: %s", name, err)
+			}
+		}
+	}
+
+	// Parse the option of partials.
+	if _v, ok := _conf["partials"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of partials is not json")
+		}
+		v, ok := toStringMap(_v.(map[string]interface{}))
+		if !ok {
+			return nil, fmt.Errorf("the type of the value of partials is wrong")
+		}
+		conf.Partials = v
+	}
+
+	// Parse the option of tag_limits.
+	if _v, ok := _conf["tag_limits"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of tag_limits is not json")
+		}
+		if err := decodeJSON(_v, &conf.TagLimits); err != nil {
+			return nil, fmt.Errorf("the type of tag_limits is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of canaries.
+	if _v, ok := _conf["canaries"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of canaries is not json")
+		}
+		if err := decodeJSON(_v, &conf.Canaries); err != nil {
+			return nil, fmt.Errorf("the type of canaries is wrong: %s", err)
+		}
+		for provider, c := range conf.Canaries {
+			if c.Recipient == "" {
+				return nil, fmt.Errorf("the canary[%s]: the recipient is empty", provider)
+			}
+		}
+	}
+
+	// Parse the option of payload_logging.
+	if _v, ok := _conf["payload_logging"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of payload_logging is not json")
+		}
+		if err := decodeJSON(_v, &conf.PayloadLogging); err != nil {
+			return nil, fmt.Errorf("the type of payload_logging is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of features.
+	if _v, ok := _conf["features"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of features is not json")
+		}
+		if err := decodeJSON(_v, &conf.Features); err != nil {
+			return nil, fmt.Errorf("the type of features is wrong: %s", err)
+		} else if err := checkFeatures(conf.Features); err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse the option of digests.
+	if _v, ok := _conf["digests"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of digests is not json")
+		}
+		if err := decodeJSON(_v, &conf.Digests); err != nil {
+			return nil, fmt.Errorf("the type of digests is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of integrations.
+	if _v, ok := _conf["integrations"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of integrations is not json")
+		}
+		if err := decodeJSON(_v, &conf.Integrations); err != nil {
+			return nil, fmt.Errorf("the type of integrations is wrong: %s", err)
+		}
+	}
+
+	// Parse the option of templates.
+	if _v, ok := _conf["templates"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of templates is not json")
+		}
+		if err := decodeJSON(_v, &conf.Templates); err != nil {
+			return nil, fmt.Errorf("the type of templates is wrong: %s", err)
+		}
+		for name, t := range conf.Templates {
+			if err := t.validate(conf.Partials); err != nil {
+				return nil, fmt.Errorf("the template[%s]: %s", name, err)
+			}
+		}
+	}
+
+	// Parse the option of tenants.
+	if _v, ok := _conf["tenants"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of tenants is not json")
+		}
+		if err := decodeJSON(_v, &conf.Tenants); err != nil {
+			return nil, fmt.Errorf("the type of tenants is wrong: %s", err)
+		}
+		for name, t := range conf.Tenants {
+			for tname, tt := range t.Templates {
+				if err := tt.validate(conf.Partials); err != nil {
+					return nil, fmt.Errorf("the template[%s] of the tenant[%s]: %s", tname, name, err)
+				}
+			}
+		}
+	}
+
+	for name, d := range conf.Digests {
+		if _, ok := conf.Templates[d.Template]; !ok {
+			return nil, fmt.Errorf("the digest[%s]: have no the template[%s]",
+				name, d.Template)
+		}
+	}
+	for name, i := range conf.Integrations {
+		if err := i.validate(conf.Templates); err != nil {
+			return nil, fmt.Errorf("the integration[%s]: %s", name, err)
+		}
+	}
+
+	// Parse the option of defaults.
+	if _v, ok := _conf["defaults"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of defaults is not json")
+		}
+		v, ok := toStringMap(_v.(map[string]interface{}))
+		if !ok {
+			return nil, fmt.Errorf("the type of the value of defaults is wrong")
+		}
+		conf.Defaults = v
+	}
+
 	// Parse the option of emails.
 	if _v, ok := _conf["emails"]; ok {
 		if !validation.VerifyType(_v, "string2interface") {
@@ -150,7 +1304,10 @@ func parseConfig(_conf map[string]interface{}) (conf *Config, err error) {
 				return nil, fmt.Errorf("the type of the email provider[%s] config is not json", key)
 			}
 			v := value.(map[string]interface{})
-			if _v, ok := toStringMap(v); ok {
+			if _v, ok := toProviderOptions(v); ok {
+				if _, err := providerEnabled(_v); err != nil {
+					return nil, fmt.Errorf("the option enabled of the email provider[%s] is not bool", key)
+				}
 				conf.Emails[key] = _v
 			} else {
 				return nil, fmt.Errorf("the type of the value of email is wrong")
@@ -171,7 +1328,10 @@ func parseConfig(_conf map[string]interface{}) (conf *Config, err error) {
 				return nil, fmt.Errorf("the type of the sms provider[%s] config is not json", key)
 			}
 			v := value.(map[string]interface{})
-			if _v, ok := toStringMap(v); ok {
+			if _v, ok := toProviderOptions(v); ok {
+				if _, err := providerEnabled(_v); err != nil {
+					return nil, fmt.Errorf("the option enabled of the sms provider[%s] is not bool", key)
+				}
 				conf.SMSes[key] = _v
 			} else {
 				return nil, fmt.Errorf("the type of the value of sms is wrong")
@@ -179,5 +1339,69 @@ func parseConfig(_conf map[string]interface{}) (conf *Config, err error) {
 		}
 	}
 
+	// Parse the option of mmses.
+	if _v, ok := _conf["mmses"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of mmses is not json")
+		}
+		m := _v.(map[string]interface{})
+		conf.MMSes = make(map[string]map[string]string)
+
+		for key, value := range m {
+			if !validation.VerifyType(value, "string2interface") {
+				return nil, fmt.Errorf("the type of the mms provider[%s] config is not json", key)
+			}
+			v := value.(map[string]interface{})
+			if _v, ok := toProviderOptions(v); ok {
+				if _, err := providerEnabled(_v); err != nil {
+					return nil, fmt.Errorf("the option enabled of the mms provider[%s] is not bool", key)
+				}
+				conf.MMSes[key] = _v
+			} else {
+				return nil, fmt.Errorf("the type of the value of mms is wrong")
+			}
+		}
+	}
+
+	// Parse the option of messengers.
+	if _v, ok := _conf["messengers"]; ok {
+		if !validation.VerifyType(_v, "string2interface") {
+			return nil, fmt.Errorf("the type of messengers is not json")
+		}
+		m := _v.(map[string]interface{})
+		conf.Messengers = make(map[string]map[string]string)
+
+		for key, value := range m {
+			if !validation.VerifyType(value, "string2interface") {
+				return nil, fmt.Errorf("the type of the messenger provider[%s] config is not json", key)
+			}
+			v := value.(map[string]interface{})
+			if _v, ok := toProviderOptions(v); ok {
+				if _, err := providerEnabled(_v); err != nil {
+					return nil, fmt.Errorf("the option enabled of the messenger provider[%s] is not bool", key)
+				}
+				conf.Messengers[key] = _v
+			} else {
+				return nil, fmt.Errorf("the type of the value of messenger is wrong")
+			}
+		}
+	}
+
+	// Parse the option of media_base_url.
+	if _v, ok := _conf["media_base_url"]; ok {
+		if !validation.VerifyType(_v, "string") {
+			return nil, fmt.Errorf("the type of media_base_url is not string")
+		}
+		conf.MediaBaseURL = strings.TrimSuffix(_v.(string), "/")
+	}
+
+	// Parse the option of webhook_base_url.
+	if _v, ok := _conf["webhook_base_url"]; ok {
+		if !validation.VerifyType(_v, "string") {
+			return nil, fmt.Errorf("the type of webhook_base_url is not string")
+		}
+		conf.WebhookBaseURL = strings.TrimSuffix(_v.(string), "/")
+	}
+
 	return
 }
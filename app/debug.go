@@ -0,0 +1,260 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// The feature flags, which are configured by Config.Features and may be
+// changed at runtime by "/v1/admin/features".
+const (
+	// Log the payloads of all the providers, see PayloadLogging.
+	// It is disabled by default.
+	FeaturePayloadLogging = "payload_logging"
+
+	// Send the canary messages, see Canary. It is enabled by default.
+	FeatureCanaries = "canaries"
+
+	// Try the providers violating the latency SLOs after the others,
+	// see LatencySLO. It is enabled by default.
+	FeatureLatencySLO = "latency_slo"
+)
+
+var defaultFeatures = map[string]bool{
+	FeaturePayloadLogging: false,
+	FeatureCanaries:       true,
+	FeatureLatencySLO:     true,
+}
+
+var (
+	featureLocker    = new(sync.Mutex)
+	featureOverrides = make(map[string]bool)
+)
+
+// featureEnabled reports whether the feature is enabled, by the runtime
+// override, the configuration or the default in turn.
+func featureEnabled(name string) bool {
+	featureLocker.Lock()
+	enabled, ok := featureOverrides[name]
+	featureLocker.Unlock()
+	if ok {
+		return enabled
+	}
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+	if enabled, ok := _config.Features[name]; ok {
+		return enabled
+	}
+	return defaultFeatures[name]
+}
+
+func getFeatures() map[string]bool {
+	features := make(map[string]bool, len(defaultFeatures))
+	for name := range defaultFeatures {
+		features[name] = featureEnabled(name)
+	}
+	return features
+}
+
+func checkFeatures(features map[string]bool) error {
+	for name := range features {
+		if _, ok := defaultFeatures[name]; !ok {
+			return fmt.Errorf("the feature %s is unknown", name)
+		}
+	}
+	return nil
+}
+
+// handleFeatures manages the feature flags at runtime, which needs the scope
+// "admin:debug". "GET" returns the flags, "POST" overrides them by the JSON
+// object like {"payload_logging": true}, and "DELETE" removes the override
+// of the query argument "name", or all if it is empty, to restore the ones
+// of the configuration.
+func handleFeatures(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if !authorize(_config, ScopeAdminDebug, w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		content, err := json.Marshal(getFeatures())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+
+	case "POST":
+		buf := bytes.NewBuffer(nil)
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var features map[string]bool
+		if err := json.Unmarshal(buf.Bytes(), &features); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		} else if err := checkFeatures(features); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		featureLocker.Lock()
+		for name, enabled := range features {
+			featureOverrides[name] = enabled
+		}
+		featureLocker.Unlock()
+		glog.Warningf("override the features: %v", features)
+
+	case "DELETE":
+		name := r.URL.Query().Get("name")
+		featureLocker.Lock()
+		if name == "" {
+			featureOverrides = make(map[string]bool)
+		} else {
+			delete(featureOverrides, name)
+		}
+		featureLocker.Unlock()
+		glog.Warningf("remove the override of the features: %s", name)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// LogLevel is the log level of glog, which may be changed at runtime
+// by "/v1/admin/loglevel". The omitted fields are not changed.
+type LogLevel struct {
+	// The verbosity of the debug logs, such as 1 to log each send.
+	V *int `json:"v,omitempty"`
+
+	// The verbosity per file, such as "dispatch=2,sms*=1".
+	VModule *string `json:"vmodule,omitempty"`
+
+	// The severity from which the logs are also written to stderr,
+	// such as "INFO", "WARNING" or "ERROR".
+	StderrThreshold *string `json:"stderrthreshold,omitempty"`
+}
+
+func getLogFlag(name string) (string, error) {
+	f := flag.Lookup(name)
+	if f == nil {
+		return "", fmt.Errorf("the log flag %s is not registered", name)
+	}
+	return f.Value.String(), nil
+}
+
+func getLogLevel() (level LogLevel, err error) {
+	var v, vmodule, threshold string
+	if v, err = getLogFlag("v"); err != nil {
+		return
+	} else if vmodule, err = getLogFlag("vmodule"); err != nil {
+		return
+	} else if threshold, err = getLogFlag("stderrthreshold"); err != nil {
+		return
+	}
+
+	n, _ := strconv.Atoi(v)
+	return LogLevel{V: &n, VModule: &vmodule, StderrThreshold: &threshold}, nil
+}
+
+func setLogLevel(level LogLevel) (err error) {
+	if level.V != nil {
+		if err = flag.Set("v", strconv.Itoa(*level.V)); err != nil {
+			return
+		}
+	}
+	if level.VModule != nil {
+		if err = flag.Set("vmodule", *level.VModule); err != nil {
+			return
+		}
+	}
+	if level.StderrThreshold != nil {
+		err = flag.Set("stderrthreshold", *level.StderrThreshold)
+	}
+	return
+}
+
+// handleLogLevel gets the log level by "GET", or changes it by "POST"
+// with LogLevel, which needs the scope "admin:debug".
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if !authorize(_config, ScopeAdminDebug, w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		buf := bytes.NewBuffer(nil)
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var level LogLevel
+		if err := json.Unmarshal(buf.Bytes(), &level); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		} else if err := setLogLevel(level); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		glog.Warningf("change the log level: %s", buf.String())
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	level, err := getLogLevel()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	content, err := json.Marshal(level)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// defaultDedupWindow is the default window of the deduplication.
+const defaultDedupWindow = 300
+
+type dedupState struct {
+	id      string
+	count   int       // The number of the duplicates.
+	latest  *Request  // The latest duplicate.
+	since   time.Time // When the first duplicate arrives.
+	isEmail bool
+	window  time.Duration
+}
+
+var (
+	dedupLocker = new(sync.Mutex)
+	dedupStates = make(map[string]*dedupState)
+)
+
+// checkDedup checks whether the message is the duplicate of the message with
+// the same dedup key and recipient in the window. If so, it writes the response
+// and returns true; or it starts the window and returns false.
+func checkDedup(w http.ResponseWriter, channel string, args *Request) bool {
+	recipient := args.Phone
+	if channel == "email" {
+		recipient = args.To
+	}
+
+	if args.id == "" {
+		args.id = newMessageID()
+	}
+
+	key := channel + "\x00" + args.DedupKey + "\x00" + recipient
+	dedupLocker.Lock()
+	state, ok := dedupStates[key]
+	if ok {
+		if state.count++; state.count == 1 {
+			state.since = time.Now()
+		}
+		old := state.latest
+		state.latest = args
+		count := state.count
+		dedupLocker.Unlock()
+
+		// Only the latest duplicate is sent at the end of the window.
+		if old != nil {
+			old.closeAttachments()
+		}
+
+		content, _ := json.Marshal(map[string]interface{}{
+			"id":        state.id,
+			"duplicate": true,
+			"count":     count,
+		})
+		w.Header().Set("X-Message-ID", state.id)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+		return true
+	}
+
+	window := args.DedupWindow
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+	state = &dedupState{id: args.id, isEmail: channel == "email",
+		window: time.Duration(window) * time.Second}
+	dedupStates[key] = state
+	dedupLocker.Unlock()
+
+	time.AfterFunc(state.window, func() { flushDedup(key, state.isEmail, state.window) })
+	return false
+}
+
+// flushDedup ends the window, and sends the latest duplicate with the counter
+// if there are the duplicates in the window.
+func flushDedup(key string, isEmail bool, window time.Duration) {
+	dedupLocker.Lock()
+	state := dedupStates[key]
+	delete(dedupStates, key)
+	dedupLocker.Unlock()
+	if state == nil || state.count == 0 {
+		return
+	}
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	args := state.latest
+	defer args.closeAttachments()
+
+	args.id = ""
+	if args.Template != "" {
+		args.Vars = setVar(args.Vars, "dedup_count", state.count)
+		if err := args.applyTemplate(_config); err != nil {
+			glog.Errorf("failed to render the duplicates of %s: %s", args.DedupKey, err)
+			return
+		}
+	} else {
+		args.Content += fmt.Sprintf("\n(repeated %d times in the last %s)",
+			state.count, window)
+	}
+
+	if _, err := deliver(isEmail, args); err != nil {
+		glog.Errorf("failed to send the duplicates of %s: %s", args.DedupKey, err)
+	}
+}
+
+// flushAllDedups ends all the windows at once.
+func flushAllDedups() {
+	dedupLocker.Lock()
+	states := make(map[string]*dedupState, len(dedupStates))
+	for key, state := range dedupStates {
+		states[key] = state
+	}
+	dedupLocker.Unlock()
+
+	for key, state := range states {
+		flushDedup(key, state.isEmail, state.window)
+	}
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// deliveryTTL is the duration that the delivery state of a message is kept.
+const deliveryTTL = time.Hour
+
+type delivery struct {
+	messageID string
+	delivered chan struct{}
+	done      bool
+	expire    time.Time
+}
+
+var (
+	deliveryLocker = new(sync.Mutex)
+	deliveries     = make(map[string]*delivery)
+)
+
+func deliveryKey(provider, vendorID string) string {
+	return provider + ":" + vendorID
+}
+
+// getDelivery returns the delivery state, which is created if not exist,
+// so the delivery report may arrive before the sender waits for it.
+// The caller must hold the lock.
+func getDelivery(key string, now time.Time) *delivery {
+	d, ok := deliveries[key]
+	if !ok {
+		for k, d := range deliveries {
+			if now.After(d.expire) {
+				delete(deliveries, k)
+			}
+		}
+
+		d = &delivery{delivered: make(chan struct{}), expire: now.Add(deliveryTTL)}
+		deliveries[key] = d
+	}
+	return d
+}
+
+// expectDelivery returns the channel which is closed when the message sent
+// by the provider with the vendor id is reported to be delivered.
+func expectDelivery(provider, vendorID, messageID string) <-chan struct{} {
+	deliveryLocker.Lock()
+	defer deliveryLocker.Unlock()
+
+	d := getDelivery(deliveryKey(provider, vendorID), time.Now())
+	d.messageID = messageID
+	if d.done {
+		setDelivered(messageID)
+		publishDelivered(provider, vendorID, messageID)
+	}
+	return d.delivered
+}
+
+// setDelivered marks the message as delivered in the history, and counts it
+// by the variant of its template.
+func setDelivered(messageID string) {
+	messageHistory.setStatus(messageID, StatusDelivered)
+	if record, ok := messageHistory.get(messageID); ok {
+		recordVariantEvent(record, StatusDelivered)
+	}
+}
+
+// markDelivered marks the message sent by the provider with the vendor id
+// as delivered.
+func markDelivered(provider, vendorID string) {
+	deliveryLocker.Lock()
+	defer deliveryLocker.Unlock()
+
+	d := getDelivery(deliveryKey(provider, vendorID), time.Now())
+	if !d.done {
+		d.done = true
+		close(d.delivered)
+		if d.messageID != "" {
+			setDelivered(d.messageID)
+			publishDelivered(provider, vendorID, d.messageID)
+		}
+	}
+}
+
+// handleDeliveryStatus handles the delivery reports posted by the messenger
+// providers to "/v1/status/PROVIDER", the PROVIDER of which is "whatsapp"
+// or "viber".
+func handleDeliveryStatus(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	provider := strings.TrimPrefix(r.URL.Path, "/v1/status/")
+	if provider != "whatsapp" && provider != "viber" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The verification of the webhook of WhatsApp.
+	if provider == "whatsapp" && r.Method == "GET" {
+		query := r.URL.Query()
+		token := _config.providerOptions("messenger", _config.Messengers[provider])["verify_token"]
+		if token == "" || query.Get("hub.mode") != "subscribe" ||
+			query.Get("hub.verify_token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(query.Get("hub.challenge")))
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeInbound, w, r) {
+		return
+	}
+
+	var err error
+	switch provider {
+	case "whatsapp":
+		var body struct {
+			Entry []struct {
+				Changes []struct {
+					Value struct {
+						Statuses []struct {
+							ID     string `json:"id"`
+							Status string `json:"status"`
+						} `json:"statuses"`
+					} `json:"value"`
+				} `json:"changes"`
+			} `json:"entry"`
+		}
+		if err = json.NewDecoder(r.Body).Decode(&body); err == nil {
+			for _, entry := range body.Entry {
+				for _, change := range entry.Changes {
+					for _, s := range change.Value.Statuses {
+						if s.Status == "delivered" || s.Status == "read" {
+							markDelivered(provider, s.ID)
+						}
+					}
+				}
+			}
+		}
+
+	case "viber":
+		var body struct {
+			Event        string `json:"event"`
+			MessageToken int64  `json:"message_token"`
+		}
+		if err = json.NewDecoder(r.Body).Decode(&body); err == nil {
+			if body.Event == "delivered" || body.Event == "seen" {
+				markDelivered(provider, strconv.FormatInt(body.MessageToken, 10))
+			}
+		}
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+	}
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Digest is the configuration of the digest, which combines the messages
+// of the same digest key and recipient into a summary message.
+type Digest struct {
+	// The number of the seconds to hold the messages, which is 3600 by default.
+	Window int `json:"window,omitempty"`
+
+	// The name of the template to render the summary message, see
+	// Config.Templates, with the variables "digest", the digest key,
+	// "count", the number of the messages, and "items", the messages,
+	// each of which has "subject", "content", "vars" and "time".
+	Template string `json:"template"`
+}
+
+func (d Digest) window() time.Duration {
+	if d.Window > 0 {
+		return time.Duration(d.Window) * time.Second
+	}
+	return time.Hour
+}
+
+type digestBatch struct {
+	channel string
+	first   *Request
+	items   []map[string]interface{}
+	since   time.Time
+}
+
+var (
+	digestLocker  = new(sync.Mutex)
+	digestBatches = make(map[string]*digestBatch)
+)
+
+// digestBatchKey returns the key of the batch, which is apart by the tenant.
+func digestBatchKey(tenant, channel, digest, recipient string) string {
+	return tenant + "\x00" + channel + "\x00" + digest + "\x00" + recipient
+}
+
+// holdDigest holds the message into the batch of its digest.
+func holdDigest(w http.ResponseWriter, r *http.Request, channel string, args *Request) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	digest, ok := _config.Digests[args.Digest]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("have no the digest[%s]", args.Digest)))
+		return
+	}
+
+	recipient := args.Phone
+	if channel == "email" {
+		recipient = args.To
+	}
+
+	item := map[string]interface{}{
+		"subject": args.Subject,
+		"content": args.Content,
+		"vars":    args.Vars,
+		"time":    time.Now(),
+	}
+
+	key := digestBatchKey(args.tenant, channel, args.Digest, recipient)
+	digestLocker.Lock()
+	batch, ok := digestBatches[key]
+	if !ok {
+		batch = &digestBatch{channel: channel, first: args, since: time.Now()}
+		digestBatches[key] = batch
+		time.AfterFunc(digest.window(), func() { flushDigest(key, digest) })
+	}
+	batch.items = append(batch.items, item)
+	count := len(batch.items)
+	digestLocker.Unlock()
+
+	content, _ := json.Marshal(map[string]interface{}{"digest": args.Digest, "count": count})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(content)
+}
+
+// flushDigest sends the summary message of the batch.
+func flushDigest(key string, digest Digest) {
+	digestLocker.Lock()
+	batch := digestBatches[key]
+	delete(digestBatches, key)
+	digestLocker.Unlock()
+	if batch == nil {
+		return
+	}
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	first := batch.first
+	args := &Request{
+		Provider: first.Provider,
+		Phone:    first.Phone,
+		To:       first.To,
+		Subject:  first.Subject,
+		Template: digest.Template,
+		Vars: map[string]interface{}{
+			"digest": first.Digest,
+			"count":  len(batch.items),
+			"items":  batch.items,
+		},
+		Retry:    first.Retry,
+		Fallback: first.Fallback,
+		tos:      first.tos,
+		tenant:   first.tenant,
+	}
+
+	err := args.applyTemplate(_config)
+	if err == nil {
+		_, err = deliver(batch.channel == "email", args)
+	}
+	if err != nil {
+		glog.Errorf("failed to send the digest[%s] of %d messages: %s",
+			first.Digest, len(batch.items), err)
+	}
+}
+
+// flushAllDigests sends the summary messages of all the batches at once.
+func flushAllDigests() {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	digestLocker.Lock()
+	batches := make(map[string]string, len(digestBatches))
+	for key, batch := range digestBatches {
+		batches[key] = batch.first.Digest
+	}
+	digestLocker.Unlock()
+
+	for key, name := range batches {
+		flushDigest(key, _config.Digests[name])
+	}
+}
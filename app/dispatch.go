@@ -0,0 +1,480 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// noProviderError is returned when there is no the provider to send.
+type noProviderError string
+
+func (e noProviderError) Error() string { return string(e) }
+
+// suppressedError is returned when the recipients are in the suppression list.
+type suppressedError string
+
+func (e suppressedError) Error() string { return string(e) }
+
+// preferenceError is returned when the message violates the preferences of
+// the recipients, see Preference.
+type preferenceError string
+
+func (e preferenceError) Error() string { return string(e) }
+
+// errorStatus returns the HTTP status code of the error of dispatch.
+func errorStatus(err error) int {
+	switch err.(type) {
+	case noProviderError:
+		return http.StatusBadRequest
+	case suppressedError, preferenceError:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// splitChain returns the names of the providers by the provider option of the
+// request, which is "all", a provider name, or the comma-separated names of
+// the providers, which are tried in order as a chain.
+//
+// For the chain, the providers known to be down are skipped.
+func splitChain(channel, name string, configured []string) (names []string, chain bool) {
+	if name == "all" {
+		sort.Strings(configured)
+		return healthyFirst(channel, configured), true
+	}
+
+	if strings.IndexByte(name, ',') < 0 {
+		return []string{strings.TrimSpace(name)}, false
+	}
+
+	names = strings.Split(name, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	if len(names) == 1 {
+		return names, false
+	}
+	return healthyFirst(channel, names), true
+}
+
+func getEmail(tenant, name string) (names []string, emails []messageapi.Email, chain bool) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	var configured []string
+	if name == "all" {
+		configured = _config.providerNames(tenant, "email")
+	}
+
+	names, chain = splitChain("email", name, configured)
+	names = _config.skipDisabled("email", names)
+	emails = make([]messageapi.Email, len(names))
+	for i, n := range names {
+		e, ok := _config.lookupProvider(tenant, "email", n)
+		if !ok {
+			return nil, nil, false
+		}
+		emails[i] = e.(messageapi.Email)
+	}
+	return
+}
+
+func getSMS(tenant, name string) (names []string, smses []messageapi.SMS, chain bool) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	var configured []string
+	if name == "all" {
+		configured = _config.providerNames(tenant, "sms")
+	}
+
+	names, chain = splitChain("sms", name, configured)
+	names = _config.skipDisabled("sms", names)
+	smses = make([]messageapi.SMS, len(names))
+	for i, n := range names {
+		s, ok := _config.lookupProvider(tenant, "sms", n)
+		if !ok {
+			return nil, nil, false
+		}
+		smses[i] = s.(messageapi.SMS)
+	}
+	return
+}
+
+// retryBackoff returns the duration to wait before the next retry,
+// which is doubled each time from 500ms to 5s.
+func retryBackoff(attempt int) time.Duration {
+	if attempt > 3 {
+		return 5 * time.Second
+	}
+	return (500 * time.Millisecond) << uint(attempt)
+}
+
+// retryDelay returns the duration to wait before retrying the error.
+//
+// If the provider asks to retry after a while, such as by the header
+// "Retry-After", it is honored instead of retryBackoff, but return false
+// if it is longer than Config.RateLimitWait, that's, not to retry now.
+func retryDelay(attempt int, err error) (time.Duration, bool) {
+	if d := messageapi.GetRetryAfter(err); d > 0 {
+		return d, d <= getRateLimitWait()
+	}
+	return retryBackoff(attempt), true
+}
+
+// sendResult is the result of dispatching a message.
+type sendResult struct {
+	ID       string            `json:"id"`
+	Provider string            `json:"provider,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// The encoding of the sms, see Config.SMSEncoding.
+	Encoding *smsEncoding `json:"encoding,omitempty"`
+
+	// The result of the message sent by the other channel instead,
+	// such as the SMS fallback of the messenger.
+	Fallback *sendResult `json:"fallback,omitempty"`
+}
+
+func sendEmailBy(name string, email messageapi.Email, args *Request) (map[string]string, error) {
+	if isProviderPaused("email", name) {
+		return nil, errProviderPaused
+	}
+
+	recipients := messageapi.EmailRecipients(args.tos, args.emailOptions)
+	if err := checkProviderAllowlist("email", name, recipients); err != nil {
+		return nil, err
+	}
+	if err := reserveWarmup(name, len(recipients)); err != nil {
+		return nil, err
+	}
+	if err := waitRateLimit("email", name); err != nil {
+		releaseWarmup(name, len(recipients))
+		return nil, err
+	}
+
+	ctx, result := messageapi.WithResult(context.TODO())
+	ctx = messageapi.WithEmailOptions(ctx, args.emailOptions)
+	ctx = withPayloadLog(ctx, "email", name, args, recipients, args.Subject, args.Content)
+	start := time.Now()
+	err := email.SendEmail(ctx, args.tos, args.Subject, args.Content,
+		args.openAttachments())
+	err = messageapi.TranslateError(name, err)
+	reportResult("email", name, time.Since(start), err)
+	if err != nil {
+		releaseWarmup(name, len(recipients))
+	}
+	return result.Metadata(), err
+}
+
+func sendSMSBy(name string, sms messageapi.SMS, args *Request) (map[string]string, error) {
+	if isProviderPaused("sms", name) {
+		return nil, errProviderPaused
+	} else if err := checkProviderAllowlist("sms", name, []string{args.Phone}); err != nil {
+		return nil, err
+	} else if isSandboxed("sms", name, args.Phone) {
+		return dryRun("sms", name, args.Phone), nil
+	}
+
+	if split, ok := getSMSSplit(name); ok && !messageapi.IsTemplateSMS(sms) {
+		if parts := split.split(args.Content); len(parts) > 1 {
+			return sendSMSParts(name, sms, args, parts, split.delay())
+		}
+	}
+	return sendSMSContent(name, sms, args, args.smsContent(sms))
+}
+
+// sendSMSContent sends the content as the sms of the request by the provider.
+func sendSMSContent(name string, sms messageapi.SMS, args *Request, content string) (map[string]string, error) {
+	if err := waitRateLimit("sms", name); err != nil {
+		return nil, err
+	}
+
+	ctx, result := messageapi.WithResult(context.TODO())
+	ctx = messageapi.WithSMSOptions(ctx, args.smsOptions)
+	ctx = withPayloadLog(ctx, "sms", name, args, []string{args.Phone}, content)
+	start := time.Now()
+	err := sms.SendSMS(ctx, args.Phone, content)
+	err = messageapi.TranslateError(name, err)
+	reportResult("sms", name, time.Since(start), err)
+	return result.Metadata(), err
+}
+
+// dispatchEmail sends the email by the provider or the providers
+// in the request, and records it into the history.
+func dispatchEmail(args *Request) (result sendResult, err error) {
+	if result.ID = args.id; result.ID == "" {
+		result.ID = newMessageID()
+	}
+	publishAccepted(result.ID, "email", args.Provider, args.envelope(), args.Template)
+	defer func() {
+		record := Record{
+			ID:         result.ID,
+			Channel:    "email",
+			Provider:   result.Provider,
+			Tenant:     args.tenant,
+			Recipients: args.envelope(),
+			Subject:    args.Subject,
+			Template:   args.Template,
+			Variant:    args.variant,
+			Status:     StatusSent,
+			Metadata:   result.Metadata,
+			CreatedAt:  time.Now(),
+
+			OriginalRecipients: args.originalTos,
+		}
+		recordVariantStats(args.Template, args.variant, err)
+		if err != nil {
+			record.Status = failedStatus(err)
+			record.setError(err)
+		}
+		recordHistory(record)
+		archiveRecord(record, args.Content, func(w io.Writer) error {
+			return args.writeEmail(w, record.Provider)
+		})
+	}()
+
+	names, emails, chain := getEmail(args.tenant, args.Provider)
+	if len(emails) == 0 {
+		return result, noProviderError("have no the email provider[" + args.Provider + "]")
+	}
+
+	var optedOut error
+	tos := make([]string, 0, len(args.tos))
+	for _, to := range args.tos {
+		if isSuppressed("email", to) {
+			continue
+		} else if e := checkPreference("email", to, args.Category); e != nil {
+			optedOut = e
+			continue
+		}
+		tos = append(tos, to)
+	}
+	if len(tos) == 0 {
+		if optedOut != nil && len(args.tos) == 1 {
+			return result, optedOut
+		} else if optedOut != nil {
+			return result, preferenceError("all the recipients are suppressed or opted out")
+		}
+		return result, suppressedError("all the recipients are suppressed")
+	}
+	args.tos = tos
+	args.emailOptions.Cc = skipSuppressed(args.emailOptions.Cc, args.Category)
+	args.emailOptions.Bcc = skipSuppressed(args.emailOptions.Bcc, args.Category)
+	args.redirectEmail()
+
+	if chain {
+		for i, email := range emails {
+			result.Provider = names[i]
+			if result.Metadata, err = sendEmailBy(names[i], email, args); err == nil {
+				return
+			}
+			glog.Errorf("failed to send the email by %s: %s", names[i], privateError(err, args.tos...))
+			args.resendFailed(err)
+		}
+	} else {
+		result.Provider = names[0]
+		for attempt := 0; ; attempt++ {
+			if result.Metadata, err = sendEmailBy(names[0], emails[0], args); err == nil {
+				return
+			} else if attempt >= args.Retry || messageapi.IsPermanent(err) {
+				break
+			}
+			delay, ok := retryDelay(attempt, err)
+			if !ok {
+				break
+			}
+			glog.Errorf("failed to send the email by %s, retry: %s", names[0],
+				privateError(err, args.tos...))
+			args.resendFailed(err)
+			time.Sleep(delay)
+		}
+	}
+	return
+}
+
+// resendFailed narrows the envelope of the email to the recipients failed by
+// err if the others have been sent, so that the retry or the next provider
+// does not send it to them again, see messageapi.RecipientsError.
+func (r *Request) resendFailed(err error) {
+	var e *messageapi.RecipientsError
+	if errors.As(err, &e) {
+		r.emailOptions.Envelope = e.FailedRecipients()
+	}
+}
+
+// skipSuppressed returns the email recipients without the suppressed or
+// opted-out ones, such as the carbon-copied ones.
+func skipSuppressed(recipients []string, category string) []string {
+	if len(recipients) == 0 {
+		return recipients
+	}
+
+	results := make([]string, 0, len(recipients))
+	for _, to := range recipients {
+		if !isSuppressed("email", to) && checkPreference("email", to, category) == nil {
+			results = append(results, to)
+		}
+	}
+	return results
+}
+
+// dispatchSMS sends the sms by the provider or the providers in the request,
+// and records it into the history.
+func dispatchSMS(args *Request) (result sendResult, err error) {
+	if result.ID = args.id; result.ID == "" {
+		result.ID = newMessageID()
+	}
+	publishAccepted(result.ID, "sms", args.Provider, []string{args.Phone}, args.Template)
+	result.Encoding = applySMSEncoding(args)
+	defer func() {
+		record := Record{
+			ID:         result.ID,
+			Channel:    "sms",
+			Provider:   result.Provider,
+			Tenant:     args.tenant,
+			Recipients: []string{args.Phone},
+			Template:   args.Template,
+			Variant:    args.variant,
+			Status:     recordStatus(result.Metadata),
+			Metadata:   result.Metadata,
+			CreatedAt:  time.Now(),
+		}
+		recordVariantStats(args.Template, args.variant, err)
+		if err != nil {
+			record.Status = failedStatus(err)
+			record.setError(err)
+		}
+		recordHistory(record)
+		archiveRecord(record, args.Content, nil)
+		if err == nil && args.smsRest != nil {
+			sendSMSRest(result.ID, args)
+		}
+	}()
+
+	names, smses, chain := getSMS(args.tenant, args.Provider)
+	if len(smses) == 0 {
+		return result, noProviderError("have no the sms provider[" + args.Provider + "]")
+	} else if isSuppressed("sms", args.Phone) {
+		return result, suppressedError("the phone is suppressed")
+	} else if err = checkPreference("sms", args.Phone, args.Category); err != nil {
+		return
+	}
+
+	if chain {
+		for i, sms := range smses {
+			result.Provider = names[i]
+			if result.Metadata, err = sendSMSBy(names[i], sms, args); err == nil {
+				return
+			}
+			glog.Errorf("failed to send the sms by %s: %s", names[i], privateError(err, args.Phone))
+		}
+	} else {
+		result.Provider = names[0]
+		for attempt := 0; ; attempt++ {
+			if result.Metadata, err = sendSMSBy(names[0], smses[0], args); err == nil {
+				return
+			} else if attempt >= args.Retry || messageapi.IsPermanent(err) {
+				break
+			}
+			delay, ok := retryDelay(attempt, err)
+			if !ok {
+				break
+			}
+			glog.Errorf("failed to send the sms by %s, retry: %s", names[0],
+				privateError(err, args.Phone))
+			time.Sleep(delay)
+		}
+	}
+	return
+}
+
+// deliver sends the email or the sms, and runs the fallback steps
+// if it fails or it is not acknowledged in time.
+func deliver(isEmail bool, args *Request) (result sendResult, err error) {
+	if isEmail {
+		result, err = dispatchEmail(args)
+	} else {
+		result, err = dispatchSMS(args)
+	}
+
+	if err != nil && errorStatus(err) >= 500 && args.Fallback != "" {
+		glog.Errorf("failed to send the message, fallback to %s: %s", args.Fallback,
+			privateError(err, append([]string{args.Phone}, args.tos...)...))
+
+		var fallback sendResult
+		fallback, err = dispatchFallback(args)
+		result.Fallback = &fallback
+	}
+	if err == nil && args.AckTimeout > 0 && args.Fallback != "" {
+		go escalateIfUnacked(result.ID, args)
+	}
+	return
+}
+
+// dispatchFallback sends the message by the fallback steps of the request
+// in order until one succeeds, and returns the result of the last one.
+func dispatchFallback(args *Request) (result sendResult, err error) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	text := args.Content
+	if args.Subject != "" {
+		text = args.Subject + "\n" + text
+	}
+
+	for _, step := range strings.Split(args.Fallback, ",") {
+		step = strings.TrimSpace(step)
+		index := strings.IndexByte(step, ':')
+		if index < 0 {
+			return result, noProviderError("the fallback step[" + step + "] is invalid")
+		}
+
+		channel, provider := step[:index], step[index+1:]
+		switch channel {
+		case "email":
+			if args.To == "" {
+				err = noProviderError("the to of the fallback email is empty")
+				continue
+			}
+			result, err = dispatchEmail(&Request{Provider: provider, To: args.To,
+				Subject: args.Subject, Content: args.Content, Retry: args.Retry,
+				Category: args.Category, tos: strings.Split(args.To, ","), tenant: args.tenant})
+
+		case "sms":
+			if args.Phone == "" {
+				err = noProviderError("the phone of the fallback sms is empty")
+				continue
+			}
+			result, err = dispatchSMS(&Request{Provider: provider, Phone: args.Phone,
+				Content: text, Retry: args.Retry, Category: args.Category, tenant: args.tenant})
+
+		case "messenger":
+			msg := messageapi.Message{Title: args.Subject, Content: args.Content}
+			result, err = dispatchMessage(_config, &MessageRequest{Provider: provider,
+				Retry: args.Retry, Message: msg, tenant: args.tenant})
+
+		default:
+			return result, noProviderError("the fallback step[" + step + "] is invalid")
+		}
+
+		if err == nil {
+			return
+		}
+		glog.Errorf("failed to send the message by the fallback %s: %s", step,
+			privateError(err, args.To, args.Phone))
+	}
+	return
+}
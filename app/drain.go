@@ -0,0 +1,119 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ScopeAdminDrain is the scope of the API key to drain the server.
+const ScopeAdminDrain = "admin:drain"
+
+// drainRetryAfter is the seconds of the header "Retry-After" of the requests
+// rejected when draining, after which the load balancer should have routed
+// them to the other instances.
+const drainRetryAfter = 30
+
+var (
+	draining int32
+	inflight int64
+)
+
+// drainable wraps the handler to send the messages, which rejects the new
+// requests with the status code 503 when draining, and counts the in-flight
+// requests.
+func drainable(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&draining) == 1 {
+			w.Header().Set("Retry-After", fmt.Sprint(drainRetryAfter))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("the server is draining"))
+			return
+		}
+
+		atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+		handler(w, r)
+	}
+}
+
+// IsDraining reports whether the server is draining.
+func IsDraining() bool { return atomic.LoadInt32(&draining) == 1 }
+
+// Drain stops accepting the new requests to send the messages, sends the
+// queued messages, such as the digests and the duplicates, at once, and waits
+// for the in-flight requests to finish until the timeout, which is used
+// before stopping the server for the rolling deployment.
+//
+// If timeout is 0, it does not wait.
+func Drain(timeout time.Duration) error {
+	if atomic.CompareAndSwapInt32(&draining, 0, 1) {
+		glog.Infof("start to drain")
+
+		atomic.AddInt64(&inflight, 1)
+		go func() {
+			defer atomic.AddInt64(&inflight, -1)
+			flushAllDigests()
+			flushAllDedups()
+		}()
+	}
+
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&inflight) > 0 {
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%d requests are still in flight", atomic.LoadInt64(&inflight))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// Undrain accepts the new requests again.
+func Undrain() {
+	if atomic.CompareAndSwapInt32(&draining, 1, 0) {
+		glog.Infof("stop draining")
+	}
+}
+
+// handleDrain starts draining by "POST", which returns at once, stops it by
+// "DELETE", and returns the state by "GET", such as
+// {"draining": true, "inflight": 2}, so the deployment may wait until
+// the in-flight requests are 0.
+func handleDrain(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if !authorize(_config, ScopeAdminDrain, w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST":
+		Drain(0)
+	case "DELETE":
+		Undrain()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	content, _ := json.Marshal(map[string]interface{}{
+		"draining": IsDraining(),
+		"inflight": atomic.LoadInt64(&inflight),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
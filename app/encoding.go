@@ -0,0 +1,95 @@
+package app
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xgfone/messageapi"
+)
+
+// The policies of the characters of the sms forcing the UCS-2 encoding,
+// such as the emojis and the smart quotes, see Config.SMSEncoding.
+const (
+	SMSEncodingAllow         = "allow"
+	SMSEncodingTransliterate = "transliterate"
+	SMSEncodingStrip         = "strip"
+)
+
+// smsEncoding is the decision of the encoding of the sms,
+// which is reported in the response.
+type smsEncoding struct {
+	// The encoding of the sent content, "gsm7" or "ucs2".
+	Encoding string `json:"encoding"`
+	Segments int    `json:"segments"`
+
+	// The applied policy, and the numbers of the characters replaced by
+	// their look-alikes and removed. The policy is "allow" if the request
+	// allows the UCS-2 characters.
+	Policy   string `json:"policy,omitempty"`
+	Replaced int    `json:"replaced,omitempty"`
+	Removed  int    `json:"removed,omitempty"`
+
+	// Whether the policy "strip" falls back to the UCS-2 encoding, since it
+	// would remove most of the characters or all the letters and the digits,
+	// such as the Chinese, Arabic or Cyrillic sms, see overStripped.
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// applySMSEncoding applies the policy of Config.SMSEncoding to the content
+// of the sms, unless the request allows the UCS-2 characters by Request.Unicode.
+//
+// The original content is kept for the sms providers only sending the
+// templates, the content of which is the parameters, see Request.smsContent.
+func applySMSEncoding(args *Request) *smsEncoding {
+	configLocker.Lock()
+	policy := config.SMSEncoding
+	configLocker.Unlock()
+
+	result := &smsEncoding{Policy: policy}
+	if args.Unicode {
+		result.Policy = SMSEncodingAllow
+	}
+
+	args.rawContent = args.Content
+	if !messageapi.IsGSM7(args.Content) {
+		switch result.Policy {
+		case SMSEncodingTransliterate:
+			args.Content, result.Replaced = messageapi.TransliterateGSM7(args.Content)
+		case SMSEncodingStrip:
+			args.Content, result.Replaced = messageapi.TransliterateGSM7(args.Content)
+			if stripped, removed := messageapi.StripNonGSM7(args.Content); overStripped(stripped, removed) {
+				result.Fallback = true
+			} else {
+				args.Content, result.Removed = stripped, removed
+			}
+		}
+	}
+
+	result.Encoding = messageapi.SMSEncoding(args.Content)
+	result.Segments = messageapi.SMSSegments(args.Content)
+	return result
+}
+
+// overStripped reports whether stripping the characters out of the GSM 7-bit
+// alphabet removes most of the content or leaves no letter or digit.
+func overStripped(stripped string, removed int) bool {
+	if removed == 0 {
+		return false
+	} else if removed > utf8.RuneCountInString(stripped) {
+		return true
+	}
+	return strings.IndexFunc(stripped, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}) < 0
+}
+
+// smsContent returns the content of the sms sent by the provider, which is
+// the original one before applySMSEncoding if the provider only sends the
+// templates, such as "aliyun", see messageapi.TemplateSMS.
+func (r *Request) smsContent(sms messageapi.SMS) string {
+	if r.rawContent != "" && messageapi.IsTemplateSMS(sms) {
+		return r.rawContent
+	}
+	return r.Content
+}
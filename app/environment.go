@@ -0,0 +1,126 @@
+package app
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// envEnvironment is the environment variable of the name of the environment,
+// see SetEnvironment.
+const envEnvironment = "MESSAGEAPI_ENV"
+
+var (
+	environmentLocker = new(sync.Mutex)
+	environmentName   string
+)
+
+// SetEnvironment sets the name of the environment, such as "dev", "staging"
+// or "prod", by which the configuration is selected from the option
+// "environments" when it is loaded from the file or reset by the HTTP API.
+//
+// If empty, it is the environment variable MESSAGEAPI_ENV, or the option
+// "environment" of the configuration.
+//
+// The option "environments" is the map from the names of the environments
+// to the partial configurations, which are merged into the top-level one as
+// JSON Merge Patch (RFC 7396), that's, the objects are merged recursively,
+// the other values replace the ones, and null removes the option. So the
+// providers only for production may be given only in "prod", and a provider
+// of the top-level configuration may be disabled by null in "dev":
+//
+//	{
+//	    "smses": {"twilio": {...}},
+//	    "environments": {
+//	        "dev":  {"smses": {"twilio": null}, "sandbox": {...}},
+//	        "prod": {"emails": {"ses": {...}}, "load_shedding": {...}}
+//	    }
+//	}
+//
+// If no environment is selected, the top-level configuration is used as it is.
+// It is an error if the selected environment is not in "environments".
+func SetEnvironment(name string) {
+	environmentLocker.Lock()
+	environmentName = strings.TrimSpace(name)
+	environmentLocker.Unlock()
+}
+
+func getEnvironment(_conf map[string]interface{}) string {
+	environmentLocker.Lock()
+	name := environmentName
+	environmentLocker.Unlock()
+
+	if name == "" {
+		name = strings.TrimSpace(os.Getenv(envEnvironment))
+	}
+	if name == "" {
+		name, _ = _conf["environment"].(string)
+	}
+	return name
+}
+
+// applyEnvironment returns the raw configuration of the selected environment,
+// which has the option "environment" of the name, but no "environments".
+func applyEnvironment(_conf map[string]interface{}) (map[string]interface{}, error) {
+	name := getEnvironment(_conf)
+	_v, ok := _conf["environments"]
+	if !ok {
+		if name != "" {
+			_conf = mergePatch(_conf, map[string]interface{}{"environment": name}).(map[string]interface{})
+		}
+		return _conf, nil
+	}
+
+	environments, ok := _v.(map[string]interface{})
+	if !ok {
+		return nil, ConfigErrors{{Path: "environments", Message: "the type of environments is not json"}}
+	}
+
+	patch := map[string]interface{}{}
+	if name != "" {
+		env, ok := environments[name]
+		if !ok {
+			names := make([]string, 0, len(environments))
+			for n := range environments {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, ConfigErrors{{Path: "environments", Message: "have no the environment " +
+				name + ", but only " + strings.Join(names, ", ")}}
+		} else if patch, ok = env.(map[string]interface{}); !ok {
+			return nil, ConfigErrors{{Path: "environments." + name,
+				Message: "the type of the environment is not json"}}
+		}
+	}
+
+	_conf = mergePatch(_conf, patch).(map[string]interface{})
+	delete(_conf, "environments")
+	if name != "" {
+		_conf["environment"] = name
+	}
+	return _conf, nil
+}
+
+// mergePatch returns the result of applying the patch to the target
+// as JSON Merge Patch, which does not modify either of them.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, _ := target.(map[string]interface{})
+	result := make(map[string]interface{}, len(t)+len(p))
+	for k, v := range t {
+		result[k] = v
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(result, k)
+		} else {
+			result[k] = mergePatch(result[k], v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,258 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// ScopeAdminErasure is the scope of the API key to erase the data of the
+// recipients.
+const ScopeAdminErasure = "admin:erasure"
+
+// erasedRecipient replaces the erased recipient of the message to the others.
+const erasedRecipient = "[erased]"
+
+// ErasureReport is the report of the data erased for a recipient, such as
+// the data subject of GDPR.
+type ErasureReport struct {
+	Recipient string `json:"recipient"`
+
+	// The ids of the messages purged from the history, which were only sent
+	// to the recipient.
+	PurgedMessages []string `json:"purged_messages"`
+
+	// The ids of the messages also sent to the others, the recipient of which
+	// is replaced with "[erased]" in the history.
+	AnonymizedMessages []string `json:"anonymized_messages"`
+
+	// The channels of the suppression list from which the recipient is removed,
+	// which is replaced with the hashed tombstone, see tombstoneKey.
+	Suppressions []string `json:"suppressions"`
+
+	// Whether the preferences of the recipient are removed, the opt-outs
+	// of which are kept by the hashed tombstone, see Preference.
+	Preferences bool `json:"preferences"`
+
+	// The number of the held messages to the recipient which are discarded,
+	// such as the duplicates and the digests.
+	PendingMessages int `json:"pending_messages"`
+
+	// The keys of the archived objects of the purged messages which are
+	// deleted from the object storage, see Archive.
+	ArchivedObjects []string `json:"archived_objects"`
+
+	// The errors of the stores which fail to be erased, so the request
+	// should be retried.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// recipientMatcher returns the function to report whether the recipient of
+// the message is the address, which is the email address or the phone.
+func recipientMatcher(address string) func(recipient string) bool {
+	if strings.Contains(address, "@") {
+		address = normalizeRecipient("email", address)
+		return func(recipient string) bool {
+			if addr, err := mail.ParseAddress(recipient); err == nil {
+				recipient = addr.Address
+			}
+			return normalizeRecipient("email", recipient) == address
+		}
+	}
+
+	phone := normalizeRecipient("sms", address)
+	return func(recipient string) bool {
+		if recipient == address {
+			return true
+		}
+		return phone != "" && !strings.Contains(recipient, "@") &&
+			normalizeRecipient("sms", recipient) == phone
+	}
+}
+
+// tombstoneKey returns the hashed tombstone of the normalized recipient,
+// such as "t:0123...", which replaces the erased recipient in the suppression
+// list and the preferences, so that the recipient who opted out is never
+// messaged again, but cannot be read back.
+//
+// It is HMAC-SHA256 by the salt of Privacy, which should be configured,
+// or else the tombstones of the phones may be reversed by guessing.
+func tombstoneKey(recipient string) string {
+	_, salt := getPrivacy()
+	h := hmac.New(sha256.New, []byte(salt))
+	h.Write([]byte(recipient))
+	return "t:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// tombstonePreference returns the tombstone of the preferences, which only
+// keeps the opt-outs, or false if none.
+func tombstonePreference(p Preference) (Preference, bool) {
+	tombstone := Preference{
+		Recipient: tombstoneKey(normalizeAddress(p.Recipient)),
+		Channels:  p.Channels,
+		UpdatedAt: p.UpdatedAt,
+	}
+	for category, allowed := range p.Categories {
+		if !allowed {
+			if tombstone.Categories == nil {
+				tombstone.Categories = make(map[string]bool)
+			}
+			tombstone.Categories[category] = false
+		}
+	}
+	return tombstone, len(tombstone.Channels) > 0 || len(tombstone.Categories) > 0
+}
+
+// recipientOfKey returns the recipient of the key of the held messages,
+// which is like "CHANNEL\x00KEY\x00RECIPIENT".
+func recipientOfKey(key string) string {
+	return key[strings.LastIndexByte(key, 0)+1:]
+}
+
+// eraseRecipient purges or anonymizes all the data of the recipient.
+//
+// The archived objects of the anonymized messages, and the events published
+// to the event bus, are not erased, which should be done by the downstream.
+func eraseRecipient(address string) ErasureReport {
+	match := recipientMatcher(address)
+	report := ErasureReport{
+		Recipient:          address,
+		PurgedMessages:     []string{},
+		AnonymizedMessages: []string{},
+		Suppressions:       []string{},
+		ArchivedObjects:    []string{},
+	}
+
+	// Discard the held messages to the recipient.
+	dedupLocker.Lock()
+	for key := range dedupStates {
+		if match(recipientOfKey(key)) {
+			report.PendingMessages += dedupStates[key].count
+			delete(dedupStates, key)
+		}
+	}
+	dedupLocker.Unlock()
+
+	digestLocker.Lock()
+	for key, batch := range digestBatches {
+		if match(recipientOfKey(key)) {
+			report.PendingMessages += len(batch.items)
+			delete(digestBatches, key)
+		}
+	}
+	digestLocker.Unlock()
+
+	// Replace the recipient in the suppression list with the tombstone,
+	// which is added before removing the recipient, so it is never lost.
+	store := getSuppressionStore()
+	for _, channel := range []string{"email", "sms"} {
+		recipient := normalizeRecipient(channel, address)
+		sp, ok, err := store.Get(channel, recipient)
+		if err == nil && ok {
+			sp.Recipient = tombstoneKey(recipient)
+			if err = store.Add(sp); err == nil {
+				err = store.Remove(channel, recipient)
+			}
+		}
+
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		} else if ok {
+			report.Suppressions = append(report.Suppressions, channel)
+		}
+	}
+
+	// Replace the preferences of the recipient with the tombstone of the opt-outs.
+	if p, ok, err := getPreferenceStore().Get(normalizeAddress(address)); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	} else if ok {
+		if tombstone, ok := tombstonePreference(p); ok {
+			err = getPreferenceStore().Set(tombstone)
+		}
+		if err == nil {
+			err = getPreferenceStore().Remove(normalizeAddress(address))
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		} else {
+			report.Preferences = true
+		}
+	}
+
+	// Purge or anonymize the history, and delete the archives of the purged.
+	purged, anonymized := messageHistory.erase(func(recipient string) bool {
+		return match(openRecipient(recipient))
+	})
+	for _, r := range anonymized {
+		report.AnonymizedMessages = append(report.AnonymizedMessages, r.ID)
+	}
+	for _, r := range purged {
+		report.PurgedMessages = append(report.PurgedMessages, r.ID)
+		keys, err := deleteArchives(openRecord(r))
+		report.ArchivedObjects = append(report.ArchivedObjects, keys...)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	return report
+}
+
+// handleRecipients erases all the data of the recipient, which is the email
+// address or the phone, and returns ErasureReport:
+//
+//	DELETE /v1/recipients/ADDRESS
+func handleRecipients(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeAdminErasure, w, r) {
+		return
+	}
+
+	address, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/v1/recipients/"))
+	if address = strings.TrimSpace(address); err != nil || address == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid recipient"))
+		return
+	}
+
+	// Not log the recipient, which is to be forgotten.
+	report := eraseRecipient(address)
+	if len(report.Errors) > 0 {
+		glog.Errorf("failed to erase a recipient: %s", strings.Join(report.Errors, "; "))
+	} else {
+		glog.Infof("erased a recipient: %d messages purged, %d anonymized",
+			len(report.PurgedMessages), len(report.AnonymizedMessages))
+	}
+
+	content, err := json.Marshal(report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(report.Errors) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write(content)
+}
@@ -0,0 +1,308 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// The types of the lifecycle events of the messages.
+const (
+	// The message is accepted by the API, and is to be sent.
+	EventAccepted = "accepted"
+
+	// The message is sent to the provider successfully, or failed.
+	EventSent   = "sent"
+	EventFailed = "failed"
+
+	// The message is reported to be delivered by the provider.
+	EventDelivered = "delivered"
+
+	// The email is bounced, which is reported by the delivery status
+	// notification received by the inbound email.
+	EventBounced = "bounced"
+
+	// The message is rejected by the load shedding, see LoadShedding.
+	EventShed = "shed"
+
+	// The inbound sms is received, the sender of which is the recipient,
+	// and the message id of the provider, the receiving number and the
+	// content of which are in the metadata, such as "to" and "content".
+	EventReceived = "received"
+)
+
+// Event is the lifecycle event of a message, which is published to the event
+// bus, so that the downstream systems, such as the analytics and the CRM,
+// consume the stream instead of polling the history.
+type Event struct {
+	Type string `json:"type"`
+
+	// The id of the message, which is empty for the bounced email.
+	MessageID  string   `json:"message_id,omitempty"`
+	Channel    string   `json:"channel"`
+	Provider   string   `json:"provider,omitempty"`
+	Recipients []string `json:"recipients,omitempty"`
+	Template   string   `json:"template,omitempty"`
+
+	// The error of the failed message, or the diagnostic of the bounce.
+	Error       string `json:"error,omitempty"`
+	ErrorReason string `json:"error_reason,omitempty"`
+
+	// The provider-specific response data, see messageapi.Result.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EventPublisher publishes the events to the event bus, such as Kafka, NATS
+// or Redis streams. Publish is called by a background goroutine in turn.
+// If it implements io.Closer, it is closed when replaced.
+type EventPublisher interface {
+	Publish(event Event) error
+}
+
+// EventBus is the options of the builtin event publishers.
+type EventBus struct {
+	// The type of the event bus, which is one of "redis" for Redis streams,
+	// "nats" for NATS and "kafka" for the Kafka REST Proxy.
+	Type string `json:"type"`
+
+	// The address like "host:port" of Redis or NATS, or the base URL of
+	// the Kafka REST Proxy, such as "http://127.0.0.1:8082".
+	Addr string `json:"addr"`
+
+	// The password of Redis, or the auth token of NATS.
+	Password string `json:"password,omitempty"`
+
+	// The database of Redis.
+	DB int `json:"db,omitempty"`
+
+	// The stream of Redis, the subject of NATS or the topic of Kafka,
+	// which is "messageapi.events" by default.
+	Topic string `json:"topic,omitempty"`
+
+	// The types of the events to be published, such as "sent" and "failed".
+	// If empty, publish all.
+	Events []string `json:"events,omitempty"`
+}
+
+func (b EventBus) topic() string {
+	if b.Topic != "" {
+		return b.Topic
+	}
+	return "messageapi.events"
+}
+
+// newEventPublisher returns the builtin publisher of the event bus.
+func newEventPublisher(b EventBus, password string) (EventPublisher, error) {
+	switch b.Type {
+	case "redis":
+		return NewRedisEventPublisher(b.Addr, password, b.DB, b.topic()), nil
+	case "nats":
+		return NewNATSEventPublisher(b.Addr, password, b.topic()), nil
+	case "kafka":
+		return NewKafkaEventPublisher(b.Addr, b.topic()), nil
+	default:
+		return nil, fmt.Errorf("the event bus %s is not supported", b.Type)
+	}
+}
+
+// eventQueueSize is the maximum number of the events to be published,
+// the new ones beyond which are dropped.
+const eventQueueSize = 10000
+
+var (
+	eventLocker     = new(sync.Mutex)
+	eventPublisher  EventPublisher
+	customPublisher EventPublisher
+	eventTypes      map[string]bool
+	eventQueue      = make(chan Event, eventQueueSize)
+	eventOnce       sync.Once
+)
+
+// SetEventPublisher sets the publisher of the events, which takes precedence
+// over the one of Config.EventBus. If nil, use the latter.
+func SetEventPublisher(p EventPublisher) {
+	eventLocker.Lock()
+	old := customPublisher
+	customPublisher = p
+	eventLocker.Unlock()
+	closePublisher(old)
+}
+
+func closePublisher(p EventPublisher) {
+	if c, ok := p.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			glog.Errorf("failed to close the event publisher: %s", err)
+		}
+	}
+}
+
+// resetEventBus replaces the publisher of the event bus by the configuration.
+func resetEventBus(c *Config) {
+	var p EventPublisher
+	if c.EventBus != nil {
+		var err error
+		if p, err = newEventPublisher(*c.EventBus, c.eventBusPassword); err != nil {
+			glog.Errorf("failed to create the event publisher: %s", err)
+		}
+	}
+
+	var types map[string]bool
+	if c.EventBus != nil && len(c.EventBus.Events) > 0 {
+		types = make(map[string]bool, len(c.EventBus.Events))
+		for _, t := range c.EventBus.Events {
+			types[t] = true
+		}
+	}
+
+	eventLocker.Lock()
+	old := eventPublisher
+	eventPublisher, eventTypes = p, types
+	eventLocker.Unlock()
+	closePublisher(old)
+}
+
+func getEventPublisher(eventType string) EventPublisher {
+	eventLocker.Lock()
+	defer eventLocker.Unlock()
+	if customPublisher != nil {
+		return customPublisher
+	} else if eventTypes != nil && !eventTypes[eventType] {
+		return nil
+	}
+	return eventPublisher
+}
+
+// publishEvent publishes the event in the background. If the event bus
+// falls behind, the event is dropped instead of blocking the sending.
+func publishEvent(e Event) {
+	if getEventPublisher(e.Type) == nil {
+		return
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	if len(e.Recipients) > 0 {
+		e.Error = privateText(e.Error, e.Recipients...)
+		e.Recipients = privateRecipients(e.Recipients)
+	}
+
+	eventOnce.Do(func() { go runEventQueue() })
+	select {
+	case eventQueue <- e:
+	default:
+		glog.Errorf("the event queue is full, drop the %s event of %s", e.Type, e.MessageID)
+		eventWorker.drop()
+	}
+}
+
+var eventWorker = &worker{name: "event"}
+
+func runEventQueue() {
+	for e := range eventQueue {
+		eventWorker.run(func() {
+			for attempt := 0; ; attempt++ {
+				p := getEventPublisher(e.Type)
+				if p == nil {
+					break
+				}
+
+				err := p.Publish(e)
+				if err == nil {
+					break
+				} else if attempt >= 2 {
+					glog.Errorf("failed to publish the %s event of %s: %s", e.Type, e.MessageID, err)
+					eventWorker.drop()
+					break
+				}
+				time.Sleep(time.Duration(100<<uint(attempt)) * time.Millisecond)
+			}
+		})
+	}
+}
+
+// publishAccepted publishes the event that the message is accepted.
+func publishAccepted(id, channel, provider string, recipients []string, template string) {
+	publishEvent(Event{Type: EventAccepted, MessageID: id, Channel: channel,
+		Provider: provider, Recipients: recipients, Template: template})
+}
+
+// publishDelivered publishes the event that the message is delivered.
+func publishDelivered(provider, vendorID, messageID string) {
+	if messageID == "" {
+		return
+	}
+	publishEvent(Event{Type: EventDelivered, MessageID: messageID, Channel: "messenger",
+		Provider: provider, Metadata: map[string]string{messageapi.ResultMessageID: vendorID}})
+}
+
+// publishBounces publishes the bounced events of the failed recipients
+// in the delivery status notification, see RFC 3464.
+func publishBounces(e *parsedEmail) {
+	if len(e.DeliveryStatus) == 0 {
+		return
+	}
+
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(e.DeliveryStatus)))
+	for {
+		// The per-message fields, then the per-recipient fields,
+		// each of which is separated by a blank line.
+		fields, err := r.ReadMIMEHeader()
+		if recipient := fields.Get("Final-Recipient"); recipient != "" &&
+			strings.EqualFold(fields.Get("Action"), "failed") {
+			if i := strings.IndexByte(recipient, ';'); i >= 0 {
+				recipient = recipient[i+1:]
+			}
+
+			diagnostic := fields.Get("Diagnostic-Code")
+			if i := strings.IndexByte(diagnostic, ';'); i >= 0 {
+				diagnostic = diagnostic[i+1:]
+			}
+			publishEvent(Event{
+				Type:       EventBounced,
+				Channel:    "email",
+				Recipients: []string{strings.TrimSpace(recipient)},
+				Error:      strings.TrimSpace(diagnostic),
+				Metadata:   map[string]string{"status": fields.Get("Status")},
+			})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// publishRecord publishes the event of the result of the message.
+func publishRecord(r Record) {
+	var eventType string
+	switch r.Status {
+	case StatusSent:
+		eventType = EventSent
+	case StatusFailed:
+		eventType = EventFailed
+	default:
+		return
+	}
+
+	publishEvent(Event{
+		Type:        eventType,
+		MessageID:   r.ID,
+		Channel:     r.Channel,
+		Provider:    r.Provider,
+		Recipients:  r.Recipients,
+		Template:    r.Template,
+		Error:       r.Error,
+		ErrorReason: r.ErrorReason,
+		Metadata:    r.Metadata,
+		CreatedAt:   r.CreatedAt,
+	})
+}
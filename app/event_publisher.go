@@ -0,0 +1,225 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi/internal/redis"
+)
+
+// redisStreamMaxLen is the approximate maximum length of the Redis stream.
+const redisStreamMaxLen = "1000000"
+
+type redisEventPublisher struct {
+	client *redis.Client
+	stream string
+}
+
+// NewRedisEventPublisher returns a new EventPublisher, which appends the
+// events to the Redis stream by XADD with the fields "type" and "data",
+// the latter of which is the JSON of the event.
+func NewRedisEventPublisher(addr, password string, db int, stream string) EventPublisher {
+	client := redis.NewClient(redis.Option{Addr: addr, Password: password, DB: db})
+	return redisEventPublisher{client: client, stream: stream}
+}
+
+func (p redisEventPublisher) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = p.client.Do("XADD", p.stream, "MAXLEN", "~", redisStreamMaxLen, "*",
+		"type", event.Type, "data", string(data))
+	return err
+}
+
+func (p redisEventPublisher) Close() error {
+	return p.client.Close()
+}
+
+type natsEventPublisher struct {
+	addr    string
+	token   string
+	subject string
+
+	lock sync.Mutex
+	conn net.Conn
+}
+
+// NewNATSEventPublisher returns a new EventPublisher, which publishes the
+// JSON of the events to the subject of NATS. If token is not empty,
+// it is used to authenticate.
+func NewNATSEventPublisher(addr, token, subject string) EventPublisher {
+	return &natsEventPublisher{addr: addr, token: token, subject: subject}
+}
+
+// connect connects to the NATS server. The caller must hold the lock.
+func (p *natsEventPublisher) connect() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", p.addr, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server sends INFO first.
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	r := bufio.NewReader(conn)
+	if line, err := r.ReadString('\n'); err != nil {
+		conn.Close()
+		return nil, err
+	} else if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected nats reply: %s", strings.TrimSpace(line))
+	}
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false,
+		"name": "messageapi", "lang": "go"}
+	if p.token != "" {
+		options["auth_token"] = p.token
+	}
+	data, _ := json.Marshal(options)
+	if _, err = fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", data); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The server replies PONG if connected, or -ERR.
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		} else if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return nil, fmt.Errorf("nats: %s", line)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	go p.serve(conn, r)
+	return conn, nil
+}
+
+// serve replies the PING of the server to keep the connection alive,
+// until the connection is closed.
+func (p *natsEventPublisher) serve(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "PING" {
+			p.lock.Lock()
+			conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+			_, err = io.WriteString(conn, "PONG\r\n")
+			p.lock.Unlock()
+			if err != nil {
+				break
+			}
+		} else if strings.HasPrefix(line, "-ERR") {
+			glog.Errorf("nats %s: %s", p.addr, line)
+		}
+	}
+
+	p.lock.Lock()
+	if p.conn == conn {
+		p.conn = nil
+	}
+	p.lock.Unlock()
+	conn.Close()
+}
+
+func (p *natsEventPublisher) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conn == nil {
+		if p.conn, err = p.connect(); err != nil {
+			return err
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "PUB %s %d\r\n", p.subject, len(data))
+	buf.Write(data)
+	buf.WriteString("\r\n")
+
+	p.conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+	if _, err = p.conn.Write(buf.Bytes()); err != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+func (p *natsEventPublisher) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+type kafkaEventPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// NewKafkaEventPublisher returns a new EventPublisher, which produces the
+// events to the topic of Kafka by the REST Proxy, such as the one of
+// Confluent, the key of the records of which is the message id.
+func NewKafkaEventPublisher(restURL, topic string) EventPublisher {
+	return kafkaEventPublisher{
+		url:    strings.TrimRight(restURL, "/") + "/topics/" + topic,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p kafkaEventPublisher) Publish(event Event) error {
+	type record struct {
+		Key   string `json:"key,omitempty"`
+		Value Event  `json:"value"`
+	}
+	data, err := json.Marshal(map[string][]record{
+		"records": {{Key: event.MessageID, Value: event}},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Post(p.url, "application/vnd.kafka.json.v2+json",
+		bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("the kafka rest proxy returned %s: %s", resp.Status,
+			bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+const (
+	defaultBreakerThreshold = 3
+	defaultBreakerTimeout   = 60
+)
+
+// breaker is a simple circuit breaker of a provider.
+//
+// It opens after the provider fails for threshold times continuously,
+// then the provider is considered to be down until the timeout elapses.
+type breaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+var (
+	breakerLocker = new(sync.Mutex)
+	breakers      = make(map[string]*breaker)
+)
+
+func providerKey(channel, name string) string {
+	return channel + ":" + name
+}
+
+// splitProviderKey is the reverse of providerKey.
+func splitProviderKey(key string) (channel, name string) {
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
+}
+
+func getBreakerOptions() (threshold int, timeout time.Duration) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	threshold = defaultBreakerThreshold
+	timeout = defaultBreakerTimeout * time.Second
+	if _config != nil {
+		if _config.BreakerThreshold != 0 {
+			threshold = _config.BreakerThreshold
+		}
+		if _config.BreakerTimeout > 0 {
+			timeout = time.Duration(_config.BreakerTimeout) * time.Second
+		}
+	}
+	return
+}
+
+// isHealthy reports whether the provider is not known to be down.
+func isHealthy(channel, name string) bool {
+	breakerLocker.Lock()
+	defer breakerLocker.Unlock()
+
+	b, ok := breakers[providerKey(channel, name)]
+	if !ok {
+		return true
+	}
+	return !time.Now().Before(b.openUntil)
+}
+
+// reportResult records the result and the latency of sending the message
+// by the provider.
+func reportResult(channel, name string, latency time.Duration, err error) {
+	recordStats(providerKey(channel, name), latency, err)
+	if glog.V(1) {
+		glog.Infof("send by %s in %s: err=%v", providerKey(channel, name), latency, err)
+	}
+	if d := messageapi.GetRetryAfter(err); d > 0 {
+		throttleProvider(providerKey(channel, name), d)
+	}
+	threshold, timeout := getBreakerOptions()
+
+	breakerLocker.Lock()
+	defer breakerLocker.Unlock()
+
+	key := providerKey(channel, name)
+	b, ok := breakers[key]
+	if !ok {
+		b = new(breaker)
+		breakers[key] = b
+	}
+
+	if err == nil {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+
+	b.failures++
+	if threshold > 0 && b.failures >= threshold {
+		b.openUntil = time.Now().Add(timeout)
+	}
+}
+
+// healthyFirst returns the names of the providers in order, which are
+// not known to be down, and the degraded ones by the latency SLO are moved
+// after the others. If all the providers are down, return all of them
+// so that they still have a chance to be tried.
+func healthyFirst(channel string, names []string) []string {
+	results := make([]string, 0, len(names))
+	var degraded []string
+	for _, name := range names {
+		if !isHealthy(channel, name) {
+			continue
+		} else if featureEnabled(FeatureLatencySLO) && isDegraded(channel, name) {
+			degraded = append(degraded, name)
+		} else {
+			results = append(results, name)
+		}
+	}
+	if results = append(results, degraded...); len(results) == 0 {
+		return names
+	}
+	return results
+}
@@ -0,0 +1,407 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// ScopeReadHistory is the scope of the API key to read the history.
+const ScopeReadHistory = "read:history"
+
+const defaultHistorySize = 1000
+
+// The status of the record.
+const (
+	StatusSent      = "sent"
+	StatusFailed    = "failed"
+	StatusDelivered = "delivered"
+
+	// The message is not sent by the sandboxed provider,
+	// see Config.SandboxNumbers.
+	StatusDryRun = "dry_run"
+
+	// The message is not sent as it violates the preferences of the
+	// recipients, see Preference.
+	StatusOptedOut = "opted_out"
+)
+
+// Record is the history record of a message.
+type Record struct {
+	ID         string   `json:"id"`
+	Channel    string   `json:"channel"`
+	Provider   string   `json:"provider"`
+	Tenant     string   `json:"tenant,omitempty"`
+	Recipients []string `json:"recipients"`
+	Subject    string   `json:"subject,omitempty"`
+	Template   string   `json:"template,omitempty"`
+	Variant    string   `json:"variant,omitempty"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+
+	// The class of the error, "temporary" or "permanent".
+	ErrorClass string `json:"error_class,omitempty"`
+
+	// The vendor code of the error, such as "21211" of Twilio, with the unified
+	// reason, such as "invalid_recipient", and the human-readable description,
+	// see messageapi.TranslateError.
+	ErrorCode        string `json:"error_code,omitempty"`
+	ErrorReason      string `json:"error_reason,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+
+	// The provider-specific response data, see messageapi.Result.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// The original recipients of the redirected email, see Config.EmailRedirect.
+	OriginalRecipients []string `json:"original_recipients,omitempty"`
+
+	// Who and when acknowledged the message, see "/v1/messages/ID/ack".
+	AckedBy string     `json:"acked_by,omitempty"`
+	AckedAt *time.Time `json:"acked_at,omitempty"`
+
+	// When the message is opened and clicked first, see Template.Variants.
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	ClickedAt *time.Time `json:"clicked_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// setError sets the error of the record, and the class and the code of the
+// error of the provider.
+func (r *Record) setError(err error) {
+	r.Error = err.Error()
+	if errorStatus(err) < 500 {
+		return
+	}
+
+	r.ErrorClass = string(messageapi.GetErrorClass(err))
+	var e *messageapi.Error
+	if errors.As(err, &e) {
+		r.ErrorCode = e.Code
+		r.ErrorReason = e.Reason
+		r.ErrorDescription = e.Description
+	}
+}
+
+// failedStatus returns the status of the record of the message failed by err.
+func failedStatus(err error) string {
+	if _, ok := err.(preferenceError); ok {
+		return StatusOptedOut
+	}
+	return StatusFailed
+}
+
+// history is a ring buffer of the latest records.
+type history struct {
+	sync.RWMutex
+	records    []Record
+	index      map[string]int
+	recipients *historyIndex
+	next       int
+	full       bool
+}
+
+var messageHistory = newHistory(defaultHistorySize)
+
+func newHistory(size int) *history {
+	return &history{
+		records:    make([]Record, size),
+		index:      make(map[string]int, size),
+		recipients: newHistoryIndex(size),
+	}
+}
+
+// SetHistorySize sets the maximum number of the latest messages kept in the
+// history, which is 1000 by default.
+//
+// It should be called before Start, and the current history is discarded.
+func SetHistorySize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("the history size must be a positive integer, but got %d", size)
+	}
+	messageHistory.reset(size)
+	return nil
+}
+
+// reset discards all the records and resizes the history.
+func (h *history) reset(size int) {
+	nh := newHistory(size)
+	h.Lock()
+	h.records, h.index, h.recipients = nh.records, nh.index, nh.recipients
+	h.next, h.full = 0, false
+	h.Unlock()
+}
+
+// add adds the sealed record, which is indexed by the plain recipients,
+// that's, the recipients followed by the original recipients.
+func (h *history) add(r Record, recipients []string) {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.full {
+		delete(h.index, h.records[h.next].ID)
+		h.recipients.remove(h.next, nil)
+	}
+	h.records[h.next] = r
+	h.index[r.ID] = h.next
+	h.recipients.add(h.next, recipients, r.CreatedAt)
+	h.next++
+	if h.next == len(h.records) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+func (h *history) get(id string) (Record, bool) {
+	h.RLock()
+	defer h.RUnlock()
+
+	if i, ok := h.index[id]; ok {
+		return h.records[i], true
+	}
+	return Record{}, false
+}
+
+func (h *history) setStatus(id, status string) {
+	h.Lock()
+	if i, ok := h.index[id]; ok {
+		h.records[i].Status = status
+	}
+	h.Unlock()
+}
+
+// ack records the acknowledgement of the message,
+// and returns false if the message does not exist.
+func (h *history) ack(id, by string, at time.Time) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	i, ok := h.index[id]
+	if ok {
+		h.records[i].AckedBy = by
+		h.records[i].AckedAt = &at
+	}
+	return ok
+}
+
+// track records the event of the message, "open" or "click", and returns
+// the record and whether it is the first event, or false if the message
+// does not exist.
+func (h *history) track(id, event string, at time.Time) (record Record, first, ok bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	i, ok := h.index[id]
+	if !ok {
+		return
+	}
+
+	switch r := &h.records[i]; event {
+	case trackOpen:
+		if first = r.OpenedAt == nil; first {
+			r.OpenedAt = &at
+		}
+	case trackClick:
+		if first = r.ClickedAt == nil; first {
+			r.ClickedAt = &at
+		}
+	}
+	return h.records[i], first, true
+}
+
+// list returns the latest records in the reverse chronological order,
+// which match the filter.
+func (h *history) list(limit int, filter func(*Record) bool) []Record {
+	h.RLock()
+	defer h.RUnlock()
+
+	total := h.next
+	if h.full {
+		total = len(h.records)
+	}
+
+	results := make([]Record, 0, limit)
+	for i := 1; i <= total && len(results) < limit; i++ {
+		r := &h.records[(h.next-i+len(h.records))%len(h.records)]
+		if r.ID == "" { // The erased record.
+			continue
+		} else if filter == nil || filter(r) {
+			results = append(results, *r)
+		}
+	}
+	return results
+}
+
+// each calls fn with each record in the history in no particular order.
+func (h *history) each(fn func(*Record)) {
+	h.RLock()
+	defer h.RUnlock()
+
+	for i := range h.records {
+		if h.records[i].ID != "" {
+			fn(&h.records[i])
+		}
+	}
+}
+
+// erase purges the records all the recipients of which match, and replaces
+// the matched recipients of the others with erasedRecipient. It returns the
+// purged and the anonymized records.
+func (h *history) erase(match func(recipient string) bool) (purged, anonymized []Record) {
+	h.Lock()
+	defer h.Unlock()
+
+	for i := range h.records {
+		r := &h.records[i]
+		if r.ID == "" {
+			continue
+		}
+
+		recipients, n := anonymizeRecipients(r.Recipients, match)
+		originals, m := anonymizeRecipients(r.OriginalRecipients, match)
+		if n+m == 0 {
+			continue
+		} else if n == len(r.Recipients) && m == len(r.OriginalRecipients) {
+			purged = append(purged, *r)
+			delete(h.index, r.ID)
+			h.recipients.remove(i, nil)
+			*r = Record{}
+		} else {
+			var positions []int
+			for j, recipient := range append(recipients, originals...) {
+				if recipient == erasedRecipient {
+					positions = append(positions, j)
+				}
+			}
+			h.recipients.remove(i, positions)
+
+			r.Recipients, r.OriginalRecipients = recipients, originals
+			anonymized = append(anonymized, *r)
+		}
+	}
+	return
+}
+
+// anonymizeRecipients returns the copy of the recipients, the matched ones of
+// which are replaced with erasedRecipient, and the number of the matched.
+func anonymizeRecipients(recipients []string, match func(string) bool) ([]string, int) {
+	var n int
+	results := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		if match(recipient) {
+			recipient = erasedRecipient
+			n++
+		}
+		results[i] = recipient
+	}
+	return results, n
+}
+
+func newMessageID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func recordHistory(r Record) {
+	recipients := make([]string, 0, len(r.Recipients)+len(r.OriginalRecipients))
+	recipients = append(recipients, r.Recipients...)
+	recipients = append(recipients, r.OriginalRecipients...)
+	messageHistory.add(sealRecord(r), recipients)
+	publishRecord(r)
+}
+
+// handleHistory returns the history records:
+//
+//	GET /v1/history?channel=CHANNEL&recipient=RECIPIENT&limit=N
+//	GET /v1/history/search?recipient=RECIPIENT, see handleHistorySearch
+//	GET /v1/history/ID
+//	GET /v1/history/ID/content, see handleHistoryContent
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/v1/history"), "/")
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if strings.HasSuffix(id, "/content") {
+		handleHistoryContent(_config, w, r, strings.TrimSuffix(id, "/content"))
+		return
+	} else if !authorize(_config, ScopeReadHistory, w, r) {
+		return
+	}
+
+	// The API key of the tenant only reads the messages of the tenant.
+	tenant := _config.keyTenants[getAPIKey(r)]
+
+	var result interface{}
+	if id == "search" {
+		handleHistorySearch(w, r, tenant)
+		return
+	} else if id != "" {
+		record, ok := messageHistory.get(id)
+		if !ok || (tenant != "" && record.Tenant != tenant) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		result = openRecord(record)
+	} else {
+		query := r.URL.Query()
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		if limit <= 0 || limit > 1000 {
+			limit = 100
+		}
+
+		channel, recipient := query.Get("channel"), query.Get("recipient")
+		records := messageHistory.list(limit, func(r *Record) bool {
+			if channel != "" && r.Channel != channel {
+				return false
+			} else if tenant != "" && r.Tenant != tenant {
+				return false
+			}
+			if recipient != "" {
+				for _, rcpt := range r.Recipients {
+					if openRecipient(rcpt) == recipient {
+						return true
+					}
+				}
+				return false
+			}
+			return true
+		})
+		for i := range records {
+			records[i] = openRecord(records[i])
+		}
+		result = records
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
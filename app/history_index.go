@@ -0,0 +1,240 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// historyBucket is the duration of the time bucket of the history index.
+const historyBucket = time.Hour
+
+// historyEntry is the indexed keys of a slot of the history.
+type historyEntry struct {
+	bucket int64
+	keys   []string // The hashes of the recipients by the position.
+}
+
+// historyIndex indexes the slots of the history by the hashed recipients and
+// the time buckets, so the messages of a recipient are searched without
+// scanning the whole history.
+//
+// It only indexes the records in the in-memory ring buffer of the history,
+// which are lost on restart and not shared by the instances, so the search
+// covers the latest messages set by SetHistorySize, not all the messages
+// ever sent. For the long-term search, index the records by the webhooks or
+// the archive in an external store instead.
+//
+// The recipients are hashed by the salt generated at startup, so the index
+// holds neither the plain nor the sealed recipients. And they are normalized
+// before hashing, so "+1 (415) 555-0100" matches "+14155550100" and the email
+// addresses are case-insensitive.
+type historyIndex struct {
+	salt    []byte
+	buckets map[string]map[int64][]int // Hash => Bucket => Slots in order.
+	entries []historyEntry
+}
+
+func newHistoryIndex(size int) *historyIndex {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	return &historyIndex{
+		salt:    salt,
+		buckets: make(map[string]map[int64][]int),
+		entries: make([]historyEntry, size),
+	}
+}
+
+func (x *historyIndex) hash(recipient string) string {
+	h := hmac.New(sha256.New, x.salt)
+	h.Write([]byte(normalizeAddress(recipient)))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// add indexes the slot by the plain recipients of the record created at t.
+func (x *historyIndex) add(slot int, recipients []string, t time.Time) {
+	entry := historyEntry{
+		bucket: t.UnixNano() / int64(historyBucket),
+		keys:   make([]string, len(recipients)),
+	}
+
+	for i, recipient := range recipients {
+		key := x.hash(recipient)
+		entry.keys[i] = key
+
+		buckets, ok := x.buckets[key]
+		if !ok {
+			buckets = make(map[int64][]int)
+			x.buckets[key] = buckets
+		}
+
+		slots := buckets[entry.bucket]
+		if n := len(slots); n == 0 || slots[n-1] != slot {
+			buckets[entry.bucket] = append(slots, slot)
+		}
+	}
+	x.entries[slot] = entry
+}
+
+// remove removes the slot from the index by the recipients at the positions,
+// or by all the recipients if positions is nil.
+func (x *historyIndex) remove(slot int, positions []int) {
+	entry := &x.entries[slot]
+	if positions == nil {
+		positions = make([]int, len(entry.keys))
+		for i := range positions {
+			positions[i] = i
+		}
+	}
+
+	for _, i := range positions {
+		if i >= len(entry.keys) || entry.keys[i] == "" {
+			continue
+		}
+
+		key := entry.keys[i]
+		entry.keys[i] = ""
+		buckets := x.buckets[key]
+		slots := buckets[entry.bucket]
+		for j := range slots {
+			if slots[j] == slot {
+				slots = append(slots[:j], slots[j+1:]...)
+				break
+			}
+		}
+
+		if len(slots) > 0 {
+			buckets[entry.bucket] = slots
+			continue
+		}
+
+		delete(buckets, entry.bucket)
+		if len(buckets) == 0 {
+			delete(x.buckets, key)
+		}
+	}
+}
+
+// search returns the slots of the recipient in the time buckets overlapping
+// [since, until] in the reverse chronological order. The zero time is unbounded.
+func (x *historyIndex) search(recipient string, since, until time.Time) []int {
+	buckets := x.buckets[x.hash(recipient)]
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(buckets))
+	for id := range buckets {
+		if !since.IsZero() && id < since.UnixNano()/int64(historyBucket) {
+			continue
+		} else if !until.IsZero() && id > until.UnixNano()/int64(historyBucket) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+
+	var slots []int
+	for _, id := range ids {
+		bucket := buckets[id]
+		for i := len(bucket) - 1; i >= 0; i-- {
+			slots = append(slots, bucket[i])
+		}
+	}
+	return slots
+}
+
+// search returns the latest records of the recipient created in [since, until]
+// in the reverse chronological order by the index, which match the filter.
+func (h *history) search(recipient string, since, until time.Time, limit int,
+	filter func(*Record) bool) []Record {
+	h.RLock()
+	defer h.RUnlock()
+
+	results := make([]Record, 0, limit)
+	for _, slot := range h.recipients.search(recipient, since, until) {
+		if len(results) >= limit {
+			break
+		}
+
+		r := &h.records[slot]
+		if r.ID == "" {
+			continue
+		} else if !since.IsZero() && r.CreatedAt.Before(since) {
+			continue
+		} else if !until.IsZero() && r.CreatedAt.After(until) {
+			continue
+		} else if filter == nil || filter(r) {
+			results = append(results, *r)
+		}
+	}
+	return results
+}
+
+// parseTimeQuery parses the time of the query in RFC 3339,
+// which returns the zero time if it is empty.
+func parseTimeQuery(r *http.Request, key string) (time.Time, bool) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, err == nil
+}
+
+// handleHistorySearch searches the messages of the recipient by the index
+// in the reverse chronological order:
+//
+//	GET /v1/history/search?recipient=RECIPIENT&since=TIME&until=TIME&channel=CHANNEL&limit=N
+//
+// TIME is in RFC 3339, such as "2006-01-02T15:04:05Z". If tenant is not empty,
+// only the messages of the tenant are searched.
+func handleHistorySearch(w http.ResponseWriter, r *http.Request, tenant string) {
+	query := r.URL.Query()
+	recipient, channel := query.Get("recipient"), query.Get("channel")
+	if recipient == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("missing the recipient"))
+		return
+	}
+
+	since, ok := parseTimeQuery(r, "since")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("the since is not a RFC 3339 time"))
+		return
+	}
+	until, ok := parseTimeQuery(r, "until")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("the until is not a RFC 3339 time"))
+		return
+	}
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	records := messageHistory.search(recipient, since, until, limit, func(r *Record) bool {
+		return (channel == "" || r.Channel == channel) && (tenant == "" || r.Tenant == tenant)
+	})
+	for i := range records {
+		records[i] = openRecord(records[i])
+	}
+
+	content, err := json.Marshal(records)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
@@ -0,0 +1,60 @@
+package app
+
+import "fmt"
+
+// Identity is the profile of the sender, which is referred by the option
+// "identity" of the request, and overrides the sender configured by the
+// provider if the provider supports it.
+type Identity struct {
+	// The sender of the email.
+	From         string `json:"from,omitempty"`
+	FromName     string `json:"from_name,omitempty"`
+	ReplyTo      string `json:"reply_to,omitempty"`
+	DKIMSelector string `json:"dkim_selector,omitempty"`
+
+	// The sender id of the sms and the mms, such as the phone number
+	// or the alphanumeric sender id.
+	SenderID string `json:"sender_id,omitempty"`
+
+	// The email domains and the phone prefixes to which the identity may
+	// send, such as ["@mycompany.com", "+86"]. If nil, it may send to all.
+	Allowlist []string `json:"allowlist,omitempty"`
+}
+
+// allowIdentity reports whether the API key is allowed to use the identity.
+//
+// The API key without the entry in Config.KeyIdentities may use any identity.
+func (c *Config) allowIdentity(key, identity string) bool {
+	identities, ok := c.KeyIdentities[key]
+	if !ok {
+		return true
+	}
+
+	for _, name := range identities {
+		if name == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// applyIdentity applies the sender identity of the request if given.
+func (r *Request) applyIdentity(c *Config, key string) error {
+	if r.Identity == "" {
+		return nil
+	}
+
+	identity, ok := c.Identities[r.Identity]
+	if !ok {
+		return fmt.Errorf("have no the identity[%s]", r.Identity)
+	} else if !c.allowIdentity(key, r.Identity) {
+		return fmt.Errorf("the api key is not allowed to use the identity[%s]", r.Identity)
+	}
+
+	r.emailOptions.From = identity.From
+	r.emailOptions.FromName = identity.FromName
+	r.emailOptions.ReplyTo = identity.ReplyTo
+	r.emailOptions.DKIMSelector = identity.DKIMSelector
+	r.smsOptions.SenderID = identity.SenderID
+	return nil
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ScopeInbound is the scope of the API key to call the inbound webhooks.
+const ScopeInbound = "inbound"
+
+// InboundAttachment is an attachment of the inbound email.
+type InboundAttachment struct {
+	Filename string `json:"filename"`
+	Size     int    `json:"size"`
+
+	// The base64-encoded content of the attachment.
+	Content string `json:"content"`
+}
+
+// InboundEmail is the inbound email forwarded to the webhooks as JSON.
+type InboundEmail struct {
+	From        string              `json:"from"`
+	To          []string            `json:"to"`
+	Cc          []string            `json:"cc,omitempty"`
+	Subject     string              `json:"subject"`
+	Text        string              `json:"text,omitempty"`
+	HTML        string              `json:"html,omitempty"`
+	Headers     map[string][]string `json:"headers"`
+	Attachments []InboundAttachment `json:"attachments,omitempty"`
+
+	// The envelope information, which is only available for SMTP.
+	EnvelopeFrom string   `json:"envelope_from,omitempty"`
+	EnvelopeTo   []string `json:"envelope_to,omitempty"`
+	RemoteAddr   string   `json:"remote_addr,omitempty"`
+
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+func newInboundEmail(e *parsedEmail) *InboundEmail {
+	ie := &InboundEmail{
+		From:       e.From,
+		To:         e.To,
+		Cc:         e.Cc,
+		Subject:    e.Subject,
+		Text:       e.Text,
+		HTML:       e.HTML,
+		Headers:    e.Header,
+		ReceivedAt: time.Now(),
+	}
+	for name, data := range e.Attachments {
+		ie.Attachments = append(ie.Attachments, InboundAttachment{
+			Filename: name,
+			Size:     len(data),
+			Content:  base64.StdEncoding.EncodeToString(data),
+		})
+	}
+	return ie
+}
+
+func getInboundEmailWebhooks() []string {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+	return _config.InboundEmailWebhooks
+}
+
+// forwardInboundEmail forwards the inbound email to the configured webhooks.
+func forwardInboundEmail(ie *InboundEmail) error {
+	return postWebhooks(getInboundEmailWebhooks(), ie)
+}
+
+// readRawEmail reads the raw MIME message from the request, which is the body
+// with the type "message/rfc822", or the form field "body-mime" of Mailgun
+// or "email" of SendGrid with the raw option.
+func readRawEmail(r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data", "application/x-www-form-urlencoded":
+		if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+			return nil, err
+		}
+		if v := r.FormValue("body-mime"); v != "" {
+			return []byte(v), nil
+		}
+		return []byte(r.FormValue("email")), nil
+	default:
+		return ioutil.ReadAll(r.Body)
+	}
+}
+
+func handleInboundEmail(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if len(_config.InboundEmailWebhooks) == 0 {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	if signed, err := verifyInboundEmail(_config, r); err != nil {
+		glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	} else if !signed && !authorize(_config, ScopeInbound, w, r) {
+		return
+	}
+
+	data, err := readRawEmail(r)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("have no the raw email"))
+		return
+	}
+
+	e, err := parseEmail(data)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	publishBounces(e)
+	if err = forwardInboundEmail(newInboundEmail(e)); err != nil {
+		glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(err.Error()))
+	}
+}
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// webhookMaxAge is the maximum age of the signed webhooks with the timestamp,
+// the older ones of which are rejected to prevent the replay.
+const webhookMaxAge = 5 * time.Minute
+
+// The headers of the signed webhooks of SendGrid.
+const (
+	sendgridSignatureHeader = "X-Twilio-Email-Event-Webhook-Signature"
+	sendgridTimestampHeader = "X-Twilio-Email-Event-Webhook-Timestamp"
+)
+
+func checkWebhookTimestamp(timestamp string) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("the timestamp is invalid")
+	}
+
+	age := time.Since(time.Unix(ts, 0))
+	if age > webhookMaxAge || age < -webhookMaxAge {
+		return fmt.Errorf("the timestamp is expired")
+	}
+	return nil
+}
+
+// readWebhookBody reads the body of the request, which is restored
+// to be read again by the parsers.
+func readWebhookBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// webhookURL returns the URL of the request called by the vendor, the base
+// of which is Config.WebhookBaseURL if configured, since the server may be
+// behind the proxy. The forwarded headers are not trusted, which may be
+// forged by the client.
+func webhookURL(c *Config, r *http.Request) string {
+	if c.WebhookBaseURL != "" {
+		return c.WebhookBaseURL + r.URL.RequestURI()
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// verifyTwilio verifies the header "X-Twilio-Signature" by the auth token,
+// which is the base64-encoded HMAC-SHA1 of the URL followed by the sorted
+// POST parameters.
+func verifyTwilio(c *Config, authToken string, r *http.Request) error {
+	signature := r.Header.Get("X-Twilio-Signature")
+	if signature == "" {
+		return fmt.Errorf("have no the signature")
+	} else if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(r.PostForm))
+	for key := range r.PostForm {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := hmac.New(sha1.New, []byte(authToken))
+	h.Write([]byte(webhookURL(c, r)))
+	for _, key := range keys {
+		values := append([]string(nil), r.PostForm[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			h.Write([]byte(key))
+			h.Write([]byte(value))
+		}
+	}
+
+	expected := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("the signature is wrong")
+	}
+	return nil
+}
+
+// verifyMailgun verifies the form fields "timestamp", "token" and "signature"
+// by the webhook signing key, the signature of which is the hex-encoded
+// HMAC-SHA256 of the timestamp followed by the token. The token is used only
+// once, see SetNonceStore.
+func verifyMailgun(signingKey string, r *http.Request) error {
+	timestamp, token := r.FormValue("timestamp"), r.FormValue("token")
+	signature := r.FormValue("signature")
+	if timestamp == "" || token == "" || signature == "" {
+		return fmt.Errorf("have no the signature")
+	} else if err := checkWebhookTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	h := hmac.New(sha256.New, []byte(signingKey))
+	h.Write([]byte(timestamp))
+	h.Write([]byte(token))
+	expected := hex.EncodeToString(h.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("the signature is wrong")
+	}
+
+	// The token is random for each webhook, so it only needs to be
+	// remembered until the timestamp is expired.
+	ts, _ := strconv.ParseInt(timestamp, 10, 64)
+	expire := time.Unix(ts, 0).Add(webhookMaxAge)
+	if ok, err := useNonce("mailgun:"+token, expire); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("the webhook has been replayed")
+	}
+	return nil
+}
+
+// verifySendGrid verifies the signed webhook of SendGrid by the base64-encoded
+// public key, the signature of which is the ECDSA signature of the timestamp
+// followed by the body.
+func verifySendGrid(publicKey string, r *http.Request) error {
+	signature := r.Header.Get(sendgridSignatureHeader)
+	timestamp := r.Header.Get(sendgridTimestampHeader)
+	if signature == "" || timestamp == "" {
+		return fmt.Errorf("have no the signature")
+	} else if err := checkWebhookTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	der, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return fmt.Errorf("the public key is invalid: %s", err)
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("the public key is invalid: %s", err)
+	}
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("the public key is not ECDSA")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("the signature is invalid")
+	}
+	var rs struct{ R, S *big.Int }
+	if _, err = asn1.Unmarshal(sig, &rs); err != nil {
+		return fmt.Errorf("the signature is invalid")
+	}
+
+	body, err := readWebhookBody(r)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(append([]byte(timestamp), body...))
+	if !ecdsa.Verify(pub, digest[:], rs.R, rs.S) {
+		return fmt.Errorf("the signature is wrong")
+	}
+	return nil
+}
+
+// verifySlack verifies the header "X-Slack-Signature" by the signing secret,
+// which is "v0=" and the hex-encoded HMAC-SHA256 of "v0:TIMESTAMP:BODY".
+func verifySlack(signingSecret string, r *http.Request, body []byte) error {
+	signature := r.Header.Get("X-Slack-Signature")
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	if signature == "" || timestamp == "" {
+		return fmt.Errorf("have no the signature")
+	} else if err := checkWebhookTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	h := hmac.New(sha256.New, []byte(signingSecret))
+	h.Write([]byte("v0:" + timestamp + ":"))
+	h.Write(body)
+	expected := "v0=" + hex.EncodeToString(h.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("the signature is wrong")
+	}
+	return nil
+}
+
+// verifyInboundSMS verifies the signature of the inbound sms by the webhook
+// secret of the provider. It reports false if the secret is not configured,
+// then the request is authorized by the API key instead.
+func verifyInboundSMS(c *Config, provider string, r *http.Request) (bool, error) {
+	secret := c.webhookSecrets[provider]
+	if secret == "" {
+		return false, nil
+	}
+
+	switch provider {
+	case "twilio":
+		return true, verifyTwilio(c, secret, r)
+	default:
+		return true, fmt.Errorf("the provider %s does not support the signature", provider)
+	}
+}
+
+// verifyInboundEmail verifies the signature of the inbound email posted by
+// Mailgun or SendGrid by their webhook secrets. If either is configured, the
+// request must be signed by it. Or, it reports false, then the request is
+// authorized by the API key instead.
+func verifyInboundEmail(c *Config, r *http.Request) (bool, error) {
+	mailgun, sendgrid := c.webhookSecrets["mailgun"], c.webhookSecrets["sendgrid"]
+	if mailgun == "" && sendgrid == "" {
+		return false, nil
+	} else if sendgrid != "" && r.Header.Get(sendgridSignatureHeader) != "" {
+		return true, verifySendGrid(sendgrid, r)
+	} else if mailgun == "" {
+		return true, fmt.Errorf("have no the signature")
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		return true, err
+	}
+	return true, verifyMailgun(mailgun, r)
+}
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// InboundSMS is the normalized inbound sms (mobile originated),
+// which is forwarded to the webhooks as JSON.
+type InboundSMS struct {
+	// The provider which receives the sms, such as "twilio".
+	Provider string `json:"provider"`
+
+	// The message id of the provider.
+	MessageID string `json:"message_id"`
+
+	// The phone of the sender, and the number which receives the sms.
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	Content    string    `json:"content"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// inboundSMSParser parses the inbound sms from the request of the provider,
+// and writes the response which the provider expects.
+type inboundSMSParser struct {
+	parse func(r *http.Request) ([]InboundSMS, error)
+	reply func(w http.ResponseWriter)
+}
+
+var inboundSMSParsers = map[string]inboundSMSParser{
+	"twilio": {parse: parseTwilioSMS, reply: replyTwilioSMS},
+	"vonage": {parse: parseVonageSMS, reply: replyOK},
+	"aliyun": {parse: parseAliyunSMS, reply: replyAliyunSMS},
+}
+
+func replyOK(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+func parseTwilioSMS(r *http.Request) ([]InboundSMS, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	return []InboundSMS{{
+		Provider:   "twilio",
+		MessageID:  r.FormValue("MessageSid"),
+		From:       r.FormValue("From"),
+		To:         r.FormValue("To"),
+		Content:    r.FormValue("Body"),
+		ReceivedAt: time.Now(),
+	}}, nil
+}
+
+func replyTwilioSMS(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Response></Response>`))
+}
+
+// parseVonageSMS parses the inbound sms of both the SMS API, which is
+// the query, the form or the JSON, and the Messages API, which is the JSON.
+func parseVonageSMS(r *http.Request) ([]InboundSMS, error) {
+	values := make(map[string]string)
+	if isJSONRequest(r) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]interface{}
+		if err = json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				values[k] = s
+			}
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		for k := range r.Form {
+			values[k] = r.Form.Get(k)
+		}
+	}
+
+	sms := InboundSMS{Provider: "vonage", ReceivedAt: time.Now()}
+	if id, ok := values["message_uuid"]; ok { // Messages API
+		sms.MessageID = id
+		sms.From = values["from"]
+		sms.To = values["to"]
+		sms.Content = values["text"]
+	} else { // SMS API
+		sms.MessageID = values["messageId"]
+		sms.From = values["msisdn"]
+		sms.To = values["to"]
+		sms.Content = values["text"]
+	}
+
+	if sms.From == "" {
+		return nil, fmt.Errorf("have no the sender")
+	}
+	// Vonage uses the international number without the leading "+".
+	sms.From = inboundPhone(sms.From, "")
+	return []InboundSMS{sms}, nil
+}
+
+// inboundPhone normalizes the phone of the sender to the E.164 format,
+// which is the national number of the country code if it has the national
+// length, such as the mainland phone of Aliyun, or the international number
+// without the leading "+".
+func inboundPhone(phone, countryCode string) string {
+	phone = strings.TrimSpace(phone)
+	if countryCode == "86" && len(phone) == 11 && phone[0] == '1' {
+		phone = countryCode + phone
+	}
+	if !strings.HasPrefix(phone, "+") && !strings.HasPrefix(phone, "00") {
+		phone = "+" + phone
+	}
+	return normalizeRecipient("sms", phone)
+}
+
+// parseAliyunSMS parses the inbound sms pushed by Aliyun by HTTP in batch.
+func parseAliyunSMS(r *http.Request) ([]InboundSMS, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []struct {
+		PhoneNumber string `json:"phone_number"`
+		Content     string `json:"content"`
+		DestCode    string `json:"dest_code"`
+		SequenceID  int64  `json:"sequence_id"`
+		SendTime    string `json:"send_time"`
+	}
+	if err = json.Unmarshal(data, &msgs); err != nil {
+		return nil, err
+	}
+
+	results := make([]InboundSMS, len(msgs))
+	for i, msg := range msgs {
+		received, err := time.ParseInLocation("2006-01-02 15:04:05", msg.SendTime,
+			time.FixedZone("CST", 8*3600))
+		if err != nil {
+			received = time.Now()
+		}
+		results[i] = InboundSMS{
+			Provider:   "aliyun",
+			MessageID:  fmt.Sprint(msg.SequenceID),
+			From:       inboundPhone(msg.PhoneNumber, "86"),
+			To:         msg.DestCode,
+			Content:    msg.Content,
+			ReceivedAt: received,
+		}
+	}
+	return results, nil
+}
+
+func replyAliyunSMS(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"code":0,"msg":"success"}`))
+}
+
+func handleInboundSMS(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	provider := strings.TrimPrefix(r.URL.Path, "/v1/inbound/sms/")
+	parser, ok := inboundSMSParsers[provider]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if r.Method != "POST" && r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if signed, err := verifyInboundSMS(_config, provider, r); err != nil {
+		glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	} else if !signed && !authorize(_config, ScopeInbound, w, r) {
+		return
+	}
+
+	msgs, err := parser.parse(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	for i := range msgs {
+		handleSMSKeywords(_config, msgs[i])
+		publishEvent(Event{
+			Type:       EventReceived,
+			Channel:    "sms",
+			Provider:   msgs[i].Provider,
+			Recipients: []string{msgs[i].From},
+			Metadata: map[string]string{
+				messageapi.ResultMessageID: msgs[i].MessageID,
+				"to":                       msgs[i].To,
+				"content":                  msgs[i].Content,
+			},
+			CreatedAt: msgs[i].ReceivedAt,
+		})
+		if err = postWebhooks(_config.InboundSMSWebhooks, msgs[i]); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	parser.reply(w)
+}
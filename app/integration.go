@@ -0,0 +1,426 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+	"github.com/xgfone/messageapi/internal/jq"
+)
+
+// ScopeIntegration is the scope of the API key to post the events
+// of the integrations, such as the Alertmanager webhook.
+const ScopeIntegration = "integration"
+
+// IntegrationRoute is the rule to route the event of the integration
+// to a channel.
+type IntegrationRoute struct {
+	// The labels which the event must have with the same values.
+	// If empty, all the events are matched.
+	Match map[string]string `json:"match,omitempty"`
+
+	// The channel is one of "email", "sms" and "messenger".
+	Channel  string `json:"channel"`
+	Provider string `json:"provider,omitempty"`
+
+	// The recipient, which is the email addresses for "email", the phone
+	// for "sms", or the recipient of the messenger, which may be empty
+	// to use the default recipient of the provider.
+	To string `json:"to,omitempty"`
+
+	// The template to render the message with the event as the variables.
+	// If empty, the subject and the content are the variables "subject" and
+	// "content" of the event, such as mapped by Integration.Fields.
+	Template string `json:"template,omitempty"`
+
+	// By default, the event is only sent by the first matched route.
+	// If true, the routes after it are also matched.
+	Continue bool `json:"continue,omitempty"`
+}
+
+func (r IntegrationRoute) match(labels map[string]string) bool {
+	for k, v := range r.Match {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Integration is the configuration of an inbound integration,
+// such as "alertmanager".
+//
+// If Fields is not empty, the integration is the generic one, which may have
+// any name, and the events are converted from the JSON payload by the fields.
+type Integration struct {
+	Routes []IntegrationRoute `json:"routes"`
+
+	// If not empty, the requests are verified by the secret instead of the
+	// API key, such as the secret of the GitHub webhook or the secret token
+	// of the GitLab webhook, which cannot send the API key.
+	Secret string `json:"secret,omitempty"`
+
+	// The jq-like expression to select the array of the items in the payload,
+	// each of which is an event, such as ".events". If empty, the payload is
+	// an event. See the package internal/jq.
+	Each string `json:"each,omitempty"`
+
+	// The fields of the event mapped from the item by the jq-like expressions,
+	// such as {"subject": ".title", "severity": ".level // \"info\""}, which
+	// are the variables of the templates with the item as "payload", and the
+	// labels to match the routes if they are not the objects or the arrays.
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+func (i Integration) validate(templates map[string]Template) error {
+	if i.Each != "" {
+		if _, err := jq.Compile(i.Each); err != nil {
+			return err
+		}
+	}
+	for name, expr := range i.Fields {
+		if _, err := jq.Compile(expr); err != nil {
+			return fmt.Errorf("the field[%s]: %s", name, err)
+		}
+	}
+
+	for index, r := range i.Routes {
+		switch r.Channel {
+		case "email", "sms", "messenger":
+		default:
+			return fmt.Errorf("the channel[%s] of the route %d is unknown", r.Channel, index)
+		}
+
+		if _, ok := templates[r.Template]; r.Template != "" && !ok {
+			return fmt.Errorf("the route %d has no the template[%s]", index, r.Template)
+		} else if r.To == "" && r.Channel != "messenger" {
+			return fmt.Errorf("the to of the route %d is empty", index)
+		}
+	}
+	return nil
+}
+
+// parseGeneric converts the JSON payload into the events by the fields.
+func (i Integration) parseGeneric(r *http.Request, body []byte) ([]IntegrationEvent, error) {
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	items := []interface{}{payload}
+	if i.Each != "" {
+		each, err := jq.Compile(i.Each)
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		if items, ok = each.Eval(payload).([]interface{}); !ok {
+			return nil, fmt.Errorf("the items selected by %s are not an array", i.Each)
+		}
+	}
+
+	queries := make(map[string]*jq.Query, len(i.Fields))
+	for name, expr := range i.Fields {
+		q, err := jq.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		queries[name] = q
+	}
+
+	events := make([]IntegrationEvent, len(items))
+	for index, item := range items {
+		event := IntegrationEvent{
+			Labels: make(map[string]string, len(queries)),
+			Vars:   make(map[string]interface{}, len(queries)+1),
+		}
+		event.Vars["payload"] = item
+
+		for name, q := range queries {
+			v := q.Eval(item)
+			event.Vars[name] = v
+			switch v.(type) {
+			case nil, map[string]interface{}, []interface{}:
+			default:
+				event.Labels[name] = fmt.Sprint(v)
+			}
+		}
+		events[index] = event
+	}
+	return events, nil
+}
+
+// IntegrationEvent is the event converted from the payload of the integration.
+type IntegrationEvent struct {
+	// The labels to match the routes.
+	Labels map[string]string
+
+	// The variables to render the templates.
+	Vars map[string]interface{}
+}
+
+// IntegrationParser converts the payload posted to the integration
+// into the events.
+type IntegrationParser func(r *http.Request, body []byte) ([]IntegrationEvent, error)
+
+var (
+	integrationLocker  = new(sync.Mutex)
+	integrationParsers = map[string]IntegrationParser{
+		"alertmanager": parseAlertmanager,
+		"grafana":      parseGrafana,
+	}
+)
+
+// RegisterIntegration registers the parser of the integration, the payload
+// of which is posted to "/v1/integrations/NAME" and routed by the routes
+// of Config.Integrations[NAME].
+func RegisterIntegration(name string, parser IntegrationParser) {
+	integrationLocker.Lock()
+	integrationParsers[name] = parser
+	integrationLocker.Unlock()
+}
+
+func getIntegrationParser(name string) (IntegrationParser, bool) {
+	integrationLocker.Lock()
+	parser, ok := integrationParsers[name]
+	integrationLocker.Unlock()
+	return parser, ok
+}
+
+// toLabels converts the JSON object to the labels, the values of which
+// are not string are ignored.
+func toLabels(labels map[string]string, v interface{}) {
+	m, _ := v.(map[string]interface{})
+	for key, value := range m {
+		if s, ok := value.(string); ok {
+			labels[key] = s
+		}
+	}
+}
+
+// parseAlertmanager parses the payload of the Prometheus Alertmanager webhook,
+// which is an event with the variables of the whole payload, such as "status",
+// "alerts" and "commonLabels", and the labels of the common labels, the group
+// labels and "status".
+func parseAlertmanager(r *http.Request, body []byte) ([]IntegrationEvent, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	} else if _, ok := payload["alerts"].([]interface{}); !ok {
+		return nil, fmt.Errorf("have no the alerts")
+	}
+
+	labels := make(map[string]string)
+	toLabels(labels, payload["commonLabels"])
+	toLabels(labels, payload["groupLabels"])
+	if status, ok := payload["status"].(string); ok {
+		labels["status"] = status
+	}
+
+	return []IntegrationEvent{{Labels: labels, Vars: payload}}, nil
+}
+
+// parseGrafana parses the payload of the Grafana alerting webhook.
+//
+// For the unified alerting, the payload is compatible with Alertmanager, and
+// has the extra variables, such as "title" and "message". For the legacy
+// alerting, the labels are the tags and "state", such as "alerting" or "ok".
+func parseGrafana(r *http.Request, body []byte) ([]IntegrationEvent, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	if _, ok := payload["alerts"].([]interface{}); ok {
+		return parseAlertmanager(r, body)
+	}
+
+	labels := make(map[string]string)
+	toLabels(labels, payload["tags"])
+	if state, ok := payload["state"].(string); ok {
+		labels["state"] = state
+	} else {
+		return nil, fmt.Errorf("have no the alerts or the state")
+	}
+	if name, ok := payload["ruleName"].(string); ok {
+		labels["rule"] = name
+	}
+
+	return []IntegrationEvent{{Labels: labels, Vars: payload}}, nil
+}
+
+// integrationResult is the result of sending the event by a route.
+type integrationResult struct {
+	Route int    `json:"route"`
+	Error string `json:"error,omitempty"`
+	sendResult
+}
+
+// sendByRoute renders the event by the template of the route,
+// and sends it to the channel of the route.
+func sendByRoute(c *Config, route IntegrationRoute, event IntegrationEvent) (sendResult, error) {
+	args := &Request{
+		Provider: route.Provider,
+		Template: route.Template,
+		Vars:     event.Vars,
+	}
+
+	if route.Channel == "email" {
+		args.To = route.To
+	} else {
+		args.Phone = route.To
+	}
+	if route.Template == "" {
+		if v, ok := event.Vars["subject"]; ok && v != nil {
+			args.Subject = fmt.Sprint(v)
+		}
+		if v, ok := event.Vars["content"]; ok && v != nil {
+			args.Content = fmt.Sprint(v)
+		}
+	} else if err := args.applyTemplate(c); err != nil {
+		return sendResult{}, err
+	}
+
+	switch route.Channel {
+	case "email":
+		if args.Provider == "" {
+			args.Provider = getDefaultProvider(c, true)
+		}
+		if err := args.validateEmail(); err != nil {
+			return sendResult{}, err
+		}
+		return deliver(true, args)
+
+	case "sms":
+		if args.Provider == "" {
+			args.Provider = routeSMS(c, args.Phone)
+		}
+		if args.Provider == "" {
+			args.Provider = getDefaultProvider(c, false)
+		}
+		if err := args.validateSMS(); err != nil {
+			return sendResult{}, err
+		}
+		return deliver(false, args)
+
+	default:
+		msg := messageapi.Message{To: route.To, Title: args.Subject, Content: args.Content}
+		return dispatchMessage(c, &MessageRequest{Provider: route.Provider, Message: msg})
+	}
+}
+
+// routeEvent sends the event by the matched routes of the integration.
+func routeEvent(c *Config, integration Integration, event IntegrationEvent) []integrationResult {
+	var results []integrationResult
+	for index, route := range integration.Routes {
+		if !route.match(event.Labels) {
+			continue
+		}
+
+		result, err := sendByRoute(c, route, event)
+		r := integrationResult{Route: index, sendResult: result}
+		if err != nil {
+			glog.Errorf("failed to send the event by the route %d: %s", index, err)
+			r.Error = err.Error()
+		}
+		results = append(results, r)
+
+		if !route.Continue {
+			break
+		}
+	}
+	return results
+}
+
+// handleIntegration converts the payload posted to "/v1/integrations/NAME"
+// into the events, and routes them by the configured routes. If all the
+// matched routes fail, the status code is 500 so the sender may retry.
+func handleIntegration(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/v1/integrations/")
+	integration, ok := _config.Integrations[name]
+	parser, _ok := getIntegrationParser(name)
+	if ok && (len(integration.Fields) > 0 || integration.Each != "") {
+		parser, _ok = integration.parseGeneric, true
+	}
+	if !ok || !_ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("have no the integration " + name))
+		return
+	}
+
+	if integration.Secret == "" && !authorize(_config, ScopeIntegration, w, r) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if integration.Secret != "" {
+		secret, err := decryptValue(getCipher(), integration.Secret)
+		if err == nil {
+			err = verifyIntegrationSecret(secret, r, body)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	events, err := parser(r, body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	results := make([]integrationResult, 0, len(events))
+	failed := 0
+	for _, event := range events {
+		for _, result := range routeEvent(_config, integration, event) {
+			if result.Error != "" {
+				failed++
+			}
+			results = append(results, result)
+		}
+	}
+
+	content, err := json.Marshal(results)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if failed > 0 && failed == len(results) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write(content)
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	RegisterIntegration("github", parseGitHub)
+	RegisterIntegration("gitlab", parseGitLab)
+}
+
+// verifyIntegrationSecret verifies the request by the secret of the
+// integration, that's, the HMAC-SHA256 signature of the body in the header
+// "X-Hub-Signature-256" sent by GitHub, the header "X-Gitlab-Token" sent
+// by GitLab, or the header "X-Slack-Signature" signed by the signing secret
+// of the Slack app.
+func verifyIntegrationSecret(secret string, r *http.Request, body []byte) error {
+	if r.Header.Get("X-Slack-Signature") != "" {
+		return verifySlack(secret, r, body)
+	} else if token := r.Header.Get("X-Gitlab-Token"); token != "" {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return fmt.Errorf("the token is wrong")
+		}
+		return nil
+	}
+
+	signature := r.Header.Get("X-Hub-Signature-256")
+	if signature == "" {
+		return fmt.Errorf("have no the signature")
+	}
+
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(body)
+	expected := "sha256=" + hex.EncodeToString(h.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("the signature is wrong")
+	}
+	return nil
+}
+
+// getString returns the string of the JSON object by the path of the keys,
+// or "" if not found.
+func getString(v interface{}, keys ...string) string {
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[key]
+	}
+
+	s, _ := v.(string)
+	return s
+}
+
+// parseGitHub parses the payload of the GitHub webhook, the variables of
+// which are the payload and "event", the header "X-GitHub-Event".
+//
+// The labels are "event", "action", "repository", the full name of the
+// repository, and "status" and "branch" for the events "workflow_run" and
+// "check_suite", such as {"event": "workflow_run", "status": "failure"}.
+// The event "ping" is ignored.
+func parseGitHub(r *http.Request, body []byte) ([]IntegrationEvent, error) {
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "" {
+		return nil, fmt.Errorf("have no the header X-GitHub-Event")
+	} else if event == "ping" {
+		return nil, nil
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	payload["event"] = event
+
+	labels := map[string]string{
+		"event":      event,
+		"action":     getString(payload, "action"),
+		"repository": getString(payload, "repository", "full_name"),
+	}
+	switch event {
+	case "workflow_run", "check_suite":
+		labels["status"] = getString(payload, event, "conclusion")
+		labels["branch"] = getString(payload, event, "head_branch")
+	}
+
+	return []IntegrationEvent{{Labels: labels, Vars: payload}}, nil
+}
+
+// parseGitLab parses the payload of the GitLab webhook, the variables of
+// which are the payload and "event", the field "object_kind", such as
+// "pipeline", "release", "issue" and "merge_request".
+//
+// The labels are "event", "action", "repository", the path of the project,
+// and "status" and "branch" for the event "pipeline", such as
+// {"event": "pipeline", "status": "failed"}.
+func parseGitLab(r *http.Request, body []byte) ([]IntegrationEvent, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	event := getString(payload, "object_kind")
+	if event == "" {
+		return nil, fmt.Errorf("have no the object_kind")
+	}
+	payload["event"] = event
+
+	labels := map[string]string{
+		"event":      event,
+		"action":     getString(payload, "object_attributes", "action"),
+		"repository": getString(payload, "project", "path_with_namespace"),
+	}
+	if event == "release" {
+		labels["action"] = getString(payload, "action")
+	} else if event == "pipeline" {
+		labels["status"] = getString(payload, "object_attributes", "status")
+		labels["branch"] = strings.TrimPrefix(
+			getString(payload, "object_attributes", "ref"), "refs/heads/")
+	}
+
+	return []IntegrationEvent{{Labels: labels, Vars: payload}}, nil
+}
@@ -0,0 +1,373 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// latencyBuckets is the upper bounds of the buckets of the histogram
+// of the send latency, and the last bucket is +Inf.
+var latencyBuckets = [...]time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// latencyHistogram is the counts of the latency by latencyBuckets.
+type latencyHistogram [len(latencyBuckets) + 1]int64
+
+func (h *latencyHistogram) observe(latency time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool {
+		return latency <= latencyBuckets[i]
+	})
+	h[i]++
+}
+
+func (h *latencyHistogram) add(o *latencyHistogram) {
+	for i := range h {
+		h[i] += o[i]
+	}
+}
+
+func (h *latencyHistogram) count() (n int64) {
+	for _, c := range h {
+		n += c
+	}
+	return
+}
+
+// quantile estimates the quantile, such as 0.95, of the latency by the linear
+// interpolation in the bucket. The latency in the last bucket is estimated
+// as the upper bound of the previous one.
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	total := h.count()
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var seen float64
+	for i, c := range h {
+		if c == 0 || seen+float64(c) < rank {
+			seen += float64(c)
+			continue
+		} else if i == len(latencyBuckets) {
+			return latencyBuckets[i-1]
+		}
+
+		var lower time.Duration
+		if i > 0 {
+			lower = latencyBuckets[i-1]
+		}
+		upper := latencyBuckets[i]
+		return lower + time.Duration(float64(upper-lower)*(rank-seen)/float64(c))
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+// LatencySLO is the objective of the send latency of a provider.
+//
+// If the percentile of the latency in the last Window minutes exceeds the
+// threshold, the provider is considered to be degraded, and tried after the
+// others by "all" and the chains. The thresholds of 0 are not checked.
+type LatencySLO struct {
+	P50 int `json:"p50_ms,omitempty"`
+	P95 int `json:"p95_ms,omitempty"`
+	P99 int `json:"p99_ms,omitempty"`
+
+	// The number of the last minutes, which is 5 by default.
+	Window int `json:"window,omitempty"`
+
+	// The minimum number of the sends in the window to check the objective,
+	// which is 20 by default, so that a few slow sends don't mark it.
+	MinSamples int `json:"min_samples,omitempty"`
+}
+
+func (s LatencySLO) window() int {
+	if s.Window > 0 && s.Window <= statsMinutes {
+		return s.Window
+	}
+	return 5
+}
+
+func (s LatencySLO) minSamples() int64 {
+	if s.MinSamples > 0 {
+		return int64(s.MinSamples)
+	}
+	return 20
+}
+
+// LatencyStats is the percentiles of the send latency of a provider.
+type LatencyStats struct {
+	// The provider, such as "email:plain" or "sms:NAME".
+	Provider string  `json:"provider"`
+	Sends    int64   `json:"sends"`
+	P50Ms    float64 `json:"p50_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+
+	// Whether the latency violates the SLO of the provider, see LatencySLO.
+	Degraded bool `json:"degraded"`
+}
+
+func newLatencyStats(provider string, h *latencyHistogram) LatencyStats {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return LatencyStats{
+		Provider: provider,
+		Sends:    h.count(),
+		P50Ms:    ms(h.quantile(0.50)),
+		P95Ms:    ms(h.quantile(0.95)),
+		P99Ms:    ms(h.quantile(0.99)),
+	}
+}
+
+// violates reports whether the stats violate the SLO.
+func (s LatencySLO) violates(ls LatencyStats) bool {
+	if ls.Sends < s.minSamples() {
+		return false
+	}
+	return (s.P50 > 0 && ls.P50Ms > float64(s.P50)) ||
+		(s.P95 > 0 && ls.P95Ms > float64(s.P95)) ||
+		(s.P99 > 0 && ls.P99Ms > float64(s.P99))
+}
+
+// getLatencySLO returns the SLO of the provider, or the default one by "*".
+func getLatencySLO(provider string) (LatencySLO, bool) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if slo, ok := _config.LatencySLOs[provider]; ok {
+		return slo, true
+	}
+	slo, ok := _config.LatencySLOs["*"]
+	return slo, ok
+}
+
+// windowHistogram returns the histogram of the last minutes of the provider.
+// The caller must hold statsLocker.
+func windowHistogram(ps *providerStats, minutes int) *latencyHistogram {
+	now := time.Now().Unix() / 60
+	h := new(latencyHistogram)
+	for i := 0; i < minutes; i++ {
+		minute := now - int64(i)
+		if b := &ps.buckets[minute%statsMinutes]; b.minute == minute {
+			h.add(&b.hist)
+		}
+	}
+	return h
+}
+
+// getLatencyStats returns the percentiles of the latency of the providers
+// in the last minutes. If minutes is 0, use the window of the SLO of each
+// provider. If provider is empty, return all the providers.
+func getLatencyStats(provider string, minutes int) []LatencyStats {
+	if minutes > statsMinutes {
+		minutes = statsMinutes
+	}
+
+	statsLocker.Lock()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		if provider == "" || provider == name {
+			names = append(names, name)
+		}
+	}
+	statsLocker.Unlock()
+	sort.Strings(names)
+
+	results := make([]LatencyStats, 0, len(names))
+	for _, name := range names {
+		slo, hasSLO := getLatencySLO(name)
+		window := minutes
+		if window <= 0 {
+			window = slo.window()
+		}
+
+		statsLocker.Lock()
+		ls := newLatencyStats(name, windowHistogram(stats[name], window))
+		statsLocker.Unlock()
+
+		ls.Degraded = hasSLO && slo.violates(ls)
+		results = append(results, ls)
+	}
+	return results
+}
+
+// isDegraded reports whether the latency of the provider violates its SLO.
+func isDegraded(channel, name string) bool {
+	key := providerKey(channel, name)
+	slo, ok := getLatencySLO(key)
+	if !ok {
+		return false
+	}
+
+	statsLocker.Lock()
+	ps, ok := stats[key]
+	var ls LatencyStats
+	if ok {
+		ls = newLatencyStats(key, windowHistogram(ps, slo.window()))
+	}
+	statsLocker.Unlock()
+	return ok && slo.violates(ls)
+}
+
+// handleLatencyStats returns the percentiles of the send latency of the
+// providers by "GET", which needs the scope "read:stats". The query argument
+// "provider" selects a provider, and "minutes" is the number of the last
+// minutes, which is the window of the SLO by default.
+func handleLatencyStats(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeReadStats, w, r) || rejectTenant(_config, w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	var minutes int
+	if v := query.Get("minutes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		minutes = n
+	}
+
+	content, err := json.Marshal(getLatencyStats(query.Get("provider"), minutes))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// handleMetrics exports the metrics of the providers in the Prometheus text
+// format by "GET", which needs the scope "read:stats", including the counters
+// of the sends and the failures, the histogram of the send latency since
+// the start, the percentiles and the degradation in the SLO window, and the
+// backlog of the queues and the scheduled messages, see writeBacklogMetrics.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeReadStats, w, r) || rejectTenant(_config, w, r) {
+		return
+	}
+
+	type totals struct {
+		name     string
+		sends    int64
+		failures int64
+		latency  time.Duration
+		hist     latencyHistogram
+	}
+
+	statsLocker.Lock()
+	all := make([]totals, 0, len(stats))
+	for name, ps := range stats {
+		all = append(all, totals{name: name, sends: ps.sends, failures: ps.failures,
+			latency: ps.latency, hist: ps.hist})
+	}
+	statsLocker.Unlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("# HELP messageapi_sends_total The number of the messages sent by the provider.\n")
+	buf.WriteString("# TYPE messageapi_sends_total counter\n")
+	for _, t := range all {
+		fmt.Fprintf(buf, "messageapi_sends_total{provider=%q} %d\n", t.name, t.sends)
+	}
+
+	buf.WriteString("# HELP messageapi_failures_total The number of the messages failed to send by the provider.\n")
+	buf.WriteString("# TYPE messageapi_failures_total counter\n")
+	for _, t := range all {
+		fmt.Fprintf(buf, "messageapi_failures_total{provider=%q} %d\n", t.name, t.failures)
+	}
+
+	buf.WriteString("# HELP messageapi_send_duration_seconds The latency to send the message by the provider.\n")
+	buf.WriteString("# TYPE messageapi_send_duration_seconds histogram\n")
+	for _, t := range all {
+		var cumulative int64
+		for i, le := range latencyBuckets {
+			cumulative += t.hist[i]
+			fmt.Fprintf(buf, "messageapi_send_duration_seconds_bucket{provider=%q,le=\"%g\"} %d\n",
+				t.name, le.Seconds(), cumulative)
+		}
+		fmt.Fprintf(buf, "messageapi_send_duration_seconds_bucket{provider=%q,le=\"+Inf\"} %d\n",
+			t.name, t.sends)
+		fmt.Fprintf(buf, "messageapi_send_duration_seconds_sum{provider=%q} %g\n",
+			t.name, t.latency.Seconds())
+		fmt.Fprintf(buf, "messageapi_send_duration_seconds_count{provider=%q} %d\n",
+			t.name, t.sends)
+	}
+
+	latencies := getLatencyStats("", 0)
+	buf.WriteString("# HELP messageapi_send_latency_seconds The percentiles of the latency in the SLO window.\n")
+	buf.WriteString("# TYPE messageapi_send_latency_seconds gauge\n")
+	for _, ls := range latencies {
+		for _, q := range []struct {
+			quantile string
+			ms       float64
+		}{{"0.5", ls.P50Ms}, {"0.95", ls.P95Ms}, {"0.99", ls.P99Ms}} {
+			fmt.Fprintf(buf, "messageapi_send_latency_seconds{provider=%q,quantile=%q} %g\n",
+				ls.Provider, q.quantile, q.ms/1000)
+		}
+	}
+
+	buf.WriteString("# HELP messageapi_provider_degraded Whether the latency of the provider violates the SLO.\n")
+	buf.WriteString("# TYPE messageapi_provider_degraded gauge\n")
+	for _, ls := range latencies {
+		var degraded int
+		if ls.Degraded {
+			degraded = 1
+		}
+		fmt.Fprintf(buf, "messageapi_provider_degraded{provider=%q} %d\n", ls.Provider, degraded)
+	}
+	writeBacklogMetrics(buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+// parsedEmail is the email parsed from the raw message.
+type parsedEmail struct {
+	Header      mail.Header
+	From        string
+	To          []string
+	Cc          []string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments map[string][]byte
+
+	// The part "message/delivery-status" of the delivery status
+	// notification, such as the bounce, which is also an attachment.
+	DeliveryStatus []byte
+}
+
+var wordDecoder = new(mime.WordDecoder)
+
+func decodeHeader(s string) string {
+	if v, err := wordDecoder.DecodeHeader(s); err == nil {
+		return v
+	}
+	return s
+}
+
+func parseAddressList(h mail.Header, key string) []string {
+	if h.Get(key) == "" {
+		return nil
+	}
+
+	addrs, err := h.AddressList(key)
+	if err != nil {
+		return []string{h.Get(key)}
+	}
+	results := make([]string, len(addrs))
+	for i, addr := range addrs {
+		results[i] = addr.Address
+	}
+	return results
+}
+
+// parseEmail parses the raw message, such as received by SMTP.
+func parseEmail(data []byte) (*parsedEmail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &parsedEmail{
+		Header:      msg.Header,
+		To:          parseAddressList(msg.Header, "To"),
+		Cc:          parseAddressList(msg.Header, "Cc"),
+		Subject:     decodeHeader(msg.Header.Get("Subject")),
+		Attachments: make(map[string][]byte),
+	}
+	if from := parseAddressList(msg.Header, "From"); len(from) > 0 {
+		e.From = from[0]
+	}
+
+	err = e.parsePart(msg.Header.Get("Content-Type"),
+		msg.Header.Get("Content-Disposition"),
+		msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func decodeBody(encoding string, r io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, newBase64Cleaner(r))
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+	return ioutil.ReadAll(r)
+}
+
+func (e *parsedEmail) parsePart(contentType, disposition, encoding string, body io.Reader) error {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			err = e.parsePart(part.Header.Get("Content-Type"),
+				part.Header.Get("Content-Disposition"),
+				part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := decodeBody(encoding, body)
+	if err != nil {
+		return err
+	}
+
+	if mediaType == "message/delivery-status" {
+		e.DeliveryStatus = append(e.DeliveryStatus, data...)
+	}
+
+	var filename string
+	if disposition != "" {
+		if d, dparams, err := mime.ParseMediaType(disposition); err == nil {
+			filename = decodeHeader(dparams["filename"])
+			if d == "attachment" && filename == "" {
+				filename = "attachment"
+			}
+		}
+	}
+	if filename == "" && params["name"] != "" {
+		filename = decodeHeader(params["name"])
+	}
+
+	switch {
+	case filename != "":
+		if _, ok := e.Attachments[filename]; ok {
+			filename = fmt.Sprintf("%d-%s", len(e.Attachments), filename)
+		}
+		e.Attachments[filename] = data
+	case mediaType == "text/plain" && e.Text == "":
+		e.Text = string(data)
+	case mediaType == "text/html" && e.HTML == "":
+		e.HTML = string(data)
+	default:
+		e.Attachments[fmt.Sprintf("part%d", len(e.Attachments)+1)] = data
+	}
+	return nil
+}
+
+// base64Cleaner removes the line breaks and the spaces in the base64 data.
+type base64Cleaner struct {
+	r io.Reader
+}
+
+func newBase64Cleaner(r io.Reader) io.Reader { return base64Cleaner{r: r} }
+
+func (c base64Cleaner) Read(p []byte) (n int, err error) {
+	for n == 0 && err == nil {
+		var m int
+		m, err = c.r.Read(p)
+		for _, b := range p[:m] {
+			if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
+				p[n] = b
+				n++
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mediaTTL is the duration that the generated media is kept,
+// during which the carriers fetch it.
+const mediaTTL = 24 * time.Hour
+
+// maxMedia is the maximum number of the media kept in the memory,
+// beyond which the oldest one is dropped.
+const maxMedia = 1000
+
+// maxInlineMedia is the maximum size of the media carried by the signed link
+// itself, which is served by any instance without the store.
+const maxInlineMedia = 2048
+
+type media struct {
+	id          string
+	contentType string
+	data        []byte
+	expire      time.Time
+}
+
+var (
+	mediaLocker = new(sync.Mutex)
+	mediaStore  = make(map[string]media)
+	mediaQueue  []string // The ids of the media in the order of expiration.
+)
+
+func mediaSignature(secret, id, contentType, expires string) string {
+	return signToken(secret, []byte(id+"\n"+contentType+"\n"+expires))
+}
+
+// putMedia returns the public URL of the media under the public base url.
+//
+// If the token secret is configured and the media is small, such as the
+// vCard, the media is carried by the signed link, so it survives the restart
+// and is served by any instance. Or, it is kept in the memory of the instance.
+func putMedia(c *Config, ext, contentType string, data []byte) string {
+	now := time.Now()
+	if c.tokenSecret != "" && len(data) <= maxInlineMedia {
+		id := base64.RawURLEncoding.EncodeToString(data) + ext
+		expires := strconv.FormatInt(now.Add(mediaTTL).Unix(), 10)
+		query := url.Values{
+			"type":    {contentType},
+			"expires": {expires},
+			"sig":     {mediaSignature(c.tokenSecret, id, contentType, expires)},
+		}
+		return c.MediaBaseURL + "/v1/media/" + id + "?" + query.Encode()
+	}
+
+	id := newMessageID() + ext
+	mediaLocker.Lock()
+	for len(mediaQueue) > 0 {
+		m := mediaStore[mediaQueue[0]]
+		if len(mediaQueue) < maxMedia && now.Before(m.expire) {
+			break
+		}
+		delete(mediaStore, mediaQueue[0])
+		mediaQueue = mediaQueue[1:]
+	}
+	mediaStore[id] = media{id: id, contentType: contentType, data: data, expire: now.Add(mediaTTL)}
+	mediaQueue = append(mediaQueue, id)
+	mediaLocker.Unlock()
+
+	return c.MediaBaseURL + "/v1/media/" + id
+}
+
+// getMedia returns the media by the id and the query of its link.
+func getMedia(c *Config, id string, query url.Values) (m media, ok bool) {
+	if sig := query.Get("sig"); sig != "" {
+		if c.tokenSecret == "" {
+			return
+		}
+
+		expires, contentType := query.Get("expires"), query.Get("type")
+		n, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || time.Now().After(time.Unix(n, 0).Add(c.clockSkew())) {
+			return
+		}
+
+		_sig := mediaSignature(c.tokenSecret, id, contentType, expires)
+		if !hmac.Equal([]byte(sig), []byte(_sig)) {
+			return
+		}
+
+		encoded := id
+		if i := strings.IndexByte(id, '.'); i > -1 {
+			encoded = id[:i]
+		}
+		data, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return
+		}
+		return media{id: id, contentType: contentType, data: data}, true
+	}
+
+	mediaLocker.Lock()
+	m, ok = mediaStore[id]
+	mediaLocker.Unlock()
+	if ok && time.Now().After(m.expire) {
+		return media{}, false
+	}
+	return
+}
+
+// handleMedia serves the media generated by the server, the link of which
+// is signed or the id of which is random and unguessable, so it needs no
+// authorization.
+func handleMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/media/")
+	m, ok := getMedia(_config, id, r.URL.Query())
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", m.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.data)))
+	if r.Method == "GET" {
+		w.Write(m.data)
+	}
+}
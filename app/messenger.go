@@ -0,0 +1,314 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// ScopeSendMessage is the scope of the API key to send the message
+// by the messengers.
+const ScopeSendMessage = "send:message"
+
+// MessageRequest is the request to send the message by the messengers.
+type MessageRequest struct {
+	// The name of the messenger provider, or the comma-separated names of
+	// the providers, which are tried in order as a chain, like Request.
+	// It may be omitted if only one provider is configured.
+	//
+	// The chain may cross the channels, such as "whatsapp,viber,sms:aliyun",
+	// the names with the prefix "sms:" of which are the sms providers, to
+	// which the content is sent to Phone if all the messengers fail.
+	Provider string `json:"provider"`
+
+	// If greater than 0, for the cross-channel chain, the content is also
+	// sent by SMS if the message sent by the messenger is not reported to be
+	// delivered within the seconds. Only "whatsapp" and "viber" support the
+	// delivery reports, see "/v1/status/PROVIDER".
+	DeliveryWindow int `json:"delivery_window,omitempty"`
+
+	// Retry to send the message for N times, like Request.
+	Retry int `json:"retry"`
+
+	// The phone number of the recipient, to which the content is sent by SMS
+	// if the recipient is unreachable on the messenger, such as not registered
+	// on WhatsApp, and the provider has the option "fallback_sms", which is
+	// the name of the sms provider. It is the option "to" by default.
+	Phone string `json:"phone,omitempty"`
+
+	// The category of the message, see Request.Category.
+	Category string `json:"category,omitempty"`
+
+	messageapi.Message
+
+	tenant string // The tenant of the API key, see Tenant.
+}
+
+func getMessengers(c *Config, tenant, name string) (names []string,
+	messengers []messageapi.Messenger, chain bool) {
+	configured := c.providerNames(tenant, "messenger")
+	if name == "" && len(configured) == 1 {
+		name = configured[0]
+	}
+
+	names, chain = splitChain("messenger", name, configured)
+	names = c.skipDisabled("messenger", names)
+	messengers = make([]messageapi.Messenger, len(names))
+	for i, n := range names {
+		m, ok := c.lookupProvider(tenant, "messenger", n)
+		if !ok {
+			return nil, nil, false
+		}
+		messengers[i] = m.(messageapi.Messenger)
+	}
+	return
+}
+
+func sendMessageBy(name string, messenger messageapi.Messenger,
+	args *MessageRequest) (map[string]string, error) {
+	if isProviderPaused("messenger", name) {
+		return nil, errProviderPaused
+	}
+	if err := waitRateLimit("messenger", name); err != nil {
+		return nil, err
+	}
+
+	ctx, result := messageapi.WithResult(context.TODO())
+	ctx = withPayloadLog(ctx, "messenger", name, args.Message, []string{args.To},
+		args.Title, args.Content)
+	start := time.Now()
+	err := messenger.SendMessage(ctx, args.Message)
+	reportResult("messenger", name, time.Since(start), err)
+	return result.Metadata(), err
+}
+
+// dispatchMessage sends the message by the provider or the providers
+// in the request, and records it into the history.
+func dispatchMessage(c *Config, args *MessageRequest) (result sendResult, err error) {
+	result.ID = newMessageID()
+	publishAccepted(result.ID, "messenger", args.Provider, []string{args.To}, "")
+	defer func() {
+		record := Record{
+			ID:         result.ID,
+			Channel:    "messenger",
+			Provider:   result.Provider,
+			Tenant:     args.tenant,
+			Recipients: []string{args.To},
+			Subject:    args.Title,
+			Status:     StatusSent,
+			Metadata:   result.Metadata,
+			CreatedAt:  time.Now(),
+		}
+		if err != nil {
+			record.Status = failedStatus(err)
+			record.setError(err)
+		}
+		recordHistory(record)
+		archiveRecord(record, args.Content, nil)
+	}()
+
+	names, messengers, chain := getMessengers(c, args.tenant, args.Provider)
+	if len(messengers) == 0 {
+		return result, noProviderError("have no the messenger provider[" + args.Provider + "]")
+	} else if err = checkPreference("messenger", args.To, args.Category); err != nil {
+		return
+	}
+
+	if chain {
+		for i, messenger := range messengers {
+			result.Provider = names[i]
+			if result.Metadata, err = sendMessageBy(names[i], messenger, args); err == nil {
+				return
+			}
+			glog.Errorf("failed to send the message by %s: %s", names[i], privateError(err, args.To))
+		}
+	} else {
+		result.Provider = names[0]
+		for attempt := 0; ; attempt++ {
+			if result.Metadata, err = sendMessageBy(names[0], messengers[0], args); err == nil {
+				return
+			} else if attempt >= args.Retry || messageapi.IsPermanent(err) {
+				break
+			}
+			delay, ok := retryDelay(attempt, err)
+			if !ok {
+				break
+			}
+			glog.Errorf("failed to send the message by %s, retry: %s", names[0],
+				privateError(err, args.To))
+			time.Sleep(delay)
+		}
+	}
+	return
+}
+
+// shedPriority returns the priority of the message for the load shedding,
+// that's, "high" for the positive priority, "low" for the negative one,
+// or decided by the category.
+func (r *MessageRequest) shedPriority() string {
+	switch {
+	case r.Priority > 0:
+		return PriorityHigh
+	case r.Priority < 0:
+		return PriorityLow
+	default:
+		return ""
+	}
+}
+
+func sendMessage(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if len(_config.messengers) == 0 {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	} else if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeSendMessage, w, r) {
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("cannot read the body, err=%s", err)))
+		return
+	}
+
+	args := new(MessageRequest)
+	if err := json.Unmarshal(buf.Bytes(), args); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	args.tenant = _config.keyTenants[getAPIKey(r)]
+
+	// The recipient may be empty for some providers, such as gotify.
+	if args.Content == "" && args.Template == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("the content is empty"))
+		return
+	} else if strings.Contains(args.Provider, "sms:") && args.Content == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("the content of the sms fallback is empty"))
+		return
+	}
+	if args.Retry < 0 {
+		args.Retry = 0
+	}
+	if shedLoad(_config, w, "messenger", []string{args.To}, args.shedPriority(), args.Category) {
+		return
+	}
+
+	var smsChain string
+	args.Provider, smsChain = splitCrossChain(args.Provider)
+	if checkPaused(w, "messenger", args.Provider, getAPIKey(r), func() {
+		if _, err := dispatchMessage(_config, args); err != nil {
+			glog.Errorf("failed to send the held message: %s", err)
+		}
+	}, nil) {
+		return
+	}
+
+	result, err := dispatchMessage(_config, args)
+	switch {
+	case err != nil && errorStatus(err) < 500:
+	case err != nil && smsChain != "":
+		glog.Errorf("failed to send the message by %s, fallback to sms: %s",
+			result.Provider, privateError(err, args.To))
+
+		var fallback sendResult
+		fallback, err = dispatchSMS(newFallbackSMS(args, smsChain))
+		result.Fallback = &fallback
+
+	case err != nil && messageapi.IsUnreachable(err):
+		if smsProvider := _config.providerOptions("messenger", _config.Messengers[result.Provider])["fallback_sms"]; smsProvider != "" {
+			glog.Errorf("failed to send the message by %s, fallback to sms: %s",
+				result.Provider, privateError(err, args.To))
+
+			var fallback sendResult
+			fallback, err = dispatchSMS(newFallbackSMS(args, smsProvider))
+			result.Fallback = &fallback
+		}
+
+	case err == nil && smsChain != "" && args.DeliveryWindow > 0:
+		vendorID := result.Metadata[messageapi.ResultMessageID]
+		if deliveryReported[result.Provider] && vendorID != "" {
+			delivered := expectDelivery(result.Provider, vendorID, result.ID)
+			window := time.Duration(args.DeliveryWindow) * time.Second
+			go fallbackIfUndelivered(delivered, window, result, newFallbackSMS(args, smsChain))
+		}
+	}
+	writeResult(w, r, result, err)
+}
+
+// deliveryReported is the set of the messenger providers
+// which report the delivery status.
+var deliveryReported = map[string]bool{"whatsapp": true, "viber": true}
+
+// splitCrossChain splits the cross-channel chain into the messenger chain
+// and the sms chain.
+func splitCrossChain(chain string) (messengers, smses string) {
+	var ms, ss []string
+	for _, name := range strings.Split(chain, ",") {
+		if name = strings.TrimSpace(name); strings.HasPrefix(name, "sms:") {
+			ss = append(ss, strings.TrimPrefix(name, "sms:"))
+		} else if name != "" {
+			ms = append(ms, name)
+		}
+	}
+	return strings.Join(ms, ","), strings.Join(ss, ",")
+}
+
+// fallbackIfUndelivered sends the sms if the message is not delivered
+// within the window.
+func fallbackIfUndelivered(delivered <-chan struct{}, window time.Duration,
+	result sendResult, req *Request) {
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+
+	select {
+	case <-delivered:
+	case <-timer.C:
+		glog.Errorf("the message %s by %s is not delivered in %s, fallback to sms",
+			result.ID, result.Provider, window)
+		if _, err := dispatchSMS(req); err != nil {
+			glog.Errorf("failed to send the fallback sms of the message %s: %s",
+				result.ID, err)
+		}
+	}
+}
+
+// newFallbackSMS returns the request to send the content of the message
+// by the sms provider or chain.
+func newFallbackSMS(args *MessageRequest, provider string) *Request {
+	phone := args.Phone
+	if phone == "" {
+		phone = args.To
+	}
+
+	content := args.Content
+	if args.Title != "" {
+		content = args.Title + "\n" + content
+	}
+
+	return &Request{Provider: provider, Phone: phone, Content: content,
+		Retry: args.Retry, tenant: args.tenant}
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// ScopeSendMMS is the scope of the API key to send the mms.
+const ScopeSendMMS = "send:mms"
+
+func (r *Request) validateMMS(c *Config) error {
+	if err := r.validate(); err != nil {
+		return err
+	} else if r.Phone == "" {
+		return fmt.Errorf("the phone is empty")
+	} else if len(r.Media) == 0 && r.VCard == nil {
+		return fmt.Errorf("the media is empty")
+	}
+
+	r.media = make([]messageapi.Media, 0, len(r.Media)+1)
+	for _, url := range r.Media {
+		r.media = append(r.media, messageapi.Media{URL: url})
+	}
+
+	if r.VCard != nil {
+		if c.MediaBaseURL == "" {
+			return fmt.Errorf("the vcard needs the media_base_url configuration")
+		}
+		url := putMedia(c, ".vcf", messageapi.ContentTypeVCard,
+			r.VCard.Bytes())
+		r.media = append(r.media, messageapi.Media{URL: url,
+			ContentType: messageapi.ContentTypeVCard})
+	}
+	return nil
+}
+
+// getMMS returns the mms provider used by the tenant, which is the only one
+// if the name is empty.
+func getMMS(c *Config, tenant, name string) (string, messageapi.MMS) {
+	if names := c.providerNames(tenant, "mms"); name == "" && len(names) == 1 {
+		name = names[0]
+	}
+	if p, ok := c.lookupProvider(tenant, "mms", name); ok {
+		return name, p.(messageapi.MMS)
+	}
+	return name, nil
+}
+
+func sendMMSBy(name string, mms messageapi.MMS, args *Request) (map[string]string, error) {
+	if isProviderPaused("mms", name) {
+		return nil, errProviderPaused
+	} else if err := checkProviderAllowlist("mms", name, []string{args.Phone}); err != nil {
+		return nil, err
+	} else if isSandboxed("mms", name, args.Phone) {
+		return dryRun("mms", name, args.Phone), nil
+	}
+	if err := waitRateLimit("mms", name); err != nil {
+		return nil, err
+	}
+
+	ctx, result := messageapi.WithResult(context.TODO())
+	ctx = messageapi.WithSMSOptions(ctx, args.smsOptions)
+	ctx = withPayloadLog(ctx, "mms", name, args, []string{args.Phone}, args.Content)
+	start := time.Now()
+	err := mms.SendMMS(ctx, args.Phone, args.Content, args.media)
+	reportResult("mms", name, time.Since(start), err)
+	return result.Metadata(), err
+}
+
+// dispatchMMS sends the mms by the provider in the request,
+// and records it into the history.
+func dispatchMMS(c *Config, args *Request) (result sendResult, err error) {
+	if result.ID = args.id; result.ID == "" {
+		result.ID = newMessageID()
+	}
+	publishAccepted(result.ID, "mms", args.Provider, []string{args.Phone}, args.Template)
+	defer func() {
+		record := Record{
+			ID:         result.ID,
+			Channel:    "mms",
+			Provider:   result.Provider,
+			Tenant:     args.tenant,
+			Recipients: []string{args.Phone},
+			Status:     recordStatus(result.Metadata),
+			Metadata:   result.Metadata,
+			CreatedAt:  time.Now(),
+		}
+		if err != nil {
+			record.Status = failedStatus(err)
+			record.setError(err)
+		}
+		recordHistory(record)
+		archiveRecord(record, args.Content, nil)
+	}()
+
+	name, mms := getMMS(c, args.tenant, args.Provider)
+	if mms == nil {
+		return result, noProviderError("have no the mms provider[" + args.Provider + "]")
+	} else if isSuppressed("sms", args.Phone) {
+		return result, suppressedError("the phone is suppressed")
+	} else if err = checkPreference("mms", args.Phone, args.Category); err != nil {
+		return
+	}
+
+	result.Provider = name
+	for attempt := 0; ; attempt++ {
+		if result.Metadata, err = sendMMSBy(name, mms, args); err == nil {
+			return
+		} else if attempt >= args.Retry || messageapi.IsPermanent(err) {
+			break
+		}
+		delay, ok := retryDelay(attempt, err)
+		if !ok {
+			break
+		}
+		glog.Errorf("failed to send the mms by %s, retry: %s", name, privateError(err, args.Phone))
+		time.Sleep(delay)
+	}
+	return
+}
+
+func sendMMS(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if len(_config.mmses) == 0 {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	} else if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeSendMMS, w, r) {
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("cannot read the body, err=%s", err)))
+		return
+	}
+
+	args := new(Request)
+	if err := json.Unmarshal(buf.Bytes(), args); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	args.tenant = _config.keyTenants[getAPIKey(r)]
+	if err := args.applyIdentity(_config, getAPIKey(r)); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	args.Provider, _ = getMMS(_config, args.tenant, args.Provider)
+	err := args.applyTemplate(_config)
+	if err == nil {
+		err = args.validateMMS(_config)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err = args.checkAllowlists(_config, "mms", getAPIKey(r)); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if shedLoad(_config, w, "mms", []string{args.Phone}, args.Priority, args.Category) {
+		return
+	}
+
+	if checkPaused(w, "mms", args.Provider, getAPIKey(r), func() {
+		if _, err := dispatchMMS(_config, args); err != nil {
+			glog.Errorf("failed to send the held mms: %s", err)
+		}
+	}, nil) {
+		return
+	}
+
+	result, err := dispatchMMS(_config, args)
+	writeResult(w, r, result, err)
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xgfone/messageapi/internal/redis"
+)
+
+// NonceStore is used to record the used nonces to reject the replays.
+type NonceStore interface {
+	// Use records the nonce until the expiration time, and returns false
+	// if the nonce has been used and not expired.
+	Use(nonce string, expire time.Time) (bool, error)
+}
+
+type memoryNonceStore struct {
+	sync.Mutex
+	nonces map[string]time.Time
+	last   time.Time
+}
+
+// NewMemoryNonceStore returns a new NonceStore based on the memory.
+func NewMemoryNonceStore() NonceStore {
+	return &memoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+func (s *memoryNonceStore) Use(nonce string, expire time.Time) (bool, error) {
+	now := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	// Clean the expired nonces once per minute at most.
+	if now.Sub(s.last) > time.Minute {
+		for n, e := range s.nonces {
+			if now.After(e) {
+				delete(s.nonces, n)
+			}
+		}
+		s.last = now
+	}
+
+	if e, ok := s.nonces[nonce]; ok && !now.After(e) {
+		return false, nil
+	}
+	s.nonces[nonce] = expire
+	return true, nil
+}
+
+var (
+	nonceLocker = new(sync.Mutex)
+	nonceStore  = NewMemoryNonceStore()
+)
+
+// SetNonceStore sets the store of the used nonces, which is in memory
+// by default. If there are more than one instances, you should use
+// a shared store.
+func SetNonceStore(s NonceStore) {
+	if s == nil {
+		panic("the nonce store must not be nil")
+	}
+
+	nonceLocker.Lock()
+	nonceStore = s
+	nonceLocker.Unlock()
+}
+
+func useNonce(nonce string, expire time.Time) (bool, error) {
+	nonceLocker.Lock()
+	s := nonceStore
+	nonceLocker.Unlock()
+	return s.Use(nonce, expire)
+}
+
+type redisNonceStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisNonceStore returns a new NonceStore based on redis, which can be
+// shared by more than one instances.
+//
+// All the keys of the nonces have the prefix, such as "messageapi:nonce:".
+func NewRedisNonceStore(addr, password string, db int, prefix string) NonceStore {
+	client := redis.NewClient(redis.Option{Addr: addr, Password: password, DB: db})
+	return redisNonceStore{client: client, prefix: prefix}
+}
+
+func (s redisNonceStore) Use(nonce string, expire time.Time) (bool, error) {
+	ttl := time.Until(expire) / time.Millisecond
+	if ttl <= 0 {
+		ttl = 1
+	}
+
+	_, err := redis.String(s.client.Do("SET", s.prefix+nonce, "1", "NX", "PX",
+		strconv.FormatInt(int64(ttl), 10)))
+	if err == redis.ErrNil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
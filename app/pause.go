@@ -0,0 +1,230 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// ScopeAdminPause is the scope of the API key to pause and resume the sending.
+const ScopeAdminPause = "admin:pause"
+
+// maxHeld is the maximum number of the messages held by a pause.
+const maxHeld = 1000
+
+// Pause stops sending the messages until it is resumed.
+//
+// The empty Channel, Provider or Key matches all, so the pause with all of
+// them empty is the global kill switch.
+type Pause struct {
+	// The channel, such as "email", "sms", "mms" or "messenger".
+	Channel  string `json:"channel,omitempty"`
+	Provider string `json:"provider,omitempty"`
+
+	// The API key of the tenant, which only pauses the messages sent by it.
+	Key string `json:"key,omitempty"`
+
+	// If true, the messages are held and sent when resumed. Or, they are
+	// rejected with the status code 503.
+	//
+	// The held messages are kept in memory, so they are lost on restart.
+	// At most 1000 messages are held by a pause, beyond which the messages
+	// are rejected with the status code 503.
+	Hold bool `json:"hold,omitempty"`
+
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// The number of the held messages.
+	Held int `json:"held"`
+
+	held   []func()
+	heldAt time.Time // When the first message is held.
+}
+
+func (p *Pause) match(channel, provider, key string) bool {
+	if p.Channel != "" && p.Channel != channel {
+		return false
+	} else if p.Key != "" && p.Key != key {
+		return false
+	} else if p.Provider == "" {
+		return true
+	}
+
+	for _, name := range strings.Split(provider, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(name), channel+":") == p.Provider {
+			return true
+		}
+	}
+	return false
+}
+
+// errProviderPaused is returned when sending by the paused provider.
+var errProviderPaused = messageapi.NewError(messageapi.ClassTemporary, "",
+	"the provider is paused")
+
+var (
+	pauseLocker = new(sync.Mutex)
+	pauses      = make(map[string]*Pause)
+)
+
+func pauseKey(channel, provider, key string) string {
+	return channel + "\x00" + provider + "\x00" + key
+}
+
+// checkPaused reports whether the message is paused. If so, it writes the
+// response, and the message is held to be sent by send when resumed, or
+// rejected and dropped by drop if not nil.
+func checkPaused(w http.ResponseWriter, channel, provider, key string,
+	send func(), drop func()) bool {
+	pauseLocker.Lock()
+	var pause *Pause
+	for _, p := range pauses {
+		if p.match(channel, provider, key) && (pause == nil || !p.Hold) {
+			pause = p
+		}
+	}
+	var full bool
+	if pause != nil && pause.Hold {
+		if full = len(pause.held) >= maxHeld; !full {
+			if len(pause.held) == 0 {
+				pause.heldAt = time.Now()
+			}
+			pause.held = append(pause.held, send)
+			pause.Held++
+		}
+	}
+	pauseLocker.Unlock()
+
+	if pause == nil {
+		return false
+	} else if pause.Hold && !full {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"held":true}`))
+		return true
+	}
+
+	msg := "the sending of " + channel + " is paused"
+	if full {
+		msg += " with too many held messages"
+	}
+	if pause.Reason != "" {
+		msg += ": " + pause.Reason
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(msg))
+	if drop != nil {
+		drop()
+	}
+	return true
+}
+
+// isProviderPaused reports whether the provider is paused for all the
+// tenants, which is checked before sending by the provider, so that the
+// messages sent by the fallbacks or the integrations are also stopped.
+func isProviderPaused(channel, provider string) bool {
+	pauseLocker.Lock()
+	defer pauseLocker.Unlock()
+	for _, p := range pauses {
+		if p.Key == "" && p.match(channel, provider, "") {
+			return true
+		}
+	}
+	return false
+}
+
+// resume removes the pause, and sends the held messages.
+func resume(channel, provider, key string) bool {
+	pauseLocker.Lock()
+	p, ok := pauses[pauseKey(channel, provider, key)]
+	delete(pauses, pauseKey(channel, provider, key))
+	pauseLocker.Unlock()
+
+	if ok && len(p.held) > 0 {
+		glog.Infof("resume to send %d held messages", len(p.held))
+		go func() {
+			for _, send := range p.held {
+				send()
+			}
+		}()
+	}
+	return ok
+}
+
+func handlePause(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if !authorize(_config, ScopeAdminPause, w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		pauseLocker.Lock()
+		items := make([]Pause, 0, len(pauses))
+		for _, p := range pauses {
+			items = append(items, *p)
+		}
+		pauseLocker.Unlock()
+
+		content, err := json.Marshal(items)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+
+	case "POST":
+		buf := bytes.NewBuffer(nil)
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var p Pause
+		if err := json.Unmarshal(buf.Bytes(), &p); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		p.CreatedAt, p.Held, p.held = time.Now(), 0, nil
+
+		key := pauseKey(p.Channel, p.Provider, p.Key)
+		pauseLocker.Lock()
+		if old, ok := pauses[key]; ok {
+			p.Held, p.held, p.heldAt = old.Held, old.held, old.heldAt
+		}
+		pauses[key] = &p
+		pauseLocker.Unlock()
+		glog.Warningf("pause the sending: channel=%s, provider=%s, reason=%s",
+			p.Channel, p.Provider, p.Reason)
+
+	case "DELETE":
+		query := r.URL.Query()
+		if !resume(query.Get("channel"), query.Get("provider"), query.Get("key")) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("have no the pause"))
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPauseHeldCap(t *testing.T) {
+	key := pauseKey("sms", "", "")
+	pauseLocker.Lock()
+	pauses[key] = &Pause{Channel: "sms", Hold: true}
+	pauseLocker.Unlock()
+	defer func() {
+		pauseLocker.Lock()
+		delete(pauses, key)
+		pauseLocker.Unlock()
+	}()
+
+	var sent, dropped int
+	send, drop := func() { sent++ }, func() { dropped++ }
+	for i := 0; i < maxHeld; i++ {
+		w := httptest.NewRecorder()
+		if !checkPaused(w, "sms", "aliyun", "", send, drop) || w.Code != http.StatusAccepted {
+			t.Fatalf("expect the held message, but got the status code %d", w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	if !checkPaused(w, "sms", "aliyun", "", send, drop) {
+		t.Fatal("expect the paused message, but got not")
+	} else if w.Code != http.StatusServiceUnavailable || dropped != 1 {
+		t.Errorf("expect the dropped message, but got the status code %d", w.Code)
+	}
+
+	if w = httptest.NewRecorder(); checkPaused(w, "email", "plain", "", send, drop) {
+		t.Error("expect the email not paused, but got paused")
+	}
+
+	pauseLocker.Lock()
+	held := pauses[key].held
+	pauseLocker.Unlock()
+	for _, send := range held {
+		send()
+	}
+	if sent != maxHeld {
+		t.Errorf("expect %d held messages, but got %d", maxHeld, sent)
+	}
+}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// ScopeAdminDebug is the scope of the API key to toggle the debug logging.
+const ScopeAdminDebug = "admin:debug"
+
+// PayloadLogging is the options of the debug logging of the payloads, which
+// logs the arguments of the message, the HTTP requests to the vendor and its
+// responses, to troubleshoot the integration with the provider.
+//
+// The recipients and the content of the message are redacted unless shown,
+// the former of which are always redacted in the privacy mode, see Privacy,
+// and the secrets, such as the header "Authorization" and the fields named
+// like "password" or "token", are always redacted, see messageapi.PayloadLog.
+type PayloadLogging struct {
+	// The providers like "CHANNEL:NAME", such as "sms:twilio",
+	// or "*" for all, whose payloads are logged.
+	Providers []string `json:"providers,omitempty"`
+
+	ShowContent    bool `json:"show_content,omitempty"`
+	ShowRecipients bool `json:"show_recipients,omitempty"`
+}
+
+// PayloadToggle is the payload logging of a provider enabled at runtime.
+type PayloadToggle struct {
+	// The provider like "CHANNEL:NAME", or "*" for all.
+	Provider string `json:"provider"`
+
+	// The number of the seconds for which the logging is enabled,
+	// which is 900 by default.
+	Duration int `json:"duration,omitempty"`
+
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+var (
+	payloadLocker  = new(sync.Mutex)
+	payloadToggles = make(map[string]PayloadToggle)
+)
+
+// isPayloadLogged reports whether the payloads of the provider are logged
+// by the feature flag, the configuration or the runtime toggle.
+func isPayloadLogged(c *Config, provider string) bool {
+	if featureEnabled(FeaturePayloadLogging) {
+		return true
+	}
+	for _, p := range c.PayloadLogging.Providers {
+		if p == provider || p == "*" {
+			return true
+		}
+	}
+
+	payloadLocker.Lock()
+	defer payloadLocker.Unlock()
+	now := time.Now()
+	for _, p := range []string{provider, "*"} {
+		if t, ok := payloadToggles[p]; ok {
+			if now.Before(t.ExpiresAt) {
+				return true
+			}
+			delete(payloadToggles, p)
+		}
+	}
+	return false
+}
+
+// withPayloadLog returns the context with the payload logging if it is
+// enabled for the provider, and logs the arguments of the message.
+// The recipients and the contents are redacted unless shown.
+func withPayloadLog(ctx context.Context, channel, name string, args interface{},
+	recipients []string, contents ...string) context.Context {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	provider := providerKey(channel, name)
+	if !isPayloadLogged(_config, provider) {
+		return ctx
+	}
+
+	var values []string
+	if !_config.PayloadLogging.ShowRecipients || _config.Privacy != nil {
+		values = append(values, recipients...)
+	}
+	if !_config.PayloadLogging.ShowContent {
+		values = append(values, contents...)
+	}
+
+	pl := messageapi.PayloadLog{Provider: provider, Values: values, Logf: glog.Infof}
+	if data, err := json.Marshal(args); err != nil {
+		glog.Errorf("%s: failed to encode the arguments: %s", provider, err)
+	} else {
+		pl.Log("arguments", data)
+	}
+	return messageapi.WithPayloadLog(ctx, pl)
+}
+
+// handlePayloadLogging manages the payload logging enabled at runtime, which
+// needs the scope "admin:debug". "GET" returns the enabled ones, "POST"
+// enables it by PayloadToggle, and "DELETE" disables the one of the query
+// argument "provider".
+func handlePayloadLogging(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if !authorize(_config, ScopeAdminDebug, w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		now := time.Now()
+		payloadLocker.Lock()
+		toggles := make([]PayloadToggle, 0, len(payloadToggles))
+		for p, t := range payloadToggles {
+			if now.Before(t.ExpiresAt) {
+				toggles = append(toggles, t)
+			} else {
+				delete(payloadToggles, p)
+			}
+		}
+		payloadLocker.Unlock()
+		sort.Slice(toggles, func(i, j int) bool { return toggles[i].Provider < toggles[j].Provider })
+
+		content, err := json.Marshal(toggles)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(content)
+
+	case "POST":
+		buf := bytes.NewBuffer(nil)
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var t PayloadToggle
+		if err := json.Unmarshal(buf.Bytes(), &t); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		} else if t.Provider == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("the provider is empty"))
+			return
+		}
+		if t.Duration <= 0 {
+			t.Duration = 900
+		}
+		t.ExpiresAt = time.Now().Add(time.Duration(t.Duration) * time.Second)
+
+		payloadLocker.Lock()
+		payloadToggles[t.Provider] = t
+		payloadLocker.Unlock()
+		glog.Warningf("enable the payload logging of %s for %ds", t.Provider, t.Duration)
+
+	case "DELETE":
+		provider := r.URL.Query().Get("provider")
+		payloadLocker.Lock()
+		_, ok := payloadToggles[provider]
+		delete(payloadToggles, provider)
+		payloadLocker.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("have no the payload logging"))
+			return
+		}
+		glog.Warningf("disable the payload logging of %s", provider)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
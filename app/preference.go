@@ -0,0 +1,448 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ScopeAdminPreference is the scope of the API key to manage the preferences
+// of the recipients.
+const ScopeAdminPreference = "admin:preference"
+
+// preferenceLinkTTL is the duration during which the signed link to manage
+// the preferences is valid.
+const preferenceLinkTTL = 30 * 24 * time.Hour
+
+// QuietHours is the daily period in which the recipient receives no messages,
+// such as from "22:00" to "08:00".
+type QuietHours struct {
+	// The start and the end like "HH:MM", the former of which may be later
+	// than the latter to cross the midnight.
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	// The IANA time zone of the recipient, such as "Asia/Shanghai",
+	// which is UTC by default.
+	Timezone string `json:"timezone,omitempty"`
+
+	// The categories of the messages which are still sent in the quiet hours,
+	// such as "security".
+	Except []string `json:"except,omitempty"`
+}
+
+func parseClock(s string) (minutes int, err error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s' of the quiet hours", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (q QuietHours) validate() (err error) {
+	if _, err = parseClock(q.Start); err == nil {
+		if _, err = parseClock(q.End); err == nil {
+			_, err = time.LoadLocation(q.Timezone)
+		}
+	}
+	return
+}
+
+// contains reports whether the time is in the quiet hours.
+func (q QuietHours) contains(now time.Time) bool {
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return false
+	}
+	start, _ := parseClock(q.Start)
+	end, _ := parseClock(q.End)
+
+	now = now.In(loc)
+	minutes := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return start <= minutes && minutes < end
+	}
+	return minutes >= start || minutes < end
+}
+
+// Preference is the consent and the preferences of a recipient, which are
+// checked before sending the messages with the category, see Request.Category.
+// The messages violating them are not sent, and recorded with the status
+// StatusOptedOut.
+type Preference struct {
+	// The email address or the phone of the recipient.
+	Recipient string `json:"recipient"`
+
+	// The channels allowed to reach the recipient, such as "sms" but not
+	// "mms" or "messenger" for the phone. If empty, allow all.
+	Channels []string `json:"channels,omitempty"`
+
+	// The consent of the categories, such as {"marketing": false}.
+	// The categories not in it are allowed.
+	Categories map[string]bool `json:"categories,omitempty"`
+
+	// The preferred locale, such as "zh-CN", which is the template variable
+	// "locale" of the messages to the recipient if not given.
+	Locale string `json:"locale,omitempty"`
+
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (p Preference) validate() error {
+	for _, channel := range p.Channels {
+		switch channel {
+		case "email", "sms", "mms", "messenger":
+		default:
+			return fmt.Errorf("unknown channel '%s'", channel)
+		}
+	}
+	if p.QuietHours != nil {
+		return p.QuietHours.validate()
+	}
+	return nil
+}
+
+// check returns the reason why the message of the category by the channel
+// is not sent to the recipient at the time, or "" if it is allowed.
+func (p Preference) check(channel, category string, now time.Time) string {
+	if len(p.Channels) > 0 && !inStrings(p.Channels, channel) {
+		return "the recipient does not allow the channel " + channel
+	} else if consent, ok := p.Categories[category]; ok && !consent {
+		return "the recipient does not consent to the category " + category
+	} else if q := p.QuietHours; q != nil && !inStrings(q.Except, category) && q.contains(now) {
+		return "it is in the quiet hours of the recipient"
+	}
+	return ""
+}
+
+func inStrings(ss []string, s string) bool {
+	for _, _s := range ss {
+		if _s == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PreferenceStore is used to store the preferences of the recipients,
+// the key of which is the normalized recipient, see Preference.Recipient.
+type PreferenceStore interface {
+	Set(Preference) error
+	Remove(recipient string) error
+	Get(recipient string) (p Preference, ok bool, err error)
+	List() ([]Preference, error)
+}
+
+type memoryPreferenceStore struct {
+	sync.RWMutex
+	items map[string]Preference
+}
+
+// NewMemoryPreferenceStore returns a new PreferenceStore based on the memory.
+func NewMemoryPreferenceStore() PreferenceStore {
+	return &memoryPreferenceStore{items: make(map[string]Preference)}
+}
+
+func (s *memoryPreferenceStore) Set(p Preference) error {
+	s.Lock()
+	s.items[p.Recipient] = p
+	s.Unlock()
+	return nil
+}
+
+func (s *memoryPreferenceStore) Remove(recipient string) error {
+	s.Lock()
+	delete(s.items, recipient)
+	s.Unlock()
+	return nil
+}
+
+func (s *memoryPreferenceStore) Get(recipient string) (Preference, bool, error) {
+	s.RLock()
+	p, ok := s.items[recipient]
+	s.RUnlock()
+	return p, ok, nil
+}
+
+func (s *memoryPreferenceStore) List() ([]Preference, error) {
+	s.RLock()
+	results := make([]Preference, 0, len(s.items))
+	for _, p := range s.items {
+		results = append(results, p)
+	}
+	s.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Recipient < results[j].Recipient
+	})
+	return results, nil
+}
+
+var (
+	preferenceLocker = new(sync.Mutex)
+	preferenceStore  = NewMemoryPreferenceStore()
+)
+
+// SetPreferenceStore sets the store of the preferences of the recipients,
+// which is in memory by default.
+func SetPreferenceStore(s PreferenceStore) {
+	if s == nil {
+		panic("the preference store must not be nil")
+	}
+
+	preferenceLocker.Lock()
+	preferenceStore = s
+	preferenceLocker.Unlock()
+}
+
+func getPreferenceStore() PreferenceStore {
+	preferenceLocker.Lock()
+	defer preferenceLocker.Unlock()
+	return preferenceStore
+}
+
+// normalizeAddress normalizes the email address or the phone of the recipient
+// whose channel is unknown, see normalizeRecipient.
+func normalizeAddress(address string) string {
+	if strings.Contains(address, "@") {
+		return normalizeRecipient("email", address)
+	}
+	return normalizeRecipient("sms", address)
+}
+
+// getPreference returns the preferences of the recipient, or the tombstone
+// of its opt-outs after erased, see tombstonePreference.
+func getPreference(recipient string) (Preference, bool) {
+	store := getPreferenceStore()
+	recipient = normalizeAddress(recipient)
+	p, ok, err := store.Get(recipient)
+	if err == nil && !ok {
+		p, ok, err = store.Get(tombstoneKey(recipient))
+	}
+	if err != nil {
+		glog.Errorf("failed to look up the preferences: %s", err)
+		return Preference{}, false
+	}
+	return p, ok
+}
+
+// checkPreference returns preferenceError if the message of the category by
+// the channel violates the preferences of the recipient. The messages
+// without the category are always allowed.
+func checkPreference(channel, recipient, category string) error {
+	if category == "" {
+		return nil
+	} else if p, ok := getPreference(recipient); !ok {
+		return nil
+	} else if reason := p.check(channel, category, time.Now()); reason != "" {
+		return preferenceError(reason)
+	}
+	return nil
+}
+
+// preferenceLink returns the signed link by which the recipient manages
+// the own preferences, which can be embedded in the message, or "" if the
+// public base url or the token secret is not configured.
+func preferenceLink(c *Config, recipient string) string {
+	recipient = normalizeAddress(recipient)
+	if c.MediaBaseURL == "" || c.tokenSecret == "" || recipient == "" {
+		return ""
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(preferenceLinkTTL).Unix(), 10)
+	query := url.Values{
+		"expires": {expires},
+		"sig":     {preferenceSignature(c.tokenSecret, recipient, expires)},
+	}
+	return c.MediaBaseURL + "/v1/preferences/" + url.PathEscape(recipient) + "?" + query.Encode()
+}
+
+func preferenceSignature(secret, recipient, expires string) string {
+	return signToken(secret, []byte("preferences\n"+recipient+"\n"+expires))
+}
+
+// verifyPreferenceLink reports whether the query of the preference link
+// of the normalized recipient is valid.
+func verifyPreferenceLink(c *Config, recipient string, query url.Values) bool {
+	if c.tokenSecret == "" || query.Get("sig") == "" {
+		return false
+	}
+
+	expires := query.Get("expires")
+	n, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().After(time.Unix(n, 0).Add(c.clockSkew())) {
+		return false
+	}
+
+	sig := preferenceSignature(c.tokenSecret, recipient, expires)
+	return hmac.Equal([]byte(sig), []byte(query.Get("sig")))
+}
+
+// handlePreferences manages the preferences of the recipients with the scope
+// "admin:preference":
+//
+//	GET    /v1/preferences                     list all
+//	GET    /v1/preferences?recipient=RECIPIENT get one
+//	POST   /v1/preferences                     set, see Preference
+//	DELETE /v1/preferences?recipient=RECIPIENT remove
+//
+// And "/v1/preferences/RECIPIENT" gets and sets the preferences of a
+// recipient by "GET" and "PUT", see handleRecipientPreference.
+func handlePreferences(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/v1/preferences/") {
+		handleRecipientPreference(_config, w, r)
+		return
+	} else if !authorize(_config, ScopeAdminPreference, w, r) {
+		return
+	}
+
+	var err error
+	var result interface{}
+	store := getPreferenceStore()
+	recipient := normalizeAddress(r.URL.Query().Get("recipient"))
+	switch r.Method {
+	case "GET":
+		if recipient == "" {
+			result, err = store.List()
+		} else {
+			var ok bool
+			if result, ok, err = store.Get(recipient); err == nil && !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+	case "POST":
+		var p Preference
+		if p, err = readPreference(r); err == nil && p.Recipient == "" {
+			err = fmt.Errorf("the recipient is empty")
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		err = store.Set(p)
+	case "DELETE":
+		err = store.Remove(recipient)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writePreferenceResult(w, result, err)
+}
+
+// handleRecipientPreference gets or replaces the preferences of a recipient,
+// which is authorized by the API key with the scope "admin:preference", or by
+// the signed link for the recipient to manage the own preferences, such as
+// opting out of "marketing" but keeping "security", which is the template
+// variable "preferences_url". The self-service page of the link should call
+// the API with the query arguments "expires" and "sig" of the link.
+//
+//	GET /v1/preferences/RECIPIENT
+//	PUT /v1/preferences/RECIPIENT
+//
+// If the recipient has no preferences, GET returns the default.
+func handleRecipientPreference(c *Config, w http.ResponseWriter, r *http.Request) {
+	escaped := strings.TrimPrefix(r.URL.EscapedPath(), "/v1/preferences/")
+	recipient, err := url.PathUnescape(escaped)
+	if recipient = normalizeAddress(recipient); err != nil || recipient == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !verifyPreferenceLink(c, recipient, r.URL.Query()) &&
+		!authorize(c, ScopeAdminPreference, w, r) {
+		return
+	}
+
+	var result interface{}
+	store := getPreferenceStore()
+	switch r.Method {
+	case "GET":
+		p, ok, err := store.Get(recipient)
+		if err == nil && !ok {
+			p = Preference{Recipient: recipient}
+		}
+		writePreferenceResult(w, p, err)
+
+	case "PUT":
+		p, err := readPreference(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		} else if p.Recipient != "" && p.Recipient != recipient {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("the recipient does not match the path"))
+			return
+		}
+
+		p.Recipient = recipient
+		if err = store.Set(p); err == nil {
+			result = p
+		}
+		writePreferenceResult(w, result, err)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// readPreference reads the preference from the body of the request.
+// The recipient of it may be empty, which is set by the caller.
+func readPreference(r *http.Request) (p Preference, err error) {
+	buf := bytes.NewBuffer(nil)
+	if _, err = buf.ReadFrom(r.Body); err != nil {
+		return
+	} else if err = json.Unmarshal(buf.Bytes(), &p); err != nil {
+		return
+	}
+
+	p.Recipient = normalizeAddress(p.Recipient)
+	p.UpdatedAt = time.Now()
+	err = p.validate()
+	return
+}
+
+func writePreferenceResult(w http.ResponseWriter, result interface{}, err error) {
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	} else if result == nil {
+		return
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
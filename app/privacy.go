@@ -0,0 +1,148 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// Privacy is the options of the privacy mode, in which the recipients are
+// logged and published only as the salted hashes, such as in the payload
+// logs, the error logs and the events of the event bus, while the full values
+// are kept only in the message store, that's, the history and the archives.
+//
+// The hash of a recipient is like "h:0123456789abcdef", which is the prefix of
+// HMAC-SHA256 of the normalized email address or phone by the salt, so the
+// logs of the same recipient may still be correlated.
+type Privacy struct {
+	// The secret salt of the hashes, which may be encrypted, see SetCipher.
+	Salt string `json:"salt"`
+
+	// If true, the recipients in the history are also encrypted by the cipher
+	// in memory, and decrypted only when read by the history API.
+	// It needs the cipher, see SetCipher.
+	EncryptHistory bool `json:"encrypt_history,omitempty"`
+}
+
+func getPrivacy() (*Privacy, string) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+	return _config.Privacy, _config.privacySalt
+}
+
+func hashRecipient(salt, recipient string) string {
+	h := hmac.New(sha256.New, []byte(salt))
+	h.Write([]byte(normalizeAddress(recipient)))
+	return "h:" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// privateRecipients returns the hashes of the recipients in the privacy mode,
+// or the recipients themselves.
+func privateRecipients(recipients []string) []string {
+	privacy, salt := getPrivacy()
+	if privacy == nil || len(recipients) == 0 {
+		return recipients
+	}
+
+	hashes := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		hashes[i] = hashRecipient(salt, recipient)
+	}
+	return hashes
+}
+
+// privateRecipient is the same as privateRecipients, but for one recipient.
+func privateRecipient(recipient string) string {
+	return privateRecipients([]string{recipient})[0]
+}
+
+// privateText replaces the recipients in the text, such as the error of the
+// provider, with their hashes in the privacy mode.
+func privateText(text string, recipients ...string) string {
+	privacy, salt := getPrivacy()
+	if privacy == nil {
+		return text
+	}
+
+	for _, recipient := range recipients {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			text = strings.Replace(text, recipient, hashRecipient(salt, recipient), -1)
+		}
+	}
+	return text
+}
+
+// privateError is the same as privateText, but for the error.
+func privateError(err error, recipients ...string) string {
+	if err == nil {
+		return ""
+	}
+	return privateText(err.Error(), recipients...)
+}
+
+// sealRecord encrypts the recipients of the record to be stored into the
+// history if Privacy.EncryptHistory is true.
+func sealRecord(r Record) Record {
+	privacy, salt := getPrivacy()
+	if privacy == nil || !privacy.EncryptHistory {
+		return r
+	}
+
+	c := getCipher()
+	seal := func(recipients []string) []string {
+		if len(recipients) == 0 {
+			return recipients
+		}
+
+		sealed := make([]string, len(recipients))
+		for i, recipient := range recipients {
+			var err error
+			if c == nil {
+				sealed[i] = hashRecipient(salt, recipient)
+			} else if sealed[i], err = encryptValue(c, recipient); err != nil {
+				glog.Errorf("failed to encrypt the recipient of the message %s: %s", r.ID, err)
+				sealed[i] = hashRecipient(salt, recipient)
+			}
+		}
+		return sealed
+	}
+
+	r.Recipients = seal(r.Recipients)
+	r.OriginalRecipients = seal(r.OriginalRecipients)
+	return r
+}
+
+// openRecipient decrypts the recipient sealed by sealRecord. If failing,
+// it returns the recipient as it is.
+func openRecipient(recipient string) string {
+	if !strings.HasPrefix(recipient, SecretPrefix) {
+		return recipient
+	}
+	if plain, err := decryptValue(getCipher(), recipient); err == nil {
+		return plain
+	}
+	return recipient
+}
+
+// openRecord decrypts the recipients of the record sealed by sealRecord.
+func openRecord(r Record) Record {
+	open := func(recipients []string) []string {
+		if len(recipients) == 0 {
+			return recipients
+		}
+
+		opened := make([]string, len(recipients))
+		for i, recipient := range recipients {
+			opened[i] = openRecipient(recipient)
+		}
+		return opened
+	}
+
+	r.Recipients = open(r.Recipients)
+	r.OriginalRecipients = open(r.OriginalRecipients)
+	return r
+}
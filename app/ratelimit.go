@@ -0,0 +1,149 @@
+package app
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/xgfone/messageapi"
+)
+
+const defaultRateLimitWait = 10
+
+// tokenBucket is a token bucket to limit the rate of sending the messages.
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64 // The number of the tokens per second.
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(1, math.Ceil(rate))
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes a token and returns the duration to wait until it is
+// available. If the duration exceeds maxWait, no token is taken and
+// return false.
+func (b *tokenBucket) reserve(maxWait time.Duration) (time.Duration, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		if wait > maxWait {
+			return wait, false
+		}
+	}
+	b.tokens--
+	return wait, true
+}
+
+var (
+	limiterLocker = new(sync.Mutex)
+	limiters      = make(map[string]*tokenBucket)
+
+	// The providers are paused until the time, which they ask for by
+	// the rate limited responses, such as by the header "Retry-After".
+	throttles = make(map[string]time.Time)
+)
+
+// throttleProvider pauses the provider of the key for the duration.
+func throttleProvider(key string, d time.Duration) {
+	until := time.Now().Add(d)
+	limiterLocker.Lock()
+	if until.After(throttles[key]) {
+		throttles[key] = until
+	}
+	limiterLocker.Unlock()
+}
+
+// getThrottle returns the duration until the provider is not paused.
+func getThrottle(key string) time.Duration {
+	limiterLocker.Lock()
+	defer limiterLocker.Unlock()
+
+	until, ok := throttles[key]
+	if !ok {
+		return 0
+	} else if wait := time.Until(until); wait > 0 {
+		return wait
+	}
+	delete(throttles, key)
+	return 0
+}
+
+// getRateLimitWait returns the maximum duration to wait for the rate limit.
+func getRateLimitWait() time.Duration {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if _config.RateLimitWait > 0 {
+		return time.Duration(_config.RateLimitWait) * time.Second
+	}
+	return time.Duration(defaultRateLimitWait) * time.Second
+}
+
+func getLimiter(key string) (*tokenBucket, time.Duration) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	rate := _config.RateLimits[key]
+	maxWait := getRateLimitWait()
+
+	limiterLocker.Lock()
+	defer limiterLocker.Unlock()
+
+	if rate <= 0 {
+		delete(limiters, key)
+		return nil, 0
+	}
+
+	b, ok := limiters[key]
+	if !ok || b.rate != rate {
+		b = newTokenBucket(rate)
+		limiters[key] = b
+	}
+	return b, maxWait
+}
+
+// waitRateLimit waits until the provider is allowed to send the message by
+// the rate limit. If it needs to wait too long, return a temporary error
+// so that the message is deferred, or sent by the next provider in the chain.
+//
+// It also waits until the provider is not paused by throttleProvider.
+func waitRateLimit(channel, name string) error {
+	if wait := getThrottle(providerKey(channel, name)); wait > 0 {
+		if wait > getRateLimitWait() {
+			e := messageapi.NewError(messageapi.ClassTemporary, "",
+				"the provider "+providerKey(channel, name)+" is throttled")
+			e.RetryAfter = wait
+			return e
+		}
+		time.Sleep(wait)
+	}
+
+	b, maxWait := getLimiter(providerKey(channel, name))
+	if b == nil {
+		return nil
+	}
+
+	wait, ok := b.reserve(maxWait)
+	if !ok {
+		return messageapi.NewError(messageapi.ClassTemporary, "",
+			"the provider "+providerKey(channel, name)+" is rate limited")
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return nil
+}
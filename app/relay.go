@@ -0,0 +1,188 @@
+package app
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"net"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi/smtpd"
+)
+
+// SMTPRelay is an embedded SMTP server, which receives the emails from the
+// legacy applications that can only send the emails by SMTP, and sends them
+// by the configured email providers, like "/v1/email".
+//
+// The envelope recipients are used as the recipients, and the text body,
+// or the html body if no text body, is used as the content.
+//
+// Notice: If Username is empty, anyone who can connect to it can send emails,
+// so you should listen on the local or internal address only.
+type SMTPRelay struct {
+	// The address to listen on, such as "127.0.0.1:2525".
+	Addr string
+
+	// The hostname in the greeting. The default is os.Hostname().
+	Hostname string
+
+	// If Username is not empty, the client must authenticate by them.
+	Username string
+	Password string
+
+	// The email provider and the retry number to send the received emails.
+	// If Provider is empty, use the default email provider in the configuration.
+	Provider string
+	Retry    int
+
+	// If set, STARTTLS is supported and required before authentication.
+	TLSConfig *tls.Config
+
+	// The maximum size of the message. The default is 10MB.
+	MaxSize int64
+
+	// If true, the received emails are not sent by the providers, but parsed
+	// and forwarded as JSON to Config.InboundEmailWebhooks, see InboundEmail.
+	// It is used to receive the replies, for example.
+	//
+	// If no webhooks are configured, the emails are refused temporarily by 451
+	// instead of being dropped, except the trusted bounces, see BouncePeers,
+	// which are handled by publishing the bounced events.
+	Inbound bool
+
+	// The IP addresses or the CIDRs of the peers, such as the MTA receiving
+	// the bounces, from which the bounces are trusted in the inbound mode.
+	//
+	// Since the bounced recipients may be suppressed, the bounces are only
+	// published if the client is authenticated by Username or in BouncePeers.
+	// Or, they are handled as the other inbound emails.
+	BouncePeers []string
+}
+
+// ListenAndServe starts the SMTP relay.
+func (r SMTPRelay) ListenAndServe() error {
+	server := &smtpd.Server{
+		Addr:      r.Addr,
+		Hostname:  r.Hostname,
+		Handler:   r.handle,
+		TLSConfig: r.TLSConfig,
+		MaxSize:   r.MaxSize,
+	}
+	if r.Username != "" {
+		server.Auth = r.auth
+	}
+
+	glog.Infof("the smtp relay is listening on %s", r.Addr)
+	return server.ListenAndServe()
+}
+
+func (r SMTPRelay) auth(username, password string) error {
+	if subtle.ConstantTimeCompare([]byte(username), []byte(r.Username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(r.Password)) != 1 {
+		return smtpd.Error{Code: 535, Message: "Authentication credentials invalid"}
+	}
+	return nil
+}
+
+// trustBounces reports whether the bounces from the client are trusted.
+func (r SMTPRelay) trustBounces(env *smtpd.Envelope) bool {
+	if env.Username != "" {
+		return true
+	} else if env.RemoteAddr == nil {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(env.RemoteAddr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, peer := range r.BouncePeers {
+		if _, ipnet, err := net.ParseCIDR(peer); err == nil {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		} else if peerIP := net.ParseIP(peer); peerIP != nil && peerIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r SMTPRelay) handle(env *smtpd.Envelope) error {
+	if IsReadOnly() {
+		return smtpd.Error{Code: 554, Message: "Transaction failed: the server is a read-only replica"}
+	}
+
+	e, err := parseEmail(env.Data)
+	if err != nil {
+		return smtpd.Error{Code: 554, Message: "Invalid message: " + err.Error()}
+	}
+
+	if r.Inbound {
+		trusted := r.trustBounces(env)
+		if trusted {
+			publishBounces(e)
+		}
+		if len(getInboundEmailWebhooks()) == 0 {
+			if trusted && len(e.DeliveryStatus) > 0 {
+				return nil
+			}
+			return smtpd.Error{Code: 451, Message: "Requested action aborted: no inbound email webhooks"}
+		}
+
+		ie := newInboundEmail(e)
+		ie.EnvelopeFrom = env.From
+		ie.EnvelopeTo = env.To
+		ie.RemoteAddr = env.RemoteAddr.String()
+		if err = forwardInboundEmail(ie); err != nil {
+			glog.Errorf("failed to forward the email from %s to %v: %s", privateRecipient(env.From),
+				privateRecipients(env.To), privateError(err, append([]string{env.From}, env.To...)...))
+			return smtpd.Error{Code: 451, Message: "Requested action aborted: " + err.Error()}
+		}
+		return nil
+	}
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	args := &Request{
+		Provider: r.Provider,
+		To:       strings.Join(env.To, ","),
+		Subject:  e.Subject,
+		Content:  e.Text,
+		HTML:     e.HTML,
+		Retry:    r.Retry,
+	}
+	if args.Provider == "" {
+		args.Provider = getDefaultProvider(_config, true)
+	}
+	if args.Subject == "" {
+		args.Subject = "(no subject)"
+	}
+	if len(e.Attachments) > 0 {
+		args.Attachments = make(map[string]string, len(e.Attachments))
+		for name, data := range e.Attachments {
+			args.Attachments[name] = string(data)
+		}
+	}
+
+	if err = args.validateEmail(); err != nil {
+		return smtpd.Error{Code: 554, Message: err.Error()}
+	}
+
+	if _, err = dispatchEmail(args); err != nil {
+		glog.Errorf("failed to relay the email from %s to %v: %s", privateRecipient(env.From),
+			privateRecipients(env.To), privateError(err, append([]string{env.From}, env.To...)...))
+		if errorStatus(err) < 500 {
+			return smtpd.Error{Code: 550, Message: err.Error()}
+		}
+		return smtpd.Error{Code: 451, Message: "Requested action aborted: " + err.Error()}
+	}
+	return nil
+}
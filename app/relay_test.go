@@ -0,0 +1,34 @@
+package app
+
+import (
+	"net"
+	"testing"
+
+	"github.com/xgfone/messageapi/smtpd"
+)
+
+func TestSMTPRelayTrustBounces(t *testing.T) {
+	r := SMTPRelay{BouncePeers: []string{"10.0.0.0/8", "192.168.1.1", "::1", "invalid"}}
+	for _, c := range []struct {
+		addr     string
+		username string
+		trusted  bool
+	}{
+		{"10.1.2.3:25", "", true},
+		{"192.168.1.1:25", "", true},
+		{"[::1]:25", "", true},
+		{"192.168.1.2:25", "", false},
+		{"172.16.0.1:25", "", false},
+		{"172.16.0.1:25", "relay", true},
+	} {
+		addr, err := net.ResolveTCPAddr("tcp", c.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		env := &smtpd.Envelope{RemoteAddr: addr, Username: c.username}
+		if trusted := r.trustBounces(env); trusted != c.trusted {
+			t.Errorf("%s: expect %v, but got %v", c.addr, c.trusted, trusted)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+)
+
+var readOnly int32
+
+// SetReadOnly sets whether the server is a read-only replica, which serves
+// only the read endpoints backed by the configuration and the stores shared
+// with the other instances, such as getting the configuration and the
+// suppressions and the preferences, see SetSuppressionStore and
+// SetPreferenceStore, but refuses to send the messages and to change the
+// state with the status code 403. So it may be used by the support tooling
+// without granting the send capability.
+//
+// The history, the statistics, the webhook deliveries and the media are kept
+// in the memory of each instance, so the replica, which sends nothing, refuses
+// to serve them with the status code 501 instead of the empty results. Read
+// them from the instances sending the messages, or from the shared event bus
+// or the archive, see Config.EventBus and Config.Archive.
+//
+// The replica does not send the canary messages or rotate the credentials
+// of the providers either. Since resetting the configuration by the API is
+// also refused, it is given by Start or WatchConfig.
+//
+// It should be called before Start.
+func SetReadOnly(b bool) {
+	var v int32
+	if b {
+		v = 1
+	}
+	if atomic.SwapInt32(&readOnly, v) != v {
+		glog.Infof("set the read-only replica mode to %v", b)
+	}
+}
+
+// IsReadOnly reports whether the server is a read-only replica.
+func IsReadOnly() bool { return atomic.LoadInt32(&readOnly) == 1 }
+
+func writeReadOnly(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("the server is a read-only replica"))
+}
+
+// readable wraps the handler, which only allows the GET and HEAD requests
+// on the read-only replica.
+func readable(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if IsReadOnly() && r.Method != "GET" && r.Method != "HEAD" {
+			writeReadOnly(w)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// local wraps the handler serving the state kept in the memory of the
+// instance, such as the history, which is refused on the read-only replica.
+func local(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if IsReadOnly() {
+			w.WriteHeader(http.StatusNotImplemented)
+			w.Write([]byte("the read-only replica has no the state of the other instances"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// writable wraps the handler, which is refused on the read-only replica,
+// such as the one to send the messages.
+func writable(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if IsReadOnly() {
+			writeReadOnly(w)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+var (
+	rotationLocker = new(sync.Mutex)
+	stopRotation   context.CancelFunc
+)
+
+// startRotation restarts rotating the credentials of the providers of the
+// configuration which implement messageapi.Rotator, and stops the previous.
+func startRotation(c *Config) {
+	rotators := make(map[string]messageapi.Rotator)
+	add := func(channel, name string, provider interface{}) {
+		if r, ok := provider.(messageapi.Rotator); ok {
+			rotators[providerKey(channel, name)] = r
+		}
+	}
+	for name, p := range c.emails {
+		add("email", name, p)
+	}
+	for name, p := range c.smses {
+		add("sms", name, p)
+	}
+	for name, p := range c.mmses {
+		add("mms", name, p)
+	}
+	for name, p := range c.messengers {
+		add("messenger", name, p)
+	}
+	for tenant, instances := range c.tenantProviders {
+		for key, p := range instances {
+			if r, ok := p.(messageapi.Rotator); ok {
+				rotators[tenant+"/"+key] = r
+			}
+		}
+	}
+
+	rotationLocker.Lock()
+	defer rotationLocker.Unlock()
+
+	if stopRotation != nil {
+		stopRotation()
+		stopRotation = nil
+	}
+	if len(rotators) == 0 || IsReadOnly() {
+		return
+	}
+
+	var ctx context.Context
+	ctx, stopRotation = context.WithCancel(context.Background())
+	interval := time.Duration(c.RotateInterval) * time.Second
+	go messageapi.RunRotation(ctx, interval, rotators, func(key string, err error) {
+		glog.Errorf("failed to rotate the credentials of the provider %s: %s", key, err)
+	})
+}
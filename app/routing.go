@@ -0,0 +1,192 @@
+package app
+
+import (
+	"sort"
+	"strings"
+)
+
+// defaultRoute is the key of the default route in the routing table.
+const defaultRoute = "default"
+
+// RoutingLeastCost is the routing strategy to select the cheapest provider.
+const RoutingLeastCost = "least_cost"
+
+// e164Digits returns the digits of the phone in the E.164 format
+// without the leading "+", or "" if the phone is not in the E.164 format.
+func e164Digits(phone string) string {
+	if i := strings.IndexAny(phone, "+0123456789"); i > -1 {
+		phone = phone[i:]
+	}
+	if !strings.HasPrefix(phone, "+") && !strings.HasPrefix(phone, "00") {
+		return ""
+	}
+	return strings.TrimPrefix(normalizeRecipient("sms", phone), "+")
+}
+
+// routeSMS returns the provider chain of the phone by the routing table,
+// which is the comma-separated provider names, or "" if no route matches.
+//
+// The route of the longest country code, which is the prefix of the phone,
+// is selected, or the default route if none matches.
+//
+// If the routing strategy is "least_cost", the providers of the route, or all
+// the sms providers if no routing table, are sorted by the price to the phone.
+func routeSMS(c *Config, phone string) string {
+	digits := e164Digits(phone)
+
+	var chain []string
+	if len(c.SMSRoutes) > 0 {
+		if c.routeCodes != nil {
+			chain = c.SMSRoutes[c.routeCodes.match(digits)]
+		} else {
+			chain = c.SMSRoutes[matchCountryCode(c.SMSRoutes, digits)]
+		}
+	}
+
+	if c.SMSRouting == RoutingLeastCost {
+		if len(c.SMSRoutes) == 0 {
+			chain = make([]string, 0, len(c.smses))
+			for name := range c.smses {
+				chain = append(chain, name)
+			}
+			sort.Strings(chain)
+		}
+		chain = sortByPrice(c.SMSPrices, c.priceCodes, chain, digits)
+	}
+
+	if len(chain) == 1 {
+		return chain[0]
+	}
+	return strings.Join(chain, ",")
+}
+
+// countryCode is a preparsed country code of the routing or price table.
+type countryCode struct {
+	prefix string // The digits without "+".
+	key    string // The original key in the table.
+}
+
+// countryCodes is the country codes of the table, which are sorted by the
+// length in descending order, so the first prefix of the phone is the longest.
+type countryCodes []countryCode
+
+func newCountryCodes(table interface{}) countryCodes {
+	var keys []string
+	switch t := table.(type) {
+	case map[string][]string:
+		for code := range t {
+			keys = append(keys, code)
+		}
+	case map[string]float64:
+		for code := range t {
+			keys = append(keys, code)
+		}
+	case map[string]string:
+		for code := range t {
+			keys = append(keys, code)
+		}
+	}
+
+	codes := make(countryCodes, 0, len(keys))
+	for _, key := range keys {
+		if key != defaultRoute {
+			codes = append(codes, countryCode{prefix: strings.TrimPrefix(key, "+"), key: key})
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if len(codes[i].prefix) != len(codes[j].prefix) {
+			return len(codes[i].prefix) > len(codes[j].prefix)
+		}
+		return codes[i].key < codes[j].key
+	})
+	return codes
+}
+
+// match is the same as matchCountryCode, but without the allocations.
+func (cs countryCodes) match(digits string) string {
+	if digits != "" {
+		for _, c := range cs {
+			if strings.HasPrefix(digits, c.prefix) {
+				return c.key
+			}
+		}
+	}
+	return defaultRoute
+}
+
+// prepareRoutes preparses the routing and price tables of the sms and the
+// locales of the country codes, which is called when the configuration is reset.
+func (c *Config) prepareRoutes() {
+	c.routeCodes = newCountryCodes(c.SMSRoutes)
+	c.localeCodes = newCountryCodes(c.CountryLocales)
+	c.priceCodes = make(map[string]countryCodes, len(c.SMSPrices))
+	for name, table := range c.SMSPrices {
+		c.priceCodes[name] = newCountryCodes(table)
+	}
+}
+
+// matchCountryCode returns the longest country code in the table, which is
+// the prefix of the phone digits, or "default" if none matches.
+func matchCountryCode(table interface{}, digits string) string {
+	var codes []string
+	switch t := table.(type) {
+	case map[string][]string:
+		for code := range t {
+			codes = append(codes, code)
+		}
+	case map[string]float64:
+		for code := range t {
+			codes = append(codes, code)
+		}
+	}
+
+	matched := defaultRoute
+	if digits == "" {
+		return matched
+	}
+	for _, code := range codes {
+		if code != defaultRoute && (matched == defaultRoute || len(code) > len(matched)) &&
+			strings.HasPrefix(digits, strings.TrimPrefix(code, "+")) {
+			matched = code
+		}
+	}
+	return matched
+}
+
+// sortByPrice sorts the providers by the price to the phone in ascending
+// order, and the providers without the price are put last in the original order.
+//
+// The codes are the preparsed country codes of the price tables, which may be nil.
+func sortByPrice(prices map[string]map[string]float64, codes map[string]countryCodes,
+	providers []string, digits string) []string {
+	type item struct {
+		name  string
+		price float64
+		ok    bool
+	}
+
+	items := make([]item, len(providers))
+	for i, name := range providers {
+		items[i].name = name
+		if table, ok := prices[name]; ok {
+			if cs, ok := codes[name]; ok {
+				items[i].price, items[i].ok = table[cs.match(digits)]
+			} else {
+				items[i].price, items[i].ok = table[matchCountryCode(table, digits)]
+			}
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].ok != items[j].ok {
+			return items[i].ok
+		}
+		return items[i].ok && items[i].price < items[j].price
+	})
+
+	results := make([]string, len(items))
+	for i, it := range items {
+		results[i] = it.name
+	}
+	return results
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// envEmailRedirect is the environment variable which overrides
+// Config.EmailRedirect, so that the staging environment cannot email
+// the real recipients even by the wrong configuration.
+const envEmailRedirect = "MESSAGEAPI_EMAIL_REDIRECT"
+
+// headerOriginalTo is the header of the redirected email,
+// which is the original recipients.
+const headerOriginalTo = "X-Original-To"
+
+// metadataDryRun is the key of the metadata of the message which is not sent
+// by the sandboxed provider, see Config.SandboxNumbers.
+const metadataDryRun = "dry_run"
+
+// isSandboxed reports whether the provider is in the sandbox mode and the phone
+// is not one of its sandbox numbers, so that the message should not be sent.
+func isSandboxed(channel, provider, phone string) bool {
+	configLocker.Lock()
+	numbers, ok := config.SandboxNumbers[providerKey(channel, provider)]
+	configLocker.Unlock()
+	if !ok {
+		return false
+	}
+
+	phone = normalizeRecipient(channel, phone)
+	for _, number := range numbers {
+		if normalizeRecipient(channel, number) == phone {
+			return false
+		}
+	}
+	return true
+}
+
+// dryRun returns the metadata of the message not sent by the sandboxed provider.
+func dryRun(channel, provider, phone string) map[string]string {
+	glog.Infof("dry-run the %s to %s by the sandboxed provider %s", channel, phone, provider)
+	return map[string]string{metadataDryRun: "true"}
+}
+
+// recordStatus returns the status of the record of the message sent
+// successfully, which is StatusDryRun if it is not sent by the sandbox.
+func recordStatus(metadata map[string]string) string {
+	if metadata[metadataDryRun] == "true" {
+		return StatusDryRun
+	}
+	return StatusSent
+}
+
+// getEmailRedirect returns the address to which all the recipients of
+// the emails are rewritten, or "" if not redirected.
+func getEmailRedirect() string {
+	if addr := strings.TrimSpace(os.Getenv(envEmailRedirect)); addr != "" {
+		return addr
+	}
+
+	configLocker.Lock()
+	addr := config.EmailRedirect
+	configLocker.Unlock()
+	return addr
+}
+
+// redirectEmail rewrites the recipients of the email to the redirect address
+// if configured, and records the original ones by the header "X-Original-To",
+// including the carbon-copied and the blind ones, which are dropped.
+// It only rewrites once for the same request, such as by the retries.
+func (r *Request) redirectEmail() {
+	addr := getEmailRedirect()
+	if addr == "" || r.originalTos != nil {
+		return
+	}
+
+	r.originalTos = r.envelope()
+	r.tos = []string{addr}
+	r.emailOptions.Cc, r.emailOptions.Bcc = nil, nil
+	r.emailOptions.Headers = withHeader(r.emailOptions.Headers, headerOriginalTo,
+		strings.Join(r.originalTos, ", "))
+}
+
+// withHeader returns a copy of the headers with the header added.
+func withHeader(headers map[string]string, key, value string) map[string]string {
+	_headers := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		_headers[k] = v
+	}
+	_headers[key] = value
+	return _headers
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// ProviderSchema is the schema of the configuration options of the provider.
+type ProviderSchema struct {
+	Name string `json:"name"`
+
+	// The channels which the provider is registered as, such as "sms" and "mms".
+	Channels []string                  `json:"channels"`
+	Options  []messageapi.ConfigOption `json:"options"`
+}
+
+// handleProviderSchema returns the schema of the provider
+// by "GET /v1/providers/PROVIDER/schema".
+func handleProviderSchema(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeAdminConfig, w, r) {
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/providers/")
+	if !strings.HasSuffix(path, "/schema") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	schema := ProviderSchema{Name: strings.TrimSuffix(path, "/schema")}
+	for _, channel := range []string{"email", "sms", "mms", "messenger"} {
+		provider := getProvider(channel, schema.Name)
+		if provider == nil {
+			continue
+		}
+
+		schema.Channels = append(schema.Channels, channel)
+		if schema.Options == nil {
+			schema.Options = messageapi.GetConfigSchema(provider)
+		}
+	}
+
+	if len(schema.Channels) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("have no the provider " + schema.Name))
+		return
+	} else if schema.Options == nil {
+		schema.Options = []messageapi.ConfigOption{}
+	}
+
+	content, err := json.Marshal(schema)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
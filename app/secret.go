@@ -0,0 +1,321 @@
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/xgfone/messageapi"
+)
+
+// SecretPrefix is the prefix of the encrypted configuration value.
+const SecretPrefix = "enc:"
+
+// Cipher is the interface to encrypt and decrypt the secret configuration
+// options of the providers, such as the password.
+//
+// You can implement it based on KMS or Vault, and set it by SetCipher.
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+var (
+	cipherLocker = new(sync.Mutex)
+	secretCipher Cipher
+)
+
+// SetCipher sets the cipher to encrypt and decrypt the secret options.
+//
+// When it is set, the secret options are encrypted when getting the
+// configuration by the HTTP API, and the option values having the prefix
+// SecretPrefix are decrypted before being loaded by the provider.
+func SetCipher(c Cipher) {
+	cipherLocker.Lock()
+	secretCipher = c
+	cipherLocker.Unlock()
+}
+
+func getCipher() Cipher {
+	cipherLocker.Lock()
+	defer cipherLocker.Unlock()
+	return secretCipher
+}
+
+type aesCipher struct {
+	aead cipher.AEAD
+}
+
+// NewAESCipher returns a new Cipher based on AES-GCM with the master key.
+//
+// The master key may be any length, and the real key is its SHA-256 sum.
+func NewAESCipher(key []byte) (Cipher, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("the master key is empty")
+	}
+
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return aesCipher{aead: aead}, nil
+}
+
+// NewAESCipherFromEnv is the same as NewAESCipher, but reads the master key
+// from the environment variable named env.
+func NewAESCipherFromEnv(env string) (Cipher, error) {
+	key := os.Getenv(env)
+	if key == "" {
+		return nil, fmt.Errorf("no the environment variable %s", env)
+	}
+	return NewAESCipher([]byte(key))
+}
+
+func (c aesCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (c aesCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, fmt.Errorf("the ciphertext is too short")
+	}
+	return c.aead.Open(nil, ciphertext[:size], ciphertext[size:], nil)
+}
+
+// isSecretOption reports whether the configuration option is a secret.
+func isSecretOption(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"password", "secret", "token", "key"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func encryptValue(c Cipher, value string) (string, error) {
+	if strings.HasPrefix(value, SecretPrefix) {
+		return value, nil
+	}
+	data, err := c.Encrypt([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	return SecretPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decryptValue(c Cipher, value string) (string, error) {
+	if !strings.HasPrefix(value, SecretPrefix) {
+		return value, nil
+	} else if c == nil {
+		return "", fmt.Errorf("no cipher to decrypt the secret")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value[len(SecretPrefix):])
+	if err != nil {
+		return "", err
+	}
+	if data, err = c.Decrypt(data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decryptOptions returns a copy of the provider options, the encrypted
+// values of which have been decrypted.
+func decryptOptions(options map[string]string) (map[string]string, error) {
+	c := getCipher()
+	results := make(map[string]string, len(options))
+	for k, v := range options {
+		s, err := decryptValue(c, v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt the option[%s]: %s", k, err)
+		}
+		results[k] = s
+	}
+	return results, nil
+}
+
+// getProvider returns the registered provider of the channel, or nil.
+func getProvider(channel, name string) interface{} {
+	var provider interface{}
+	switch channel {
+	case "email":
+		provider = messageapi.GetEmail(name)
+	case "sms":
+		provider = messageapi.GetSMS(name)
+	case "mms":
+		provider = messageapi.GetMMS(name)
+	case "messenger":
+		provider = messageapi.GetMessenger(name)
+	}
+	return provider
+}
+
+// secretOptions returns the secret options declared by the schema
+// of the provider.
+func secretOptions(channel, name string) map[string]bool {
+	secrets := make(map[string]bool)
+	for _, o := range messageapi.GetConfigSchema(getProvider(channel, name)) {
+		if o.Secret {
+			secrets[o.Name] = true
+		}
+	}
+	return secrets
+}
+
+// encryptProviders encrypts the secret options of the providers, which are
+// declared by the schemas of the providers, or guessed by the names.
+func encryptProviders(c Cipher, channel string, providers map[string]map[string]string) (
+	map[string]map[string]string, error) {
+	if providers == nil {
+		return nil, nil
+	}
+
+	results := make(map[string]map[string]string, len(providers))
+	for name, options := range providers {
+		secrets := secretOptions(channel, name)
+		_options := make(map[string]string, len(options))
+		for k, v := range options {
+			if secrets[k] || isSecretOption(k) {
+				s, err := encryptValue(c, v)
+				if err != nil {
+					return nil, err
+				}
+				v = s
+			}
+			_options[k] = v
+		}
+		results[name] = _options
+	}
+	return results, nil
+}
+
+// exportConfig returns a copy of the configuration to be exported,
+// the secret options of which have been encrypted if the cipher is set.
+func exportConfig(conf *Config) (*Config, error) {
+	c := getCipher()
+	if c == nil {
+		return conf, nil
+	}
+
+	_conf := *conf
+	var err error
+	if _conf.TokenSecret != "" {
+		if _conf.TokenSecret, err = encryptValue(c, conf.TokenSecret); err != nil {
+			return nil, err
+		}
+	}
+	if len(conf.Secrets) != 0 {
+		_conf.Secrets = make(map[string]string, len(conf.Secrets))
+		for k, v := range conf.Secrets {
+			if _conf.Secrets[k], err = encryptValue(c, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(conf.WebhookSecrets) != 0 {
+		_conf.WebhookSecrets = make(map[string]string, len(conf.WebhookSecrets))
+		for k, v := range conf.WebhookSecrets {
+			if _conf.WebhookSecrets[k], err = encryptValue(c, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if conf.Webhooks.Secret != "" {
+		if _conf.Webhooks.Secret, err = encryptValue(c, conf.Webhooks.Secret); err != nil {
+			return nil, err
+		}
+	}
+	if conf.EventBus != nil && conf.EventBus.Password != "" {
+		eventBus := *conf.EventBus
+		if eventBus.Password, err = encryptValue(c, eventBus.Password); err != nil {
+			return nil, err
+		}
+		_conf.EventBus = &eventBus
+	}
+	if conf.Archive != nil && conf.Archive.SecretAccessKey != "" {
+		archive := *conf.Archive
+		if archive.SecretAccessKey, err = encryptValue(c, archive.SecretAccessKey); err != nil {
+			return nil, err
+		}
+		_conf.Archive = &archive
+	}
+	if conf.Privacy != nil && conf.Privacy.Salt != "" {
+		privacy := *conf.Privacy
+		if privacy.Salt, err = encryptValue(c, privacy.Salt); err != nil {
+			return nil, err
+		}
+		_conf.Privacy = &privacy
+	}
+	if len(conf.Integrations) != 0 {
+		_conf.Integrations = make(map[string]Integration, len(conf.Integrations))
+		for k, v := range conf.Integrations {
+			if v.Secret != "" {
+				if v.Secret, err = encryptValue(c, v.Secret); err != nil {
+					return nil, err
+				}
+			}
+			_conf.Integrations[k] = v
+		}
+	}
+	if len(conf.Defaults) != 0 {
+		_conf.Defaults = make(map[string]string, len(conf.Defaults))
+		for k, v := range conf.Defaults {
+			if isSecretOption(k) {
+				if v, err = encryptValue(c, v); err != nil {
+					return nil, err
+				}
+			}
+			_conf.Defaults[k] = v
+		}
+	}
+	if len(conf.Tenants) != 0 {
+		_conf.Tenants = make(map[string]Tenant, len(conf.Tenants))
+		for name, t := range conf.Tenants {
+			if len(t.Options) != 0 {
+				options := make(map[string]map[string]string, len(t.Options))
+				for key, o := range t.Options {
+					channel, provider := splitProviderKey(key)
+					providers, err := encryptProviders(c, channel, map[string]map[string]string{provider: o})
+					if err != nil {
+						return nil, err
+					}
+					options[key] = providers[provider]
+				}
+				t.Options = options
+			}
+			_conf.Tenants[name] = t
+		}
+	}
+	if _conf.Emails, err = encryptProviders(c, "email", conf.Emails); err != nil {
+		return nil, err
+	}
+	if _conf.SMSes, err = encryptProviders(c, "sms", conf.SMSes); err != nil {
+		return nil, err
+	}
+	if _conf.MMSes, err = encryptProviders(c, "mms", conf.MMSes); err != nil {
+		return nil, err
+	}
+	if _conf.Messengers, err = encryptProviders(c, "messenger", conf.Messengers); err != nil {
+		return nil, err
+	}
+	return &_conf, nil
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// ServerOptions is the options of the HTTP server started by Start, which
+// defend against the slow clients, such as slowloris, and the leaked idle
+// connections. They only take effect when the server starts.
+//
+// The timeouts are in seconds. 0 is the default, and a negative number
+// disables the timeout.
+type ServerOptions struct {
+	ReadHeaderTimeout int `json:"read_header_timeout,omitempty"` // Default: 10
+	ReadTimeout       int `json:"read_timeout,omitempty"`        // Default: 60
+	WriteTimeout      int `json:"write_timeout,omitempty"`       // Default: 120
+	IdleTimeout       int `json:"idle_timeout,omitempty"`        // Default: 120
+
+	// The maximum bytes of the request headers. Default: 1MB
+	MaxHeaderBytes int `json:"max_header_bytes,omitempty"`
+
+	// The period of the TCP keep-alive probes of the connections. Default: 15
+	KeepAlivePeriod int `json:"keep_alive_period,omitempty"`
+
+	// If true, close the connection after each response instead of keeping
+	// it alive for the next request.
+	DisableKeepAlive bool `json:"disable_keep_alive,omitempty"`
+
+	// HTTP/2 is enabled over TLS by default, which may be disabled.
+	DisableHTTP2 bool `json:"disable_http2,omitempty"`
+
+	// If true, also serve HTTP/2 over the plaintext connections, that's, h2c,
+	// such as for the internal callers behind the service mesh.
+	H2C bool `json:"h2c,omitempty"`
+
+	// The maximum number of the concurrent streams of each HTTP/2 connection.
+	// Default: 250
+	MaxConcurrentStreams uint32 `json:"max_concurrent_streams,omitempty"`
+}
+
+func (o ServerOptions) seconds(n, _default int) time.Duration {
+	if n < 0 {
+		return 0
+	} else if n == 0 {
+		n = _default
+	}
+	return time.Duration(n) * time.Second
+}
+
+func (o ServerOptions) newServer(addr string, handler http.Handler) (*http.Server, error) {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+
+	maxHeaderBytes := o.MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: o.seconds(o.ReadHeaderTimeout, 10),
+		ReadTimeout:       o.seconds(o.ReadTimeout, 60),
+		WriteTimeout:      o.seconds(o.WriteTimeout, 120),
+		IdleTimeout:       o.seconds(o.IdleTimeout, 120),
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+	server.SetKeepAlivesEnabled(!o.DisableKeepAlive)
+
+	if o.DisableHTTP2 {
+		// A non-nil empty map disables HTTP/2 over TLS.
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return server, nil
+	}
+
+	h2 := &http2.Server{
+		MaxConcurrentStreams: o.MaxConcurrentStreams,
+		IdleTimeout:          server.IdleTimeout,
+	}
+	if err := http2.ConfigureServer(server, h2); err != nil {
+		return nil, err
+	}
+	if o.H2C {
+		server.Handler = h2c.NewHandler(handler, h2)
+	}
+	return server, nil
+}
+
+// listen listens on the TCP address with the keep-alive period,
+// or the Unix socket if the address is "unix:PATH".
+func (o ServerOptions) listen(addr string) (net.Listener, error) {
+	period := o.seconds(o.KeepAlivePeriod, 15)
+	if period == 0 {
+		period = -1 // Disable the keep-alive probes.
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", addr[len("unix:"):]
+
+		// Remove the stale socket left by the last run.
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(addr)
+		}
+	}
+
+	lc := net.ListenConfig{KeepAlive: period}
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// Listener is an address on which the server listens, so that the server
+// may listen on several addresses, such as the public HTTPS for the sends
+// and the localhost HTTP for the admin.
+type Listener struct {
+	// The TCP address, such as ":8443" or "127.0.0.1:8080",
+	// or the Unix socket, such as "unix:/run/messageapi.sock".
+	Addr string `json:"addr"`
+
+	// The certificate and the key files of TLS. If empty, serve the plaintext.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+
+	// The paths served by the listener, such as "/v1/email" or "/v1/admin/",
+	// which ends with "/" to match the path prefix. If empty, serve all.
+	Paths []string `json:"paths,omitempty"`
+}
+
+func (l Listener) tls() bool { return l.CertFile != "" && l.KeyFile != "" }
+
+// handler returns the default handler, which only serves the paths
+// of the listener if given.
+func (l Listener) handler() http.Handler {
+	if len(l.Paths) == 0 {
+		return http.DefaultServeMux
+	}
+
+	paths := l.Paths
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range paths {
+			if r.URL.Path == path ||
+				(strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path)) {
+				http.DefaultServeMux.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+}
+
+// serve serves on all the listeners until any fails, and then closes all.
+func (o ServerOptions) serve(listeners []Listener) error {
+	servers := make([]*http.Server, len(listeners))
+	lns := make([]net.Listener, len(listeners))
+	closeAll := func() {
+		for i := range lns {
+			if servers[i] != nil {
+				servers[i].Close()
+			}
+			if lns[i] != nil {
+				lns[i].Close()
+			}
+		}
+	}
+
+	for i, l := range listeners {
+		var err error
+		if servers[i], err = o.newServer(l.Addr, l.handler()); err == nil {
+			lns[i], err = o.listen(l.Addr)
+		}
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("failed to listen on %s: %s", l.Addr, err)
+		}
+	}
+
+	errs := make(chan error, len(listeners))
+	for i, l := range listeners {
+		glog.Infof("listening on %s", l.Addr)
+		go func(server *http.Server, ln net.Listener, l Listener) {
+			if l.tls() {
+				errs <- server.ServeTLS(ln, l.CertFile, l.KeyFile)
+			} else {
+				errs <- server.Serve(ln)
+			}
+		}(servers[i], lns[i], l)
+	}
+
+	err := <-errs
+	closeAll()
+	return err
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// The priorities of the messages, see Request.Priority.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// LoadShedding is the policy to reject the messages when overloaded, which
+// protects the critical traffic, such as OTP and alerts. When any of the
+// thresholds is exceeded, the messages of the priority "low" are rejected with
+// the status code 429; and beyond NormalRatio times of the thresholds, the ones
+// of the priority "normal" are also rejected. The messages of the priority
+// "high" are always accepted.
+//
+// Each rejected message is published as the event "shed" to the event bus.
+type LoadShedding struct {
+	// The thresholds of the in-flight requests to send the messages, the
+	// messages held to be sent later, such as the digests and the paused ones,
+	// and the heap memory in MB. 0 means no limit.
+	MaxInflight  int `json:"max_inflight,omitempty"`
+	MaxScheduled int `json:"max_scheduled,omitempty"`
+	MaxMemoryMB  int `json:"max_memory_mb,omitempty"`
+
+	// The ratio of the thresholds, beyond which the messages of the priority
+	// "normal" are also rejected, which is 1.5 by default.
+	NormalRatio float64 `json:"normal_ratio,omitempty"`
+
+	// The priorities of the categories of the messages without the priority,
+	// such as {"otp": "high", "marketing": "low"}, see Request.Category.
+	// The others are "normal".
+	Priorities map[string]string `json:"priorities,omitempty"`
+}
+
+func (s LoadShedding) normalRatio() float64 {
+	if s.NormalRatio > 1 {
+		return s.NormalRatio
+	}
+	return 1.5
+}
+
+func validPriority(priority string) bool {
+	switch priority {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+		return true
+	default:
+		return false
+	}
+}
+
+// priority returns the priority of the message with the priority and the
+// category in the request.
+func (s LoadShedding) priority(priority, category string) string {
+	if priority == "" {
+		priority = s.Priorities[category]
+	}
+	if priority == "" {
+		priority = PriorityNormal
+	}
+	return priority
+}
+
+// heapSampleInterval is the interval to sample the heap memory, which stops
+// the world shortly.
+const heapSampleInterval = time.Second
+
+// The number of the messages rejected by the load shedding.
+var shedLow, shedNormal int64
+
+var (
+	heapLocker   = new(sync.Mutex)
+	heapSampleAt time.Time
+	heapAlloc    uint64
+)
+
+func getHeapAlloc() uint64 {
+	heapLocker.Lock()
+	defer heapLocker.Unlock()
+
+	if now := time.Now(); now.Sub(heapSampleAt) >= heapSampleInterval {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		heapSampleAt, heapAlloc = now, stats.HeapAlloc
+	}
+	return heapAlloc
+}
+
+// load returns the max ratio of the current load to the thresholds,
+// and the name of the resource of the max ratio.
+func (s LoadShedding) load() (ratio float64, resource string) {
+	check := func(name string, value float64, max int) {
+		if max > 0 && value/float64(max) > ratio {
+			ratio, resource = value/float64(max), name
+		}
+	}
+
+	check("inflight", float64(atomic.LoadInt64(&inflight)), s.MaxInflight)
+	if s.MaxScheduled > 0 {
+		var scheduled int
+		for _, p := range getPendingStats() {
+			scheduled += p.count
+		}
+		check("scheduled", float64(scheduled), s.MaxScheduled)
+	}
+	if s.MaxMemoryMB > 0 {
+		check("memory", float64(getHeapAlloc())/(1<<20), s.MaxMemoryMB)
+	}
+	return
+}
+
+// shedLoad reports whether the message is rejected by the load shedding.
+// If so, it writes the response with the status code 429, and publishes the
+// event "shed".
+func shedLoad(c *Config, w http.ResponseWriter, channel string, recipients []string,
+	priority, category string) bool {
+	if c.LoadShedding == nil {
+		return false
+	}
+
+	s := c.LoadShedding
+	if priority = s.priority(priority, category); priority == PriorityHigh {
+		return false
+	}
+
+	ratio, resource := s.load()
+	if ratio <= 1 || (priority == PriorityNormal && ratio <= s.normalRatio()) {
+		return false
+	}
+
+	if priority == PriorityLow {
+		atomic.AddInt64(&shedLow, 1)
+	} else {
+		atomic.AddInt64(&shedNormal, 1)
+	}
+	glog.V(1).Infof("shed the %s %s message, as the %s is %.0f%% of the threshold",
+		priority, channel, resource, ratio*100)
+	publishEvent(Event{Type: EventShed, Channel: channel, Recipients: recipients,
+		Error: "overloaded", Metadata: map[string]string{"priority": priority,
+			"category": category, "resource": resource}})
+
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(fmt.Sprintf("the server is overloaded, reject the %s priority message", priority)))
+	return true
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultSignTolerance = 300
+
+// signRequest returns the HMAC-SHA256 signature of the request.
+//
+// The signed string is the lines joined by "\n" as follows:
+//
+//	METHOD
+//	REQUEST_URI
+//	TIMESTAMP
+//	NONCE
+//	HEX(SHA256(BODY))
+func signRequest(secret, method, uri, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n"))
+	h.Write([]byte(hex.EncodeToString(sum[:])))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// verifySignature verifies the signature of the request signed by the secret,
+// which is given by the headers "X-Timestamp", "X-Nonce" and "X-Signature".
+//
+// The timestamp must be within the tolerance, and the nonce must not be used
+// within the tolerance window, so that the request cannot be replayed.
+func verifySignature(c *Config, secret string, r *http.Request) error {
+	timestamp := r.Header.Get("X-Timestamp")
+	nonce := r.Header.Get("X-Nonce")
+	signature := r.Header.Get("X-Signature")
+	if timestamp == "" || nonce == "" || signature == "" {
+		return fmt.Errorf("the request is not signed")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("the timestamp is invalid")
+	}
+
+	tolerance := time.Duration(defaultSignTolerance) * time.Second
+	if c.SignTolerance > 0 {
+		tolerance = time.Duration(c.SignTolerance) * time.Second
+	}
+	tolerance += c.clockSkew()
+
+	t := time.Unix(ts, 0)
+	d := time.Since(t)
+	if d > tolerance || d < -tolerance {
+		return fmt.Errorf("the timestamp is out of the tolerance")
+	}
+
+	// The body is read into memory to be hashed before the request is
+	// authenticated, so it is limited by the size of the attachments, see
+	// AttachmentLimits, with the headroom for the other fields.
+	limit := c.AttachmentLimits.MaxSize
+	if limit <= 0 {
+		limit = 25 << 20
+	}
+	limit += 1 << 20
+	if r.ContentLength > limit {
+		return fmt.Errorf("the body exceeds %d bytes", limit)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return err
+	} else if int64(len(body)) > limit {
+		return fmt.Errorf("the body exceeds %d bytes", limit)
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	expected := signRequest(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("the signature is invalid")
+	}
+
+	// Observe the skew only after the signature is verified, so that it
+	// cannot be polluted by the forged timestamps.
+	observeClockSkew(c, r.RemoteAddr, -d)
+
+	// The nonce only needs to be remembered until the timestamp is out of
+	// the tolerance, after which the request is rejected anyway.
+	if ok, err := useNonce(getAPIKey(r)+":"+nonce, t.Add(tolerance)); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("the request has been replayed")
+	}
+	return nil
+}
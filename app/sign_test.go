@@ -0,0 +1,111 @@
+package app
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSignedRequest(secret, body string, timestamp time.Time, nonce string) *http.Request {
+	r := httptest.NewRequest("POST", "/v1/sms?key=k", strings.NewReader(body))
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	r.Header.Set("X-Timestamp", ts)
+	r.Header.Set("X-Nonce", nonce)
+	r.Header.Set("X-Signature", signRequest(secret, "POST", "/v1/sms?key=k", ts, nonce,
+		[]byte(body)))
+	return r
+}
+
+func TestSignatureClockSkew(t *testing.T) {
+	c := new(Config)
+	setConfig(t, c)
+
+	// The forged request does not change the statistics of the clock.
+	before := getClockStats()
+	r := newSignedRequest("other", "{}", time.Now().Add(-100*time.Second), "skew-1")
+	if err := verifySignature(c, "secret", r); err == nil {
+		t.Fatal("expect the error of the forged signature, but got nil")
+	} else if after := getClockStats(); after != before {
+		t.Errorf("expect the unchanged clock stats %+v, but got %+v", before, after)
+	}
+
+	r = newSignedRequest("secret", "{}", time.Now().Add(-100*time.Second), "skew-2")
+	if err := verifySignature(c, "secret", r); err != nil {
+		t.Fatal(err)
+	} else if after := getClockStats(); after.Samples != before.Samples+1 ||
+		after.LastSkew > -99 || after.Drifted != before.Drifted+1 {
+		t.Errorf("expect the observed skew, but got %+v", after)
+	}
+}
+
+func TestSignatureRoundTrip(t *testing.T) {
+	c := new(Config)
+	setConfig(t, c)
+
+	body := `{"phone":"+8613800000000","content":"hello"}`
+	r := newSignedRequest("secret", body, time.Now(), "round-trip")
+	if err := verifySignature(c, "secret", r); err != nil {
+		t.Fatal(err)
+	} else if data, _ := ioutil.ReadAll(r.Body); string(data) != body {
+		t.Errorf("expect the body '%s', but got '%s'", body, data)
+	}
+
+	r = newSignedRequest("secret", body, time.Now(), "round-trip")
+	if err := verifySignature(c, "secret", r); err == nil {
+		t.Error("expect the error of the replayed request, but got nil")
+	}
+}
+
+func TestSignatureForgery(t *testing.T) {
+	c := &Config{AttachmentLimits: AttachmentLimits{MaxSize: 1}}
+	setConfig(t, c)
+
+	now := time.Now()
+	for name, r := range map[string]*http.Request{
+		"secret":  newSignedRequest("other", "{}", now, "forgery-1"),
+		"expired": newSignedRequest("secret", "{}", now.Add(-time.Hour), "forgery-2"),
+		"future":  newSignedRequest("secret", "{}", now.Add(time.Hour), "forgery-3"),
+		"large":   newSignedRequest("secret", strings.Repeat("x", 2<<20), now, "forgery-4"),
+	} {
+		if err := verifySignature(c, "secret", r); err == nil {
+			t.Errorf("%s: expect the error, but got nil", name)
+		}
+	}
+
+	r := newSignedRequest("secret", "{}", now, "forgery-5")
+	r.Body = ioutil.NopCloser(strings.NewReader(`{"to":"mallory@example.com"}`))
+	if err := verifySignature(c, "secret", r); err == nil {
+		t.Error("body: expect the error, but got nil")
+	}
+
+	r = newSignedRequest("secret", "{}", now, "forgery-6")
+	r.URL.RawQuery = "key=other"
+	if err := verifySignature(c, "secret", r); err == nil {
+		t.Error("uri: expect the error, but got nil")
+	}
+
+	for _, header := range []string{"X-Timestamp", "X-Nonce", "X-Signature"} {
+		r = newSignedRequest("secret", "{}", now, "forgery-7")
+		r.Header.Del(header)
+		if err := verifySignature(c, "secret", r); err == nil {
+			t.Errorf("%s: expect the error, but got nil", header)
+		}
+	}
+
+	// The body without the length, such as chunked, is also limited.
+	r = newSignedRequest("secret", strings.Repeat("x", 2<<20), now, "forgery-9")
+	r.ContentLength = -1
+	if err := verifySignature(c, "secret", r); err == nil {
+		t.Error("chunked: expect the error, but got nil")
+	}
+
+	r = newSignedRequest("secret", "{}", now, "forgery-8")
+	r.Header.Set("X-Timestamp", "now")
+	if err := verifySignature(c, "secret", r); err == nil {
+		t.Error("timestamp: expect the error, but got nil")
+	}
+}
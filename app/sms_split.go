@@ -0,0 +1,136 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// SMSSplit is the splitting of the long sms into the numbered parts like
+// "(1/3) ...", which are sent sequentially as the single sms by the provider
+// not handling the concatenation, see messageapi.SplitSMS.
+//
+// The sms providers only sending the templates, such as "aliyun",
+// are never split, see messageapi.TemplateSMS.
+type SMSSplit struct {
+	// The maximum length of each part with its number, which is a single
+	// segment by default, that's, 160 septets for the GSM 7-bit sms and
+	// 70 UTF-16 code units for the UCS-2 one. The shorter sms is sent as
+	// it is.
+	GSM7Limit int `json:"gsm7_limit,omitempty"`
+	UCS2Limit int `json:"ucs2_limit,omitempty"`
+
+	// The number of the milliseconds between the parts, so that they arrive
+	// in order, which is 1000 by default.
+	DelayMs int `json:"delay_ms,omitempty"`
+}
+
+func (s SMSSplit) validate() error {
+	if s.GSM7Limit < 0 || (s.GSM7Limit > 0 && s.GSM7Limit < 20) || s.GSM7Limit > 160 {
+		return fmt.Errorf("the gsm7 limit must be between 20 and 160")
+	} else if s.UCS2Limit < 0 || (s.UCS2Limit > 0 && s.UCS2Limit < 20) || s.UCS2Limit > 70 {
+		return fmt.Errorf("the ucs2 limit must be between 20 and 70")
+	} else if s.DelayMs < 0 {
+		return fmt.Errorf("the delay must not be negative")
+	}
+	return nil
+}
+
+func (s SMSSplit) delay() time.Duration {
+	if s.DelayMs == 0 {
+		return time.Second
+	}
+	return time.Duration(s.DelayMs) * time.Millisecond
+}
+
+// split splits the content by the limit of its encoding.
+func (s SMSSplit) split(content string) []string {
+	limit := s.UCS2Limit
+	if messageapi.IsGSM7(content) {
+		limit = s.GSM7Limit
+	}
+	return messageapi.SplitSMS(content, limit)
+}
+
+func getSMSSplit(name string) (split SMSSplit, ok bool) {
+	configLocker.Lock()
+	split, ok = config.SMSSplits[name]
+	configLocker.Unlock()
+	return
+}
+
+// smsRest is the rest parts of the split sms after the first one,
+// which are sent in background, see sendSMSParts.
+type smsRest struct {
+	name  string
+	sms   messageapi.SMS
+	parts []string
+	delay time.Duration
+}
+
+// sendSMSParts sends the first part of the split sms by the provider, and
+// returns its metadata with the number of the parts. The rest are sent in
+// background after the message is recorded, see dispatchSMS, so that
+// the request does not wait for the delays between them.
+//
+// If the first part fails, nothing is sent, so the sms may be retried or
+// sent by the next provider in the chain as a whole.
+func sendSMSParts(name string, sms messageapi.SMS, args *Request, parts []string,
+	delay time.Duration) (map[string]string, error) {
+	metadata, err := sendSMSContent(name, sms, args, parts[0])
+	if err != nil {
+		return metadata, err
+	}
+
+	if metadata == nil {
+		metadata = make(map[string]string, 1)
+	}
+	metadata["parts"] = strconv.Itoa(len(parts))
+	args.smsRest = &smsRest{name: name, sms: sms, parts: parts[1:], delay: delay}
+	return metadata, nil
+}
+
+// sendSMSRest sends the rest parts of the sms of the message id in background,
+// which are counted as the in-flight requests for Drain.
+func sendSMSRest(id string, args *Request) {
+	rest := args.smsRest
+	args.smsRest = nil
+
+	_args := *args
+	atomic.AddInt64(&inflight, 1)
+	go func() {
+		defer atomic.AddInt64(&inflight, -1)
+		rest.send(id, &_args)
+	}()
+}
+
+// send sends the rest parts in order by the provider of the first one.
+//
+// Each part is retried for Request.Retry times like the first one, and the
+// sent parts are never sent again. If a part still fails, the rest are not
+// sent, and the message is marked as failed in the history.
+func (s *smsRest) send(id string, args *Request) {
+	total := len(s.parts) + 1
+	for i, part := range s.parts {
+		time.Sleep(s.delay)
+		for attempt := 0; ; attempt++ {
+			_, err := sendSMSContent(s.name, s.sms, args, part)
+			if err == nil {
+				break
+			}
+
+			delay, ok := retryDelay(attempt, err)
+			if !ok || attempt >= args.Retry || messageapi.IsPermanent(err) {
+				glog.Errorf("failed to send the part %d/%d of the sms %s by %s: %s",
+					i+2, total, id, s.name, privateError(err, args.Phone))
+				messageHistory.setStatus(id, StatusFailed)
+				return
+			}
+			time.Sleep(delay)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ScopeReadStats is the scope of the API key to read the statistics.
+const ScopeReadStats = "read:stats"
+
+// statsMinutes is the number of the per-minute buckets, that's, 24 hours.
+const statsMinutes = 1440
+
+type statsBucket struct {
+	minute   int64
+	sends    int64
+	failures int64
+	latency  time.Duration
+	maxDelay time.Duration
+	hist     latencyHistogram
+}
+
+type providerStats struct {
+	buckets [statsMinutes]statsBucket
+
+	// The totals since the start, which are exported by "/v1/metrics".
+	sends    int64
+	failures int64
+	latency  time.Duration
+	hist     latencyHistogram
+}
+
+var (
+	statsLocker = new(sync.Mutex)
+	stats       = make(map[string]*providerStats)
+)
+
+func recordStats(provider string, latency time.Duration, err error) {
+	minute := time.Now().Unix() / 60
+
+	statsLocker.Lock()
+	defer statsLocker.Unlock()
+
+	ps, ok := stats[provider]
+	if !ok {
+		ps = new(providerStats)
+		stats[provider] = ps
+	}
+
+	b := &ps.buckets[minute%statsMinutes]
+	if b.minute != minute {
+		*b = statsBucket{minute: minute}
+	}
+	b.sends++
+	if err != nil {
+		b.failures++
+	}
+	b.latency += latency
+	if latency > b.maxDelay {
+		b.maxDelay = latency
+	}
+	b.hist.observe(latency)
+
+	ps.sends++
+	if err != nil {
+		ps.failures++
+	}
+	ps.latency += latency
+	ps.hist.observe(latency)
+}
+
+// StatsPoint is the statistics of a provider in a minute.
+type StatsPoint struct {
+	// The unix timestamp of the start of the minute.
+	Time int64 `json:"time"`
+
+	Sends        int64   `json:"sends"`
+	Failures     int64   `json:"failures"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	MaxLatencyMs float64 `json:"max_latency_ms"`
+}
+
+// StatsSeries is the per-minute time series of the statistics of a provider.
+type StatsSeries struct {
+	// The provider, such as "email:plain" or "sms:NAME".
+	Provider string       `json:"provider"`
+	Points   []StatsPoint `json:"points"`
+}
+
+// getStats returns the time series of the last minutes of the providers.
+//
+// If provider is empty, return all the providers.
+func getStats(provider string, minutes int) []StatsSeries {
+	if minutes <= 0 || minutes > statsMinutes {
+		minutes = statsMinutes
+	}
+	now := time.Now().Unix() / 60
+
+	statsLocker.Lock()
+	defer statsLocker.Unlock()
+
+	results := make([]StatsSeries, 0, len(stats))
+	for name, ps := range stats {
+		if provider != "" && provider != name {
+			continue
+		}
+
+		points := make([]StatsPoint, minutes)
+		for i := range points {
+			minute := now - int64(minutes-1-i)
+			points[i].Time = minute * 60
+
+			b := ps.buckets[minute%statsMinutes]
+			if b.minute != minute || b.sends == 0 {
+				continue
+			}
+			points[i].Sends = b.sends
+			points[i].Failures = b.failures
+			points[i].AvgLatencyMs = float64(b.latency) / float64(b.sends) / float64(time.Millisecond)
+			points[i].MaxLatencyMs = float64(b.maxDelay) / float64(time.Millisecond)
+		}
+		results = append(results, StatsSeries{Provider: name, Points: points})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Provider < results[j].Provider })
+	return results
+}
+
+// getTenantStats returns the time series of the last minutes of the providers
+// used by the tenant, which are counted by the messages of the tenant in the
+// history, so they have no latencies and are limited by the history size.
+func getTenantStats(tenant, provider string, minutes int) []StatsSeries {
+	if minutes <= 0 || minutes > statsMinutes {
+		minutes = statsMinutes
+	}
+	now := time.Now().Unix() / 60
+	since := now - int64(minutes-1)
+
+	series := make(map[string][]StatsPoint)
+	messageHistory.each(func(r *Record) {
+		minute := r.CreatedAt.Unix() / 60
+		key := providerKey(r.Channel, r.Provider)
+		if r.Tenant != tenant || r.Provider == "" || minute < since || minute > now {
+			return
+		} else if provider != "" && provider != key {
+			return
+		}
+
+		points, ok := series[key]
+		if !ok {
+			points = make([]StatsPoint, minutes)
+			for i := range points {
+				points[i].Time = (since + int64(i)) * 60
+			}
+			series[key] = points
+		}
+
+		points[minute-since].Sends++
+		if r.Status == StatusFailed {
+			points[minute-since].Failures++
+		}
+	})
+
+	results := make([]StatsSeries, 0, len(series))
+	for key, points := range series {
+		results = append(results, StatsSeries{Provider: key, Points: points})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Provider < results[j].Provider })
+	return results
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeReadStats, w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	minutes := 60
+	if v := query.Get("minutes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		minutes = n
+	}
+
+	var series []StatsSeries
+	if tenant := _config.keyTenants[getAPIKey(r)]; tenant != "" {
+		series = getTenantStats(tenant, query.Get("provider"), minutes)
+	} else {
+		series = getStats(query.Get("provider"), minutes)
+	}
+
+	content, err := json.Marshal(series)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// VariantStats is the statistics of a variant of a template.
+type VariantStats struct {
+	Template string `json:"template"`
+	Variant  string `json:"variant"`
+	Sends    int64  `json:"sends"`
+	Failures int64  `json:"failures"`
+
+	// The numbers of the messages reported to be delivered, and opened
+	// and clicked by the tracking links, see Template.Variants.
+	Deliveries int64 `json:"deliveries"`
+	Opens      int64 `json:"opens"`
+	Clicks     int64 `json:"clicks"`
+}
+
+var variantStats = make(map[string]*VariantStats)
+
+// getVariantStatsLocked returns the statistics of the variant, which are
+// created if not exist. The caller must hold statsLocker.
+func getVariantStatsLocked(template, variant string) *VariantStats {
+	key := template + "/" + variant
+	vs, ok := variantStats[key]
+	if !ok {
+		vs = &VariantStats{Template: template, Variant: variant}
+		variantStats[key] = vs
+	}
+	return vs
+}
+
+func recordVariantStats(template, variant string, err error) {
+	if variant == "" {
+		return
+	}
+
+	statsLocker.Lock()
+	defer statsLocker.Unlock()
+
+	vs := getVariantStatsLocked(template, variant)
+	vs.Sends++
+	if err != nil {
+		vs.Failures++
+	}
+}
+
+// recordVariantEvent records the event of the message of the record,
+// "delivered", "open" or "click", into the statistics of its variant.
+func recordVariantEvent(r Record, event string) {
+	if r.Variant == "" {
+		return
+	}
+
+	statsLocker.Lock()
+	defer statsLocker.Unlock()
+
+	vs := getVariantStatsLocked(r.Template, r.Variant)
+	switch event {
+	case StatusDelivered:
+		vs.Deliveries++
+	case trackOpen:
+		vs.Opens++
+	case trackClick:
+		vs.Clicks++
+	}
+}
+
+func getVariantStats(template string) []VariantStats {
+	statsLocker.Lock()
+	defer statsLocker.Unlock()
+
+	results := make([]VariantStats, 0, len(variantStats))
+	for _, vs := range variantStats {
+		if template == "" || template == vs.Template {
+			results = append(results, *vs)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Template != results[j].Template {
+			return results[i].Template < results[j].Template
+		}
+		return results[i].Variant < results[j].Variant
+	})
+	return results
+}
+
+func handleVariantStats(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeReadStats, w, r) || rejectTenant(_config, w, r) {
+		return
+	}
+
+	content, err := json.Marshal(getVariantStats(r.URL.Query().Get("template")))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// TagLimit is the cap of the messages with the same tag across all the
+// recipients in the window, which protects the humans from the alert floods.
+type TagLimit struct {
+	Max int `json:"max"`
+
+	// The number of the seconds of the window, which is 3600 by default.
+	Window int `json:"window,omitempty"`
+}
+
+func (l TagLimit) window() time.Duration {
+	if l.Window > 0 {
+		return time.Duration(l.Window) * time.Second
+	}
+	return time.Hour
+}
+
+type tagCounter struct {
+	start   time.Time
+	count   int
+	dropped int
+}
+
+var (
+	tagLocker   = new(sync.Mutex)
+	tagCounters = make(map[string]*tagCounter)
+)
+
+// allowTag reports whether the message with the tag is allowed by the cap.
+// If not, it writes the response. When the cap is hit for the first time
+// in the window, a summary message is sent to the recipient of the message.
+func allowTag(w http.ResponseWriter, isEmail bool, args *Request) bool {
+	configLocker.Lock()
+	limit, ok := config.TagLimits[args.Tag]
+	configLocker.Unlock()
+	if !ok || limit.Max <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	window := limit.window()
+
+	tagLocker.Lock()
+	c, ok := tagCounters[args.Tag]
+	if !ok || now.Sub(c.start) >= window {
+		c = &tagCounter{start: now}
+		tagCounters[args.Tag] = c
+	}
+	if c.count < limit.Max {
+		c.count++
+		tagLocker.Unlock()
+		return true
+	}
+	c.dropped++
+	dropped, until := c.dropped, c.start.Add(window)
+	tagLocker.Unlock()
+
+	if dropped == 1 {
+		summary := *args
+		summary.id = ""
+		summary.Subject = fmt.Sprintf("Too many messages tagged %s", args.Tag)
+		summary.Content = fmt.Sprintf("The messages tagged %s have hit the cap of %d "+
+			"per %s, and the further ones are dropped until %s.", args.Tag, limit.Max,
+			window, until.Format(time.RFC3339))
+		summary.Template = ""
+		summary.AckTimeout = 0
+		summary.attachments = nil
+		summary.emailOptions.Calendar = nil
+
+		go func() {
+			if _, err := deliver(isEmail, &summary); err != nil {
+				glog.Errorf("failed to send the summary of the tag %s: %s", args.Tag, err)
+			}
+		}()
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprint(int(time.Until(until).Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(fmt.Sprintf("the messages tagged %s have hit the cap", args.Tag)))
+	return false
+}
@@ -0,0 +1,289 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ScopeAdminSuppression is the scope of the API key to manage the suppressions.
+const ScopeAdminSuppression = "admin:suppression"
+
+// Suppression is a recipient to which no messages are sent.
+type Suppression struct {
+	// The channel, "sms" or "email".
+	Channel   string    `json:"channel"`
+	Recipient string    `json:"recipient"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// The tenant which adds the suppression, see Tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// SuppressionStore is used to store the suppression list.
+type SuppressionStore interface {
+	Add(Suppression) error
+	Remove(channel, recipient string) error
+	Get(channel, recipient string) (s Suppression, ok bool, err error)
+	List() ([]Suppression, error)
+}
+
+type memorySuppressionStore struct {
+	sync.RWMutex
+	items map[string]Suppression
+}
+
+// NewMemorySuppressionStore returns a new SuppressionStore based on the memory.
+func NewMemorySuppressionStore() SuppressionStore {
+	return &memorySuppressionStore{items: make(map[string]Suppression)}
+}
+
+func (s *memorySuppressionStore) Add(sp Suppression) error {
+	s.Lock()
+	s.items[providerKey(sp.Channel, sp.Recipient)] = sp
+	s.Unlock()
+	return nil
+}
+
+func (s *memorySuppressionStore) Remove(channel, recipient string) error {
+	s.Lock()
+	delete(s.items, providerKey(channel, recipient))
+	s.Unlock()
+	return nil
+}
+
+func (s *memorySuppressionStore) Get(channel, recipient string) (Suppression, bool, error) {
+	s.RLock()
+	sp, ok := s.items[providerKey(channel, recipient)]
+	s.RUnlock()
+	return sp, ok, nil
+}
+
+func (s *memorySuppressionStore) List() ([]Suppression, error) {
+	s.RLock()
+	results := make([]Suppression, 0, len(s.items))
+	for _, sp := range s.items {
+		results = append(results, sp)
+	}
+	s.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CreatedAt.Before(results[j].CreatedAt)
+	})
+	return results, nil
+}
+
+var (
+	suppressionLocker = new(sync.Mutex)
+	suppressionStore  = NewMemorySuppressionStore()
+)
+
+// SetSuppressionStore sets the store of the suppression list,
+// which is in memory by default.
+func SetSuppressionStore(s SuppressionStore) {
+	if s == nil {
+		panic("the suppression store must not be nil")
+	}
+
+	suppressionLocker.Lock()
+	suppressionStore = s
+	suppressionLocker.Unlock()
+}
+
+func getSuppressionStore() SuppressionStore {
+	suppressionLocker.Lock()
+	defer suppressionLocker.Unlock()
+	return suppressionStore
+}
+
+// normalizeRecipient normalizes the recipient to look up the suppression list,
+// that's, the lower-case email address, or the phone in the E.164 format,
+// such as "+14155550100" for "+1 (415) 555-0100", "0014155550100" and
+// "14155550100". The national phone with the trunk prefix "0" has no country
+// code, so it is kept only with the digits.
+func normalizeRecipient(channel, recipient string) string {
+	recipient = strings.TrimSpace(recipient)
+	if channel == "email" {
+		return strings.ToLower(recipient)
+	}
+
+	if i := strings.IndexAny(recipient, "+0123456789"); i > -1 {
+		recipient = recipient[i:]
+	}
+
+	buf := make([]byte, 1, len(recipient)+1)
+	buf[0] = '+'
+	for i := 0; i < len(recipient); i++ {
+		if c := recipient[i]; c >= '0' && c <= '9' {
+			buf = append(buf, c)
+		}
+	}
+
+	switch digits := string(buf[1:]); {
+	case digits == "":
+		return ""
+	case strings.HasPrefix(recipient, "+"):
+		return string(buf)
+	case strings.HasPrefix(digits, "00"):
+		return "+" + digits[2:]
+	case strings.HasPrefix(digits, "0"):
+		return digits
+	default:
+		return string(buf)
+	}
+}
+
+// isSuppressed reports whether the recipient, or its tombstone after erased,
+// is in the suppression list.
+func isSuppressed(channel, recipient string) bool {
+	store := getSuppressionStore()
+	recipient = normalizeRecipient(channel, recipient)
+	_, ok, err := store.Get(channel, recipient)
+	if err == nil && !ok {
+		_, ok, err = store.Get(channel, tombstoneKey(recipient))
+	}
+	if err != nil {
+		glog.Errorf("failed to look up the suppression list: %s", err)
+		return false
+	}
+	return ok
+}
+
+func suppress(channel, recipient, reason string) error {
+	return getSuppressionStore().Add(Suppression{
+		Channel:   channel,
+		Recipient: normalizeRecipient(channel, recipient),
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+}
+
+// unsuppress removes the recipient and its tombstone from the suppression
+// list, or the tombstone itself like "t:0123...", see tombstoneKey.
+func unsuppress(channel, recipient string) error {
+	store := getSuppressionStore()
+	if strings.HasPrefix(recipient, "t:") {
+		return store.Remove(channel, recipient)
+	}
+
+	recipient = normalizeRecipient(channel, recipient)
+	if err := store.Remove(channel, recipient); err != nil {
+		return err
+	}
+	return store.Remove(channel, tombstoneKey(recipient))
+}
+
+// The default STOP and START keywords, which are matched with the whole
+// content of the inbound sms case-insensitively.
+var (
+	defaultStopKeywords = []string{"STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL",
+		"END", "QUIT", "OPTOUT", "REVOKE", "TD", "退订"}
+	defaultStartKeywords = []string{"START", "UNSTOP"}
+)
+
+func matchKeyword(content string, keywords []string) bool {
+	content = strings.TrimSpace(content)
+	for _, keyword := range keywords {
+		if strings.EqualFold(content, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSMSKeywords adds the sender of the inbound sms to the suppression list
+// if it is a STOP keyword, or removes it if it is a START keyword.
+func handleSMSKeywords(c *Config, sms InboundSMS) {
+	if c.DisableSMSKeywords {
+		return
+	}
+
+	stops, starts := c.StopKeywords, c.StartKeywords
+	if len(stops) == 0 {
+		stops = defaultStopKeywords
+	}
+	if len(starts) == 0 {
+		starts = defaultStartKeywords
+	}
+
+	var err error
+	if matchKeyword(sms.Content, stops) {
+		err = suppress("sms", sms.From, "stop keyword by "+sms.Provider)
+		glog.Infof("suppress the phone %s by the stop keyword", sms.From)
+	} else if matchKeyword(sms.Content, starts) {
+		err = unsuppress("sms", sms.From)
+		glog.Infof("unsuppress the phone %s by the start keyword", sms.From)
+	}
+	if err != nil {
+		glog.Errorf("failed to update the suppression list: %s", err)
+	}
+}
+
+// handleSuppressions manages the suppression list:
+//
+//	GET    /v1/suppressions                                 list all
+//	POST   /v1/suppressions                                 add, see Suppression
+//	DELETE /v1/suppressions?channel=CHANNEL&recipient=RECIPIENT remove
+func handleSuppressions(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if !authorize(_config, ScopeAdminSuppression, w, r) {
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case "GET":
+		var items []Suppression
+		if items, err = getSuppressionStore().List(); err == nil {
+			var content []byte
+			if content, err = json.Marshal(items); err == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(content)
+				return
+			}
+		}
+	case "POST":
+		buf := bytes.NewBuffer(nil)
+		if _, err = buf.ReadFrom(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var sp Suppression
+		if err = json.Unmarshal(buf.Bytes(), &sp); err != nil ||
+			(sp.Channel != "sms" && sp.Channel != "email") || sp.Recipient == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid suppression"))
+			return
+		}
+		err = suppress(sp.Channel, sp.Recipient, sp.Reason)
+	case "DELETE":
+		query := r.URL.Query()
+		err = unsuppress(query.Get("channel"), query.Get("recipient"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
@@ -0,0 +1,612 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/xgfone/messageapi/internal/mustache"
+)
+
+// TemplateVariant is a variant of the template for A/B testing.
+type TemplateVariant struct {
+	Name    string `json:"name"`
+	Weight  int    `json:"weight"`
+	Subject string `json:"subject,omitempty"`
+	Content string `json:"content"`
+
+	// The target of the call-to-action link of the variant, which is the
+	// template variable "click_url" redirecting to it to count the clicks,
+	// see trackLink.
+	URL string `json:"url,omitempty"`
+}
+
+// TemplateLocale is the localized subject and content of the template.
+type TemplateLocale struct {
+	Subject string `json:"subject,omitempty"`
+	Content string `json:"content"`
+}
+
+// The types of the template variables.
+const (
+	TypeAny     = "any"
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBool    = "bool"
+	TypeArray   = "array"
+	TypeObject  = "object"
+)
+
+// TemplateVariable is the declaration of a template variable.
+type TemplateVariable struct {
+	// The type of the variable, which is "any" by default.
+	Type     string `json:"type,omitempty"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// empty returns the empty value of the missing optional variable,
+// which is false by {{if}} and rendered as "".
+func (v TemplateVariable) empty() interface{} {
+	switch v.Type {
+	case TypeArray:
+		return []interface{}{}
+	case TypeObject:
+		return map[string]interface{}{}
+	default:
+		return ""
+	}
+}
+
+// check returns the reason why the value is not the type, or "".
+func (v TemplateVariable) check(value interface{}) string {
+	var ok bool
+	switch v.Type {
+	case "", TypeAny:
+		return ""
+	case TypeString:
+		_, ok = value.(string)
+	case TypeNumber:
+		_, ok = value.(float64)
+	case TypeInteger:
+		var f float64
+		if f, ok = value.(float64); ok {
+			ok = f == math.Trunc(f)
+		}
+	case TypeBool:
+		_, ok = value.(bool)
+	case TypeArray:
+		_, ok = value.([]interface{})
+	case TypeObject:
+		_, ok = value.(map[string]interface{})
+	}
+
+	if ok {
+		return ""
+	}
+	return "must be " + v.Type
+}
+
+// checkVars checks the variables against the declarations,
+// and returns all the problems.
+func (t Template) checkVars(vars map[string]interface{}) error {
+	names := make([]string, 0, len(t.Variables))
+	for name := range t.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, name := range names {
+		v := t.Variables[name]
+		value, ok := vars[name]
+		if !ok || value == nil {
+			if v.Required {
+				problems = append(problems, fmt.Sprintf("%s is required", name))
+			}
+		} else if reason := v.check(value); reason != "" {
+			problems = append(problems, fmt.Sprintf("%s %s", name, reason))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid variables: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Template is the template of the message, which is rendered with
+// the variables in the request.
+type Template struct {
+	// The engine to render the template, which is "go" by default, that's,
+	// the Go text/template, or "mustache". See RegisterTemplateEngine.
+	//
+	// The mustache engine escapes the variables as HTML only for the HTML
+	// document, such as the PDF attachment, but not for the subject and the
+	// content, which are the plain text.
+	Engine string `json:"engine,omitempty"`
+
+	// The subject is only used by the email.
+	Subject string `json:"subject,omitempty"`
+	Content string `json:"content"`
+
+	// The name of the partial as the layout, see Config.Partials. If given,
+	// the rendered content is rendered again by the layout as the variable
+	// "content", so the templates can share the same layout. For the HTML
+	// document by the mustache engine, it should be "{{{content}}}", which
+	// is not escaped again.
+	Layout string `json:"layout,omitempty"`
+
+	// The variables declared by the template. If not empty, the request is
+	// rejected if any required variable is missing or the type of any declared
+	// variable is wrong. The missing optional ones are empty, but the other
+	// missing variables fail to render by the Go engine, so the request is
+	// rejected with 400.
+	Variables map[string]TemplateVariable `json:"variables,omitempty"`
+
+	// If not empty, a variant is selected for each recipient by the weights,
+	// instead of the subject and the content above. The same recipient always
+	// gets the same variant, which is selected by the first one of the message
+	// to many recipients, and each recipient of the bulk gets its own variant.
+	//
+	// The deliveries, the opens and the clicks are reported per variant, the
+	// opens of which are counted by the template variable "open_url" embedded
+	// as an image and the clicks by "click_url", see TemplateVariant.URL.
+	Variants []TemplateVariant `json:"variants,omitempty"`
+
+	// The localized subjects and contents. The key is the locale, such as
+	// "zh-CN", or the language, such as "zh", which is selected by the
+	// variable "locale" instead of the subject, the content and the variants
+	// above. The locale is matched first and then its language, or it falls
+	// back to the template itself if none matches.
+	//
+	// If the request has no variable "locale", it is the preferred locale of
+	// the recipient, or detected by the phone, see Config.CountryLocales.
+	Locales map[string]TemplateLocale `json:"locales,omitempty"`
+}
+
+func (t Template) validate(partials map[string]string) error {
+	if _, ok := partials[t.Layout]; t.Layout != "" && !ok {
+		return fmt.Errorf("have no the layout[%s]", t.Layout)
+	}
+	if _, ok := getTemplateEngine(t.Engine); !ok {
+		return fmt.Errorf("have no the template engine[%s]", t.Engine)
+	}
+
+	for name, v := range t.Variables {
+		switch v.Type {
+		case "", TypeAny, TypeString, TypeNumber, TypeInteger, TypeBool,
+			TypeArray, TypeObject:
+		default:
+			return fmt.Errorf("the type of the variable[%s] is unknown", name)
+		}
+	}
+
+	for _, v := range t.Variants {
+		if v.Name == "" {
+			return fmt.Errorf("the name of the variant is empty")
+		} else if v.Weight <= 0 {
+			return fmt.Errorf("the weight of the variant[%s] is not positive", v.Name)
+		}
+
+		if v.URL != "" {
+			u, err := url.Parse(v.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("the url of the variant[%s] is not http or https", v.Name)
+			}
+		}
+	}
+
+	for locale := range t.Locales {
+		if locale == "" {
+			return fmt.Errorf("the locale is empty")
+		}
+	}
+	return nil
+}
+
+// pickLocale selects the localized subject and content by the locale, such
+// as "zh-CN", which falls back to its language, such as "zh". The locale is
+// case-insensitive, and "_" is the same as "-".
+func (t Template) pickLocale(locale string) *TemplateLocale {
+	if len(t.Locales) == 0 || locale == "" {
+		return nil
+	}
+
+	normalize := func(s string) string {
+		return strings.ToLower(strings.Replace(s, "_", "-", -1))
+	}
+	locale = normalize(locale)
+	language := locale
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		language = locale[:i]
+	}
+
+	var matched *TemplateLocale
+	for key := range t.Locales {
+		switch normalize(key) {
+		case locale:
+			l := t.Locales[key]
+			return &l
+		case language:
+			l := t.Locales[key]
+			matched = &l
+		}
+	}
+	return matched
+}
+
+// detectLocale returns the locale of the phone by Config.CountryLocales, or "".
+func (c *Config) detectLocale(phone string) string {
+	if len(c.CountryLocales) == 0 || phone == "" {
+		return ""
+	}
+	return c.CountryLocales[c.localeCodes.match(e164Digits(phone))]
+}
+
+// variant returns the variant by the name if given, or selects one by the
+// weights for the recipient.
+func (t Template) variant(name, recipient string) *TemplateVariant {
+	if name == "" {
+		return t.pickVariant(recipient)
+	}
+	for i := range t.Variants {
+		if t.Variants[i].Name == name {
+			return &t.Variants[i]
+		}
+	}
+	return nil
+}
+
+// pickVariant selects a variant by the weights for the recipient.
+func (t Template) pickVariant(recipient string) *TemplateVariant {
+	total := 0
+	for _, v := range t.Variants {
+		total += v.Weight
+	}
+	if total == 0 {
+		return nil
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(recipient))
+	n := int(h.Sum32() % uint32(total))
+	for i := range t.Variants {
+		if n -= t.Variants[i].Weight; n < 0 {
+			return &t.Variants[i]
+		}
+	}
+	return nil
+}
+
+// TemplateEngine is the engine to render the templates.
+//
+// partials are the shared templates, such as the header and the footer,
+// which may be referred by the template, such as `{{template "header" .}}`
+// for the Go engine and "{{> header}}" for the mustache engine.
+type TemplateEngine interface {
+	Render(text string, partials map[string]string, vars map[string]interface{}) (string, error)
+}
+
+// TextTemplateEngine is implemented optionally by the template engine, which
+// renders the plain text, such as the sms and the subject, without escaping
+// the variables as HTML, like the mustache engine. Or, Render is used.
+type TextTemplateEngine interface {
+	RenderText(text string, partials map[string]string, vars map[string]interface{}) (string, error)
+}
+
+// renderTemplate renders the text by the engine, which is the HTML document,
+// such as the PDF, or the plain text.
+func renderTemplate(engine TemplateEngine, html bool, text string, partials map[string]string,
+	vars map[string]interface{}) (string, error) {
+	if e, ok := engine.(TextTemplateEngine); ok && !html {
+		return e.RenderText(text, partials, vars)
+	}
+	return engine.Render(text, partials, vars)
+}
+
+// The builtin template engines.
+const (
+	EngineGo       = "go"
+	EngineMustache = "mustache"
+)
+
+var (
+	engineLocker = new(sync.Mutex)
+	engines      = map[string]TemplateEngine{
+		EngineGo:       newCachedEngine(parseGoTemplate),
+		EngineMustache: newCachedEngine(parseMustacheTemplate),
+	}
+)
+
+// RegisterTemplateEngine registers the template engine, which may be
+// selected by the option "engine" of the template.
+func RegisterTemplateEngine(name string, engine TemplateEngine) {
+	engineLocker.Lock()
+	engines[name] = engine
+	engineLocker.Unlock()
+}
+
+func getTemplateEngine(name string) (TemplateEngine, bool) {
+	if name == "" {
+		name = EngineGo
+	}
+
+	engineLocker.Lock()
+	engine, ok := engines[name]
+	engineLocker.Unlock()
+	return engine, ok
+}
+
+// renderFunc renders the parsed template, which escapes the variables
+// as HTML if html is true and the engine escapes them.
+type renderFunc func(vars map[string]interface{}, html bool) (string, error)
+
+// cachedEngine is the template engine which caches the parsed templates.
+type cachedEngine struct {
+	sync.Mutex
+	parse func(text string, partials map[string]string) (renderFunc, error)
+	cache map[string]renderFunc
+}
+
+func newCachedEngine(parse func(string, map[string]string) (renderFunc, error)) *cachedEngine {
+	return &cachedEngine{parse: parse, cache: make(map[string]renderFunc)}
+}
+
+// partialsSum returns the checksum of the partials, so that the cached
+// templates are parsed again after the partials are changed.
+func partialsSum(partials map[string]string) string {
+	names := make([]string, 0, len(partials))
+	for name := range partials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(partials[name]))
+		h.Write([]byte{0})
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+func (e *cachedEngine) Render(text string, partials map[string]string,
+	vars map[string]interface{}) (string, error) {
+	return e.render(text, partials, vars, true)
+}
+
+// RenderText implements the interface TextTemplateEngine.
+func (e *cachedEngine) RenderText(text string, partials map[string]string,
+	vars map[string]interface{}) (string, error) {
+	return e.render(text, partials, vars, false)
+}
+
+func (e *cachedEngine) render(text string, partials map[string]string,
+	vars map[string]interface{}, html bool) (string, error) {
+	key := text
+	if len(partials) > 0 {
+		key = partialsSum(partials) + ":" + text
+	}
+
+	e.Lock()
+	render, ok := e.cache[key]
+	e.Unlock()
+
+	if !ok {
+		var err error
+		if render, err = e.parse(text, partials); err != nil {
+			return "", err
+		}
+
+		e.Lock()
+		if len(e.cache) > 1024 {
+			e.cache = make(map[string]renderFunc)
+		}
+		e.cache[key] = render
+		e.Unlock()
+	}
+
+	return render(vars, html)
+}
+
+func parseGoTemplate(text string, partials map[string]string) (renderFunc, error) {
+	t, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for name, partial := range partials {
+		// The partials may be written for the other engines, which are
+		// ignored, and referring to them fails when executing.
+		if _, err := template.New(name).Parse(partial); err == nil {
+			t.New(name).Parse(partial)
+		}
+	}
+
+	return func(vars map[string]interface{}, html bool) (string, error) {
+		buf := bytes.NewBuffer(nil)
+		if err := t.Execute(buf, integerVars(vars)); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}, nil
+}
+
+// integerVars returns the copy of the variables, the whole numbers in which,
+// decoded from JSON as float64, are converted to int64, so that they are
+// rendered as "12345678" instead of "1.2345678e+07".
+func integerVars(v interface{}) interface{} {
+	switch _v := v.(type) {
+	case float64:
+		if _v == math.Trunc(_v) && math.Abs(_v) < 1<<53 {
+			return int64(_v)
+		}
+	case map[string]interface{}:
+		vars := make(map[string]interface{}, len(_v))
+		for k, e := range _v {
+			vars[k] = integerVars(e)
+		}
+		return vars
+	case []interface{}:
+		values := make([]interface{}, len(_v))
+		for i, e := range _v {
+			values[i] = integerVars(e)
+		}
+		return values
+	}
+	return v
+}
+
+func parseMustacheTemplate(text string, partials map[string]string) (renderFunc, error) {
+	t, err := mustache.ParseWithPartials(text, partials)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(vars map[string]interface{}, html bool) (string, error) {
+		if html {
+			return t.Render(vars), nil
+		}
+		return t.RenderText(vars), nil
+	}, nil
+}
+
+// setVar returns a copy of the variables with the variable set.
+func setVar(vars map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	_vars := make(map[string]interface{}, len(vars)+1)
+	for k, v := range vars {
+		_vars[k] = v
+	}
+	_vars[key] = value
+	return _vars
+}
+
+// setLinkVar sets the variable of the link, such as "ack_url", which is ""
+// if the link is not available and not given by the request, so that the
+// template may check it by {{if .ack_url}} without the missing key error.
+func setLinkVar(vars map[string]interface{}, key, link string) map[string]interface{} {
+	if _, ok := vars[key]; ok && link == "" {
+		return vars
+	}
+	return setVar(vars, key, link)
+}
+
+// renderContent renders the content by the template and its layout,
+// which is the HTML document if html is true, or the plain text.
+func (t Template) renderContent(c *Config, content string, html bool,
+	vars map[string]interface{}) (string, error) {
+	engine, ok := getTemplateEngine(t.Engine)
+	if !ok {
+		return "", fmt.Errorf("have no the template engine[%s]", t.Engine)
+	}
+
+	content, err := renderTemplate(engine, html, content, c.Partials, vars)
+	if err != nil {
+		return "", fmt.Errorf("failed to render the content: %s", err)
+	} else if t.Layout == "" {
+		return content, nil
+	}
+
+	_vars := make(map[string]interface{}, len(vars)+1)
+	for k, v := range vars {
+		_vars[k] = v
+	}
+	_vars["content"] = content
+
+	layout := c.Partials[t.Layout]
+	if content, err = renderTemplate(engine, html, layout, c.Partials, _vars); err != nil {
+		return "", fmt.Errorf("failed to render the layout: %s", err)
+	}
+	return content, nil
+}
+
+// applyTemplate renders the subject and the content of the request
+// by the template if given.
+func (r *Request) applyTemplate(c *Config) error {
+	if r.Template == "" {
+		return nil
+	}
+
+	t, ok := c.lookupTemplate(r.tenant, r.Template)
+	if !ok {
+		return fmt.Errorf("have no the template[%s]", r.Template)
+	}
+
+	if err := t.checkVars(r.Vars); err != nil {
+		return err
+	}
+
+	// The missing optional variables are empty, so that the template may
+	// check them without the missing key error.
+	for name, v := range t.Variables {
+		if _, ok := r.Vars[name]; !ok {
+			r.Vars = setVar(r.Vars, name, v.empty())
+		}
+	}
+
+	recipient := r.To
+	if recipient == "" {
+		recipient = r.Phone
+	}
+
+	var preferencesURL string
+	if !strings.Contains(recipient, ",") {
+		if _, ok := r.Vars["locale"]; !ok {
+			if p, ok := getPreference(recipient); ok && p.Locale != "" {
+				r.Vars = setVar(r.Vars, "locale", p.Locale)
+			} else if locale := c.detectLocale(r.Phone); locale != "" && r.To == "" {
+				r.Vars = setVar(r.Vars, "locale", locale)
+			}
+		}
+		preferencesURL = preferenceLink(c, recipient)
+	}
+	r.Vars = setLinkVar(r.Vars, "preferences_url", preferencesURL)
+
+	// Generate the message id in advance for the signed links.
+	r.id = newMessageID()
+	r.Vars = setLinkVar(r.Vars, "ack_url", ackLink(c, r.id, recipient))
+
+	var openURL, clickURL string
+	subject, content := t.Subject, t.Content
+	locale, _ := r.Vars["locale"].(string)
+	if l := t.pickLocale(locale); l != nil {
+		content = l.Content
+		if l.Subject != "" {
+			subject = l.Subject
+		}
+		r.variant = ""
+	} else if v := t.variant(r.variant, strings.TrimSpace(strings.Split(recipient, ",")[0])); v != nil {
+		subject, content = v.Subject, v.Content
+		r.variant = v.Name
+		openURL = trackLink(c, r.id, trackOpen)
+		if v.URL != "" {
+			clickURL = trackLink(c, r.id, trackClick)
+		}
+	}
+	r.Vars = setLinkVar(r.Vars, "open_url", openURL)
+	r.Vars = setLinkVar(r.Vars, "click_url", clickURL)
+
+	if subject != "" {
+		engine, ok := getTemplateEngine(t.Engine)
+		if !ok {
+			return fmt.Errorf("have no the template engine[%s]", t.Engine)
+		}
+
+		var err error
+		if r.Subject, err = renderTemplate(engine, false, subject, c.Partials, r.Vars); err != nil {
+			return fmt.Errorf("failed to render the subject: %s", err)
+		}
+	}
+
+	var err error
+	r.Content, err = t.renderContent(c, content, false, r.Vars)
+	return err
+}
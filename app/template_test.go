@@ -0,0 +1,37 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRenderTemplateNumbers(t *testing.T) {
+	var vars map[string]interface{}
+	data := `{"code":12345678,"amount":1000000,"price":12.5,"items":[{"id":9876543210}]}`
+	if err := json.Unmarshal([]byte(data), &vars); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		engine string
+		text   string
+	}{
+		{EngineGo, "code={{.code}} amount={{.amount}} price={{.price}} id={{range .items}}{{.id}}{{end}}"},
+		{EngineMustache, "code={{code}} amount={{amount}} price={{price}} id={{#items}}{{id}}{{/items}}"},
+	} {
+		engine, _ := getTemplateEngine(c.engine)
+		for _, html := range []bool{false, true} {
+			s, err := renderTemplate(engine, html, c.text, nil, vars)
+			if err != nil {
+				t.Fatal(err)
+			} else if expect := "code=12345678 amount=1000000 price=12.5 id=9876543210"; s != expect {
+				t.Errorf("%s: expect '%s', but got '%s'", c.engine, expect, s)
+			}
+		}
+	}
+
+	// The variables are not changed.
+	if _, ok := vars["code"].(float64); !ok {
+		t.Errorf("expect the float64 variable, but got %T", vars["code"])
+	}
+}
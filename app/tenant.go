@@ -0,0 +1,655 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xgfone/messageapi"
+)
+
+// ScopeAdminTenant is the scope of the admin keys of the tenants, which is
+// granted to Tenant.AdminKeys implicitly.
+const ScopeAdminTenant = "admin:tenant"
+
+// tenantScopes is the scopes which the API keys of the tenants may have.
+// The history and the statistics read by them are scoped by the tenant.
+var tenantScopes = map[string]bool{
+	ScopeSendEmail:   true,
+	ScopeSendSMS:     true,
+	ScopeSendMMS:     true,
+	ScopeSendMessage: true,
+	ScopeReadHistory: true,
+	ScopeReadStats:   true,
+}
+
+// Tenant is a tenant of the gateway, which is managed by the global admin
+// by the option "tenants" of the configuration, and the resources of which
+// are managed by the admin keys of the tenant themselves:
+//
+//	GET    /v1/tenants/NAME                               the tenant
+//	PUT    /v1/tenants/NAME/providers/CHANNEL:PROVIDER    replace the options of the provider
+//	PUT    /v1/tenants/NAME/templates/TEMPLATE            add or replace the template
+//	DELETE /v1/tenants/NAME/templates/TEMPLATE            remove the template
+//	PUT    /v1/tenants/NAME/keys                          add or replace the key, see TenantKey
+//	DELETE /v1/tenants/NAME/keys                          remove the key, see TenantKey
+//	GET    /v1/tenants/NAME/suppressions                  list the suppressions of the tenant
+//	POST   /v1/tenants/NAME/suppressions                  add, see Suppression
+//	DELETE /v1/tenants/NAME/suppressions?channel=CHANNEL&recipient=RECIPIENT remove
+//
+// The global API keys with the scope "admin:config" may also manage all the
+// tenants. The changes are applied by resetting the configuration, so they
+// are refused if the configuration is managed by the file, see WatchConfig.
+//
+// The API keys of the tenant may only send the messages by the providers
+// delegated to it. The options replaced by the tenant are loaded into the
+// instances of the tenant apart from the global providers, which are used
+// by the API keys of the tenant only.
+//
+// The suppressions added by the tenant apply to all the messages like the
+// others, since the recipient has opted out, but the tenant may only list
+// and remove the ones added by itself.
+type Tenant struct {
+	// The admin keys of the tenant, which have the scope "admin:tenant".
+	AdminKeys []string `json:"admin_keys,omitempty"`
+
+	// The providers delegated to the tenant, such as "sms:twilio", the
+	// options of which the tenant may replace.
+	Providers []string `json:"providers,omitempty"`
+
+	// The options of the delegated providers replaced by the tenant, the key
+	// of which is like Providers. The delegated provider without the options
+	// is the global one shared with the others.
+	Options map[string]map[string]string `json:"options,omitempty"`
+
+	// The API keys of the tenant with the scopes, which may only be the
+	// scopes to send the messages, such as "send:sms", or to read the history
+	// and the statistics, which are scoped by the tenant.
+	Keys map[string][]string `json:"keys,omitempty"`
+
+	// The templates of the tenant, which are used by the API keys of the
+	// tenant instead of the global ones with the same names.
+	Templates map[string]Template `json:"templates,omitempty"`
+}
+
+// TenantKey is the body of the request to add, replace or remove the API key
+// of the tenant, which is not in the url, so that it is not recorded by the
+// access logs and the proxies.
+type TenantKey struct {
+	Key string `json:"key"`
+
+	// The scopes of the key to add or replace, which are ignored to remove.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// hasProvider reports whether the provider of the channel is delegated
+// to the tenant.
+func (t Tenant) hasProvider(channel, provider string) bool {
+	for _, p := range t.Providers {
+		if p == channel+":"+provider {
+			return true
+		}
+	}
+	return false
+}
+
+// buildKeys returns all the API keys with the scopes, including the ones of
+// the tenants, and the tenants of the keys of the tenants.
+func (c *Config) buildKeys() (keys map[string][]string, keyTenants map[string]string, errs ConfigErrors) {
+	if len(c.Tenants) == 0 {
+		return c.Keys, nil, nil
+	}
+
+	keys = make(map[string][]string, len(c.Keys))
+	keyTenants = make(map[string]string)
+	for key, scopes := range c.Keys {
+		keys[key] = scopes
+	}
+
+	add := func(path, tenant, key string, scopes []string) {
+		if key == "" {
+			errs.add(path, "the key is empty")
+		} else if other, ok := keyTenants[key]; ok {
+			errs.add(path, "the key is also used by the tenant %s", other)
+		} else if _, ok := keys[key]; ok {
+			errs.add(path, "the key is also used by keys")
+		} else {
+			keys[key] = scopes
+			keyTenants[key] = tenant
+		}
+	}
+
+	names := make([]string, 0, len(c.Tenants))
+	for name := range c.Tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t := c.Tenants[name]
+		path := "tenants." + name
+		if !providerNameRegexp.MatchString(name) {
+			errs.add(path, "the name must be 1-64 letters, digits, '_', '-' or '.'")
+		}
+
+		for _, key := range t.AdminKeys {
+			add(path+".admin_keys", name, key, []string{ScopeAdminTenant})
+		}
+		tenantKeys := make([]string, 0, len(t.Keys))
+		for key := range t.Keys {
+			tenantKeys = append(tenantKeys, key)
+		}
+		sort.Strings(tenantKeys)
+		for _, key := range tenantKeys {
+			scopes := t.Keys[key]
+			for _, scope := range scopes {
+				if !tenantScopes[scope] {
+					errs.add(path+".keys", "the tenant cannot have the scope %s", scope)
+				}
+			}
+			add(path+".keys", name, key, scopes)
+		}
+
+		for _, p := range t.Providers {
+			channel, provider := splitProviderKey(p)
+			var ok bool
+			switch channel {
+			case "email":
+				_, ok = c.Emails[provider]
+			case "sms":
+				_, ok = c.SMSes[provider]
+			case "mms":
+				_, ok = c.MMSes[provider]
+			case "messenger":
+				_, ok = c.Messengers[provider]
+			}
+			if !ok {
+				errs.add(path+".providers", "have no the provider %s", p)
+			}
+		}
+	}
+	return
+}
+
+// newProvider returns a new instance of the registered provider
+// of the channel, or nil.
+func newProvider(channel, name string) messageapi.Config {
+	switch channel {
+	case "email":
+		if p := messageapi.NewEmail(name); p != nil {
+			return p
+		}
+	case "sms":
+		if p := messageapi.NewSMS(name); p != nil {
+			return p
+		}
+	case "mms":
+		if p := messageapi.NewMMS(name); p != nil {
+			return p
+		}
+	case "messenger":
+		if p := messageapi.NewMessenger(name); p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// loadTenantProviders loads the instances of the delegated providers with the
+// options replaced by the tenants. The key of the result is the tenant, and
+// the key of the instances is the provider like "CHANNEL:PROVIDER".
+func (c *Config) loadTenantProviders() (providers map[string]map[string]messageapi.Config, errs ConfigErrors) {
+	providers = make(map[string]map[string]messageapi.Config, len(c.Tenants))
+	for name, t := range c.Tenants {
+		instances := make(map[string]messageapi.Config, len(t.Options))
+		for key, options := range t.Options {
+			path := "tenants." + name + ".options." + key
+			channel, provider := splitProviderKey(key)
+			if !t.hasProvider(channel, provider) {
+				errs.add(path, "the provider %s is not delegated to the tenant", key)
+				continue
+			}
+
+			instance := newProvider(channel, provider)
+			if instance == nil {
+				errs.add(path, "have no the provider %s", key)
+				continue
+			}
+
+			options, err := decryptOptions(c.providerOptions(channel, options))
+			if err == nil {
+				options, err = messageapi.ApplySchema(messageapi.GetConfigSchema(instance), options)
+			}
+			if err == nil {
+				err = instance.Load(options)
+			}
+			if err != nil {
+				errs.add(path, "Failed to load the %s configuration, err=%s", channel, err)
+				continue
+			}
+			instances[key] = instance
+		}
+		providers[name] = instances
+	}
+	return
+}
+
+// lookupProvider returns the provider of the channel used by the tenant,
+// that's, the instance of the tenant if it has replaced the options, or the
+// global one. The tenant may only use the providers delegated to it.
+func (c *Config) lookupProvider(tenant, channel, name string) (messageapi.Config, bool) {
+	if tenant != "" {
+		if !c.Tenants[tenant].hasProvider(channel, name) {
+			return nil, false
+		} else if p, ok := c.tenantProviders[tenant][channel+":"+name]; ok {
+			return p, true
+		}
+	}
+
+	switch channel {
+	case "email":
+		if p, ok := c.emails[name]; ok {
+			return p, true
+		}
+	case "sms":
+		if p, ok := c.smses[name]; ok {
+			return p, true
+		}
+	case "mms":
+		if p, ok := c.mmses[name]; ok {
+			return p, true
+		}
+	case "messenger":
+		if p, ok := c.messengers[name]; ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// providerNames returns the names of the providers of the channel
+// which the tenant may use, or all the global ones if tenant is empty.
+func (c *Config) providerNames(tenant, channel string) []string {
+	var names []string
+	if tenant != "" {
+		for _, p := range c.Tenants[tenant].Providers {
+			if ch, name := splitProviderKey(p); ch == channel {
+				if _, ok := c.lookupProvider(tenant, channel, name); ok {
+					names = append(names, name)
+				}
+			}
+		}
+		return names
+	}
+
+	switch channel {
+	case "email":
+		for name := range c.emails {
+			names = append(names, name)
+		}
+	case "sms":
+		for name := range c.smses {
+			names = append(names, name)
+		}
+	case "mms":
+		for name := range c.mmses {
+			names = append(names, name)
+		}
+	case "messenger":
+		for name := range c.messengers {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// rejectTenant refuses the API key of the tenant to read the state which is
+// not scoped by the tenant, such as the latencies of the providers, and
+// reports whether it is refused.
+func rejectTenant(c *Config, w http.ResponseWriter, r *http.Request) bool {
+	if tenant := c.keyTenants[getAPIKey(r)]; tenant != "" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("the api key of the tenant " + tenant + " cannot read the global state"))
+		return true
+	}
+	return false
+}
+
+// lookupTemplate returns the template of the tenant, or the global one.
+func (c *Config) lookupTemplate(tenant, name string) (Template, bool) {
+	if tenant != "" {
+		if t, ok := c.Tenants[tenant].Templates[name]; ok {
+			return t, true
+		}
+	}
+	t, ok := c.Templates[name]
+	return t, ok
+}
+
+// errConfigWatched is returned when updating the configuration managed by the file.
+var errConfigWatched = fmt.Errorf("the configuration is managed by the file")
+
+// updateLocker serializes reading, updating and resetting the configuration.
+var updateLocker = new(sync.Mutex)
+
+// updateConfig resets the configuration, the raw options of which are
+// updated by update.
+func updateConfig(update func(_conf map[string]interface{}) error) error {
+	updateLocker.Lock()
+	defer updateLocker.Unlock()
+
+	if isConfigWatched() {
+		return errConfigWatched
+	}
+
+	configLocker.Lock()
+	current := config
+	configLocker.Unlock()
+
+	_conf, err := configMap(current)
+	if err != nil {
+		return err
+	} else if err = update(_conf); err != nil {
+		return err
+	}
+
+	conf, err := reparseConfig(current, _conf)
+	if err != nil {
+		return err
+	}
+	return ResetConfig(conf)
+}
+
+// reparseConfig validates and parses the raw options of the configuration,
+// which is updated from the current one.
+func reparseConfig(current *Config, _conf map[string]interface{}) (*Config, error) {
+	data, err := json.Marshal(_conf)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateConfig(data, _conf); err != nil {
+		return nil, err
+	}
+
+	conf, err := parseConfig(_conf)
+	if err != nil {
+		return nil, err
+	}
+	conf.key = current.key
+	return conf, nil
+}
+
+// authorizeTenant checks whether the request is allowed to manage the tenant,
+// that's, by the admin key of the tenant or the global admin key.
+func authorizeTenant(c *Config, tenant string, w http.ResponseWriter, r *http.Request) bool {
+	scope := ScopeAdminTenant
+	if key := getAPIKey(r); c.hasScope(key, ScopeAdminConfig) {
+		scope = ScopeAdminConfig
+	} else if len(c.keys) != 0 && key != "" && c.keyTenants[key] != tenant {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("the api key is not the admin of the tenant " + tenant))
+		return false
+	}
+	return authorize(c, scope, w, r)
+}
+
+func handleTenants(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v1/tenants/"), "/", 3)
+	name := parts[0]
+	if !authorizeTenant(_config, name, w, r) {
+		return
+	}
+	tenant, ok := _config.Tenants[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("have no the tenant " + name))
+		return
+	}
+
+	var resource, id string
+	if len(parts) > 1 {
+		resource = parts[1]
+	}
+	if len(parts) > 2 {
+		id = parts[2]
+	}
+
+	var err error
+	switch {
+	case resource == "" && r.Method == "GET":
+		getTenant(w, _config, name, tenant)
+		return
+
+	case resource == "suppressions":
+		handleTenantSuppressions(w, r, name)
+		return
+
+	case resource == "providers" && id != "" && r.Method == "PUT":
+		channel, provider := splitProviderKey(id)
+		if !tenant.hasProvider(channel, provider) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("the provider " + id + " is not delegated to the tenant"))
+			return
+		}
+
+		var options map[string]string
+		if err = json.NewDecoder(r.Body).Decode(&options); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		err = updateTenant(name, func(tenant *Tenant) {
+			_options := make(map[string]map[string]string, len(tenant.Options)+1)
+			for k, v := range tenant.Options {
+				_options[k] = v
+			}
+			_options[id] = options
+			tenant.Options = _options
+		})
+
+	case resource == "templates" && id != "" && (r.Method == "PUT" || r.Method == "DELETE"):
+		var t Template
+		if r.Method == "PUT" {
+			if err = json.NewDecoder(r.Body).Decode(&t); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+		err = updateTenant(name, func(tenant *Tenant) {
+			templates := make(map[string]Template, len(tenant.Templates)+1)
+			for k, v := range tenant.Templates {
+				templates[k] = v
+			}
+			if r.Method == "PUT" {
+				templates[id] = t
+			} else {
+				delete(templates, id)
+			}
+			tenant.Templates = templates
+		})
+
+	case resource == "keys" && id == "" && (r.Method == "PUT" || r.Method == "DELETE"):
+		var key TenantKey
+		if err = json.NewDecoder(r.Body).Decode(&key); err != nil || key.Key == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid key"))
+			return
+		}
+		err = updateTenant(name, func(tenant *Tenant) {
+			keys := make(map[string][]string, len(tenant.Keys)+1)
+			for k, v := range tenant.Keys {
+				keys[k] = v
+			}
+			if r.Method == "PUT" {
+				keys[key.Key] = key.Scopes
+			} else {
+				delete(keys, key.Key)
+			}
+			tenant.Keys = keys
+		})
+
+	case resource == "" || resource == "providers" || resource == "templates" || resource == "keys":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err == errConfigWatched {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+	} else if err != nil {
+		writeConfigError(w, err)
+	} else {
+		glog.Infof("the tenant %s updates the %s %s", name, resource, id)
+	}
+}
+
+// updateTenant resets the configuration, the tenant of which is updated.
+func updateTenant(name string, update func(tenant *Tenant)) error {
+	return updateConfig(func(_conf map[string]interface{}) error {
+		tenants := make(map[string]Tenant)
+		if err := decodeJSON(_conf["tenants"], &tenants); err != nil {
+			return err
+		}
+
+		tenant, ok := tenants[name]
+		if !ok {
+			return fmt.Errorf("have no the tenant %s", name)
+		}
+		update(&tenant)
+		tenants[name] = tenant
+
+		m := make(map[string]interface{})
+		if err := decodeJSON(tenants, &m); err != nil {
+			return err
+		}
+		_conf["tenants"] = m
+		return nil
+	})
+}
+
+// getTenant writes the tenant with the options of the delegated providers
+// replaced by the tenant, the secret options of which are encrypted if the
+// cipher is set. The options of the global providers are not written.
+func getTenant(w http.ResponseWriter, c *Config, name string, tenant Tenant) {
+	_conf, err := exportConfig(c)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	providers := make(map[string]map[string]string, len(tenant.Providers))
+	for _, p := range tenant.Providers {
+		providers[p] = _conf.Tenants[name].Options[p]
+	}
+
+	content, err := json.Marshal(map[string]interface{}{
+		"name":       name,
+		"admin_keys": tenant.AdminKeys,
+		"providers":  providers,
+		"keys":       tenant.Keys,
+		"templates":  tenant.Templates,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// handleTenantSuppressions manages the suppressions added by the tenant.
+func handleTenantSuppressions(w http.ResponseWriter, r *http.Request, tenant string) {
+	store := getSuppressionStore()
+
+	var err error
+	switch r.Method {
+	case "GET":
+		var items []Suppression
+		if items, err = store.List(); err == nil {
+			results := make([]Suppression, 0, len(items))
+			for _, sp := range items {
+				if sp.Tenant == tenant {
+					results = append(results, sp)
+				}
+			}
+
+			var content []byte
+			if content, err = json.Marshal(results); err == nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(content)
+				return
+			}
+		}
+
+	case "POST":
+		buf := bytes.NewBuffer(nil)
+		if _, err = buf.ReadFrom(r.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var sp Suppression
+		if err = json.Unmarshal(buf.Bytes(), &sp); err != nil ||
+			(sp.Channel != "sms" && sp.Channel != "email") || sp.Recipient == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid suppression"))
+			return
+		}
+
+		sp.Recipient = normalizeRecipient(sp.Channel, sp.Recipient)
+		if old, ok, _ := store.Get(sp.Channel, sp.Recipient); ok && old.Tenant != tenant {
+			return // Keep the suppression added by the others.
+		}
+		sp.Tenant, sp.CreatedAt = tenant, time.Now()
+		err = store.Add(sp)
+
+	case "DELETE":
+		query := r.URL.Query()
+		channel := query.Get("channel")
+		recipient := normalizeRecipient(channel, query.Get("recipient"))
+
+		var sp Suppression
+		var ok bool
+		if sp, ok, err = store.Get(channel, recipient); err == nil && ok {
+			if sp.Tenant != tenant {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("the suppression is not added by the tenant"))
+				return
+			}
+			err = store.Remove(channel, recipient)
+		}
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	}
+}
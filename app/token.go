@@ -0,0 +1,288 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	defaultTokenTTL = 300
+	maxTokenTTL     = 86400
+)
+
+// ScopeMintToken is the scope of the API key to mint the send tokens.
+const ScopeMintToken = "mint:token"
+
+// TokenRequest is the arguments to mint a one-time send token.
+type TokenRequest struct {
+	// The channel which the token is used for, "sms" or "email".
+	Channel string `json:"channel"`
+
+	// The recipient which the message is sent to, that's, the phone for sms,
+	// or the to for email, which must be the same as the send request.
+	// It is checked by the allowlists of the API key minting the token.
+	Recipient string `json:"recipient"`
+
+	// The provider, the sender identity and the template of the send request,
+	// which must be the same as them. If empty, the send request must not
+	// give them, so the default provider and identity are used, and the
+	// message is not rendered by any template.
+	//
+	// The send request by the token must not give the other recipients,
+	// such as "cc", "bcc", the phone of the email or the to of the sms,
+	// nor the "fallback" steps and the "attachment_urls".
+	Provider string `json:"provider,omitempty"`
+	Identity string `json:"identity,omitempty"`
+	Template string `json:"template,omitempty"`
+
+	// If given, the subject and the content of the send request must be the
+	// same as them. Or, the client may send any content to the recipient.
+	Subject string `json:"subject,omitempty"`
+	Content string `json:"content,omitempty"`
+
+	// The number of the seconds during which the token is valid.
+	// The default is 300, and the maximum is 86400.
+	TTL int `json:"ttl,omitempty"`
+}
+
+type sendToken struct {
+	Channel   string `json:"c"`
+	Recipient string `json:"r"`
+	Provider  string `json:"p,omitempty"`
+	Identity  string `json:"i,omitempty"`
+	Template  string `json:"t,omitempty"`
+	Tenant    string `json:"a,omitempty"` // The tenant of the API key minting it.
+	Digest    string `json:"d,omitempty"`
+	Expire    int64  `json:"e"`
+	Nonce     string `json:"n"`
+}
+
+func contentDigest(subject, content string) string {
+	sum := sha256.Sum256([]byte(subject + "\x00" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+func signToken(secret string, payload []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// mintToken returns a signed one-time token authorizing the send.
+func mintToken(secret, tenant string, req TokenRequest) (token string, expire time.Time, err error) {
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+
+	expire = time.Now().Add(time.Duration(req.TTL) * time.Second)
+	t := sendToken{
+		Channel:   req.Channel,
+		Recipient: req.Recipient,
+		Provider:  req.Provider,
+		Identity:  req.Identity,
+		Template:  req.Template,
+		Tenant:    tenant,
+		Expire:    expire.Unix(),
+		Nonce:     hex.EncodeToString(nonce),
+	}
+	if req.Subject != "" || req.Content != "" {
+		t.Digest = contentDigest(req.Subject, req.Content)
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	token = payload + "." + signToken(secret, []byte(payload))
+	return
+}
+
+// parseToken verifies the signature and the expiration of the send token.
+func parseToken(secret, token string) (*sendToken, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("the send token is not supported")
+	}
+
+	index := strings.IndexByte(token, '.')
+	if index < 0 {
+		return nil, fmt.Errorf("the send token is invalid")
+	}
+	payload, sign := token[:index], token[index+1:]
+	if !hmac.Equal([]byte(sign), []byte(signToken(secret, []byte(payload)))) {
+		return nil, fmt.Errorf("the send token is invalid")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("the send token is invalid")
+	}
+	var t sendToken
+	if err = json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("the send token is invalid")
+	}
+
+	// Tolerate the clock skew, since the token may be minted by another instance.
+	if time.Now().After(t.expire()) {
+		return nil, fmt.Errorf("the send token has expired")
+	}
+	return &t, nil
+}
+
+func (t *sendToken) expire() time.Time {
+	return time.Unix(t.Expire, 0).Add(getClockSkew())
+}
+
+// bind checks the send request, before the defaults are applied, against
+// the token, which authorizes only the send to the recipient of the token
+// by its provider, identity and template.
+func (t *sendToken) bind(channel string, args *Request) error {
+	recipient, other := args.Phone, args.To
+	if channel == "email" {
+		recipient, other = args.To, args.Phone
+	}
+
+	switch {
+	case t.Channel != channel || t.Recipient != recipient:
+		return fmt.Errorf("the send token does not match the request")
+	case t.Provider != args.Provider || t.Identity != args.Identity ||
+		t.Template != args.Template:
+		return fmt.Errorf("the send token does not match the request")
+	case other != "" || args.Cc != "" || args.Bcc != "":
+		return fmt.Errorf("the send token does not allow the other recipients")
+	case args.Fallback != "":
+		return fmt.Errorf("the send token does not allow the fallback")
+	case len(args.AttachmentURLs) > 0:
+		return fmt.Errorf("the send token does not allow the attachment urls")
+	}
+	return nil
+}
+
+// consume checks the content of the send request against the token,
+// and consumes it.
+func (t *sendToken) consume(args *Request) error {
+	if t.Digest != "" && t.Digest != contentDigest(args.Subject, args.Content) {
+		return fmt.Errorf("the send token does not match the request")
+	}
+
+	if ok, err := useNonce(t.Nonce, t.expire()); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("the send token has been used")
+	}
+	return nil
+}
+
+// getSendToken returns the one-time send token of the request, which is
+// from the header "X-Send-Token" or the query argument "token".
+func getSendToken(r *http.Request) string {
+	if token := r.Header.Get("X-Send-Token"); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+func handleToken(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if _config.tokenSecret == "" {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	} else if !authorize(_config, ScopeMintToken, w, r) {
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		glog.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var req TokenRequest
+	if err := json.Unmarshal(buf.Bytes(), &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if req.Channel != "sms" && req.Channel != "email" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("the channel is not sms or email"))
+		return
+	} else if req.Recipient == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("the recipient is empty"))
+		return
+	}
+	if req.TTL <= 0 {
+		req.TTL = defaultTokenTTL
+	} else if req.TTL > maxTokenTTL {
+		req.TTL = maxTokenTTL
+	}
+
+	// Check the restrictions of the API key minting the token, since
+	// the send request by the token has no API key.
+	key := getAPIKey(r)
+	if req.Identity != "" {
+		if _, ok := _config.Identities[req.Identity]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("have no the identity[%s]", req.Identity)))
+			return
+		} else if !_config.allowIdentity(key, req.Identity) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(fmt.Sprintf("the api key is not allowed to use the identity[%s]",
+				req.Identity)))
+			return
+		}
+	}
+
+	args := &Request{Phone: req.Recipient, Identity: req.Identity}
+	if req.Channel == "email" {
+		args.Phone, args.To = "", req.Recipient
+		args.tos = strings.Split(req.Recipient, ",")
+	}
+	if err := args.checkAllowlists(_config, req.Channel, key); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	token, expire, err := mintToken(_config.tokenSecret, _config.keyTenants[key], req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	content, _ := json.Marshal(map[string]interface{}{
+		"token":  token,
+		"expire": expire.Unix(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setConfig sets the configuration for the test, and restores it after that.
+func setConfig(t *testing.T, c *Config) {
+	configLocker.Lock()
+	old := config
+	config = c
+	configLocker.Unlock()
+
+	t.Cleanup(func() {
+		configLocker.Lock()
+		config = old
+		configLocker.Unlock()
+	})
+}
+
+func mustMintToken(t *testing.T, secret string, req TokenRequest) string {
+	if req.TTL == 0 {
+		req.TTL = defaultTokenTTL
+	}
+
+	token, _, err := mintToken(secret, "tenant", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	setConfig(t, new(Config))
+
+	token := mustMintToken(t, "secret", TokenRequest{Channel: "email",
+		Recipient: "alice@example.com", Template: "otp", Subject: "s", Content: "c"})
+	st, err := parseToken("secret", token)
+	if err != nil {
+		t.Fatal(err)
+	} else if st.Tenant != "tenant" {
+		t.Errorf("expect the tenant 'tenant', but got '%s'", st.Tenant)
+	}
+
+	args := &Request{To: "alice@example.com", Template: "otp", Subject: "s", Content: "c"}
+	if err = st.bind("email", args); err != nil {
+		t.Fatal(err)
+	} else if err = st.consume(args); err != nil {
+		t.Fatal(err)
+	}
+
+	// The token is used only once.
+	if st, err = parseToken("secret", token); err != nil {
+		t.Fatal(err)
+	} else if err = st.consume(args); err == nil {
+		t.Error("expect the error of the used token, but got nil")
+	}
+}
+
+func TestTokenForgery(t *testing.T) {
+	setConfig(t, new(Config))
+
+	token := mustMintToken(t, "secret", TokenRequest{Channel: "sms", Recipient: "+8613800000000"})
+	if _, err := parseToken("other", token); err == nil {
+		t.Error("expect the error of the other secret, but got nil")
+	}
+
+	// Replace the recipient but keep the signature.
+	index := strings.IndexByte(token, '.')
+	data, _ := base64.RawURLEncoding.DecodeString(token[:index])
+	data = []byte(strings.Replace(string(data), "+8613800000000", "+8613900000000", 1))
+	forged := base64.RawURLEncoding.EncodeToString(data) + token[index:]
+	if _, err := parseToken("secret", forged); err == nil {
+		t.Error("expect the error of the forged token, but got nil")
+	}
+
+	for _, s := range []string{"", ".", "abc", token[:index]} {
+		if _, err := parseToken("secret", s); err == nil {
+			t.Errorf("expect the error of the token '%s', but got nil", s)
+		}
+	}
+	if _, err := parseToken("", token); err == nil {
+		t.Error("expect the error without the secret, but got nil")
+	}
+}
+
+func TestTokenExpired(t *testing.T) {
+	setConfig(t, new(Config))
+
+	token, _, err := mintToken("secret", "", TokenRequest{Channel: "sms",
+		Recipient: "+8613800000000", TTL: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if _, err = parseToken("secret", token); err == nil {
+		t.Error("expect the error of the expired token, but got nil")
+	}
+}
+
+func TestTokenBind(t *testing.T) {
+	setConfig(t, new(Config))
+
+	token := mustMintToken(t, "secret", TokenRequest{Channel: "email",
+		Recipient: "alice@example.com", Provider: "smtp", Template: "otp"})
+	st, err := parseToken("secret", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, args := range map[string]*Request{
+		"recipient": {To: "bob@example.com", Provider: "smtp", Template: "otp"},
+		"provider":  {To: "alice@example.com", Template: "otp"},
+		"template":  {To: "alice@example.com", Provider: "smtp", Template: "other"},
+		"identity":  {To: "alice@example.com", Provider: "smtp", Template: "otp", Identity: "x"},
+		"cc":        {To: "alice@example.com", Provider: "smtp", Template: "otp", Cc: "bob@example.com"},
+		"bcc":       {To: "alice@example.com", Provider: "smtp", Template: "otp", Bcc: "bob@example.com"},
+		"phone":     {To: "alice@example.com", Provider: "smtp", Template: "otp", Phone: "+8613800000000"},
+		"fallback":  {To: "alice@example.com", Provider: "smtp", Template: "otp", Fallback: "sms:aliyun"},
+		"urls": {To: "alice@example.com", Provider: "smtp", Template: "otp",
+			AttachmentURLs: map[string]string{"a.pdf": "http://127.0.0.1/a.pdf"}},
+	} {
+		if err := st.bind("email", args); err == nil {
+			t.Errorf("%s: expect the error, but got nil", name)
+		}
+	}
+
+	if err := st.bind("sms", &Request{Phone: "alice@example.com"}); err == nil {
+		t.Error("expect the error of the other channel, but got nil")
+	}
+	if err := st.bind("email", &Request{To: "alice@example.com", Provider: "smtp",
+		Template: "otp"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTokenDigest(t *testing.T) {
+	setConfig(t, new(Config))
+
+	token := mustMintToken(t, "secret", TokenRequest{Channel: "sms",
+		Recipient: "+8613800000000", Content: "code 1234"})
+	st, err := parseToken("secret", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = st.consume(&Request{Phone: "+8613800000000", Content: "code 5678"}); err == nil {
+		t.Error("expect the error of the other content, but got nil")
+	} else if err = st.consume(&Request{Phone: "+8613800000000", Content: "code 1234"}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The events of the messages tracked by the signed links.
+const (
+	trackOpen  = "open"
+	trackClick = "click"
+)
+
+// trackLinkTTL is the duration during which the signed tracking link is valid.
+const trackLinkTTL = 30 * 24 * time.Hour
+
+// trackPixel is the transparent 1x1 GIF returned by the open link.
+var trackPixel = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x00\x00" +
+	"!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+func trackSignature(secret, id, event, expires string) string {
+	return signToken(secret, []byte(id+"\n"+event+"\n"+expires))
+}
+
+// trackLink returns the signed link to track the event of the message,
+// "open" or "click", or "" if the public base url or the token secret
+// is not configured.
+func trackLink(c *Config, id, event string) string {
+	if c.MediaBaseURL == "" || c.tokenSecret == "" {
+		return ""
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(trackLinkTTL).Unix(), 10)
+	query := url.Values{
+		"expires": {expires},
+		"sig":     {trackSignature(c.tokenSecret, id, event, expires)},
+	}
+	return c.MediaBaseURL + "/v1/messages/" + id + "/" + event + "?" + query.Encode()
+}
+
+// verifyTrackLink reports whether the query of the tracking link is valid.
+func verifyTrackLink(c *Config, id, event string, query url.Values) bool {
+	if c.tokenSecret == "" {
+		return false
+	}
+
+	expires := query.Get("expires")
+	n, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().After(time.Unix(n, 0).Add(c.clockSkew())) {
+		return false
+	}
+
+	sig := trackSignature(c.tokenSecret, id, event, expires)
+	return hmac.Equal([]byte(sig), []byte(query.Get("sig")))
+}
+
+// handleTrack handles the signed links "/v1/messages/MESSAGE_ID/open", which
+// returns a transparent image, and "/v1/messages/MESSAGE_ID/click", which
+// redirects to the url of the variant. The first open and click of each
+// message are counted by the variant of its template, see VariantStats.
+func handleTrack(c *Config, w http.ResponseWriter, r *http.Request, id, event string) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !verifyTrackLink(c, id, event, r.URL.Query()) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("the link is invalid or expired"))
+		return
+	}
+
+	record, first, ok := messageHistory.track(id, event, time.Now())
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if first {
+		recordVariantEvent(record, event)
+	}
+
+	if event == trackOpen {
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(trackPixel)
+		return
+	}
+
+	if record.Variant != "" {
+		t, _ := c.lookupTemplate(record.Tenant, record.Template)
+		if v := t.variant(record.Variant, ""); v != nil && v.URL != "" {
+			http.Redirect(w, r, v.URL, http.StatusFound)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
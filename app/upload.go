@@ -0,0 +1,258 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xgfone/messageapi"
+)
+
+// AttachmentLimits is the limits of the attachments of the email uploaded
+// by multipart/form-data or fetched from "attachment_urls", which are
+// streamed into the memory up to MaxMemory and the temporary files beyond,
+// instead of being buffered twice.
+//
+// The sizes are in bytes.
+type AttachmentLimits struct {
+	// The maximum total size of the attachments of a request, 25MB by default.
+	// The body of the signed request, which is read into memory to verify
+	// the signature, is limited to it plus 1MB.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// The maximum bytes of the attachments of a request kept in memory,
+	// 1MB by default.
+	MaxMemory int64 `json:"max_memory,omitempty"`
+
+	// The hosts from which the attachments may be fetched, such as
+	// "files.example.com". If empty, "attachment_urls" is refused.
+	URLHosts []string `json:"url_hosts,omitempty"`
+
+	// The timeout in seconds to fetch an attachment, 30 by default.
+	URLTimeout int `json:"url_timeout,omitempty"`
+}
+
+func (l AttachmentLimits) allowURL(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, h := range l.URLHosts {
+		if strings.ToLower(h) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentSource is the content of the attachment, which is read again
+// by each retry of the request.
+type attachmentSource interface {
+	Reader() io.Reader
+}
+
+type stringAttachment string
+
+func (s stringAttachment) Reader() io.Reader { return strings.NewReader(string(s)) }
+
+type bytesAttachment []byte
+
+func (b bytesAttachment) Reader() io.Reader { return bytes.NewReader(b) }
+
+// attachmentBudget is the remaining sizes of the attachments of a request.
+type attachmentBudget struct {
+	maxSize int64
+	size    int64
+	memory  int64
+}
+
+func newAttachmentBudget(l AttachmentLimits) *attachmentBudget {
+	if l.MaxSize <= 0 {
+		l.MaxSize = 25 << 20
+	}
+	if l.MaxMemory <= 0 {
+		l.MaxMemory = 1 << 20
+	}
+	return &attachmentBudget{maxSize: l.MaxSize, size: l.MaxSize, memory: l.MaxMemory}
+}
+
+// spool streams the content of the attachment into a spool.
+func (b *attachmentBudget) spool(r io.Reader) (*messageapi.Spool, error) {
+	if b.size <= 0 {
+		return nil, fmt.Errorf("the attachments exceed %d bytes", b.maxSize)
+	}
+
+	memory := b.memory
+	if memory <= 0 {
+		memory = -1 // Spill all to the file.
+	}
+
+	// The spilled file has been removed, so it is gone when the spool is
+	// closed or collected, see Request.closeAttachments.
+	s := messageapi.NewSpool(memory, b.size)
+	if _, err := io.Copy(s, r); err != nil {
+		s.Close()
+		if err == messageapi.ErrTooLarge {
+			return nil, fmt.Errorf("the attachments exceed %d bytes", b.maxSize)
+		}
+		return nil, err
+	}
+
+	b.size -= s.Size()
+	if s.Size() <= memory {
+		b.memory -= s.Size()
+	}
+	return s, nil
+}
+
+// addAttachment adds the attachment into the request.
+func (r *Request) addAttachment(name string, a attachmentSource) error {
+	if _, ok := r.attachments[name]; ok {
+		return fmt.Errorf("the attachment[%s] is duplicated", name)
+	}
+	if r.attachments == nil {
+		r.attachments = make(map[string]attachmentSource)
+	}
+	r.attachments[name] = a
+	return nil
+}
+
+// openAttachments returns the new readers of the attachments,
+// which are sent to the email provider.
+func (r *Request) openAttachments() map[string]io.Reader {
+	if len(r.attachments) == 0 {
+		return nil
+	}
+
+	readers := make(map[string]io.Reader, len(r.attachments))
+	for name, a := range r.attachments {
+		readers[name] = a.Reader()
+	}
+	return readers
+}
+
+// closeAttachments closes the attachments of the request, such as removing
+// the spilled temporary files, after the request is sent or refused.
+func (r *Request) closeAttachments() {
+	for _, a := range r.attachments {
+		if c, ok := a.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+func isMultipartForm(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// parseMultipart parses the request of multipart/form-data, the field
+// "request" of which is the JSON arguments, and the files of which are
+// the attachments of the email, which are streamed part by part.
+func (r *Request) parseMultipart(c *Config, req *http.Request) error {
+	mr, err := req.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	if r.budget == nil {
+		r.budget = newAttachmentBudget(c.AttachmentLimits)
+	}
+
+	var found bool
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if name := part.FileName(); name != "" {
+			var s *messageapi.Spool
+			if s, err = r.budget.spool(part); err == nil {
+				err = r.addAttachment(name, s)
+			}
+		} else if part.FormName() == "request" {
+			found = true
+			err = json.NewDecoder(part).Decode(r)
+		}
+		part.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("the field request is missing")
+	}
+	return nil
+}
+
+// fetchAttachments fetches the attachments of "attachment_urls".
+func (r *Request) fetchAttachments(c *Config) error {
+	if len(r.AttachmentURLs) == 0 {
+		return nil
+	}
+
+	limits := c.AttachmentLimits
+	timeout := limits.URLTimeout
+	if timeout <= 0 {
+		timeout = 30
+	}
+	client, err := messageapi.NewHTTPClient(map[string]string{"timeout": strconv.Itoa(timeout)})
+	if err != nil {
+		return err
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		} else if !limits.allowURL(req.URL) {
+			return fmt.Errorf("the host %s is not allowed", req.URL.Host)
+		}
+		return nil
+	}
+
+	if r.budget == nil {
+		r.budget = newAttachmentBudget(limits)
+	}
+	for name, rawurl := range r.AttachmentURLs {
+		u, err := url.Parse(rawurl)
+		if err != nil || !limits.allowURL(u) {
+			return fmt.Errorf("the url of the attachment[%s] is not allowed", name)
+		}
+
+		if err = r.fetchAttachment(client, name, rawurl); err != nil {
+			return fmt.Errorf("failed to fetch the attachment[%s]: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Request) fetchAttachment(client *http.Client, name, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the status code is %d", resp.StatusCode)
+	} else if resp.ContentLength > r.budget.size {
+		return fmt.Errorf("the attachments exceed %d bytes", r.budget.maxSize)
+	}
+
+	s, err := r.budget.spool(resp.Body)
+	if err != nil {
+		return err
+	}
+	return r.addAttachment(name, s)
+}
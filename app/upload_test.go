@@ -0,0 +1,38 @@
+package app
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentBudget(t *testing.T) {
+	b := newAttachmentBudget(AttachmentLimits{MaxSize: 10, MaxMemory: 4})
+
+	var r Request
+	for _, s := range []string{"abc", "defghi"} {
+		spool, err := b.spool(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		} else if err = r.addAttachment(s, spool); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, reader := range r.openAttachments() {
+		if data, _ := ioutil.ReadAll(reader); string(data) != name {
+			t.Errorf("expect the attachment '%s', but got '%s'", name, data)
+		}
+	}
+
+	if _, err := b.spool(strings.NewReader("jk")); err == nil {
+		t.Error("expect the error of the too large attachments, but got nil")
+	}
+
+	r.closeAttachments()
+	for name, reader := range r.openAttachments() {
+		if data, _ := ioutil.ReadAll(reader); len(data) != 0 {
+			t.Errorf("expect the closed attachment %s, but got '%s'", name, data)
+		}
+	}
+}
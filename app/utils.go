@@ -1,5 +1,35 @@
 package app
 
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync"
+)
+
+// maxPooledBufferSize is the maximum capacity of the buffers put back into
+// the pool, so that a huge request doesn't pin the memory.
+const maxPooledBufferSize = 1 << 20
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// getBuffer returns a reset buffer from the pool to read the request body.
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer puts the buffer back into the pool.
+//
+// The buffer must not be used after that, including the bytes returned
+// by its Bytes method.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(buf)
+	}
+}
+
 func toStringMap(v map[string]interface{}) (map[string]string, bool) {
 	if len(v) == 0 {
 		return nil, true
@@ -15,3 +45,55 @@ func toStringMap(v map[string]interface{}) (map[string]string, bool) {
 	}
 	return vs, true
 }
+
+// toProviderOptions is the same as toStringMap, but the reserved option
+// "enabled" of the provider may be a bool.
+func toProviderOptions(v map[string]interface{}) (map[string]string, bool) {
+	if b, ok := v["enabled"].(bool); ok {
+		_v := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			_v[key] = value
+		}
+		_v["enabled"] = strconv.FormatBool(b)
+		v = _v
+	}
+	return toStringMap(v)
+}
+
+func toStringSlice(v interface{}) ([]string, bool) {
+	vs, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	ss := make([]string, len(vs))
+	for i, _v := range vs {
+		s, ok := _v.(string)
+		if !ok {
+			return nil, false
+		}
+		ss[i] = s
+	}
+	return ss, true
+}
+
+func toFloatMap(v map[string]interface{}) (map[string]float64, bool) {
+	vs := make(map[string]float64, len(v))
+	for _k, _v := range v {
+		f, ok := _v.(float64)
+		if !ok {
+			return nil, false
+		}
+		vs[_k] = f
+	}
+	return vs, true
+}
+
+// decodeJSON decodes the value parsed from JSON into out.
+func decodeJSON(v interface{}, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,234 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ConfigError is a problem of the configuration.
+type ConfigError struct {
+	// The path of the option, such as "emails.plain".
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ConfigErrors is all the problems of the configuration found at once.
+type ConfigErrors []ConfigError
+
+func (es ConfigErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		if e.Path == "" {
+			msgs[i] = e.Message
+		} else {
+			msgs[i] = e.Path + ": " + e.Message
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (es *ConfigErrors) add(path, format string, args ...interface{}) {
+	*es = append(*es, ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// providerNameRegexp is the valid name of the provider, which must not
+// contain ",", ":" or the spaces used by the chains, such as "sms:aliyun".
+var providerNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// configKeys returns the known top-level keys of the configuration,
+// including "key" to reset the configuration by the HTTP API.
+func configKeys() map[string]bool {
+	keys := map[string]bool{"key": true}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("json"); tag != "" && tag != "-" {
+			keys[strings.Split(tag, ",")[0]] = true
+		}
+	}
+	return keys
+}
+
+// validateConfig validates the raw configuration before parsing it, and
+// returns all the problems as ConfigErrors, such as the duplicate keys,
+// the unknown top-level keys, the invalid or conflicting provider names,
+// and the references to the unknown providers.
+func validateConfig(data []byte, _conf map[string]interface{}) error {
+	var errs ConfigErrors
+	findDuplicateKeys(&errs, json.NewDecoder(bytes.NewReader(data)), "")
+
+	known := configKeys()
+	for _, key := range sortedKeys(_conf) {
+		if !known[key] {
+			errs.add(key, "unknown option")
+		}
+	}
+
+	providers := make(map[string]map[string]bool, 4)
+	for _, channel := range []string{"emails", "smses", "mmses", "messengers"} {
+		m, _ := _conf[channel].(map[string]interface{})
+		names := make(map[string]bool, len(m))
+		lowers := make(map[string]string, len(m))
+		for _, name := range sortedKeys(m) {
+			path := channel + "." + name
+			names[name] = true
+			if !providerNameRegexp.MatchString(name) {
+				errs.add(path, "the name must be 1-64 letters, digits, '_', '-' or '.'")
+			} else if name == "all" {
+				errs.add(path, "the name is reserved")
+			}
+			if other, ok := lowers[strings.ToLower(name)]; ok {
+				errs.add(path, "the name conflicts with %s", other)
+			}
+			lowers[strings.ToLower(name)] = name
+		}
+		if _, ok := _conf[channel]; ok {
+			providers[channel] = names
+		}
+	}
+
+	checkRef := func(path, channel, chain string) {
+		names, ok := providers[channel]
+		if !ok || chain == "" || chain == "all" {
+			return
+		}
+		for _, name := range strings.Split(chain, ",") {
+			if name = strings.TrimSpace(name); !names[name] {
+				errs.add(path, "have no the provider[%s] in %s", name, channel)
+			}
+		}
+	}
+	if v, ok := _conf["default_email_provider"].(string); ok {
+		checkRef("default_email_provider", "emails", v)
+	}
+	if v, ok := _conf["default_sms_provider"].(string); ok {
+		checkRef("default_sms_provider", "smses", v)
+	}
+	routes, _ := _conf["sms_routes"].(map[string]interface{})
+	for _, code := range sortedKeys(routes) {
+		chain, _ := toStringSlice(routes[code])
+		checkRef("sms_routes."+code, "smses", strings.Join(chain, ","))
+	}
+
+	// Parse each option alone with the options which it depends on,
+	// so that all the invalid options are reported.
+	failed := make(map[string]bool)
+	for _, key := range []string{"partials", "identities", "templates"} {
+		if _, ok := _conf[key]; ok {
+			if _, err := parseConfig(pickOptions(_conf, append(configDeps[key], key)...)); err != nil {
+				errs.add(key, "%s", err)
+				failed[key] = true
+			}
+		}
+	}
+	for _, key := range sortedKeys(_conf) {
+		switch key {
+		case "partials", "identities", "templates":
+			continue
+		}
+
+		deps := configDeps[key]
+		skip := !known[key]
+		for _, dep := range deps {
+			skip = skip || failed[dep]
+		}
+		if skip {
+			continue
+		}
+
+		if _, err := parseConfig(pickOptions(_conf, append(deps, key)...)); err != nil {
+			errs.add(key, "%s", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// configDeps is the options which the option depends on when parsing.
+var configDeps = map[string][]string{
+	"templates":      {"partials"},
+	"digests":        {"partials", "templates"},
+	"integrations":   {"partials", "templates"},
+	"key_identities": {"identities"},
+}
+
+func pickOptions(_conf map[string]interface{}, keys ...string) map[string]interface{} {
+	options := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if v, ok := _conf[key]; ok {
+			options[key] = v
+		}
+	}
+	return options
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// findDuplicateKeys finds the duplicate keys of the JSON objects at any depth,
+// which are overridden by the last one silently by encoding/json.
+func findDuplicateKeys(errs *ConfigErrors, dec *json.Decoder, path string) {
+	token, err := dec.Token()
+	if err != nil {
+		return
+	}
+
+	switch token {
+	case json.Delim('{'):
+		seen := make(map[string]bool)
+		for dec.More() {
+			token, err := dec.Token()
+			if err != nil {
+				return
+			}
+
+			key, _ := token.(string)
+			_path := key
+			if path != "" {
+				_path = path + "." + key
+			}
+			if seen[key] {
+				errs.add(_path, "duplicate key")
+			}
+			seen[key] = true
+			findDuplicateKeys(errs, dec, _path)
+		}
+		dec.Token()
+
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			findDuplicateKeys(errs, dec, fmt.Sprintf("%s[%d]", path, i))
+		}
+		dec.Token()
+	}
+}
+
+// writeConfigError writes the error of applying the configuration,
+// which is the JSON {"errors": [...]} for ConfigErrors, or the text.
+func writeConfigError(w http.ResponseWriter, err error) {
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	content, _ := json.Marshal(map[string]interface{}{"errors": errs})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(content)
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xgfone/messageapi"
+)
+
+// Warmup is the IP warm-up schedule of an email provider, which limits the
+// number of the recipients per day, increasing week by week.
+type Warmup struct {
+	// The date when the warm-up starts, such as "2026-10-01".
+	Start string `json:"start"`
+
+	// The maximum number of the recipients per day in each week since the
+	// start. After the last week, the warm-up ends and there is no limit.
+	Daily []int `json:"daily"`
+}
+
+func (w Warmup) validate() error {
+	if _, err := time.ParseInLocation("2006-01-02", w.Start, time.Local); err != nil {
+		return fmt.Errorf("the start date is invalid: %s", err)
+	} else if len(w.Daily) == 0 {
+		return fmt.Errorf("have no the daily limits")
+	}
+	return nil
+}
+
+// limit returns the daily limit at the time, or -1 if no limit.
+func (w Warmup) limit(now time.Time) int {
+	start, err := time.ParseInLocation("2006-01-02", w.Start, time.Local)
+	if err != nil || now.Before(start) {
+		return -1
+	}
+
+	week := int(now.Sub(start) / (7 * 24 * time.Hour))
+	if week >= len(w.Daily) {
+		return -1
+	}
+	return w.Daily[week]
+}
+
+type warmupCounter struct {
+	day   string
+	count int
+}
+
+var (
+	warmupLocker   = new(sync.Mutex)
+	warmupCounters = make(map[string]*warmupCounter)
+)
+
+// reserveWarmup reserves the quota of n recipients of the email provider
+// for today. If it exceeds the warm-up limit, return a temporary error
+// so that the email overflows to the next provider in the chain.
+func reserveWarmup(name string, n int) error {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	w, ok := _config.Warmups[name]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	limit := w.limit(now)
+	if limit < 0 {
+		return nil
+	}
+
+	day := now.Format("2006-01-02")
+	warmupLocker.Lock()
+	defer warmupLocker.Unlock()
+
+	c, ok := warmupCounters[name]
+	if !ok || c.day != day {
+		c = &warmupCounter{day: day}
+		warmupCounters[name] = c
+	}
+	if c.count+n > limit {
+		return messageapi.NewError(messageapi.ClassTemporary, "",
+			fmt.Sprintf("the email provider %s reaches the warm-up limit %d of today", name, limit))
+	}
+	c.count += n
+	return nil
+}
+
+// releaseWarmup releases the quota reserved by reserveWarmup,
+// when failing to send the email.
+func releaseWarmup(name string, n int) {
+	day := time.Now().Format("2006-01-02")
+	warmupLocker.Lock()
+	if c, ok := warmupCounters[name]; ok && c.day == day {
+		if c.count -= n; c.count < 0 {
+			c.count = 0
+		}
+	}
+	warmupLocker.Unlock()
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var configWatched int32
+
+// WatchConfig loads the configuration from the file, such as a mounted
+// Kubernetes ConfigMap, then watches it and resets the configuration
+// when it changes.
+//
+// The format of the file is the same as the "/v1/config" API.
+//
+// If secretDir is not empty, it is the directory, such as a mounted Kubernetes
+// Secret, each file of which is an option of a provider and named as
+// "CHANNEL.PROVIDER.OPTION", the CHANNEL of which is "emails", "smses", "mmses"
+// or "messengers", and the content of the file is the option value, which
+// overrides the one in the configuration file.
+//
+// The files are checked every interval, which is 10s by default. Since the
+// update of the projected volume in Kubernetes is atomic, the change of the
+// content is detected, not the notifications of the file system.
+//
+// When watching the configuration, resetting it by the "/v1/config" API is
+// refused. So call it before Start, and pass the nil configuration to Start.
+func WatchConfig(configFile, secretDir string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	conf, sum, err := loadConfigFile(configFile, secretDir)
+	if err != nil {
+		return err
+	}
+	if err = ResetConfig(conf); err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&configWatched, 1)
+	go watchConfig(configFile, secretDir, interval, sum)
+	return nil
+}
+
+func isConfigWatched() bool {
+	return atomic.LoadInt32(&configWatched) == 1
+}
+
+func watchConfig(configFile, secretDir string, interval time.Duration, last []byte) {
+	for range time.Tick(interval) {
+		conf, sum, err := loadConfigFile(configFile, secretDir)
+		if err != nil {
+			glog.Errorf("failed to load the configuration from %s: %s", configFile, err)
+			continue
+		} else if bytes.Equal(sum, last) {
+			continue
+		}
+
+		if err = ResetConfig(conf); err != nil {
+			glog.Errorf("failed to reset the configuration from %s: %s", configFile, err)
+			continue
+		}
+		last = sum
+		glog.Infof("reload the configuration from %s", configFile)
+	}
+}
+
+func loadConfigFile(configFile, secretDir string) (conf *Config, sum []byte, err error) {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hash := sha256.New()
+	hash.Write(data)
+
+	_conf := make(map[string]interface{})
+	if err = json.Unmarshal(data, &_conf); err != nil {
+		return nil, nil, err
+	}
+	if _conf, err = applyEnvironment(_conf); err != nil {
+		return nil, nil, err
+	}
+	if err = validateConfig(data, _conf); err != nil {
+		return nil, nil, err
+	}
+	if conf, err = parseConfig(_conf); err != nil {
+		return nil, nil, err
+	}
+
+	if secretDir != "" {
+		if err = loadSecretDir(conf, secretDir, hash.Write); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return conf, hash.Sum(nil), nil
+}
+
+func loadSecretDir(conf *Config, dir string, write func([]byte) (int, error)) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		// Skip the hidden files, such as "..data" created by Kubernetes.
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		parts := strings.SplitN(name, ".", 3)
+		if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+			continue
+		}
+
+		var providers *map[string]map[string]string
+		switch parts[0] {
+		case "emails":
+			providers = &conf.Emails
+		case "smses":
+			providers = &conf.SMSes
+		case "mmses":
+			providers = &conf.MMSes
+		case "messengers":
+			providers = &conf.Messengers
+		default:
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err != nil {
+			return err
+		} else if info.IsDir() {
+			continue
+		}
+
+		value, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read the secret %s: %s", name, err)
+		}
+		write([]byte(name))
+		write(value)
+
+		if *providers == nil {
+			*providers = make(map[string]map[string]string)
+		}
+		if (*providers)[parts[1]] == nil {
+			(*providers)[parts[1]] = make(map[string]string)
+		}
+		(*providers)[parts[1]][parts[2]] = strings.TrimSpace(string(value))
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// WebhookOptions is the options of the outbound webhooks, such as the ones
+// to which the inbound emails and sms are forwarded.
+//
+// If Secret is not empty, each request carries the headers "X-Webhook-ID",
+// "X-Webhook-Key-ID", "X-Webhook-Timestamp" and "X-Webhook-Signature", the
+// last of which is the hex-encoded HMAC-SHA256 of "TIMESTAMP.BODY", so that
+// the receivers can verify it and drop the duplicate by the id.
+type WebhookOptions struct {
+	// The id of the signing key, by which the receivers choose the secret
+	// when the key is rotated.
+	KeyID  string `json:"key_id,omitempty"`
+	Secret string `json:"secret,omitempty"`
+
+	// The maximum number of the retries when failing to connect or the
+	// webhook returns 429 or 5xx, which is 3 by default. The backoff starts
+	// from 500ms and is doubled by each retry. A negative number disables it.
+	//
+	// Only the first attempt is made by the caller, such as the inbound
+	// handler, and the retries are made in background, which are lost
+	// if the process exits, see maxPendingWebhooks.
+	Retries int `json:"retries,omitempty"`
+}
+
+func (o WebhookOptions) retries() int {
+	if o.Retries == 0 {
+		return 3
+	} else if o.Retries < 0 {
+		return 0
+	}
+	return o.Retries
+}
+
+// WebhookDelivery is an attempt to post to the webhook.
+type WebhookDelivery struct {
+	// The id of the payload, which is the same for the retries.
+	ID      string `json:"id"`
+	URL     string `json:"url"`
+	Attempt int    `json:"attempt"`
+
+	StatusCode int     `json:"status_code,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	LatencyMs  float64 `json:"latency_ms"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// maxWebhookDeliveries is the number of the last deliveries kept per webhook.
+const maxWebhookDeliveries = 100
+
+var (
+	webhookLocker     = new(sync.Mutex)
+	webhookDeliveries = make(map[string][]WebhookDelivery)
+
+	// The number of the payloads given up after all the attempts.
+	webhookDeadLetters int64
+
+	// The number of the payloads waiting to be retried in background.
+	pendingWebhooks int64
+)
+
+// maxPendingWebhooks is the maximum number of the payloads waiting to be
+// retried, beyond which the new failed ones are given up at once.
+const maxPendingWebhooks = 1000
+
+func recordWebhookDelivery(d WebhookDelivery) {
+	webhookLocker.Lock()
+	defer webhookLocker.Unlock()
+
+	deliveries := append(webhookDeliveries[d.URL], d)
+	if len(deliveries) > maxWebhookDeliveries {
+		deliveries = deliveries[len(deliveries)-maxWebhookDeliveries:]
+	}
+	webhookDeliveries[d.URL] = deliveries
+}
+
+func getWebhookOptions() (WebhookOptions, string) {
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+	return _config.Webhooks, _config.webhookSecret
+}
+
+// signWebhook returns the hex-encoded HMAC-SHA256 of "TIMESTAMP.BODY".
+func signWebhook(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sendWebhook posts the data to the webhook once, and reports whether
+// it may be retried if failed.
+func sendWebhook(opts WebhookOptions, secret, id, url string, data []byte) (
+	status int, retry bool, err error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", id)
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Webhook-Key-ID", opts.KeyID)
+		req.Header.Set("X-Webhook-Timestamp", timestamp)
+		req.Header.Set("X-Webhook-Signature", signWebhook(secret, timestamp, data))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return resp.StatusCode, retry, fmt.Errorf("the webhook %s returned %s", url, resp.Status)
+	}
+	return resp.StatusCode, false, nil
+}
+
+// webhookPayload is the payload posted to the webhook, which is the same
+// for all the attempts.
+type webhookPayload struct {
+	opts   WebhookOptions
+	secret string
+	id     string
+	url    string
+	data   []byte
+}
+
+// send posts the payload once, records the attempt in the delivery log,
+// and reports whether it may be retried if failed.
+func (p *webhookPayload) send(attempt int) (retry bool, err error) {
+	start := time.Now()
+	status, retry, err := sendWebhook(p.opts, p.secret, p.id, p.url, p.data)
+
+	d := WebhookDelivery{ID: p.id, URL: p.url, Attempt: attempt + 1, StatusCode: status,
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond), CreatedAt: start}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	recordWebhookDelivery(d)
+	return retry && attempt < p.opts.retries(), err
+}
+
+// retry posts the payload again after the backoff of the attempt in
+// background, until it succeeds or all the retries fail.
+func (p *webhookPayload) retry(attempt int) {
+	time.AfterFunc((500*time.Millisecond)<<uint(attempt-1), func() {
+		if retry, err := p.send(attempt); err == nil {
+			atomic.AddInt64(&pendingWebhooks, -1)
+		} else if retry {
+			glog.Errorf("failed to post to the webhook %s, retry: %s", p.url, err)
+			p.retry(attempt + 1)
+		} else {
+			glog.Errorf("give up posting to the webhook %s: %s", p.url, err)
+			atomic.AddInt64(&pendingWebhooks, -1)
+			atomic.AddInt64(&webhookDeadLetters, 1)
+		}
+	})
+}
+
+// postWebhook posts the payload as JSON to the webhook url, which is signed
+// and retried by Config.Webhooks. Each attempt is recorded in the delivery
+// log, see "/v1/webhooks/deliveries".
+//
+// It returns nil if the first attempt fails but will be retried in background,
+// so the caller, such as the inbound handler, does not wait for the retries.
+func postWebhook(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	opts, secret := getWebhookOptions()
+	p := &webhookPayload{opts: opts, secret: secret, id: newMessageID(), url: url, data: data}
+	retry, err := p.send(0)
+	if err == nil {
+		return nil
+	} else if !retry || atomic.AddInt64(&pendingWebhooks, 1) > maxPendingWebhooks {
+		if retry {
+			atomic.AddInt64(&pendingWebhooks, -1)
+		}
+		atomic.AddInt64(&webhookDeadLetters, 1)
+		return err
+	}
+
+	glog.Errorf("failed to post to the webhook %s, retry in background: %s", url, err)
+	p.retry(1)
+	return nil
+}
+
+// postWebhooks posts the payload to all the webhooks, and returns
+// the last error if failing to post to any one.
+func postWebhooks(urls []string, payload interface{}) (err error) {
+	for _, url := range urls {
+		if e := postWebhook(url, payload); e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// handleWebhookDeliveries returns the last attempts to post to the webhooks
+// by "GET", which needs the scope "read:history". The query argument "url"
+// selects a webhook, and "failed=true" returns only the failed attempts.
+func handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			glog.Errorf("path %s from %s: %s", r.URL.Path, r.RemoteAddr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	configLocker.Lock()
+	_config := config
+	configLocker.Unlock()
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	} else if !authorize(_config, ScopeReadHistory, w, r) || rejectTenant(_config, w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	url, failed := query.Get("url"), query.Get("failed") == "true"
+
+	webhookLocker.Lock()
+	deliveries := make(map[string][]WebhookDelivery, len(webhookDeliveries))
+	for u, ds := range webhookDeliveries {
+		if url != "" && u != url {
+			continue
+		}
+		for _, d := range ds {
+			if !failed || d.Error != "" {
+				deliveries[u] = append(deliveries[u], d)
+			}
+		}
+	}
+	webhookLocker.Unlock()
+
+	content, err := json.Marshal(deliveries)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
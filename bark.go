@@ -0,0 +1,127 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterMessenger("bark", new(bark))
+}
+
+// bark is the messenger provider by Bark, the iOS push service, the
+// configuration options of which are as follows:
+//
+//	url:        the base URL of the server, which is "https://api.day.app"
+//	            by default.
+//	device_key: the default device key, which is used if the recipient is empty.
+//	group:      the group of the notifications, which is optional.
+//	timeout:    the timeout in seconds of the request, which is 30 by default.
+//	http_proxy: the proxy url of the requests, which is optional.
+//	socks5:     the SOCKS5 proxy, such as "host:1080", which is optional.
+//
+// The recipient is the device key.
+type bark struct {
+	sync.Mutex
+
+	url       string
+	deviceKey string
+	group     string
+	client    *http.Client
+}
+
+func (b *bark) Load(m map[string]string) error {
+	_url := strings.TrimSuffix(m["url"], "/")
+	if _url == "" {
+		_url = "https://api.day.app"
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.url = _url
+	b.deviceKey = m["device_key"]
+	b.group = m["group"]
+	b.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (b *bark) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "url", Type: OptionURL, Default: "https://api.day.app",
+			Description: "the base URL of the server"},
+		{Name: "device_key", Type: OptionString, Secret: true,
+			Description: "the default device key"},
+		{Name: "group", Type: OptionString,
+			Description: "the group of the notifications"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+// barkLevels maps the priority from -2 to 2 to the interruption level of Bark.
+var barkLevels = [...]string{"passive", "passive", "active", "timeSensitive", "critical"}
+
+func (b *bark) SendMessage(cxt context.Context, msg Message) error {
+	b.Lock()
+	_url, deviceKey, group, client := b.url, b.deviceKey, b.group, b.client
+	b.Unlock()
+
+	if msg.To != "" {
+		deviceKey = msg.To
+	}
+	if deviceKey == "" {
+		return NewError(ClassPermanent, "", "bark: no the device key")
+	}
+
+	priority := msg.Priority
+	if priority > 2 {
+		priority = 2
+	} else if priority < -2 {
+		priority = -2
+	}
+
+	payload := map[string]string{
+		"device_key": deviceKey,
+		"title":      msg.Title,
+		"body":       msg.Content,
+		"level":      barkLevels[priority+2],
+	}
+	if msg.URL != "" {
+		payload["url"] = msg.URL
+	}
+	if group != "" {
+		payload["group"] = group
+	}
+
+	status, body, respHeader, err := doJSON(cxt, client, "POST", _url+"/push", nil, payload)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(body, &result)
+
+	if status >= 300 || result.Code != 200 {
+		if status < 300 {
+			status = http.StatusBadRequest
+		}
+		return httpError(status, respHeader, "", fmt.Sprintf("bark: %d %s",
+			status, result.Message))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package messageapi
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// BulkSMS is implemented optionally by the SMS provider which can send the
+// same content to many phones by the native bulk API in one request.
+//
+// It returns the errors of the phones failed to send, or an error if the
+// whole request failed.
+type BulkSMS interface {
+	SendBulkSMS(cxt context.Context, phones []string, content string) (
+		failed map[string]error, err error)
+}
+
+// BulkEmail is implemented optionally by the email provider which can send
+// a separate email with the same subject and content to each recipient by
+// the native bulk API in one request, such as the batch endpoints of SES
+// or SendGrid.
+//
+// It returns the errors of the recipients failed to send, or an error
+// if the whole request failed.
+type BulkEmail interface {
+	SendBulkEmail(cxt context.Context, to []string, subject, content string,
+		attachments map[string]io.Reader) (failed map[string]error, err error)
+}
+
+// bulkErrors returns the errors of all the recipients if err is not nil,
+// or the failed ones.
+func bulkErrors(recipients []string, failed map[string]error, err error) map[string]error {
+	if err != nil {
+		failed = make(map[string]error, len(recipients))
+		for _, recipient := range recipients {
+			failed[recipient] = err
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return failed
+}
+
+// SendBulkSMS sends the same content to each phone by the native bulk API
+// if the provider implements BulkSMS, or by SendSMS one by one.
+//
+// It returns the errors of the phones failed to send, or nil if all are sent.
+func SendBulkSMS(cxt context.Context, sms SMS, phones []string, content string) map[string]error {
+	if bulk, ok := sms.(BulkSMS); ok {
+		failed, err := bulk.SendBulkSMS(cxt, phones, content)
+		return bulkErrors(phones, failed, err)
+	}
+
+	failed := make(map[string]error)
+	for _, phone := range phones {
+		if err := sms.SendSMS(cxt, phone, content); err != nil {
+			failed[phone] = err
+		}
+	}
+	return bulkErrors(phones, failed, nil)
+}
+
+// SendBulkEmail sends a separate email to each recipient by the native bulk
+// API if the provider implements BulkEmail, or by SendEmail one by one.
+//
+// The attachments are the same as Email.SendEmail, which are read only once.
+// It returns the errors of the recipients failed to send, or nil if all are sent.
+func SendBulkEmail(cxt context.Context, email Email, to []string, subject, content string,
+	attachments map[string]io.Reader) map[string]error {
+	files, err := readAttachments(attachments)
+	if err != nil {
+		return bulkErrors(to, nil, err)
+	}
+
+	newReaders := func() map[string]io.Reader {
+		if len(files) == 0 {
+			return nil
+		}
+		readers := make(map[string]io.Reader, len(files))
+		for name, data := range files {
+			readers[name] = bytes.NewReader(data)
+		}
+		return readers
+	}
+
+	if bulk, ok := email.(BulkEmail); ok {
+		failed, err := bulk.SendBulkEmail(cxt, to, subject, content, newReaders())
+		return bulkErrors(to, failed, err)
+	}
+
+	failed := make(map[string]error)
+	for _, recipient := range to {
+		err := email.SendEmail(cxt, []string{recipient}, subject, content, newReaders())
+		if err != nil {
+			failed[recipient] = err
+		}
+	}
+	return bulkErrors(to, failed, nil)
+}
@@ -0,0 +1,116 @@
+package messageapi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The methods of the iCalendar object.
+const (
+	MethodPublish = "PUBLISH"
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+// Event is a calendar event, which is used to build the iCalendar object
+// defined by RFC 5545.
+type Event struct {
+	// The unique id of the event, which is generated if empty. The updates
+	// and the cancellations of the event must use the same uid.
+	UID string `json:"uid,omitempty"`
+
+	// The revision of the event, which must be increased for each update.
+	Sequence int `json:"sequence,omitempty"`
+
+	Summary     string    `json:"summary"`
+	Description string    `json:"description,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+
+	// The email addresses of the organizer and the attendees.
+	Organizer string   `json:"organizer,omitempty"`
+	Attendees []string `json:"attendees,omitempty"`
+}
+
+// ICS returns the iCalendar object of the event with the method,
+// such as MethodPublish, MethodRequest or MethodCancel.
+func (e Event) ICS(method string) []byte {
+	if method == "" {
+		method = MethodPublish
+	}
+
+	uid := e.UID
+	if uid == "" {
+		var buf [16]byte
+		rand.Read(buf[:])
+		uid = hex.EncodeToString(buf[:]) + "@messageapi"
+	}
+
+	buf := bytes.NewBuffer(nil)
+	writeContentLine(buf, "BEGIN:VCALENDAR")
+	writeContentLine(buf, "VERSION:2.0")
+	writeContentLine(buf, "PRODID:-//xgfone//messageapi//EN")
+	writeContentLine(buf, "CALSCALE:GREGORIAN")
+	writeContentLine(buf, "METHOD:"+method)
+	writeContentLine(buf, "BEGIN:VEVENT")
+	writeContentLine(buf, "UID:"+escapeText(uid))
+	writeContentLine(buf, "SEQUENCE:"+strconv.Itoa(e.Sequence))
+	writeContentLine(buf, "DTSTAMP:"+formatICSTime(time.Now()))
+	writeContentLine(buf, "DTSTART:"+formatICSTime(e.Start))
+	writeContentLine(buf, "DTEND:"+formatICSTime(e.End))
+	writeContentLine(buf, "SUMMARY:"+escapeText(e.Summary))
+	if e.Description != "" {
+		writeContentLine(buf, "DESCRIPTION:"+escapeText(e.Description))
+	}
+	if e.Location != "" {
+		writeContentLine(buf, "LOCATION:"+escapeText(e.Location))
+	}
+	if e.Organizer != "" {
+		writeContentLine(buf, "ORGANIZER:mailto:"+e.Organizer)
+	}
+	for _, attendee := range e.Attendees {
+		writeContentLine(buf, "ATTENDEE;ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;"+
+			"RSVP=TRUE:mailto:"+attendee)
+	}
+	if method == MethodCancel {
+		writeContentLine(buf, "STATUS:CANCELLED")
+	} else {
+		writeContentLine(buf, "STATUS:CONFIRMED")
+	}
+	writeContentLine(buf, "END:VEVENT")
+	writeContentLine(buf, "END:VCALENDAR")
+	return buf.Bytes()
+}
+
+func formatICSTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`,
+	"\r\n", `\n`, "\n", `\n`)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// writeContentLine writes the content line, which is folded at 75 octets.
+func writeContentLine(buf *bytes.Buffer, line string) {
+	// The continuation lines start with a space, which is counted.
+	for max := 75; len(line) > max; max = 74 {
+		// Do not split the UTF-8 character.
+		n := max
+		for n > 0 && line[n]&0xC0 == 0x80 {
+			n--
+		}
+		buf.WriteString(line[:n])
+		buf.WriteString("\r\n ")
+		line = line[n:]
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
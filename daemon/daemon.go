@@ -0,0 +1,93 @@
+// Package daemon runs the program as a Unix daemon or a Windows service,
+// so that it can be deployed without an external process manager.
+//
+//	err := daemon.Run(daemon.Options{
+//	    Name:    "messageapi",
+//	    PIDFile: "/run/messageapi.pid",
+//	    Daemon:  true,
+//	}, func(stop <-chan struct{}) error {
+//	    errc := make(chan error, 1)
+//	    go func() { errc <- app.Start(nil, ":8080", "", "") }()
+//	    select {
+//	    case err := <-errc:
+//	        return err
+//	    case <-stop:
+//	        return app.Drain(30 * time.Second)
+//	    }
+//	})
+//
+// On Unix, SIGTERM and SIGINT stop the program, and SIGHUP calls Options.Reopen,
+// such as to reopen the log files rotated by logrotate.
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Options is the options to run the program.
+type Options struct {
+	// The name of the Windows service. It is required on Windows.
+	Name string
+
+	// The description of the Windows service shown in the service manager.
+	Description string
+
+	// If not empty, the pid of the process is written into the file,
+	// which is removed when the program exits. If the process of the pid
+	// in the existing file is still running, Run fails.
+	PIDFile string
+
+	// If true, on Unix, run the program in the background, that's, a new
+	// session detached from the terminal, and the foreground process exits
+	// at once. It's ignored on Windows, where the service manager does it.
+	Daemon bool
+
+	// The file to which the stdout and the stderr of the daemon are appended.
+	// If empty, they are discarded. Use "copytruncate" of logrotate for it,
+	// since the file is not reopened.
+	LogFile string
+
+	// If not nil, it's called on SIGHUP on Unix, or the command
+	// "paramchange" of the service on Windows, such as to reopen
+	// the log files after rotated.
+	Reopen func()
+}
+
+// RunFunc is the function of the program run by Run, which should return
+// after the channel stop is closed, that's, the program is asked to stop.
+type RunFunc func(stop <-chan struct{}) error
+
+// writePIDFile writes the pid of the current process into the file,
+// and fails if the process of the pid in the existing file is running.
+func writePIDFile(path string) error {
+	if data, err := ioutil.ReadFile(path); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && pid != os.Getpid() && processExists(pid) {
+			return fmt.Errorf("the process %d in the pid file %s is running", pid, path)
+		}
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePIDFile removes the pid file if it is still of the current process.
+func removePIDFile(path string) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil && strings.TrimSpace(string(data)) == strconv.Itoa(os.Getpid()) {
+		os.Remove(path)
+	}
+}
+
+// runWithPIDFile runs the function with the pid file if given.
+func runWithPIDFile(o Options, run func() error) error {
+	if o.PIDFile != "" {
+		if err := writePIDFile(o.PIDFile); err != nil {
+			return err
+		}
+		defer removePIDFile(o.PIDFile)
+	}
+	return run()
+}
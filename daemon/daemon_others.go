@@ -0,0 +1,99 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// envDaemon is the environment variable which marks the background process.
+const envDaemon = "MESSAGEAPI_DAEMON"
+
+// Run runs the program until it returns or it's stopped by SIGTERM or SIGINT.
+//
+// If Options.Daemon is true, the program is started again in the background
+// with the same arguments, and Run returns nil at once in the foreground
+// process, which should exit then.
+func Run(o Options, run RunFunc) error {
+	if o.Daemon && os.Getenv(envDaemon) == "" {
+		return startDaemon(o)
+	}
+
+	return runWithPIDFile(o, func() error {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+		defer signal.Stop(signals)
+
+		stop := make(chan struct{})
+		errc := make(chan error, 1)
+		go func() { errc <- run(stop) }()
+
+		for {
+			select {
+			case err := <-errc:
+				return err
+			case sig := <-signals:
+				if sig != syscall.SIGHUP {
+					close(stop)
+					return <-errc
+				} else if o.Reopen != nil {
+					o.Reopen()
+				}
+			}
+		}
+	})
+}
+
+// startDaemon starts the current program in the background
+// in a new session detached from the terminal.
+func startDaemon(o Options) error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer null.Close()
+
+	output := null
+	if o.LogFile != "" {
+		if output, err = os.OpenFile(o.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+		defer output.Close()
+	}
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), envDaemon+"=1")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = null, output, output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start the daemon: %s", err)
+	}
+	return cmd.Process.Release()
+}
+
+// processExists reports whether the process of the pid is running.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Install is only supported on Windows, and use the init system,
+// such as systemd, on Unix.
+func Install(o Options, args ...string) error {
+	return fmt.Errorf("the service is only supported on Windows")
+}
+
+// Uninstall is only supported on Windows.
+func Uninstall(name string) error {
+	return fmt.Errorf("the service is only supported on Windows")
+}
@@ -0,0 +1,167 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Run runs the program as the Windows service named Options.Name if started
+// by the service manager, or in the foreground until it returns or it's
+// stopped by Ctrl+C.
+func Run(o Options, run RunFunc) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+
+	return runWithPIDFile(o, func() error {
+		if !isService {
+			return runInteractive(run)
+		}
+
+		if o.Name == "" {
+			return fmt.Errorf("the name of the service is empty")
+		}
+		h := &handler{options: o, run: run}
+		if err := svc.Run(o.Name, h); err != nil {
+			return err
+		}
+		return h.err
+	})
+}
+
+func runInteractive(run RunFunc) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	stop := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() { errc <- run(stop) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-signals:
+		close(stop)
+		return <-errc
+	}
+}
+
+// handler is the handler of the Windows service.
+type handler struct {
+	options Options
+	run     RunFunc
+	err     error
+}
+
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest,
+	changes chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	changes <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() { errc <- h.run(stop) }()
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+
+	for {
+		select {
+		case h.err = <-errc:
+			changes <- svc.Status{State: svc.StopPending}
+			if h.err != nil {
+				return true, 1
+			}
+			return false, 0
+
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.ParamChange:
+				if h.options.Reopen != nil {
+					h.options.Reopen()
+				}
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				close(stop)
+				h.err = <-errc
+				return false, 0
+			}
+		}
+	}
+}
+
+// processExists reports whether the process of the pid is running.
+func processExists(pid int) bool {
+	const stillActive = 259
+
+	p, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(p)
+
+	var code uint32
+	return windows.GetExitCodeProcess(p, &code) == nil && code == stillActive
+}
+
+// Install installs the current program as the Windows service named
+// Options.Name, which starts automatically with the arguments.
+func Install(o Options, args ...string) error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(o.Name); err == nil {
+		s.Close()
+		return fmt.Errorf("the service %s has existed", o.Name)
+	}
+
+	s, err := m.CreateService(o.Name, path, mgr.Config{
+		DisplayName: o.Name,
+		Description: o.Description,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// Restart the service if it fails.
+	return s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+	}, 86400)
+}
+
+// Uninstall stops and removes the Windows service.
+func Uninstall(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("the service %s is not installed", name)
+	}
+	defer s.Close()
+
+	s.Control(svc.Stop)
+	return s.Delete()
+}
@@ -0,0 +1,294 @@
+package messageapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// The IP preferences of DialOptions.
+const (
+	PreferIPv4 = "ipv4"
+	PreferIPv6 = "ipv6"
+	OnlyIPv4   = "ipv4_only"
+	OnlyIPv6   = "ipv6_only"
+)
+
+// DialOptions is the options to dial the servers of the vendors, which are
+// useful in the dual-stack data centers where the default resolution picks
+// the broken paths.
+type DialOptions struct {
+	// The IP family tried first, "ipv4" or "ipv6", or the only one allowed,
+	// "ipv4_only" or "ipv6_only". If empty, follow the order of the resolver.
+	IPPreference string `json:"ip_preference,omitempty"`
+
+	// The name of the network interface to bind, such as "eth1", the address
+	// of which of the same family is used as the source address.
+	BindInterface string `json:"bind_interface,omitempty"`
+
+	// The DNS server to resolve the hosts, such as "10.0.0.2:53",
+	// instead of the system resolver.
+	DNSServer string `json:"dns_server,omitempty"`
+
+	// The milliseconds to wait before trying the other IP family in parallel,
+	// that's, Happy Eyeballs. The default is 300, and a negative number
+	// disables it, which tries all the addresses one by one.
+	HappyEyeballsDelay int `json:"happy_eyeballs_delay,omitempty"`
+}
+
+// dialConfigOptions is the common options of the dialing of the providers.
+var dialConfigOptions = []ConfigOption{
+	{Name: "ip_preference", Type: OptionString,
+		Description: "the IP family tried first, ipv4 or ipv6, or only allowed, ipv4_only or ipv6_only"},
+	{Name: "bind_interface", Type: OptionString,
+		Description: "the name of the network interface to bind as the source address"},
+	{Name: "dns_server", Type: OptionString,
+		Description: "the DNS server to resolve the hosts, such as 10.0.0.2:53"},
+	{Name: "happy_eyeballs_delay", Type: OptionInt, Default: "300",
+		Description: "the milliseconds before trying the other IP family, or negative to disable"},
+}
+
+// parseDialOptions parses the dial options of the provider.
+func parseDialOptions(m map[string]string) (o DialOptions, err error) {
+	o.IPPreference = m["ip_preference"]
+	o.BindInterface = m["bind_interface"]
+	o.DNSServer = m["dns_server"]
+	if v := m["happy_eyeballs_delay"]; v != "" {
+		if o.HappyEyeballsDelay, err = strconv.Atoi(v); err != nil {
+			return o, fmt.Errorf("the happy_eyeballs_delay is not an integer")
+		}
+	}
+	return o, o.validate()
+}
+
+func (o DialOptions) validate() error {
+	switch o.IPPreference {
+	case "", PreferIPv4, PreferIPv6, OnlyIPv4, OnlyIPv6:
+	default:
+		return fmt.Errorf("the ip_preference '%s' is invalid", o.IPPreference)
+	}
+
+	if o.BindInterface != "" {
+		if _, err := net.InterfaceByName(o.BindInterface); err != nil {
+			return fmt.Errorf("the bind_interface '%s' is invalid: %s", o.BindInterface, err)
+		}
+	}
+
+	if o.DNSServer != "" {
+		if _, _, err := net.SplitHostPort(o.DNSServer); err != nil {
+			return fmt.Errorf("the dns_server '%s' is invalid: %s", o.DNSServer, err)
+		}
+	}
+	return nil
+}
+
+func (o DialOptions) isZero() bool { return o == DialOptions{} }
+
+// dialer dials the servers by DialOptions.
+type dialer struct {
+	net.Dialer
+	options DialOptions
+}
+
+func newDialer(d net.Dialer, o DialOptions) *dialer {
+	return &dialer{Dialer: d, options: o}
+}
+
+func (d *dialer) resolver() *net.Resolver {
+	if d.options.DNSServer == "" {
+		return net.DefaultResolver
+	}
+
+	server := d.options.DNSServer
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(cxt context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(cxt, network, server)
+		},
+	}
+}
+
+// localAddr returns the source address of the interface for the IP family,
+// or nil if no interface is bound.
+func (d *dialer) localAddr(ip net.IP) (net.Addr, error) {
+	if d.options.BindInterface == "" {
+		return d.LocalAddr, nil
+	}
+
+	iface, err := net.InterfaceByName(d.options.BindInterface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ipv4 := ip.To4() != nil
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && (ipnet.IP.To4() != nil) == ipv4 &&
+			!ipnet.IP.IsLinkLocalUnicast() {
+			return &net.TCPAddr{IP: ipnet.IP}, nil
+		}
+	}
+	return nil, fmt.Errorf("the interface %s has no address for %s", iface.Name, ip)
+}
+
+// addresses resolves the host, and returns the primary and the fallback
+// addresses by the IP preference.
+func (d *dialer) addresses(cxt context.Context, host string) (primaries, fallbacks []net.IP, err error) {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := lookupIPAddr(cxt, d.resolver(), d.options.DNSServer, host)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	var ipv4s, ipv6s []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ipv4s = append(ipv4s, ip)
+		} else {
+			ipv6s = append(ipv6s, ip)
+		}
+	}
+
+	switch d.options.IPPreference {
+	case PreferIPv4:
+		primaries, fallbacks = ipv4s, ipv6s
+	case PreferIPv6:
+		primaries, fallbacks = ipv6s, ipv4s
+	case OnlyIPv4:
+		primaries = ipv4s
+	case OnlyIPv6:
+		primaries = ipv6s
+	default:
+		if len(ips) > 0 && ips[0].To4() == nil {
+			primaries, fallbacks = ipv6s, ipv4s
+		} else {
+			primaries, fallbacks = ipv4s, ipv6s
+		}
+	}
+
+	if len(primaries) == 0 {
+		primaries, fallbacks = fallbacks, nil
+	}
+	if len(primaries) == 0 {
+		return nil, nil, fmt.Errorf("no the addresses of %s for %s", host, d.options.IPPreference)
+	}
+	return
+}
+
+// DialContext connects to the address by the dial options. If both the IP
+// families are available, the fallback family is tried in parallel after
+// the delay of Happy Eyeballs, RFC 8305.
+//
+// The host is resolved by the cache of the DNS lookups, see SetDNSCache.
+func (d *dialer) DialContext(cxt context.Context, network, addr string) (net.Conn, error) {
+	if d.options.isZero() && !dnscache.enabled() {
+		return d.Dialer.DialContext(cxt, network, addr)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		cxt, cancel = context.WithTimeout(cxt, d.Timeout)
+		defer cancel()
+	}
+
+	primaries, fallbacks, err := d.addresses(cxt, host)
+	if err != nil {
+		return nil, err
+	}
+
+	delay := time.Duration(d.options.HappyEyeballsDelay) * time.Millisecond
+	if d.options.HappyEyeballsDelay == 0 {
+		delay = 300 * time.Millisecond
+	}
+	if len(fallbacks) == 0 || delay < 0 {
+		return d.dialSerial(cxt, network, port, append(primaries, fallbacks...))
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+
+	cxt, cancel := context.WithCancel(cxt)
+	defer cancel()
+
+	results := make(chan result, 2)
+	primaryFailed := make(chan struct{})
+	go func() {
+		conn, err := d.dialSerial(cxt, network, port, primaries)
+		if err != nil {
+			close(primaryFailed)
+		}
+		results <- result{conn: conn, err: err}
+	}()
+	go func() {
+		// Start at once if the primary addresses have failed.
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-primaryFailed:
+		case <-cxt.Done():
+			results <- result{err: cxt.Err()}
+			return
+		}
+
+		conn, err := d.dialSerial(cxt, network, port, fallbacks)
+		results <- result{conn: conn, err: err}
+	}()
+
+	var first error
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err == nil {
+			cancel()
+			if i == 0 {
+				// Close the connection of the loser if it succeeds too.
+				go func() {
+					if r := <-results; r.conn != nil {
+						r.conn.Close()
+					}
+				}()
+			}
+			return r.conn, nil
+		} else if first == nil {
+			first = r.err
+		}
+	}
+	return nil, first
+}
+
+// dialSerial tries to connect to the addresses one by one.
+func (d *dialer) dialSerial(cxt context.Context, network, port string, ips []net.IP) (
+	conn net.Conn, err error) {
+	for _, ip := range ips {
+		dialer := d.Dialer
+		dialer.Timeout = 0 // The timeout is in the context.
+		if dialer.LocalAddr, err = d.localAddr(ip); err != nil {
+			continue
+		}
+		if conn, err = dialer.DialContext(cxt, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return
+		} else if cxt.Err() != nil {
+			return nil, err
+		}
+	}
+	return
+}
@@ -0,0 +1,154 @@
+package messageapi
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DNSCacheOptions is the options of the cache of the DNS lookups of the hosts
+// and the MX records, so that a flaky DNS server doesn't add the latency of
+// the lookup to every message.
+//
+// The durations are in seconds.
+type DNSCacheOptions struct {
+	// The time to live of the results, which is 60 by default.
+	// A negative number disables the cache.
+	TTL int `json:"ttl,omitempty"`
+
+	// The failed lookup of a host is not retried until the backoff, which
+	// starts from 1s and is doubled by each failure until MaxBackoff, which
+	// is 60 by default. Meanwhile, the last successful result is used if any.
+	MaxBackoff int `json:"max_backoff,omitempty"`
+}
+
+type dnsEntry struct {
+	value    interface{}
+	err      error
+	expires  time.Time
+	retryAt  time.Time
+	failures int
+}
+
+type dnsCache struct {
+	sync.Mutex
+	ttl        time.Duration
+	maxBackoff time.Duration
+	entries    map[string]*dnsEntry
+}
+
+var dnscache = &dnsCache{
+	ttl:        time.Minute,
+	maxBackoff: time.Minute,
+	entries:    make(map[string]*dnsEntry),
+}
+
+// SetDNSCache resets the cache of the DNS lookups.
+func SetDNSCache(o DNSCacheOptions) {
+	ttl, maxBackoff := time.Minute, time.Minute
+	if o.TTL < 0 {
+		ttl = 0
+	} else if o.TTL > 0 {
+		ttl = time.Duration(o.TTL) * time.Second
+	}
+	if o.MaxBackoff > 0 {
+		maxBackoff = time.Duration(o.MaxBackoff) * time.Second
+	}
+
+	dnscache.Lock()
+	dnscache.ttl = ttl
+	dnscache.maxBackoff = maxBackoff
+	dnscache.entries = make(map[string]*dnsEntry)
+	dnscache.Unlock()
+}
+
+func (c *dnsCache) enabled() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.ttl > 0
+}
+
+// lookup returns the cached result by the key, or calls the lookup function
+// and caches the result.
+func (c *dnsCache) lookup(key string, lookup func() (interface{}, error)) (interface{}, error) {
+	now := time.Now()
+	c.Lock()
+	ttl, maxBackoff := c.ttl, c.maxBackoff
+	e := c.entries[key]
+	if e != nil {
+		if e.err == nil && now.Before(e.expires) {
+			c.Unlock()
+			return e.value, nil
+		} else if e.err != nil && now.Before(e.retryAt) {
+			c.Unlock()
+			if e.value != nil {
+				return e.value, nil
+			}
+			return nil, e.err
+		}
+	}
+	c.Unlock()
+
+	if ttl <= 0 {
+		return lookup()
+	}
+
+	value, err := lookup()
+
+	c.Lock()
+	defer c.Unlock()
+	if e = c.entries[key]; e == nil {
+		e = new(dnsEntry)
+		c.entries[key] = e
+	}
+
+	if err == nil {
+		e.value, e.err, e.failures = value, nil, 0
+		e.expires = now.Add(ttl)
+		return value, nil
+	}
+
+	// The host does not exist, which is cached as the result.
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		e.value, e.err, e.failures = nil, err, 0
+		e.retryAt = now.Add(ttl)
+		return nil, err
+	}
+
+	backoff := time.Second << uint(e.failures)
+	if e.failures > 16 || backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	e.err = err
+	e.failures++
+	e.retryAt = now.Add(backoff)
+	if e.value != nil {
+		return e.value, nil // Use the stale result.
+	}
+	return nil, err
+}
+
+// lookupIPAddr looks up the IP addresses of the host by the resolver
+// with the cache.
+func lookupIPAddr(cxt context.Context, resolver *net.Resolver, server, host string) (
+	[]net.IPAddr, error) {
+	v, err := dnscache.lookup("ip:"+server+":"+host, func() (interface{}, error) {
+		return resolver.LookupIPAddr(cxt, host)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]net.IPAddr), nil
+}
+
+// lookupMX looks up the MX records of the domain with the cache.
+func lookupMX(domain string) ([]*net.MX, error) {
+	v, err := dnscache.lookup("mx:"+domain, func() (interface{}, error) {
+		return net.LookupMX(domain)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*net.MX), nil
+}
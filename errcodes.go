@@ -0,0 +1,195 @@
+package messageapi
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// The unified reasons of the errors, which are the same across the vendors,
+// so that the operators don't have to memorize the codes of each vendor.
+const (
+	ReasonInvalidRecipient  = "invalid_recipient"
+	ReasonUnsubscribed      = "unsubscribed"
+	ReasonBlocked           = "blocked"
+	ReasonRateLimited       = "rate_limited"
+	ReasonAuthFailed        = "auth_failed"
+	ReasonInsufficientFunds = "insufficient_funds"
+	ReasonMisconfigured     = "misconfigured"
+	ReasonMailboxFull       = "mailbox_full"
+	ReasonTooLarge          = "too_large"
+	ReasonUnavailable       = "unavailable"
+)
+
+// ErrorCode is the translation of an error code of a vendor.
+type ErrorCode struct {
+	Class       ErrorClass
+	Reason      string
+	Description string
+}
+
+var (
+	errorCodesLocker = new(sync.RWMutex)
+	errorCodes       = map[string]map[string]ErrorCode{
+		"twilio": {
+			"20003": {ClassPermanent, ReasonAuthFailed, "the credentials are invalid"},
+			"20429": {ClassTemporary, ReasonRateLimited, "too many requests"},
+			"21211": {ClassPermanent, ReasonInvalidRecipient, "the phone number is invalid"},
+			"21214": {ClassPermanent, ReasonInvalidRecipient, "the phone number cannot be reached"},
+			"21408": {ClassPermanent, ReasonMisconfigured, "the region of the phone is not enabled"},
+			"21606": {ClassPermanent, ReasonMisconfigured, "the from number cannot send the sms"},
+			"21608": {ClassPermanent, ReasonMisconfigured, "the trial account can only send to the verified numbers"},
+			"21610": {ClassPermanent, ReasonUnsubscribed, "the recipient has replied STOP"},
+			"21612": {ClassPermanent, ReasonInvalidRecipient, "the phone number cannot receive the sms"},
+			"21614": {ClassPermanent, ReasonInvalidRecipient, "the phone number is not a mobile number"},
+			"21617": {ClassPermanent, ReasonTooLarge, "the content is too long"},
+			"30001": {ClassTemporary, ReasonRateLimited, "the queue of the sender overflows"},
+			"30003": {ClassTemporary, ReasonInvalidRecipient, "the handset is unreachable"},
+			"30004": {ClassPermanent, ReasonBlocked, "the message is blocked"},
+			"30005": {ClassPermanent, ReasonInvalidRecipient, "the handset is unknown"},
+			"30006": {ClassPermanent, ReasonInvalidRecipient, "the phone is a landline or the carrier is unreachable"},
+			"30007": {ClassPermanent, ReasonBlocked, "the message is filtered by the carrier"},
+		},
+		"sns": {
+			"Throttling":            {ClassTemporary, ReasonRateLimited, "too many requests"},
+			"KMSThrottling":         {ClassTemporary, ReasonRateLimited, "too many requests to KMS"},
+			"InternalError":         {ClassTemporary, ReasonUnavailable, "the internal error of the vendor"},
+			"InvalidParameter":      {ClassPermanent, ReasonInvalidRecipient, "the parameter, such as the phone number, is invalid"},
+			"InvalidParameterValue": {ClassPermanent, ReasonInvalidRecipient, "the parameter, such as the phone number, is invalid"},
+			"AuthorizationError":    {ClassPermanent, ReasonAuthFailed, "the permission is denied"},
+			"InvalidClientTokenId":  {ClassPermanent, ReasonAuthFailed, "the access key id is invalid"},
+			"SignatureDoesNotMatch": {ClassPermanent, ReasonAuthFailed, "the secret access key is wrong"},
+			"ExpiredToken":          {ClassPermanent, ReasonAuthFailed, "the session token is expired"},
+			"OptInRequired":         {ClassPermanent, ReasonMisconfigured, "the account is not subscribed to SNS"},
+			"KMSAccessDenied":       {ClassPermanent, ReasonMisconfigured, "the access to the KMS key is denied"},
+			"EndpointDisabled":      {ClassPermanent, ReasonInvalidRecipient, "the endpoint is disabled"},
+		},
+		"vonage": {
+			// The error codes of the Messages API.
+			"1000": {ClassTemporary, ReasonRateLimited, "too many requests"},
+			"1010": {ClassPermanent, ReasonMisconfigured, "the parameters are missing"},
+			"1020": {ClassPermanent, ReasonMisconfigured, "the parameters are invalid"},
+			"1030": {ClassTemporary, ReasonUnavailable, "the internal error of the vendor"},
+			"1050": {ClassPermanent, ReasonBlocked, "the phone number is barred"},
+			"1060": {ClassPermanent, ReasonAuthFailed, "the account is barred"},
+			"1070": {ClassPermanent, ReasonInsufficientFunds, "the balance of the account is not enough"},
+			"1120": {ClassPermanent, ReasonMisconfigured, "the sender address is rejected"},
+			"1160": {ClassPermanent, ReasonMisconfigured, "the trial account can only send to the whitelisted numbers"},
+			"1170": {ClassPermanent, ReasonInvalidRecipient, "the phone number is invalid or missing"},
+			"1240": {ClassPermanent, ReasonInvalidRecipient, "the phone number is illegal"},
+		},
+		"aliyun": {
+			"isv.BUSINESS_LIMIT_CONTROL":      {ClassTemporary, ReasonRateLimited, "the phone exceeds the sending frequency"},
+			"isv.DAY_LIMIT_CONTROL":           {ClassTemporary, ReasonRateLimited, "the daily limit is exceeded"},
+			"Throttling.User":                 {ClassTemporary, ReasonRateLimited, "too many requests"},
+			"isv.MOBILE_NUMBER_ILLEGAL":       {ClassPermanent, ReasonInvalidRecipient, "the phone number is invalid"},
+			"isv.MOBILE_COUNT_OVER_LIMIT":     {ClassPermanent, ReasonInvalidRecipient, "too many phone numbers"},
+			"isv.AMOUNT_NOT_ENOUGH":           {ClassPermanent, ReasonInsufficientFunds, "the balance of the account is not enough"},
+			"isv.OUT_OF_SERVICE":              {ClassPermanent, ReasonInsufficientFunds, "the service is suspended"},
+			"isv.ACCOUNT_ABNORMAL":            {ClassPermanent, ReasonAuthFailed, "the account is abnormal"},
+			"isp.RAM_PERMISSION_DENY":         {ClassPermanent, ReasonAuthFailed, "the permission is denied"},
+			"SignatureDoesNotMatch":           {ClassPermanent, ReasonAuthFailed, "the access key secret is wrong"},
+			"InvalidAccessKeyId.NotFound":     {ClassPermanent, ReasonAuthFailed, "the access key is not found"},
+			"isv.SMS_TEMPLATE_ILLEGAL":        {ClassPermanent, ReasonMisconfigured, "the template is invalid"},
+			"isv.SMS_SIGNATURE_ILLEGAL":       {ClassPermanent, ReasonMisconfigured, "the signature is invalid"},
+			"isv.TEMPLATE_MISSING_PARAMETERS": {ClassPermanent, ReasonMisconfigured, "the parameters of the template are missing"},
+			"isv.INVALID_PARAMETERS":          {ClassPermanent, ReasonMisconfigured, "the parameters are invalid"},
+			"isv.BLACK_KEY_CONTROL_LIMIT":     {ClassPermanent, ReasonBlocked, "the content contains the blocked words"},
+			"isv.SYSTEM_ERROR":                {ClassTemporary, ReasonUnavailable, "the system error of the vendor"},
+			"isp.SYSTEM_ERROR":                {ClassTemporary, ReasonUnavailable, "the system error of the vendor"},
+		},
+		"smtp": {
+			// The reply codes, RFC 5321.
+			"421": {ClassTemporary, ReasonUnavailable, "the server is not available"},
+			"450": {ClassTemporary, ReasonUnavailable, "the mailbox is unavailable for now, such as by greylisting"},
+			"451": {ClassTemporary, ReasonUnavailable, "the server has a local error"},
+			"452": {ClassTemporary, ReasonMailboxFull, "the storage of the server is insufficient"},
+			"535": {ClassPermanent, ReasonAuthFailed, "the username or password is wrong"},
+			"550": {ClassPermanent, ReasonInvalidRecipient, "the mailbox is unavailable or the message is refused"},
+			"551": {ClassPermanent, ReasonInvalidRecipient, "the user is not local"},
+			"552": {ClassPermanent, ReasonMailboxFull, "the storage of the mailbox is exceeded"},
+			"553": {ClassPermanent, ReasonInvalidRecipient, "the mailbox name is not allowed"},
+			"554": {ClassPermanent, ReasonBlocked, "the transaction failed"},
+
+			// The enhanced status codes, RFC 3463, which are more specific.
+			"4.2.2":  {ClassTemporary, ReasonMailboxFull, "the mailbox is full"},
+			"4.7.0":  {ClassTemporary, ReasonRateLimited, "the message is deferred, such as by greylisting"},
+			"5.1.1":  {ClassPermanent, ReasonInvalidRecipient, "the mailbox does not exist"},
+			"5.1.2":  {ClassPermanent, ReasonInvalidRecipient, "the domain does not exist"},
+			"5.1.10": {ClassPermanent, ReasonInvalidRecipient, "the domain does not accept the email"},
+			"5.2.1":  {ClassPermanent, ReasonInvalidRecipient, "the mailbox is disabled"},
+			"5.2.2":  {ClassPermanent, ReasonMailboxFull, "the mailbox is full"},
+			"5.3.4":  {ClassPermanent, ReasonTooLarge, "the message is too large"},
+			"5.4.1":  {ClassPermanent, ReasonBlocked, "the recipient address is rejected by the access policy"},
+			"5.7.1":  {ClassPermanent, ReasonBlocked, "the message is refused by the policy or as spam"},
+			"5.7.8":  {ClassPermanent, ReasonAuthFailed, "the username or password is wrong"},
+			"5.7.23": {ClassPermanent, ReasonBlocked, "the SPF validation failed"},
+			"5.7.26": {ClassPermanent, ReasonBlocked, "the sender is not authenticated by SPF or DKIM"},
+		},
+	}
+)
+
+// RegisterErrorCodes registers the translations of the error codes
+// of the vendor, such as "twilio", which override the existing ones.
+//
+// The vendor "smtp" is for the SMTP reply codes and the enhanced status codes,
+// such as "550" or "5.1.1".
+func RegisterErrorCodes(vendor string, codes map[string]ErrorCode) {
+	vendor = strings.ToLower(vendor)
+	errorCodesLocker.Lock()
+	defer errorCodesLocker.Unlock()
+
+	m, ok := errorCodes[vendor]
+	if !ok {
+		m = make(map[string]ErrorCode, len(codes))
+		errorCodes[vendor] = m
+	}
+	for code, ec := range codes {
+		m[code] = ec
+	}
+}
+
+// LookupErrorCode returns the translation of the error code of the vendor.
+func LookupErrorCode(vendor, code string) (ErrorCode, bool) {
+	errorCodesLocker.RLock()
+	ec, ok := errorCodes[strings.ToLower(vendor)][code]
+	errorCodesLocker.RUnlock()
+	return ec, ok
+}
+
+// enhancedCodeRE matches the enhanced status code at the start of the reply.
+var enhancedCodeRE = regexp.MustCompile(`^([245]\.\d{1,3}\.\d{1,3})\b`)
+
+// TranslateError translates the code of the error returned by the provider
+// of the vendor, such as "twilio" or "aliyun", into the unified class,
+// the reason and the human-readable description, see ErrorCode.
+//
+// If err is not *Error with the code, or the code is unknown,
+// it is returned as it is.
+func TranslateError(vendor string, err error) error {
+	e, ok := err.(*Error)
+	if !ok || e.Code == "" || e.Reason != "" {
+		return err
+	}
+
+	ec, ok := LookupErrorCode(vendor, e.Code)
+	if vendor == "smtp" {
+		// Prefer the enhanced status code, such as "5.1.1" of "550 5.1.1 ...".
+		if m := enhancedCodeRE.FindStringSubmatch(e.Message); m != nil {
+			if _ec, _ok := LookupErrorCode(vendor, m[1]); _ok {
+				ec, ok = _ec, true
+			}
+		}
+	}
+	if !ok {
+		return err
+	}
+
+	ne := *e
+	if ec.Class != "" {
+		ne.Class = ec.Class
+	}
+	ne.Reason = ec.Reason
+	ne.Description = ec.Description
+	return &ne
+}
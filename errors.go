@@ -0,0 +1,156 @@
+package messageapi
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrorClass is the class of the error of sending a message.
+type ErrorClass string
+
+// The classes of the errors.
+const (
+	// The error is temporary, such as the SMTP 4xx reply or the network error,
+	// and the message may be sent successfully by retrying later.
+	ClassTemporary ErrorClass = "temporary"
+
+	// The error is permanent, such as the SMTP 5xx reply "user unknown",
+	// and it is pointless to retry the message.
+	ClassPermanent ErrorClass = "permanent"
+)
+
+// ErrUnreachable is the underlying error of the provider when the recipient
+// is not reachable on the channel, such as the phone number not registered
+// on WhatsApp, so the message may be sent by the other channel, such as SMS.
+var ErrUnreachable = errors.New("the recipient is unreachable")
+
+// IsUnreachable reports whether the error is caused by ErrUnreachable.
+func IsUnreachable(err error) bool {
+	return errors.Is(err, ErrUnreachable)
+}
+
+// Error is the classified error returned by the provider.
+type Error struct {
+	Class ErrorClass
+
+	// The code of the error, such as the SMTP reply code or the vendor code.
+	Code string
+
+	// The unified reason, such as "invalid_recipient", and the human-readable
+	// description of the code, which are set by TranslateError.
+	Reason      string
+	Description string
+
+	// If positive, the provider asks to retry after the duration, such as by
+	// the header "Retry-After" of the response 429 "Too Many Requests".
+	RetryAfter time.Duration
+
+	Message string
+	Err     error
+}
+
+// NewError returns a new classified error.
+func NewError(class ErrorClass, code, message string) *Error {
+	return &Error{Class: class, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%s error: %s", e.Class, e.Message)
+	} else if e.Description != "" {
+		return fmt.Sprintf("%s error %s (%s): %s", e.Class, e.Code, e.Description, e.Message)
+	}
+	return fmt.Sprintf("%s error %s: %s", e.Class, e.Code, e.Message)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error { return e.Err }
+
+// RecipientsError is returned when the email is accepted for some recipients
+// but fails for the others, such as by the MX hosts of some domains, so that
+// only the failed ones are sent again by EmailOptions.Envelope.
+type RecipientsError struct {
+	Sent   []string
+	Failed map[string]error
+}
+
+// FailedRecipients returns the failed recipients in order.
+func (e *RecipientsError) FailedRecipients() []string {
+	recipients := make([]string, 0, len(e.Failed))
+	for recipient := range e.Failed {
+		recipients = append(recipients, recipient)
+	}
+	sort.Strings(recipients)
+	return recipients
+}
+
+func (e *RecipientsError) Error() string {
+	recipients := e.FailedRecipients()
+	errs := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		errs[i] = recipient + ": " + e.Failed[recipient].Error()
+	}
+	return fmt.Sprintf("failed to send to %d of %d recipients: %s", len(recipients),
+		len(recipients)+len(e.Sent), strings.Join(errs, "; "))
+}
+
+// Unwrap returns the error of a failed recipient, which is a temporary one
+// if any, so that the failed recipients are retried unless all are permanent.
+func (e *RecipientsError) Unwrap() error {
+	var err error
+	for _, recipient := range e.FailedRecipients() {
+		if err = e.Failed[recipient]; !IsPermanent(err) {
+			break
+		}
+	}
+	return err
+}
+
+// GetErrorClass returns the class of the error.
+//
+// If the error is not classified, it is considered to be temporary.
+func GetErrorClass(err error) ErrorClass {
+	var e *Error
+	if errors.As(err, &e) && e.Class != "" {
+		return e.Class
+	}
+	return ClassTemporary
+}
+
+// IsPermanent reports whether the error is permanent, which should not be retried.
+func IsPermanent(err error) bool {
+	return err != nil && GetErrorClass(err) == ClassPermanent
+}
+
+// GetRetryAfter returns the duration after which the provider asks to retry,
+// that's, Error.RetryAfter. Return 0 if the provider does not tell it.
+func GetRetryAfter(err error) time.Duration {
+	var e *Error
+	if errors.As(err, &e) && e.RetryAfter > 0 {
+		return e.RetryAfter
+	}
+	return 0
+}
+
+// ClassifySMTPError classifies the error returned by net/smtp by the reply code,
+// that's, 4xx is temporary and 5xx is permanent, and translates the reply code
+// and the enhanced status code, such as "550 5.1.1", by TranslateError.
+//
+// The other errors, such as the network error, are returned as they are.
+func ClassifySMTPError(err error) error {
+	var te *textproto.Error
+	if !errors.As(err, &te) {
+		return err
+	}
+
+	class := ClassTemporary
+	if te.Code >= 500 {
+		class = ClassPermanent
+	}
+	e := &Error{Class: class, Code: fmt.Sprint(te.Code), Message: te.Msg, Err: err}
+	return TranslateError("smtp", e)
+}
@@ -0,0 +1,155 @@
+// Command bench benchmarks the pipeline of sending the messages, that's,
+// parsing the request, routing and queueing the message and calling the
+// provider, by the mock providers, so the numbers are the overhead of the
+// server itself. It prints the allocations per request and the throughput
+// of each case matched by the regular expression:
+//
+//	go run ./example/bench -bench 'sms|email' -parallel 8
+//
+// With the duration of the soak test, it generates the load at the fixed rate
+// instead, and periodically reports the latencies, the errors and the memory,
+// so that the leaks and the degradation over time may be found. The load is
+// sent to the server in the process, or the remote one by the target:
+//
+//	go run ./example/bench -soak 1h -rate 500 -concurrency 200 -latency 50ms
+//	go run ./example/bench -soak 10m -rate 100 -target http://127.0.0.1:8080 -api-key KEY
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/xgfone/messageapi"
+	"github.com/xgfone/messageapi/app"
+)
+
+// latency is the simulated latency of the mock providers.
+var latency time.Duration
+
+func wait(ctx context.Context) error {
+	if latency <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type nopSMS struct{}
+
+func (nopSMS) Load(map[string]string) error                   { return nil }
+func (nopSMS) SendSMS(ctx context.Context, _, _ string) error { return wait(ctx) }
+
+type nopEmail struct{}
+
+func (nopEmail) Load(map[string]string) error { return nil }
+func (nopEmail) SendEmail(ctx context.Context, _ []string, _, _ string,
+	_ map[string]io.Reader) error {
+	return wait(ctx)
+}
+
+type nopMessenger struct{}
+
+func (nopMessenger) Load(map[string]string) error { return nil }
+func (nopMessenger) SendMessage(ctx context.Context, _ messageapi.Message) error {
+	return wait(ctx)
+}
+
+func init() {
+	for _, name := range []string{"nop1", "nop2", "nop3"} {
+		messageapi.RegisterSMS(name, nopSMS{})
+	}
+	messageapi.RegisterEmail("nop", nopEmail{})
+	messageapi.RegisterMessenger("nop", nopMessenger{})
+}
+
+func main() {
+	bench := flag.String("bench", ".", "The regular expression of the cases to benchmark")
+	parallel := flag.Int("parallel", runtime.GOMAXPROCS(0), "The number of the concurrent senders")
+	routes := flag.Bool("routes", true, "Route the phones by the country codes and the prices")
+	flag.DurationVar(&latency, "latency", 0, "The simulated latency of the mock providers")
+	duration := flag.Duration("soak", 0, "If greater than 0, run the soak test for the duration")
+	rate := flag.Int("rate", 100, "The number of the requests per second of the soak test")
+	concurrency := flag.Int("concurrency", 100, "The maximum number of the in-flight requests of the soak test")
+	report := flag.Duration("report", 10*time.Second, "The interval to report the soak test")
+	target := flag.String("target", "", "The URL of the remote server to soak, such as http://127.0.0.1:8080")
+	apiKey := flag.String("api-key", "", "The API key of the remote server")
+	flag.Parse()
+
+	pattern, err := regexp.Compile(*bench)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *target == "" {
+		if err := app.ResetConfig(newConfig(*routes)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var cases []benchCase
+	for _, c := range benchCases {
+		if pattern.MatchString(c.name) {
+			cases = append(cases, c)
+		}
+	}
+	if len(cases) == 0 {
+		fmt.Fprintf(os.Stderr, "no case matches %s\n", *bench)
+		os.Exit(2)
+	}
+
+	if *duration > 0 {
+		s := soak{cases: cases, rate: *rate, concurrency: *concurrency, report: *report,
+			target: *target, apiKey: *apiKey}
+		if !s.run(*duration) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *target != "" {
+		fmt.Fprintln(os.Stderr, "the target is only used by the soak test")
+		os.Exit(2)
+	}
+	for _, c := range cases {
+		c.benchmark(*parallel)
+	}
+}
+
+func newConfig(routes bool) *app.Config {
+	c := app.NewDefaultConfig("")
+	c.Emails = map[string]map[string]string{"nop": {"from": "bench@example.com"}}
+	c.DefaultEmailProvider = "nop"
+	c.SMSes = map[string]map[string]string{"nop1": {}, "nop2": {}, "nop3": {}}
+	c.DefaultSMSProvider = "nop1"
+	c.Messengers = map[string]map[string]string{"nop": {}}
+	if routes {
+		c.SMSRoutes = map[string][]string{
+			"+1":    {"nop1", "nop2"},
+			"+44":   {"nop2", "nop3"},
+			"+86":   {"nop3", "nop1"},
+			"+1868": {"nop3"},
+			"*":     {"nop1"},
+		}
+		c.SMSPrices = map[string]map[string]float64{
+			"nop1": {"+1": 0.0075, "+86": 0.03, "*": 0.05},
+			"nop2": {"+1": 0.0070, "+44": 0.04, "*": 0.06},
+			"nop3": {"+44": 0.035, "+86": 0.02, "*": 0.04},
+		}
+	}
+	return c
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// soak is the load generator of the soak test, which sends the requests of
+// the cases in turn at the fixed rate by at most concurrency senders.
+type soak struct {
+	cases       []benchCase
+	rate        int
+	concurrency int
+	report      time.Duration
+	target      string
+	apiKey      string
+
+	client *http.Client
+
+	lock      sync.Mutex
+	latencies []time.Duration
+	statuses  map[int]int
+	skipped   int
+	total     int
+	failed    int
+}
+
+// send sends the ith request, and returns the status code, which is 0 if
+// failing to send it to the remote server.
+func (s *soak) send(i int) int {
+	c := s.cases[i%len(s.cases)]
+	if s.target == "" {
+		return c.serve(i / len(s.cases)).Code
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(s.target, "/")+c.path,
+		c.newBody(i/len(s.cases)))
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("X-API-Key", s.apiKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func (s *soak) record(status int, latency time.Duration) {
+	s.lock.Lock()
+	s.latencies = append(s.latencies, latency)
+	s.statuses[status]++
+	s.total++
+	if status < 200 || status >= 300 {
+		s.failed++
+	}
+	s.lock.Unlock()
+}
+
+// run runs the soak test for the duration, and reports whether all the
+// requests succeed.
+func (s *soak) run(duration time.Duration) bool {
+	if s.rate <= 0 || s.concurrency <= 0 {
+		fmt.Println("the rate and the concurrency must be greater than 0")
+		return false
+	}
+	s.statuses = make(map[int]int)
+	s.client = &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{
+		MaxIdleConnsPerHost: s.concurrency,
+	}}
+
+	ticker := time.NewTicker(time.Second / time.Duration(s.rate))
+	defer ticker.Stop()
+	reporter := time.NewTicker(s.report)
+	defer reporter.Stop()
+
+	start := time.Now()
+	deadline := time.After(duration)
+	slots := make(chan struct{}, s.concurrency)
+	wg := new(sync.WaitGroup)
+
+	fmt.Printf("soak %d requests/second by %d senders for %s\n", s.rate, s.concurrency, duration)
+	for i := 0; ; {
+		select {
+		case <-deadline:
+			wg.Wait()
+			s.print(time.Since(start))
+			return s.failed == 0
+		case <-reporter.C:
+			s.print(time.Since(start))
+		case <-ticker.C:
+			select {
+			case slots <- struct{}{}:
+			default:
+				// All the senders are busy, so the server cannot keep up.
+				s.lock.Lock()
+				s.skipped++
+				s.lock.Unlock()
+				continue
+			}
+
+			wg.Add(1)
+			go func(i int) {
+				defer func() { <-slots; wg.Done() }()
+				begin := time.Now()
+				status := s.send(i)
+				s.record(status, time.Since(begin))
+			}(i)
+			i++
+		}
+	}
+}
+
+// print prints the latencies and the statuses since the last report,
+// the totals, and the memory of the process.
+func (s *soak) print(elapsed time.Duration) {
+	s.lock.Lock()
+	latencies, statuses, skipped := s.latencies, s.statuses, s.skipped
+	total, failed := s.total, s.failed
+	s.latencies, s.statuses, s.skipped = nil, make(map[int]int), 0
+	s.lock.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(float64(len(latencies)-1)*p)]
+	}
+
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = fmt.Sprintf("%d=%d", code, statuses[code])
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	fmt.Printf("%8s  sent=%d p50=%s p99=%s max=%s status=[%s] skipped=%d"+
+		"  total=%d failed=%d  heap=%.1fMB goroutines=%d\n",
+		elapsed.Truncate(time.Second), len(latencies), percentile(0.5), percentile(0.99),
+		percentile(1), strings.Join(parts, " "), skipped, total, failed,
+		float64(stats.HeapAlloc)/(1<<20), runtime.NumGoroutine())
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchCase is a case of the benchmark, the requests of which are sent
+// to the path in turn.
+type benchCase struct {
+	name   string
+	path   string
+	bodies []string
+}
+
+var phones = []string{"+14155550100", "+447700900123", "+8613800138000", "+4915112345678"}
+
+var benchCases = []benchCase{
+	{name: "sms", path: "/v1/sms", bodies: format(phones,
+		`{"phone":"%s","content":"Your code is 123456"}`)},
+	{name: "sms-priority", path: "/v1/sms", bodies: format(phones,
+		`{"phone":"%s","content":"Your code is 123456","category":"otp","priority":"high"}`)},
+	{name: "email", path: "/v1/email", bodies: format([]string{"a@example.com", "b@example.org"},
+		`{"to":"%s","subject":"Welcome","content":"Hello, welcome!"}`)},
+	{name: "email-attachment", path: "/v1/email", bodies: format([]string{"a@example.com"},
+		`{"to":"%s","subject":"Report","content":"See the attachment.",`+
+			`"attachments":{"report.txt":"`+strings.Repeat("0123456789", 1000)+`"}}`)},
+	{name: "message", path: "/v1/message", bodies: format([]string{"user1", "user2"},
+		`{"to":"%s","title":"Alert","content":"The disk is full"}`)},
+	{name: "sms-bulk", path: "/v1/sms/bulk", bodies: []string{
+		fmt.Sprintf(`{"to":["%s"],"content":"The service is back"}`, strings.Join(phones, `","`))}},
+}
+
+func format(recipients []string, body string) []string {
+	bodies := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		bodies[i] = fmt.Sprintf(body, recipient)
+	}
+	return bodies
+}
+
+// serve sends the ith request of the case to the server in the process.
+func (c benchCase) serve(i int) *httptest.ResponseRecorder {
+	body := c.bodies[i%len(c.bodies)]
+	req := httptest.NewRequest("POST", c.path, strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	rec := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(rec, req)
+	return rec
+}
+
+func (c benchCase) benchmark(parallel int) {
+	result := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetParallelism(parallel)
+		b.RunParallel(func(pb *testing.PB) {
+			var i int
+			for pb.Next() {
+				if rec := c.serve(i); rec.Code >= 300 {
+					b.Fatalf("status=%d, body=%s", rec.Code, rec.Body.String())
+				}
+				i++
+			}
+		})
+	})
+	if result.N == 0 {
+		fmt.Printf("%-20s failed\n", c.name)
+		return
+	}
+
+	perMinute := float64(time.Minute) / float64(result.NsPerOp())
+	fmt.Printf("%-20s %s %s %12.0f requests/minute\n", c.name,
+		result.String(), result.MemString(), perMinute)
+}
+
+// newBody returns the body of the ith request of the case to the remote server.
+func (c benchCase) newBody(i int) *bytes.Reader {
+	return bytes.NewReader([]byte(c.bodies[i%len(c.bodies)]))
+}
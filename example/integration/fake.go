@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/xgfone/messageapi/testutil"
+)
+
+// received is a request received by the fake vendor.
+type received struct {
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// fakeVendor is the fake REST vendor, which fails the first requests by
+// the status code, and then succeeds.
+type fakeVendor struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	fails    int
+	status   int
+	requests []received
+	notify   chan struct{}
+}
+
+func newFakeVendor() *fakeVendor {
+	v := &fakeVendor{notify: make(chan struct{}, 100)}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serve))
+	return v
+}
+
+func (v *fakeVendor) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	v.lock.Lock()
+	v.requests = append(v.requests, received{Path: r.URL.Path, Header: r.Header, Body: body})
+	fail := v.fails != 0
+	if v.fails > 0 {
+		v.fails--
+	}
+	status := v.status
+	v.lock.Unlock()
+
+	select {
+	case v.notify <- struct{}{}:
+	default:
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if fail {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":"fake failure","errorDescription":"fake failure"}`))
+		return
+	}
+	w.Write([]byte(`{"id":1}`))
+}
+
+// fail fails the next n requests by the status code, or all if n is negative.
+func (v *fakeVendor) fail(n, status int) {
+	v.lock.Lock()
+	v.fails, v.status = n, status
+	v.lock.Unlock()
+}
+
+func (v *fakeVendor) reset() {
+	v.lock.Lock()
+	v.fails, v.requests = 0, nil
+	v.lock.Unlock()
+	for {
+		select {
+		case <-v.notify:
+		default:
+			return
+		}
+	}
+}
+
+// wait waits until the fake vendor has received n requests, and returns them.
+func (v *fakeVendor) wait(n int, timeout time.Duration) ([]received, error) {
+	deadline := time.After(timeout)
+	for {
+		v.lock.Lock()
+		requests := append([]received(nil), v.requests...)
+		v.lock.Unlock()
+		if len(requests) >= n {
+			return requests, nil
+		}
+
+		select {
+		case <-v.notify:
+		case <-deadline:
+			return requests, fmt.Errorf("received %d requests, but expect %d", len(requests), n)
+		}
+	}
+}
+
+// mailbox is the SMTP server which receives the emails.
+type mailbox interface {
+	// wait waits for the email with the subject, and returns its recipients.
+	wait(subject string, timeout time.Duration) ([]string, error)
+}
+
+// localMailbox is the mailbox of the SMTP server in the process.
+type localMailbox struct{ *testutil.SMTPServer }
+
+func (m localMailbox) wait(subject string, timeout time.Duration) ([]string, error) {
+	for n := 1; ; n++ {
+		messages, err := m.Wait(n, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("not receive the email %q", subject)
+		}
+		for _, msg := range messages {
+			if msg.Subject() == subject {
+				return msg.To, nil
+			}
+		}
+		n = len(messages)
+	}
+}
+
+// mailhogMailbox is the mailbox of MailHog by its API.
+type mailhogMailbox string
+
+func (m mailhogMailbox) wait(subject string, timeout time.Duration) ([]string, error) {
+	query := url.Values{"kind": {"containing"}, "query": {subject}}
+	_url := string(m) + "/api/v2/search?" + query.Encode()
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(200 * time.Millisecond) {
+		resp, err := http.Get(_url)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Items []struct {
+				Raw struct {
+					To []string `json:"To"`
+				} `json:"Raw"`
+			} `json:"items"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		} else if len(result.Items) > 0 {
+			return result.Items[0].Raw.To, nil
+		}
+	}
+	return nil, fmt.Errorf("not receive the email %q", subject)
+}
@@ -0,0 +1,149 @@
+// Command integration runs the integration tests of the app end to end,
+// that's, sending the messages by the HTTP API to the fake providers, the
+// retries and the failover of the providers, and the signed webhooks.
+//
+// The emails are sent by SMTP to MailHog, which is started by Docker:
+//
+//	make integration
+//
+// Or, without Docker, to the SMTP server in the process:
+//
+//	go run ./example/integration
+//
+// The REST vendors are faked by the HTTP servers in the process, such as
+// Gotify and ntfy. It prints the result of each scenario, and exits with 1
+// if any fails.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/xgfone/messageapi/app"
+	"github.com/xgfone/messageapi/testutil"
+)
+
+func main() {
+	run := flag.String("run", ".", "The regular expression of the scenarios to run")
+	smtpAddr := flag.String("smtp", "", "The address of the SMTP server, such as MailHog on 127.0.0.1:1025. If empty, use the one in the process")
+	mailhog := flag.String("mailhog", "", "The URL of the API of MailHog, such as http://127.0.0.1:8025, by which the received emails are checked")
+	timeout := flag.Duration("timeout", 10*time.Second, "The timeout to wait for the messages and the webhooks")
+	flag.Parse()
+
+	pattern, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	h, err := newHarness(*smtpAddr, *mailhog, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer h.close()
+
+	var failed bool
+	for _, s := range scenarios {
+		if !pattern.MatchString(s.name) {
+			continue
+		}
+
+		h.reset()
+		start := time.Now()
+		if err := s.run(h); err != nil {
+			failed = true
+			fmt.Printf("--- FAIL: %s (%s)\n    %s\n", s.name, time.Since(start).Truncate(time.Millisecond), err)
+		} else {
+			fmt.Printf("--- PASS: %s (%s)\n", s.name, time.Since(start).Truncate(time.Millisecond))
+		}
+	}
+
+	if failed {
+		fmt.Println("FAIL")
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
+}
+
+// harness is the app under the test and the fake providers.
+type harness struct {
+	server  *httptest.Server
+	gotify  *fakeVendor
+	ntfy    *fakeVendor
+	webhook *fakeVendor
+	mailbox mailbox
+	timeout time.Duration
+
+	smtp *testutil.SMTPServer
+}
+
+const webhookSecret = "integration-secret"
+
+func newHarness(smtpAddr, mailhog string, timeout time.Duration) (*harness, error) {
+	h := &harness{
+		gotify:  newFakeVendor(),
+		ntfy:    newFakeVendor(),
+		webhook: newFakeVendor(),
+		timeout: timeout,
+	}
+
+	if smtpAddr == "" {
+		h.smtp = testutil.NewSMTPServer()
+		smtpAddr, h.mailbox = h.smtp.Addr, localMailbox{h.smtp}
+	} else if mailhog != "" {
+		h.mailbox = mailhogMailbox(mailhog)
+	} else {
+		return nil, fmt.Errorf("the API of MailHog is required to check the emails")
+	}
+
+	// The closed port before the SMTP server, to test the failover of the hosts.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+
+	c := app.NewDefaultConfig("")
+	c.Emails = map[string]map[string]string{"plain": {"host": closed + "," + smtpAddr,
+		"username": "integration", "password": "integration",
+		"from": "integration@example.com", "timeout": "5"}}
+	c.Messengers = map[string]map[string]string{
+		"gotify": {"url": h.gotify.URL, "token": "token"},
+		"ntfy":   {"url": h.ntfy.URL, "topic": "integration"},
+	}
+	c.InboundEmailWebhooks = []string{h.webhook.URL}
+	c.Webhooks = app.WebhookOptions{KeyID: "v1", Secret: webhookSecret}
+	if err := app.ResetConfig(c); err != nil {
+		h.close()
+		return nil, err
+	}
+
+	h.server = httptest.NewServer(nil)
+	return h, nil
+}
+
+// reset resets the fake providers before each scenario.
+func (h *harness) reset() {
+	h.gotify.reset()
+	h.ntfy.reset()
+	h.webhook.reset()
+}
+
+func (h *harness) close() {
+	if h.server != nil {
+		h.server.Close()
+	}
+	if h.smtp != nil {
+		h.smtp.Close()
+	}
+	h.gotify.Close()
+	h.ntfy.Close()
+	h.webhook.Close()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// scenario is an end-to-end flow, which returns an error if it fails.
+type scenario struct {
+	name string
+	run  func(h *harness) error
+}
+
+var scenarios = []scenario{
+	{name: "email-failover", run: testEmailFailover},
+	{name: "message-send", run: testMessageSend},
+	{name: "message-retry", run: testMessageRetry},
+	{name: "message-failover", run: testMessageFailover},
+	{name: "webhook-retry", run: testWebhookRetry},
+}
+
+// post posts the JSON body to the app, and returns the status code and
+// the body of the response.
+func (h *harness) post(path, contentType, body string) (int, string, error) {
+	resp, err := http.Post(h.server.URL+path, contentType, strings.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(data), err
+}
+
+// send posts the request to the app, and expects the status code 2xx.
+func (h *harness) send(path string, request interface{}) (string, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	status, body, err := h.post(path, "application/json", string(data))
+	if err != nil {
+		return "", err
+	} else if status < 200 || status >= 300 {
+		return "", fmt.Errorf("%s: status=%d, body=%s", path, status, body)
+	}
+	return body, nil
+}
+
+// testEmailFailover sends the email by SMTP, the first host of which is
+// unreachable, and expects it received by the second one.
+func testEmailFailover(h *harness) error {
+	subject := fmt.Sprintf("integration %d", time.Now().UnixNano())
+	_, err := h.send("/v1/email", map[string]interface{}{
+		"to": "someone@example.com", "subject": subject, "content": "Hello"})
+	if err != nil {
+		return err
+	}
+
+	to, err := h.mailbox.wait(subject, h.timeout)
+	if err != nil {
+		return err
+	} else if len(to) != 1 || !strings.Contains(to[0], "someone@example.com") {
+		return fmt.Errorf("expect the recipient someone@example.com, but got %v", to)
+	}
+	return nil
+}
+
+func testMessageSend(h *harness) error {
+	if _, err := h.send("/v1/message", map[string]interface{}{"provider": "gotify",
+		"title": "Alert", "content": "The disk is full"}); err != nil {
+		return err
+	}
+
+	requests, err := h.gotify.wait(1, h.timeout)
+	if err != nil {
+		return err
+	} else if r := requests[0]; r.Path != "/message" {
+		return fmt.Errorf("expect the path /message, but got %s", r.Path)
+	} else if r.Header.Get("X-Gotify-Key") != "token" {
+		return fmt.Errorf("have no the token of gotify")
+	} else if !strings.Contains(string(r.Body), "The disk is full") {
+		return fmt.Errorf("have no the content: %s", r.Body)
+	}
+	return nil
+}
+
+func testMessageRetry(h *harness) error {
+	h.gotify.fail(2, http.StatusServiceUnavailable)
+	if _, err := h.send("/v1/message", map[string]interface{}{"provider": "gotify",
+		"content": "The disk is full", "retry": 2}); err != nil {
+		return err
+	}
+
+	requests, err := h.gotify.wait(3, h.timeout)
+	if err != nil {
+		return err
+	} else if len(requests) != 3 {
+		return fmt.Errorf("expect 3 attempts, but got %d", len(requests))
+	}
+	return nil
+}
+
+func testMessageFailover(h *harness) error {
+	h.gotify.fail(-1, http.StatusInternalServerError)
+	body, err := h.send("/v1/message", map[string]interface{}{"provider": "gotify,ntfy",
+		"content": "The disk is full"})
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Provider string `json:"provider"`
+	}
+	if err = json.Unmarshal([]byte(body), &result); err != nil {
+		return err
+	} else if result.Provider != "ntfy" {
+		return fmt.Errorf("expect the provider ntfy, but got %q", result.Provider)
+	}
+
+	requests, err := h.ntfy.wait(1, h.timeout)
+	if err != nil {
+		return err
+	} else if r := requests[0]; r.Path != "/integration" || string(r.Body) != "The disk is full" {
+		return fmt.Errorf("unexpected request of ntfy: path=%s, body=%s", r.Path, r.Body)
+	}
+	return nil
+}
+
+const inboundEmail = "From: Someone <someone@example.com>\r\n" +
+	"To: support@example.com\r\n" +
+	"Subject: Help\r\n" +
+	"Message-ID: <integration@example.com>\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"I need help.\r\n"
+
+func testWebhookRetry(h *harness) error {
+	h.webhook.fail(1, http.StatusServiceUnavailable)
+	status, body, err := h.post("/v1/inbound/email", "message/rfc822", inboundEmail)
+	if err != nil {
+		return err
+	} else if status < 200 || status >= 300 {
+		return fmt.Errorf("/v1/inbound/email: status=%d, body=%s", status, body)
+	}
+
+	requests, err := h.webhook.wait(2, h.timeout)
+	if err != nil {
+		return err
+	}
+
+	first, last := requests[0], requests[len(requests)-1]
+	if id := first.Header.Get("X-Webhook-ID"); id == "" || id != last.Header.Get("X-Webhook-ID") {
+		return fmt.Errorf("the retry has the different id")
+	} else if last.Header.Get("X-Webhook-Key-ID") != "v1" {
+		return fmt.Errorf("have no the key id")
+	}
+
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write([]byte(last.Header.Get("X-Webhook-Timestamp") + "."))
+	mac.Write(last.Body)
+	if hex.EncodeToString(mac.Sum(nil)) != last.Header.Get("X-Webhook-Signature") {
+		return fmt.Errorf("invalid signature of the webhook")
+	} else if !strings.Contains(string(last.Body), "I need help.") {
+		return fmt.Errorf("have no the inbound email: %s", last.Body)
+	}
+	return nil
+}
@@ -2,13 +2,79 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/xgfone/messageapi/app"
+	"github.com/xgfone/messageapi/daemon"
 )
 
 func main() {
+	configFile := flag.String("config-file", "", "The configuration file to watch, such as a mounted ConfigMap")
+	secretDir := flag.String("secret-dir", "", "The directory of the secret options, such as a mounted Secret")
+	env := flag.String("env", "", "The environment selected from the configuration file, such as prod, or $MESSAGEAPI_ENV")
+	readOnly := flag.Bool("read-only", false, "Run as a read-only replica, which refuses to send the messages")
+	background := flag.Bool("daemon", false, "Run in the background as a Unix daemon")
+	pidFile := flag.String("pid-file", "", "The file to write the pid into")
+	logFile := flag.String("log-file", "", "The file of the stdout and stderr of the daemon")
+	install := flag.Bool("install", false, "Install as a Windows service with the other arguments, and exit")
+	uninstall := flag.Bool("uninstall", false, "Uninstall the Windows service, and exit")
 	flag.Parse()
+
+	options := daemon.Options{
+		Name:        "messageapi",
+		Description: "The API to send the message by the email or the sms",
+		PIDFile:     *pidFile,
+		Daemon:      *background,
+		LogFile:     *logFile,
+		Reopen:      glog.Flush,
+	}
+	if *install || *uninstall {
+		var err error
+		if *install {
+			err = daemon.Install(options, removeFlags(os.Args[1:], "-install", "--install")...)
+		} else {
+			err = daemon.Uninstall(options.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	app.SetEnvironment(*env)
+	app.SetReadOnly(*readOnly)
+	if cipher, err := app.NewAESCipherFromEnv("MESSAGEAPI_MASTER_KEY"); err == nil {
+		app.SetCipher(cipher) // Encrypt the secret options of the configuration
+	}
+
+	err := daemon.Run(options, func(stop <-chan struct{}) error {
+		errc := make(chan error, 1)
+		go func() { errc <- start(*configFile, *secretDir) }()
+		select {
+		case err := <-errc:
+			return err
+		case <-stop:
+			return app.Drain(30 * time.Second)
+		}
+	})
+	if err != nil {
+		glog.Error(err)
+	}
+	glog.Flush()
+}
+
+func start(configFile, secretDir string) error {
+	if configFile != "" {
+		if err := app.WatchConfig(configFile, secretDir, 10*time.Second); err != nil {
+			return err
+		}
+		return app.Start(nil, ":8080", "", "")
+	}
+
 	c := app.NewDefaultConfig("")
 	c.AllowGet = true // Allow to use the GET method to send the message
 	c.Emails = map[string]map[string]string{
@@ -20,5 +86,23 @@ func main() {
 			"from":     "username@example.com",
 		},
 	}
-	glog.Error(app.Start(c, ":8080", "", ""))
+	return app.Start(c, ":8080", "", "")
+}
+
+// removeFlags returns the arguments without the flags.
+func removeFlags(args []string, flags ...string) []string {
+	results := make([]string, 0, len(args))
+	for _, arg := range args {
+		var found bool
+		for _, flag := range flags {
+			if arg == flag || arg == flag+"=true" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			results = append(results, arg)
+		}
+	}
+	return results
 }
@@ -0,0 +1,119 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterMessenger("gotify", new(gotify))
+}
+
+// gotify is the messenger provider by the self-hosted Gotify server,
+// the configuration options of which are as follows:
+//
+//	url:        the base URL of the server, such as "https://gotify.example.com",
+//	            which is required.
+//	token:      the token of the application, which is required.
+//	timeout:    the timeout in seconds of the request, which is 30 by default.
+//	http_proxy: the proxy url of the requests, which is optional.
+//	socks5:     the SOCKS5 proxy, such as "host:1080", which is optional.
+//
+// The recipient is ignored, since the message is sent to the users
+// subscribing the application.
+type gotify struct {
+	sync.Mutex
+
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (g *gotify) Load(m map[string]string) error {
+	_url, token := strings.TrimSuffix(m["url"], "/"), m["token"]
+	if _url == "" {
+		return fmt.Errorf("no the url configuration")
+	} else if token == "" {
+		return fmt.Errorf("no the token configuration")
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	g.url = _url
+	g.token = token
+	g.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (g *gotify) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "url", Type: OptionURL, Required: true,
+			Description: "the base URL of the server"},
+		{Name: "token", Type: OptionString, Required: true, Secret: true,
+			Description: "the token of the application"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+// gotifyPriorities maps the priority from -2 to 2 to the one of Gotify.
+var gotifyPriorities = [...]int{0, 2, 5, 7, 10}
+
+func (g *gotify) SendMessage(cxt context.Context, msg Message) error {
+	g.Lock()
+	_url, token, client := g.url, g.token, g.client
+	g.Unlock()
+
+	priority := msg.Priority
+	if priority > 2 {
+		priority = 2
+	} else if priority < -2 {
+		priority = -2
+	}
+
+	payload := map[string]interface{}{
+		"title":    msg.Title,
+		"message":  msg.Content,
+		"priority": gotifyPriorities[priority+2],
+	}
+	if msg.URL != "" {
+		payload["extras"] = map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]string{"url": msg.URL},
+			},
+		}
+	}
+
+	header := http.Header{"X-Gotify-Key": {token}}
+	status, body, respHeader, err := doJSON(cxt, client, "POST", _url+"/message", header, payload)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		ID               int64  `json:"id"`
+		ErrorDescription string `json:"errorDescription"`
+	}
+	json.Unmarshal(body, &result)
+
+	if status >= 300 {
+		return httpError(status, respHeader, "", fmt.Sprintf("gotify: %d %s",
+			status, result.ErrorDescription))
+	}
+
+	SetResult(cxt, ResultMessageID, strconv.FormatInt(result.ID, 10))
+	return nil
+}
@@ -0,0 +1,225 @@
+package messageapi
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// The encodings of the sms content.
+const (
+	EncodingGSM7 = "gsm7"
+	EncodingUCS2 = "ucs2"
+)
+
+// gsm7Basic is the GSM 03.38 7-bit default alphabet, without the escape.
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extension is the extension table of the alphabet, each character of
+// which takes two septets with the escape.
+const gsm7Extension = "\f^{}\\[~]|€"
+
+// gsm7Transliterations is the look-alikes in the alphabet of the characters
+// out of it, such as the smart quotes inserted by the editors.
+var gsm7Transliterations = map[rune]string{
+	'‘': "'", '’': "'", '‚': "'", '‛': "'", '′': "'", '`': "'", '´': "'",
+	'“': "\"", '”': "\"", '„': "\"", '‟': "\"", '″': "\"", '«': "\"", '»': "\"",
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-", '−': "-",
+	'…': "...", '•': "*", '·': ".", '\t': " ",
+	'\u00a0': " ", '\u2002': " ", '\u2003': " ", '\u2009': " ", '\u202f': " ",
+	'\u3000': " ", '\u200b': "", '\u200d': "", '\ufeff': "",
+	'á': "a", 'â': "a", 'ã': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'Á': "A", 'À': "A", 'Â': "A", 'Ã': "A", 'Ā': "A", 'Ă': "A", 'Ą': "A",
+	'ç': "Ç", 'ć': "c", 'č': "c", 'Ć': "C", 'Č': "C", 'ď': "d", 'Ď': "D",
+	'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'È': "E", 'Ê': "E", 'Ë': "E", 'Ē': "E", 'Ę': "E", 'Ě': "E",
+	'ğ': "g", 'Ğ': "G",
+	'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'ı': "i",
+	'Í': "I", 'Ì': "I", 'Î': "I", 'Ï': "I", 'Ī': "I", 'İ': "I",
+	'ł': "l", 'Ł': "L", 'ń': "n", 'ň': "n", 'Ń': "N", 'Ň': "N",
+	'ó': "o", 'ô': "o", 'õ': "o", 'ō': "o", 'ő': "ö",
+	'Ó': "O", 'Ò': "O", 'Ô': "O", 'Õ': "O", 'Ō': "O", 'Ő': "Ö",
+	'ř': "r", 'Ř': "R", 'ś': "s", 'š': "s", 'ş': "s", 'Ś': "S", 'Š': "S", 'Ş': "S",
+	'ť': "t", 'ţ': "t", 'Ť': "T", 'Ţ': "T",
+	'ú': "u", 'û': "u", 'ū': "u", 'ů': "u", 'ű': "ü",
+	'Ú': "U", 'Ù': "U", 'Û': "U", 'Ū': "U", 'Ů': "U", 'Ű': "Ü",
+	'ý': "y", 'ÿ': "y", 'Ý': "Y", 'Ÿ': "Y",
+	'ź': "z", 'ż': "z", 'ž': "z", 'Ź': "Z", 'Ż': "Z", 'Ž': "Z",
+}
+
+// gsm7Septets returns the number of the septets of the character,
+// or 0 if it is out of the alphabet.
+func gsm7Septets(r rune) int {
+	if strings.ContainsRune(gsm7Basic, r) {
+		return 1
+	} else if strings.ContainsRune(gsm7Extension, r) {
+		return 2
+	}
+	return 0
+}
+
+// IsGSM7 reports whether the sms content is encoded by the GSM 7-bit
+// alphabet. Or it is encoded by UCS-2, which holds less than half of
+// the characters per segment, so costs more.
+func IsGSM7(content string) bool {
+	for _, r := range content {
+		if gsm7Septets(r) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SMSEncoding returns the encoding of the sms content,
+// that's, EncodingGSM7 or EncodingUCS2.
+func SMSEncoding(content string) string {
+	if IsGSM7(content) {
+		return EncodingGSM7
+	}
+	return EncodingUCS2
+}
+
+// SMSSegments returns the number of the segments of the sms content, that's,
+// 160 septets for the single GSM 7-bit one and 153 for each concatenated part,
+// or 70 UTF-16 code units for the single UCS-2 one and 67 for each part.
+func SMSSegments(content string) int {
+	single, part, n := 70, 67, len(utf16.Encode([]rune(content)))
+	if IsGSM7(content) {
+		single, part, n = 160, 153, 0
+		for _, r := range content {
+			n += gsm7Septets(r)
+		}
+	}
+
+	if n <= single {
+		return 1
+	}
+	return (n + part - 1) / part
+}
+
+// TransliterateGSM7 replaces the characters out of the GSM 7-bit alphabet
+// with their look-alikes in it, such as the smart quotes and the accented
+// letters, and returns the number of the replaced characters. The characters
+// without the look-alike, such as the emojis, are kept.
+func TransliterateGSM7(content string) (string, int) {
+	var n int
+	var b strings.Builder
+	b.Grow(len(content))
+	for _, r := range content {
+		if gsm7Septets(r) == 0 {
+			if s, ok := gsm7Transliterations[r]; ok {
+				b.WriteString(s)
+				n++
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), n
+}
+
+// StripNonGSM7 removes the characters out of the GSM 7-bit alphabet,
+// and returns the number of the removed characters.
+func StripNonGSM7(content string) (string, int) {
+	var n int
+	var b strings.Builder
+	b.Grow(len(content))
+	for _, r := range content {
+		if gsm7Septets(r) == 0 {
+			n++
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), n
+}
+
+// SplitSMS splits the long sms content into the numbered parts like
+// "(1/3) ...", each of which fits in a single segment, for the providers
+// which don't concatenate the segments. The parts are broken at the
+// whitespaces if possible, and the content fitting in a segment is
+// returned as is.
+//
+// limit is the maximum length of each part with its number, counted in
+// the septets for the GSM 7-bit content or the UTF-16 code units for
+// the UCS-2 one. If not greater than 0, it is the single segment,
+// that's, 160 or 70.
+func SplitSMS(content string, limit int) []string {
+	gsm7 := IsGSM7(content)
+	width := func(r rune) int {
+		if gsm7 {
+			return gsm7Septets(r)
+		} else if r >= 0x10000 {
+			return 2 // The surrogate pair
+		}
+		return 1
+	}
+
+	if limit <= 0 {
+		if limit = 70; gsm7 {
+			limit = 160
+		}
+	}
+
+	var total int
+	for _, r := range content {
+		total += width(r)
+	}
+	if total <= limit {
+		return []string{content}
+	}
+
+	// The number like "(1/3) " takes 4 characters and the digits of the total
+	// twice, so try the more digits until the number of the parts fits in.
+	for digits, max := 1, 10; ; digits, max = digits+1, max*10 {
+		room := limit - 4 - 2*digits
+		if room < 1 {
+			return []string{content}
+		}
+
+		chunks := splitRunes([]rune(content), room, width)
+		if len(chunks) < max {
+			parts := make([]string, len(chunks))
+			for i, chunk := range chunks {
+				parts[i] = fmt.Sprintf("(%d/%d) %s", i+1, len(chunks), chunk)
+			}
+			return parts
+		}
+	}
+}
+
+// splitRunes splits the runes into the chunks of at most room in width,
+// which are broken at the last whitespaces if possible.
+func splitRunes(rs []rune, room int, width func(rune) int) (chunks []string) {
+	for {
+		for len(rs) > 0 && unicode.IsSpace(rs[0]) {
+			rs = rs[1:]
+		}
+		if len(rs) == 0 {
+			return
+		}
+
+		var n, end int
+		for end < len(rs) && n+width(rs[end]) <= room {
+			n += width(rs[end])
+			end++
+		}
+
+		if end < len(rs) {
+			for i := end; i > 0; i-- {
+				if unicode.IsSpace(rs[i]) {
+					end = i
+					break
+				}
+			}
+		}
+		if end == 0 { // The character is wider than the room.
+			end = 1
+		}
+
+		chunks = append(chunks, strings.TrimRightFunc(string(rs[:end]), unicode.IsSpace))
+		rs = rs[end:]
+	}
+}
@@ -0,0 +1,115 @@
+package messageapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxResponseSize is the maximum size of the response of the API provider.
+const maxResponseSize = 1 << 20
+
+// parseTimeout parses the option "timeout" in seconds, which is 30s by default.
+func parseTimeout(m map[string]string) (time.Duration, error) {
+	timeout := m["timeout"]
+	if timeout == "" {
+		return 30 * time.Second, nil
+	}
+
+	n, err := strconv.Atoi(timeout)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("the timeout is not a positive integer")
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+// doJSON sends the request with the JSON payload, and returns the status code,
+// the body and the header of the response. If payload is nil, the request
+// has no body.
+func doJSON(cxt context.Context, client *http.Client, method, url string,
+	header http.Header, payload interface{}) (int, []byte, http.Header, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	req = req.WithContext(cxt)
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	return resp.StatusCode, data, resp.Header, err
+}
+
+// httpErrorClass returns the class of the error by the HTTP status code
+// of the response of the API provider.
+func httpErrorClass(code int) ErrorClass {
+	if code >= 500 || code == http.StatusTooManyRequests ||
+		code == http.StatusRequestTimeout {
+		return ClassTemporary
+	}
+	return ClassPermanent
+}
+
+// maxRetryAfter is the maximum duration of the header "Retry-After",
+// which protects against the wrong one of the provider.
+const maxRetryAfter = time.Hour
+
+// parseRetryAfter parses the header "Retry-After" of the response,
+// which is either the seconds or the HTTP date, and returns 0 if absent
+// or invalid.
+func parseRetryAfter(header http.Header) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	var d time.Duration
+	if n, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(n) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = time.Until(t)
+	}
+
+	if d < 0 {
+		return 0
+	} else if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
+
+// httpError returns the error classified by the HTTP status code, with
+// Error.RetryAfter from the header "Retry-After" of the rate limited response.
+func httpError(status int, header http.Header, code, message string) *Error {
+	e := NewError(httpErrorClass(status), code, message)
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		e.RetryAfter = parseRetryAfter(header)
+	}
+	return e
+}
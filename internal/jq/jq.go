@@ -0,0 +1,197 @@
+// Package jq implements a tiny subset of the jq expressions to select
+// the value from the decoded JSON, which supports the paths, such as
+// `.alert.labels["app.name"]` and `.items[0].title`, the string literals,
+// such as `"unknown"`, and the alternative operator `//`, such as
+// `.title // .name // "untitled"`.
+package jq
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type step struct {
+	key   string
+	index int
+	isKey bool
+}
+
+type term struct {
+	literal   interface{}
+	path      []step
+	isLiteral bool
+}
+
+func (t term) eval(v interface{}) interface{} {
+	if t.isLiteral {
+		return t.literal
+	}
+
+	for _, s := range t.path {
+		switch _v := v.(type) {
+		case map[string]interface{}:
+			if !s.isKey {
+				return nil
+			}
+			v = _v[s.key]
+		case []interface{}:
+			if s.isKey {
+				return nil
+			}
+			index := s.index
+			if index < 0 {
+				index += len(_v)
+			}
+			if index < 0 || index >= len(_v) {
+				return nil
+			}
+			v = _v[index]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// Query is the compiled expression.
+type Query struct {
+	terms []term
+}
+
+// Eval returns the value selected by the expression from the decoded JSON,
+// or nil if not found.
+//
+// For the alternative operator, the first value which is not nil or false
+// is returned.
+func (q *Query) Eval(v interface{}) interface{} {
+	var result interface{}
+	for _, t := range q.terms {
+		if result = t.eval(v); result != nil && result != false {
+			return result
+		}
+	}
+	return result
+}
+
+// Compile compiles the expression.
+func Compile(expr string) (*Query, error) {
+	var q Query
+	for _, s := range splitAlternatives(expr) {
+		t, err := parseTerm(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression %q: %s", expr, err)
+		}
+		q.terms = append(q.terms, t)
+	}
+	return &q, nil
+}
+
+// splitAlternatives splits the expression by "//" outside the strings.
+func splitAlternatives(expr string) (alts []string) {
+	var quoted bool
+	var start int
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '/' && !quoted && i+1 < len(expr) && expr[i+1] == '/':
+			alts = append(alts, expr[start:i])
+			start = i + 2
+			i++
+		}
+	}
+	return append(alts, expr[start:])
+}
+
+func parseTerm(s string) (t term, err error) {
+	switch {
+	case s == "":
+		return t, fmt.Errorf("empty term")
+	case s[0] == '"':
+		t.isLiteral = true
+		t.literal, err = strconv.Unquote(s)
+		return
+	case s == "null":
+		t.isLiteral = true
+		return
+	case s == "true", s == "false":
+		t.isLiteral, t.literal = true, s == "true"
+		return
+	case s[0] != '.':
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return t, fmt.Errorf("unexpected %q", s)
+		}
+		t.isLiteral, t.literal = true, f
+		return t, nil
+	}
+
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '.':
+			i++
+			if i < len(s) && s[i] == '[' {
+				continue
+			}
+
+			start := i
+			for i < len(s) && s[i] != '.' && s[i] != '[' {
+				i++
+			}
+			if start == i {
+				if i < len(s) {
+					return t, fmt.Errorf("empty key at %d", start)
+				}
+				break
+			}
+			t.path = append(t.path, step{key: s[start:i], isKey: true})
+
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if i+1 < len(s) && s[i+1] == '"' {
+				end = closingQuote(s, i+1)
+				if end < 0 || end+1 >= len(s) || s[end+1] != ']' {
+					return t, fmt.Errorf("unterminated string at %d", i+1)
+				}
+				key, err := strconv.Unquote(s[i+1 : end+1])
+				if err != nil {
+					return t, err
+				}
+				t.path = append(t.path, step{key: key, isKey: true})
+				i = end + 2
+				continue
+			}
+
+			if end < 0 {
+				return t, fmt.Errorf("unterminated index at %d", i)
+			}
+			index, err := strconv.Atoi(strings.TrimSpace(s[i+1 : i+end]))
+			if err != nil {
+				return t, fmt.Errorf("invalid index at %d", i)
+			}
+			t.path = append(t.path, step{index: index})
+			i += end + 1
+
+		default:
+			return t, fmt.Errorf("unexpected %q at %d", s[i], i)
+		}
+	}
+	return
+}
+
+// closingQuote returns the index of the quote closing the string
+// starting at the index, or -1.
+func closingQuote(s string, start int) int {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
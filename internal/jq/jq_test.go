@@ -0,0 +1,73 @@
+package jq
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	var data interface{}
+	err := json.Unmarshal([]byte(`{
+		"title": "",
+		"name": "disk full",
+		"firing": false,
+		"alert": {"labels": {"app.name": "api", "severity": "critical"}},
+		"items": [{"title": "first"}, {"title": "second"}],
+		"matrix": [[1, 2], [3, 4]]
+	}`), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		expr   string
+		result interface{}
+	}{
+		{`.`, data},
+		{`.name`, "disk full"},
+		{`.alert.labels["app.name"]`, "api"},
+		{`.alert.labels.severity`, "critical"},
+		{`.alert["labels"]["severity"]`, "critical"},
+		{`.items[0].title`, "first"},
+		{`.items[-1].title`, "second"},
+		{`.items[2].title`, nil},
+		{`.items.title`, nil},
+		{`.alert[0]`, nil},
+		{`.matrix[1][0]`, 3.0},
+		{`.missing.key`, nil},
+		{`.missing // .name`, "disk full"},
+		{`.firing // "resolved"`, "resolved"},
+		{`.title // "untitled"`, ""},
+		{`.missing // "a//b"`, "a//b"},
+		{`.missing // "say \"hi\""`, `say "hi"`},
+		{`.missing // null`, nil},
+		{`.missing // true`, true},
+		{`.missing // 42`, 42.0},
+	} {
+		q, err := Compile(c.expr)
+		if err != nil {
+			t.Errorf("%s: %s", c.expr, err)
+		} else if result := q.Eval(data); !reflect.DeepEqual(result, c.result) {
+			t.Errorf("%s: expect %#v, but got %#v", c.expr, c.result, result)
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`name`,
+		`.a..b`,
+		`.a[`,
+		`.a[x]`,
+		`.a["b`,
+		`.a["b"`,
+		`.a // `,
+		`"unterminated`,
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("%s: expect the error, but got nil", expr)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Package mustache implements a subset of the Mustache template language,
+// which supports the variables, the unescaped variables, the sections,
+// the inverted sections, the comments and the partials, but not
+// the set delimiters and the lambdas.
+//
+// The whitespace rules of the standalone tags are not implemented either,
+// that's, the indentation and the newline around the tag alone on its line,
+// such as "{{#items}}", are kept in the output instead of being removed.
+package mustache
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type node interface {
+	render(buf *bytes.Buffer, stack []interface{}, escape bool)
+}
+
+type textNode string
+
+func (n textNode) render(buf *bytes.Buffer, stack []interface{}, escape bool) {
+	buf.WriteString(string(n))
+}
+
+type varNode struct {
+	name   string
+	escape bool
+}
+
+func (n varNode) render(buf *bytes.Buffer, stack []interface{}, escape bool) {
+	v, ok := lookup(stack, n.name)
+	if !ok || v == nil {
+		return
+	}
+
+	var s string
+	switch _v := v.(type) {
+	case float64:
+		// Avoid the exponent, such as "1e+06", of the numbers decoded from JSON.
+		s = strconv.FormatFloat(_v, 'f', -1, 64)
+	case float32:
+		s = strconv.FormatFloat(float64(_v), 'f', -1, 32)
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if n.escape && escape {
+		s = html.EscapeString(s)
+	}
+	buf.WriteString(s)
+}
+
+type sectionNode struct {
+	name     string
+	inverted bool
+	nodes    []node
+}
+
+func (n sectionNode) render(buf *bytes.Buffer, stack []interface{}, escape bool) {
+	v, _ := lookup(stack, n.name)
+	if n.inverted {
+		if !truthy(v) {
+			renderNodes(buf, n.nodes, stack, escape)
+		}
+		return
+	} else if !truthy(v) {
+		return
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i, _len := 0, rv.Len(); i < _len; i++ {
+			renderNodes(buf, n.nodes, append(stack, rv.Index(i).Interface()), escape)
+		}
+	default:
+		renderNodes(buf, n.nodes, append(stack, v), escape)
+	}
+}
+
+func renderNodes(buf *bytes.Buffer, nodes []node, stack []interface{}, escape bool) {
+	for _, n := range nodes {
+		n.render(buf, stack, escape)
+	}
+}
+
+func truthy(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+		return rv.Len() > 0
+	}
+	return true
+}
+
+// lookup looks up the dotted name from the top of the context stack.
+func lookup(stack []interface{}, name string) (interface{}, bool) {
+	if name == "." {
+		if len(stack) == 0 {
+			return nil, false
+		}
+		return stack[len(stack)-1], true
+	}
+
+	names := strings.Split(name, ".")
+	for i := len(stack) - 1; i >= 0; i-- {
+		v, ok := get(stack[i], names[0])
+		if !ok {
+			continue
+		}
+
+		for _, n := range names[1:] {
+			if v, ok = get(v, n); !ok {
+				return nil, false
+			}
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+func get(ctx interface{}, name string) (interface{}, bool) {
+	switch m := ctx.(type) {
+	case map[string]interface{}:
+		v, ok := m[name]
+		return v, ok
+	case map[string]string:
+		v, ok := m[name]
+		return v, ok
+	}
+	return nil, false
+}
+
+// Template is a parsed mustache template.
+type Template struct {
+	nodes []node
+}
+
+// maxPartialDepth is the maximum depth of the nested partials,
+// which avoids the recursive partials.
+const maxPartialDepth = 10
+
+// Parse parses the mustache template.
+func Parse(text string) (*Template, error) {
+	return ParseWithPartials(text, nil)
+}
+
+// ParseWithPartials parses the mustache template, the partials of which,
+// such as "{{> header}}", are looked up from partials.
+func ParseWithPartials(text string, partials map[string]string) (*Template, error) {
+	p := parser{partials: partials}
+	nodes, rest, err := p.parse(text, "")
+	if err != nil {
+		return nil, err
+	} else if rest != "" {
+		return nil, fmt.Errorf("mustache: unexpected content")
+	}
+	return &Template{nodes: nodes}, nil
+}
+
+type parser struct {
+	partials map[string]string
+	depth    int
+}
+
+// parse parses the nodes until the closing tag of the section.
+func (p *parser) parse(text, section string) (nodes []node, rest string, err error) {
+	for {
+		index := strings.Index(text, "{{")
+		if index < 0 {
+			if section != "" {
+				return nil, "", fmt.Errorf("mustache: unclosed section %s", section)
+			}
+			if text != "" {
+				nodes = append(nodes, textNode(text))
+			}
+			return nodes, "", nil
+		}
+		if index > 0 {
+			nodes = append(nodes, textNode(text[:index]))
+		}
+		text = text[index+2:]
+
+		var tag string
+		if strings.HasPrefix(text, "{") {
+			end := strings.Index(text, "}}}")
+			if end < 0 {
+				return nil, "", fmt.Errorf("mustache: unclosed tag")
+			}
+			tag, text = "&"+text[1:end], text[end+3:]
+		} else {
+			end := strings.Index(text, "}}")
+			if end < 0 {
+				return nil, "", fmt.Errorf("mustache: unclosed tag")
+			}
+			tag, text = text[:end], text[end+2:]
+		}
+
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, "", fmt.Errorf("mustache: empty tag")
+		}
+
+		switch tag[0] {
+		case '!':
+		case '&':
+			nodes = append(nodes, varNode{name: strings.TrimSpace(tag[1:])})
+		case '#', '^':
+			name := strings.TrimSpace(tag[1:])
+			var children []node
+			if children, text, err = p.parse(text, name); err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, sectionNode{name: name, inverted: tag[0] == '^',
+				nodes: children})
+		case '/':
+			if name := strings.TrimSpace(tag[1:]); name != section {
+				return nil, "", fmt.Errorf("mustache: unexpected closing tag %s", name)
+			}
+			return nodes, text, nil
+		case '>':
+			name := strings.TrimSpace(tag[1:])
+			partial, ok := p.partials[name]
+			if !ok {
+				return nil, "", fmt.Errorf("mustache: no partial %s", name)
+			} else if p.depth >= maxPartialDepth {
+				return nil, "", fmt.Errorf("mustache: partials nested too deeply")
+			}
+
+			p.depth++
+			children, _, err := p.parse(partial, "")
+			p.depth--
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, children...)
+		case '=':
+			return nil, "", fmt.Errorf("mustache: unsupported tag %s", tag)
+		default:
+			nodes = append(nodes, varNode{name: tag, escape: true})
+		}
+	}
+}
+
+// Render renders the template with the data, which is the context
+// of the top level.
+func (t *Template) Render(data interface{}) string {
+	buf := bytes.NewBuffer(nil)
+	renderNodes(buf, t.nodes, []interface{}{data}, true)
+	return buf.String()
+}
+
+// RenderText is the same as Render, but does not escape the variables as
+// HTML, that's, "{{name}}" is the same as "{{{name}}}", which is used to
+// render the plain text.
+func (t *Template) RenderText(data interface{}) string {
+	buf := bytes.NewBuffer(nil)
+	renderNodes(buf, t.nodes, []interface{}{data}, false)
+	return buf.String()
+}
@@ -0,0 +1,78 @@
+package mustache
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	partials := map[string]string{
+		"header": "<h1>{{title}}</h1>",
+		"item":   "[{{name}}]",
+	}
+
+	for _, c := range []struct {
+		text   string
+		data   string
+		html   string
+		plain  string
+		hasErr bool
+	}{
+		{text: "Hello, {{name}}!", data: `{"name":"World"}`,
+			html: "Hello, World!", plain: "Hello, World!"},
+		{text: "{{name}} {{{name}}} {{&name}}", data: `{"name":"<b>&</b>"}`,
+			html: "&lt;b&gt;&amp;&lt;/b&gt; <b>&</b> <b>&</b>", plain: "<b>&</b> <b>&</b> <b>&</b>"},
+		{text: "[{{missing}}]", data: `{}`, html: "[]", plain: "[]"},
+		{text: "{{a.b.c}}", data: `{"a":{"b":{"c":"deep"}}}`, html: "deep", plain: "deep"},
+		{text: "{{n}} {{f}} {{big}}", data: `{"n":12345678,"f":0.25,"big":1e21}`,
+			html: "12345678 0.25 1000000000000000000000", plain: "12345678 0.25 1000000000000000000000"},
+		{text: "{{#items}}<{{.}}>{{/items}}", data: `{"items":[1,"&",3]}`,
+			html: "<1><&amp;><3>", plain: "<1><&><3>"},
+		{text: "{{#items}}{{> item}}{{/items}}", data: `{"items":[{"name":"a"},{"name":"b"}]}`,
+			html: "[a][b]", plain: "[a][b]"},
+		{text: "{{#user}}{{name}}@{{site}}{{/user}}", data: `{"site":"x","user":{"name":"u"}}`,
+			html: "u@x", plain: "u@x"},
+		{text: "{{#ok}}yes{{/ok}}{{^ok}}no{{/ok}}", data: `{"ok":true}`, html: "yes", plain: "yes"},
+		{text: "{{#ok}}yes{{/ok}}{{^ok}}no{{/ok}}", data: `{"ok":false}`, html: "no", plain: "no"},
+		{text: "{{#list}}x{{/list}}{{^list}}empty{{/list}}", data: `{"list":[]}`,
+			html: "empty", plain: "empty"},
+		{text: "a{{! comment }}b", data: `{}`, html: "ab", plain: "ab"},
+		{text: "{{> header}}", data: `{"title":"T"}`, html: "<h1>T</h1>", plain: "<h1>T</h1>"},
+
+		{text: "{{#a}}", hasErr: true},
+		{text: "{{#a}}{{/b}}", hasErr: true},
+		{text: "{{name", hasErr: true},
+		{text: "{{}}", hasErr: true},
+		{text: "{{> nothing}}", hasErr: true},
+		{text: "{{=<% %>=}}", hasErr: true},
+	} {
+		tmpl, err := ParseWithPartials(c.text, partials)
+		if c.hasErr {
+			if err == nil {
+				t.Errorf("%s: expect the error, but got nil", c.text)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: %s", c.text, err)
+			continue
+		}
+
+		var data interface{}
+		if err = json.Unmarshal([]byte(c.data), &data); err != nil {
+			t.Fatal(err)
+		}
+
+		if s := tmpl.Render(data); s != c.html {
+			t.Errorf("%s: expect '%s', but got '%s'", c.text, c.html, s)
+		}
+		if s := tmpl.RenderText(data); s != c.plain {
+			t.Errorf("%s: expect '%s', but got '%s'", c.text, c.plain, s)
+		}
+	}
+}
+
+func TestRecursivePartial(t *testing.T) {
+	if _, err := ParseWithPartials("{{> a}}", map[string]string{"a": "{{> a}}"}); err == nil {
+		t.Error("expect the error of the recursive partial, but got nil")
+	}
+}
@@ -0,0 +1,228 @@
+// Package redis implements a tiny client of the Redis protocol,
+// which only supports the commands needed by messageapi.
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNil is returned when the reply is nil.
+var ErrNil = errors.New("redis: nil reply")
+
+// Option is the option to connect to the redis server.
+type Option struct {
+	Addr     string
+	Password string
+	DB       int
+
+	// The timeout to dial, read and write. The default is 3s.
+	Timeout time.Duration
+
+	// The maximum number of the idle connections. The default is 4.
+	MaxIdle int
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Client is a redis client.
+type Client struct {
+	opt   Option
+	lock  sync.Mutex
+	conns []*conn
+}
+
+// NewClient returns a new redis client.
+func NewClient(opt Option) *Client {
+	if opt.Timeout <= 0 {
+		opt.Timeout = 3 * time.Second
+	}
+	if opt.MaxIdle <= 0 {
+		opt.MaxIdle = 4
+	}
+	return &Client{opt: opt}
+}
+
+func (c *Client) get() (*conn, error) {
+	c.lock.Lock()
+	if n := len(c.conns); n > 0 {
+		cn := c.conns[n-1]
+		c.conns = c.conns[:n-1]
+		c.lock.Unlock()
+		return cn, nil
+	}
+	c.lock.Unlock()
+
+	nc, err := net.DialTimeout("tcp", c.opt.Addr, c.opt.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if c.opt.Password != "" {
+		if _, err = c.do(cn, "AUTH", c.opt.Password); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.opt.DB != 0 {
+		if _, err = c.do(cn, "SELECT", strconv.Itoa(c.opt.DB)); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.lock.Lock()
+	if len(c.conns) < c.opt.MaxIdle {
+		c.conns = append(c.conns, cn)
+		cn = nil
+	}
+	c.lock.Unlock()
+
+	if cn != nil {
+		cn.Close()
+	}
+}
+
+// Do executes the command and returns the reply, which is one of string,
+// int64, []interface{} or nil.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.do(cn, args...)
+	if _, ok := err.(Error); err != nil && !ok {
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Close closes all the idle connections.
+func (c *Client) Close() error {
+	c.lock.Lock()
+	conns := c.conns
+	c.conns = nil
+	c.lock.Unlock()
+
+	for _, cn := range conns {
+		cn.Close()
+	}
+	return nil
+}
+
+func (c *Client) do(cn *conn, args ...string) (interface{}, error) {
+	cn.SetDeadline(time.Now().Add(c.opt.Timeout))
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := cn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return readReply(cn.r)
+}
+
+// Error is the error replied by the redis server.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: invalid reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	} else if len(line) == 0 {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		} else if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		} else if n < 0 {
+			return nil, nil
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply %q", line)
+	}
+}
+
+// String converts the reply to string, and returns ErrNil if it is nil.
+func String(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+
+	switch v := reply.(type) {
+	case nil:
+		return "", ErrNil
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	default:
+		return "", fmt.Errorf("redis: unexpected reply type %T", reply)
+	}
+}
@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadReply(t *testing.T) {
+	for _, c := range []struct {
+		data  string
+		reply interface{}
+		err   string
+	}{
+		{data: "+OK\r\n", reply: "OK"},
+		{data: "-ERR unknown command\r\n", err: "ERR unknown command"},
+		{data: ":-42\r\n", reply: int64(-42)},
+		{data: "$5\r\nhello\r\n", reply: "hello"},
+		{data: "$7\r\nhe\r\nllo\r\n", reply: "he\r\nllo"},
+		{data: "$0\r\n\r\n", reply: ""},
+		{data: "$-1\r\n", reply: nil},
+		{data: "*-1\r\n", reply: nil},
+		{data: "*3\r\n:1\r\n$1\r\na\r\n*1\r\n+b\r\n",
+			reply: []interface{}{int64(1), "a", []interface{}{"b"}}},
+		{data: "+OK\n", err: "redis: invalid reply line"},
+		{data: "\r\n", err: "redis: empty reply"},
+		{data: "?1\r\n", err: "redis: unknown reply"},
+		{data: "$5\r\nhel", err: "EOF"},
+	} {
+		reply, err := readReply(bufio.NewReader(strings.NewReader(c.data)))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: expect the error '%s', but got '%v'", c.data, c.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("%q: %s", c.data, err)
+		} else if !reflect.DeepEqual(reply, c.reply) {
+			t.Errorf("%q: expect %#v, but got %#v", c.data, c.reply, reply)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	if s, err := String("a", nil); err != nil || s != "a" {
+		t.Errorf("expect 'a', but got '%s' and %v", s, err)
+	}
+	if s, err := String(int64(1), nil); err != nil || s != "1" {
+		t.Errorf("expect '1', but got '%s' and %v", s, err)
+	}
+	if _, err := String(nil, nil); err != ErrNil {
+		t.Errorf("expect ErrNil, but got %v", err)
+	}
+	if _, err := String([]interface{}{}, nil); err == nil {
+		t.Error("expect the error of the array, but got nil")
+	}
+}
+
+func TestClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The fake server replies the command received as the array.
+	commands := make(chan []interface{}, 10)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		r := bufio.NewReader(c)
+		for {
+			reply, err := readReply(r)
+			if err != nil {
+				return
+			}
+			args := reply.([]interface{})
+			commands <- args
+
+			if args[0] == "GET" {
+				c.Write([]byte("$-1\r\n"))
+			} else {
+				c.Write([]byte("+OK\r\n"))
+			}
+		}
+	}()
+
+	client := NewClient(Option{Addr: ln.Addr().String(), Password: "pass", DB: 2})
+	defer client.Close()
+
+	if reply, err := String(client.Do("SET", "key", "a b\r\nc", "NX")); err != nil || reply != "OK" {
+		t.Errorf("expect 'OK', but got '%s' and %v", reply, err)
+	}
+	if _, err := String(client.Do("GET", "missing")); err != ErrNil {
+		t.Errorf("expect ErrNil, but got %v", err)
+	}
+
+	// The connection is reused, so AUTH and SELECT are sent only once.
+	expected := [][]interface{}{
+		{"AUTH", "pass"},
+		{"SELECT", "2"},
+		{"SET", "key", "a b\r\nc", "NX"},
+		{"GET", "missing"},
+	}
+	for _, e := range expected {
+		if args := <-commands; !reflect.DeepEqual(args, e) {
+			t.Errorf("expect the command %q, but got %q", e, args)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package s3 implements a tiny client of the S3-compatible object storage,
+// such as AWS S3, Aliyun OSS and MinIO, which only supports to put, get and
+// delete objects.
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xgfone/messageapi/internal/sigv4"
+)
+
+// Client is the client of a bucket.
+type Client struct {
+	// The endpoint of the object storage, such as
+	// "https://s3.us-east-1.amazonaws.com" or
+	// "https://oss-cn-hangzhou.aliyuncs.com".
+	Endpoint string
+	Region   string
+	Bucket   string
+
+	Credentials sigv4.Credentials
+
+	// If true, the bucket is in the path instead of the host,
+	// such as MinIO.
+	PathStyle bool
+
+	HTTPClient *http.Client
+}
+
+func (c *Client) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimRight(c.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	key = strings.TrimPrefix(key, "/")
+	if c.PathStyle {
+		key = c.Bucket + "/" + key
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+	}
+	u.Path = "/" + key
+	u.RawPath = "/" + sigv4.Escape(key, false)
+	return u, nil
+}
+
+// PutObject uploads the object of the size, the body of which is read by
+// the readers returned by open twice, one to compute the hash to sign the
+// request and the other to upload.
+func (c *Client) PutObject(ctx context.Context, key, contentType string, size int64,
+	open func() io.Reader) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, open()); err != nil {
+		return err
+	}
+
+	u, err := c.objectURL(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, u.String(), open())
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.close(c.do(ctx, req, key, hex.EncodeToString(h.Sum(nil))))
+}
+
+// GetObject returns the body of the object, which must be closed by the caller.
+func (c *Client) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	u, err := c.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(ctx, req, key, sigv4.HashPayload(nil))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// DeleteObject deletes the object, which succeeds if it does not exist.
+func (c *Client) DeleteObject(ctx context.Context, key string) error {
+	u, err := c.objectURL(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	return c.close(c.do(ctx, req, key, sigv4.HashPayload(nil)))
+}
+
+func (c *Client) close(resp *http.Response, err error) error {
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+// do signs and sends the request, and returns the response if it succeeds.
+func (c *Client) do(ctx context.Context, req *http.Request, key, payloadHash string) (
+	*http.Response, error) {
+	region := c.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	sigv4.Sign(req, c.Credentials, region, "s3", payloadHash, time.Now())
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to %s the object %s: %s: %s", strings.ToLower(req.Method),
+			key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
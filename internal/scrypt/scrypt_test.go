@@ -0,0 +1,69 @@
+package scrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	data, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// The test vectors of RFC 7914, section 11.
+func TestPBKDF2(t *testing.T) {
+	expected := unhex("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783")
+	if dk := pbkdf2([]byte("passwd"), []byte("salt"), 64); !bytes.Equal(dk, expected) {
+		t.Errorf("expect %x, but got %x", expected, dk)
+	}
+}
+
+// The test vectors of RFC 7914, section 12, except the last one,
+// which needs 1GB memory.
+func TestKey(t *testing.T) {
+	for _, c := range []struct {
+		password string
+		salt     string
+		N, r, p  int
+		key      string
+	}{
+		{"", "", 16, 1, 1,
+			"77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442" +
+				"fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16,
+			"fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162" +
+				"2eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+		{"pleaseletmein", "SodiumChloride", 16384, 8, 1,
+			"7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2" +
+				"d5432955613f0fcf62d49705242a9af9e61e85dc0d651e40dfcf017b45575887"},
+	} {
+		key, err := Key([]byte(c.password), []byte(c.salt), c.N, c.r, c.p, 64)
+		if err != nil {
+			t.Errorf("%s: %s", c.password, err)
+		} else if expected := unhex(c.key); !bytes.Equal(key, expected) {
+			t.Errorf("%s: expect %x, but got %x", c.password, expected, key)
+		}
+	}
+}
+
+func TestKeyParameters(t *testing.T) {
+	for _, c := range []struct{ N, r, p, keyLen int }{
+		{0, 1, 1, 32},
+		{1, 1, 1, 32},
+		{15, 1, 1, 32},
+		{16, 0, 1, 32},
+		{16, 1, 0, 32},
+		{16, 1, 1, 0},
+		{16, 1 << 15, 1 << 15, 32},
+	} {
+		if _, err := Key([]byte("password"), []byte("salt"), c.N, c.r, c.p, c.keyLen); err == nil {
+			t.Errorf("%+v: expect the error, but got nil", c)
+		}
+	}
+}
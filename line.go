@@ -0,0 +1,143 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterMessenger("line", new(line))
+}
+
+const (
+	linePushURL   = "https://api.line.me/v2/bot/message/push"
+	lineNotifyURL = "https://notify-api.line.me/api/notify"
+)
+
+// line is the messenger provider by LINE, the configuration options
+// of which are as follows:
+//
+//	access_token: the channel access token of the Messaging API, or the
+//	              personal access token of LINE Notify, which is required.
+//	api:          "messaging" or "notify", which is "messaging" by default.
+//	timeout:      the timeout in seconds of the request, which is 30 by default.
+//	http_proxy:   the proxy url of the requests, which is optional.
+//	socks5:       the SOCKS5 proxy, such as "host:1080", which is optional.
+//
+// For the Messaging API, the recipient is the user, group or room id.
+// For LINE Notify, the recipient is ignored, since the message is sent to
+// the chat bound to the token.
+type line struct {
+	sync.Mutex
+
+	token  string
+	notify bool
+	client *http.Client
+}
+
+func (l *line) Load(m map[string]string) error {
+	token := m["access_token"]
+	if token == "" {
+		return fmt.Errorf("no the access_token configuration")
+	}
+
+	var notify bool
+	switch m["api"] {
+	case "", "messaging":
+	case "notify":
+		notify = true
+	default:
+		return fmt.Errorf("the api is not messaging or notify")
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.token = token
+	l.notify = notify
+	l.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (l *line) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "access_token", Type: OptionString, Required: true, Secret: true,
+			Description: "the access token of the Messaging API or LINE Notify"},
+		{Name: "api", Type: OptionString, Default: "messaging",
+			Description: "\"messaging\" or \"notify\""},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+func (l *line) SendMessage(cxt context.Context, msg Message) error {
+	l.Lock()
+	token, notify, client := l.token, l.notify, l.client
+	l.Unlock()
+
+	content := msg.Content
+	if msg.Title != "" {
+		content = msg.Title + "\n" + content
+	}
+
+	if notify {
+		return l.sendNotify(cxt, client, token, content)
+	}
+
+	payload := map[string]interface{}{
+		"to":       msg.To,
+		"messages": []map[string]string{{"type": "text", "text": content}},
+	}
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	status, body, respHeader, err := doJSON(cxt, client, "POST", linePushURL, header, payload)
+	if err != nil {
+		return err
+	} else if status >= 300 {
+		var result struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(body, &result)
+		return httpError(status, respHeader, "", fmt.Sprintf("line: %d %s",
+			status, result.Message))
+	}
+	return nil
+}
+
+func (l *line) sendNotify(cxt context.Context, client *http.Client, token,
+	content string) error {
+	form := url.Values{"message": {content}}
+	req, err := http.NewRequest("POST", lineNotifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(cxt)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if resp.StatusCode >= 300 {
+		return httpError(resp.StatusCode, resp.Header, "",
+			fmt.Sprintf("line: %d %s", resp.StatusCode, body))
+	}
+	return nil
+}
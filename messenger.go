@@ -0,0 +1,101 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// VendorTemplate is the message template approved by the vendor,
+// such as the WhatsApp message template.
+type VendorTemplate struct {
+	Name     string `json:"name"`
+	Language string `json:"language,omitempty"`
+
+	// The parameters of the body of the template.
+	Params []string `json:"params,omitempty"`
+
+	// The vendor-specific components of the template, such as the header,
+	// body and button parameters of WhatsApp, which override Params.
+	Components json.RawMessage `json:"components,omitempty"`
+}
+
+// Message is the message sent by the messenger.
+type Message struct {
+	// The recipient, such as the phone number, the user id or the topic,
+	// which depends on the provider.
+	To string `json:"to"`
+
+	Title   string `json:"title,omitempty"`
+	Content string `json:"content,omitempty"`
+
+	// The template approved by the vendor, which is used instead of
+	// the content if given and supported by the provider.
+	Template *VendorTemplate `json:"template,omitempty"`
+
+	// The priority from -2 (the lowest) to 2 (the highest), and 0 is normal,
+	// which is mapped to the priority of the provider.
+	Priority int `json:"priority,omitempty"`
+
+	// The URL opened when the notification is clicked, and the tags
+	// of the notification, which are optional.
+	URL  string   `json:"url,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Messenger is the interface which the messenger provider implements,
+// such as the instant messaging and the push notification services.
+type Messenger interface {
+	Config
+	SendMessage(cxt context.Context, msg Message) error
+}
+
+var messengers = make(map[string]Messenger)
+
+// RegisterMessenger registers a Messenger provider implementation.
+//
+// Notice: The plugin is a single instance in the global.
+func RegisterMessenger(name string, messenger Messenger) {
+	if _, ok := messengers[name]; ok {
+		panic(fmt.Errorf("%s has been registered", name))
+	}
+	messengers[name] = messenger
+}
+
+// GetMessenger returns a named Messenger provider.
+//
+// Return nil if there is no the messenger provider named name.
+func GetMessenger(name string) Messenger {
+	if m, ok := messengers[name]; ok {
+		return m
+	}
+	return nil
+}
+
+// NewMessenger returns a new instance of the Messenger provider named name,
+// like NewSMS.
+//
+// Return nil if there is no the messenger provider named name.
+func NewMessenger(name string) Messenger {
+	if m := GetMessenger(name); m != nil {
+		return newInstance(m).(Messenger)
+	}
+	return nil
+}
+
+// GetAllMessengers returns all the messenger providers.
+func GetAllMessengers() map[string]Messenger {
+	return messengers
+}
+
+// truncate truncates the string to at most n bytes without splitting
+// the UTF-8 character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,85 @@
+package messageapi_test
+
+import (
+	"testing"
+
+	"github.com/xgfone/messageapi"
+	"github.com/xgfone/messageapi/providertest"
+)
+
+func TestBark(t *testing.T) {
+	providertest.TestMessenger(t, messageapi.NewMessenger("bark"), providertest.Options{
+		Config:  func(url string) map[string]string { return map[string]string{"url": url} },
+		Succeed: succeed(`{"code":200,"message":"success"}`),
+	})
+}
+
+func TestGotify(t *testing.T) {
+	providertest.TestMessenger(t, messageapi.NewMessenger("gotify"), providertest.Options{
+		Config: func(url string) map[string]string {
+			return map[string]string{"url": url, "token": "token"}
+		},
+		Succeed: succeed(`{"id":1}`),
+	})
+}
+
+func TestNtfy(t *testing.T) {
+	providertest.TestMessenger(t, messageapi.NewMessenger("ntfy"), providertest.Options{
+		Config:  func(url string) map[string]string { return map[string]string{"url": url} },
+		Succeed: succeed(`{"id":"abc"}`),
+	})
+}
+
+func TestLine(t *testing.T) {
+	providertest.TestMessenger(t, messageapi.NewMessenger("line"), providertest.Options{
+		Config: fixedURL(t, map[string]string{"access_token": "token"}),
+	})
+}
+
+func TestOpsgenie(t *testing.T) {
+	providertest.TestMessenger(t, messageapi.NewMessenger("opsgenie"), providertest.Options{
+		Config:  fixedURL(t, map[string]string{"api_key": "key"}),
+		Succeed: succeed(`{"requestId":"abc"}`),
+	})
+}
+
+func TestPagerDuty(t *testing.T) {
+	providertest.TestMessenger(t, messageapi.NewMessenger("pagerduty"), providertest.Options{
+		Config:  fixedURL(t, map[string]string{"routing_key": "key"}),
+		Succeed: succeed(`{"status":"success","dedup_key":"abc"}`),
+	})
+}
+
+func TestPushover(t *testing.T) {
+	providertest.TestMessenger(t, messageapi.NewMessenger("pushover"), providertest.Options{
+		Config:  fixedURL(t, map[string]string{"token": "token"}),
+		Succeed: succeed(`{"status":1,"request":"abc"}`),
+	})
+}
+
+func TestServerChan(t *testing.T) {
+	providertest.TestMessenger(t, messageapi.NewMessenger("serverchan"), providertest.Options{
+		Config:  fixedURL(t, map[string]string{"send_key": "key"}),
+		Succeed: succeed(`{"code":0,"data":{"pushid":"1"}}`),
+	})
+}
+
+func TestViber(t *testing.T) {
+	providertest.TestMessenger(t, messageapi.NewMessenger("viber"), providertest.Options{
+		Config: fixedURL(t, map[string]string{
+			"auth_token":  "token",
+			"sender_name": "messageapi",
+		}),
+		Succeed: succeed(`{"status":0,"message_token":1}`),
+	})
+}
+
+func TestWhatsApp(t *testing.T) {
+	providertest.TestMessenger(t, messageapi.NewMessenger("whatsapp"), providertest.Options{
+		Config: fixedURL(t, map[string]string{
+			"phone_number_id": "123",
+			"access_token":    "token",
+		}),
+		Succeed: succeed(`{"messages":[{"id":"wamid.abc"}]}`),
+	})
+}
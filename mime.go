@@ -0,0 +1,296 @@
+package messageapi
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mimePart is a leaf part of the MIME message.
+type mimePart struct {
+	contentType string
+	body        []byte
+}
+
+func newBoundary() string {
+	var buf [12]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+func writeHeader(w *bufio.Writer, key, value string) {
+	w.WriteString(key)
+	w.WriteString(": ")
+	w.WriteString(value)
+	w.WriteString("\r\n")
+}
+
+// writePart writes the headers and the body of the part.
+func writePart(w *bufio.Writer, p mimePart) {
+	if strings.HasPrefix(p.contentType, "text/calendar") {
+		// Keep the CRLF line endings of the calendar exactly.
+		writeHeader(w, "Content-Type", p.contentType)
+		writeHeader(w, "Content-Transfer-Encoding", "base64")
+		w.WriteString("\r\n")
+		writeBase64(w, bytes.NewReader(p.body))
+		return
+	}
+
+	writeHeader(w, "Content-Type", p.contentType)
+	writeHeader(w, "Content-Transfer-Encoding", "quoted-printable")
+	w.WriteString("\r\n")
+	qw := quotedprintable.NewWriter(w)
+	qw.Write(p.body)
+	qw.Close()
+	w.WriteString("\r\n")
+}
+
+// writeAttachment writes the headers of the attachment and streams its
+// content from the reader.
+func writeAttachment(w *bufio.Writer, filename string, r io.Reader) error {
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if strings.HasPrefix(contentType, "text/calendar") {
+		// Avoid that the calendar clients treat it as another invitation.
+		contentType = "application/ics"
+	} else if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	writeHeader(w, "Content-Type", contentType)
+	writeHeader(w, "Content-Transfer-Encoding", "base64")
+	writeHeader(w, "Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": filename}))
+	w.WriteString("\r\n")
+	return writeBase64(w, r)
+}
+
+// lineWriter breaks the base64 encoding into the lines of 76 characters.
+type lineWriter struct {
+	w *bufio.Writer
+	n int // The length of the current line.
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		n := 76 - l.n
+		if n > len(p) {
+			n = len(p)
+		}
+
+		l.w.Write(p[:n])
+		p = p[n:]
+		if l.n += n; l.n == 76 {
+			l.w.WriteString("\r\n")
+			l.n = 0
+		}
+	}
+	return total, nil
+}
+
+// writeBase64 streams the base64 encoding of the reader in lines
+// of 76 characters.
+func writeBase64(w *bufio.Writer, r io.Reader) error {
+	lw := &lineWriter{w: w}
+	encoder := base64.NewEncoder(base64.StdEncoding, lw)
+	if _, err := io.Copy(encoder, r); err != nil {
+		return err
+	}
+	encoder.Close()
+	if lw.n > 0 {
+		w.WriteString("\r\n")
+	}
+	return nil
+}
+
+// writeAlternative writes the parts as the multipart/alternative,
+// or as a single part if only one.
+func writeAlternative(w *bufio.Writer, parts []mimePart) {
+	if len(parts) == 1 {
+		writePart(w, parts[0])
+		return
+	}
+
+	boundary := newBoundary()
+	writeHeader(w, "Content-Type", "multipart/alternative; boundary="+boundary)
+	w.WriteString("\r\n")
+	for _, p := range parts {
+		w.WriteString("--" + boundary + "\r\n")
+		writePart(w, p)
+	}
+	w.WriteString("--" + boundary + "--\r\n")
+}
+
+// writeMessage writes the MIME message, whose body consists of
+// the alternatives, such as the plain text and the calendar,
+// and the attachments.
+//
+// The attachments are streamed from the readers into the writer without
+// being buffered. If the reader is nil, the attachment is read from
+// the file named by the key.
+func writeMessage(out io.Writer, from mail.Address, replyTo string, to, cc []string,
+	subject string, headers map[string]string, alternatives []mimePart,
+	attachments map[string]io.Reader) error {
+	w := bufio.NewWriter(out)
+	writeHeader(w, "From", from.String())
+	if replyTo != "" {
+		writeHeader(w, "Reply-To", replyTo)
+	}
+	writeHeader(w, "To", strings.Join(to, ", "))
+	if len(cc) > 0 {
+		writeHeader(w, "Cc", strings.Join(cc, ", "))
+	}
+	writeHeader(w, "Subject", mime.QEncoding.Encode("utf-8", subject))
+	if len(headers) > 0 {
+		keys := make([]string, 0, len(headers))
+		for key := range headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeHeader(w, textproto.CanonicalMIMEHeaderKey(key),
+				mime.QEncoding.Encode("utf-8", headers[key]))
+		}
+	}
+	writeHeader(w, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(w, "Message-ID", fmt.Sprintf("<%s@%s>", newBoundary(),
+		from.Address[strings.LastIndexByte(from.Address, '@')+1:]))
+	writeHeader(w, "MIME-Version", "1.0")
+
+	if len(attachments) == 0 {
+		writeAlternative(w, alternatives)
+		return w.Flush()
+	}
+
+	names := make([]string, 0, len(attachments))
+	for name := range attachments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	boundary := newBoundary()
+	writeHeader(w, "Content-Type", "multipart/mixed; boundary="+boundary)
+	w.WriteString("\r\n")
+	w.WriteString("--" + boundary + "\r\n")
+	writeAlternative(w, alternatives)
+	for _, name := range names {
+		w.WriteString("--" + boundary + "\r\n")
+
+		var err error
+		if r := attachments[name]; r != nil {
+			err = writeAttachment(w, name, r)
+		} else if f, e := os.Open(name); e != nil {
+			err = e
+		} else {
+			err = writeAttachment(w, filepath.Base(name), f)
+			f.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the attachment[%s]: %s", name, err)
+		}
+	}
+	w.WriteString("--" + boundary + "--\r\n")
+	return w.Flush()
+}
+
+// readAttachments reads the contents of the attachments. If the reader is nil,
+// the attachment is read from the file named by the key.
+func readAttachments(attachments map[string]io.Reader) (map[string][]byte, error) {
+	files := make(map[string][]byte, len(attachments)+1)
+	for name, r := range attachments {
+		var data []byte
+		var err error
+		if r == nil {
+			data, err = ioutil.ReadFile(name)
+			name = filepath.Base(name)
+		} else {
+			data, err = ioutil.ReadAll(r)
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+var (
+	htmlInvisibleRE = regexp.MustCompile(`(?is)<(head|style|script)\b.*?</(head|style|script)\s*>`)
+	htmlBreakRE     = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6]|table|blockquote)\s*>`)
+	htmlTagRE       = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLinesRE    = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+)
+
+// htmlToText converts the HTML body of the email to the plain text roughly,
+// which is the plain-text alternative for the clients not rendering HTML.
+func htmlToText(s string) string {
+	s = htmlInvisibleRE.ReplaceAllString(s, "")
+	s = htmlBreakRE.ReplaceAllString(s, "\n")
+	s = htmlTagRE.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	s = blankLinesRE.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s)
+}
+
+// WriteEmail writes the email as the MIME message, which is sent by the
+// provider "plain", such as to archive it. The sender is from unless
+// overridden by opts.From, opts.Cc is the header "Cc" but opts.Bcc is not
+// written, and the HTML and the calendar of opts are the
+// alternatives of the content, the latter of which is also the attachment
+// "invite.ics".
+func WriteEmail(w io.Writer, from mail.Address, to []string, subject, content string,
+	attachments map[string]io.Reader, opts EmailOptions) error {
+	if opts.From != "" {
+		from = mail.Address{Name: opts.FromName, Address: opts.From}
+	} else if opts.FromName != "" {
+		from.Name = opts.FromName
+	}
+
+	files := attachments
+	alternatives := []mimePart{{contentType: "text/plain; charset=UTF-8", body: []byte(content)}}
+	if opts.HTML != "" {
+		if content == "" {
+			alternatives[0].body = []byte(htmlToText(opts.HTML))
+		}
+		alternatives = append(alternatives, mimePart{
+			contentType: "text/html; charset=UTF-8", body: []byte(opts.HTML)})
+	}
+	if len(opts.Calendar) > 0 {
+		method := opts.CalendarMethod
+		if method == "" {
+			method = MethodRequest
+		}
+		if _, ok := attachments["invite.ics"]; !ok {
+			files = make(map[string]io.Reader, len(attachments)+1)
+			for name, r := range attachments {
+				files[name] = r
+			}
+			files["invite.ics"] = bytes.NewReader(opts.Calendar)
+		}
+
+		alternatives = append(alternatives, mimePart{
+			contentType: mime.FormatMediaType("text/calendar",
+				map[string]string{"method": method, "charset": "UTF-8"}),
+			body: opts.Calendar,
+		})
+	}
+
+	return writeMessage(w, from, opts.ReplyTo, to, opts.Cc, subject, opts.Headers,
+		alternatives, files)
+}
@@ -0,0 +1,150 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterMessenger("ntfy", new(ntfy))
+}
+
+// ntfy is the messenger provider by ntfy, the configuration options
+// of which are as follows:
+//
+//	url:        the base URL of the server, which is "https://ntfy.sh" by default.
+//	topic:      the default topic, which is used if the recipient is empty.
+//	token:      the access token, which is optional.
+//	username:   the username of the basic authentication, which is optional.
+//	password:   the password of the basic authentication, which is optional.
+//	timeout:    the timeout in seconds of the request, which is 30 by default.
+//	http_proxy: the proxy url of the requests, which is optional.
+//	socks5:     the SOCKS5 proxy, such as "host:1080", which is optional.
+//
+// The recipient is the topic.
+type ntfy struct {
+	sync.Mutex
+
+	url      string
+	topic    string
+	token    string
+	username string
+	password string
+	client   *http.Client
+}
+
+func (n *ntfy) Load(m map[string]string) error {
+	_url := strings.TrimSuffix(m["url"], "/")
+	if _url == "" {
+		_url = "https://ntfy.sh"
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	n.url = _url
+	n.topic = m["topic"]
+	n.token = m["token"]
+	n.username = m["username"]
+	n.password = m["password"]
+	n.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (n *ntfy) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "url", Type: OptionURL, Default: "https://ntfy.sh",
+			Description: "the base URL of the server"},
+		{Name: "topic", Type: OptionString,
+			Description: "the default topic"},
+		{Name: "token", Type: OptionString, Secret: true,
+			Description: "the access token"},
+		{Name: "username", Type: OptionString,
+			Description: "the username of the basic authentication"},
+		{Name: "password", Type: OptionString, Secret: true,
+			Description: "the password of the basic authentication"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+func (n *ntfy) SendMessage(cxt context.Context, msg Message) error {
+	n.Lock()
+	_url, topic, token, username, password, client := n.url, n.topic, n.token,
+		n.username, n.password, n.client
+	n.Unlock()
+
+	if msg.To != "" {
+		topic = msg.To
+	}
+	if topic == "" {
+		return NewError(ClassPermanent, "", "ntfy: no the topic")
+	}
+
+	req, err := http.NewRequest("POST", _url+"/"+topic, strings.NewReader(msg.Content))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(cxt)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	if msg.Title != "" {
+		req.Header.Set("Title", msg.Title)
+	}
+	if msg.URL != "" {
+		req.Header.Set("Click", msg.URL)
+	}
+	if len(msg.Tags) > 0 {
+		req.Header.Set("Tags", strings.Join(msg.Tags, ","))
+	}
+	if msg.Priority != 0 {
+		priority := msg.Priority + 3
+		if priority > 5 {
+			priority = 5
+		} else if priority < 1 {
+			priority = 1
+		}
+		req.Header.Set("Priority", strconv.Itoa(priority))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	json.Unmarshal(body, &result)
+
+	if resp.StatusCode >= 300 {
+		return httpError(resp.StatusCode, resp.Header, "",
+			fmt.Sprintf("ntfy: %d %s", resp.StatusCode, result.Error))
+	}
+
+	SetResult(cxt, ResultMessageID, result.ID)
+	return nil
+}
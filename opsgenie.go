@@ -0,0 +1,130 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+func init() {
+	RegisterMessenger("opsgenie", new(opsgenie))
+}
+
+// opsgenie is the messenger provider which creates the alerts by the Opsgenie
+// Alert API, the configuration options of which are as follows:
+//
+//	api_key:    the API key of the integration, which is required.
+//	region:     "us" or "eu", which is "us" by default.
+//	timeout:    the timeout in seconds of the request, which is 30 by default.
+//	http_proxy: the proxy url of the requests, which is optional.
+//	socks5:     the SOCKS5 proxy, such as "host:1080", which is optional.
+//
+// The recipient is the name of the team responding the alert, which is
+// optional. The priority is mapped to P1-P5.
+type opsgenie struct {
+	sync.Mutex
+
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (o *opsgenie) Load(m map[string]string) error {
+	apiKey := m["api_key"]
+	if apiKey == "" {
+		return fmt.Errorf("no the api_key configuration")
+	}
+
+	var _url string
+	switch m["region"] {
+	case "", "us":
+		_url = "https://api.opsgenie.com/v2/alerts"
+	case "eu":
+		_url = "https://api.eu.opsgenie.com/v2/alerts"
+	default:
+		return fmt.Errorf("the region is not us or eu")
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	o.url = _url
+	o.apiKey = apiKey
+	o.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (o *opsgenie) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "api_key", Type: OptionString, Required: true, Secret: true,
+			Description: "the API key of the integration"},
+		{Name: "region", Type: OptionString, Default: "us",
+			Description: "\"us\" or \"eu\""},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+// opsgeniePriorities maps the priority from -2 to 2 to the one of Opsgenie.
+var opsgeniePriorities = [...]string{"P5", "P4", "P3", "P2", "P1"}
+
+func (o *opsgenie) SendMessage(cxt context.Context, msg Message) error {
+	o.Lock()
+	_url, apiKey, client := o.url, o.apiKey, o.client
+	o.Unlock()
+
+	priority := msg.Priority
+	if priority > 2 {
+		priority = 2
+	} else if priority < -2 {
+		priority = -2
+	}
+
+	message := msg.Title
+	if message == "" {
+		message = msg.Content
+	}
+	alert := map[string]interface{}{
+		"message":     truncate(message, 130),
+		"description": msg.Content,
+		"priority":    opsgeniePriorities[priority+2],
+	}
+	if len(msg.Tags) > 0 {
+		alert["tags"] = msg.Tags
+	}
+	if msg.To != "" {
+		alert["responders"] = []map[string]string{{"name": msg.To, "type": "team"}}
+	}
+	if msg.URL != "" {
+		alert["details"] = map[string]string{"url": msg.URL}
+	}
+
+	header := http.Header{"Authorization": {"GenieKey " + apiKey}}
+	status, body, respHeader, err := doJSON(cxt, client, "POST", _url, header, alert)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Message   string `json:"message"`
+		RequestID string `json:"requestId"`
+	}
+	json.Unmarshal(body, &result)
+
+	if status >= 300 {
+		return httpError(status, respHeader, "", fmt.Sprintf("opsgenie: %d %s",
+			status, result.Message))
+	}
+
+	SetResult(cxt, ResultMessageID, result.RequestID)
+	return nil
+}
@@ -0,0 +1,110 @@
+package messageapi
+
+import (
+	"context"
+	"strings"
+)
+
+// EmailOptions is the extra options to send the email, which is passed to
+// SendEmail by the context, see WithEmailOptions.
+//
+// The provider should honor the options which it supports.
+type EmailOptions struct {
+	// The HTML body of the email, which is sent as the "text/html"
+	// alternative of the plain-text content. If the content is empty,
+	// the plain text is converted from the HTML.
+	HTML string
+
+	// The iCalendar object of the meeting invitation, see Event.ICS,
+	// which is sent as the "text/calendar" alternative of the content
+	// with the method, such as "REQUEST", so that the calendar clients
+	// of the recipients show the Accept/Decline buttons.
+	Calendar       []byte
+	CalendarMethod string
+
+	// The sender identity, which overrides the sender configured by the
+	// provider if given. The DKIM selector is used by the provider which
+	// signs the email by DKIM, such as by the API of the vendor.
+	From         string
+	FromName     string
+	ReplyTo      string
+	DKIMSelector string
+
+	// The carbon-copied recipients, which are in the header "Cc", and the
+	// blind ones, which are not in any header. Both receive the email besides
+	// the recipients given to SendEmail, see EmailRecipients.
+	Cc  []string
+	Bcc []string
+
+	// If not empty, the recipients of the envelope instead of the recipients
+	// given to SendEmail, Cc and Bcc, which are still in the headers, such as
+	// the failed ones of RecipientsError to send the same email again.
+	Envelope []string
+
+	// The extra headers of the email, such as "X-Original-To",
+	// which the provider should add if it supports.
+	Headers map[string]string
+}
+
+type emailOptionsKey struct{}
+
+// EmailRecipients returns the recipients of the envelope of the email, that's,
+// opts.Envelope if given, or the recipients given to SendEmail followed by
+// opts.Cc and opts.Bcc, without the duplicates case-insensitively.
+func EmailRecipients(to []string, opts EmailOptions) []string {
+	if len(opts.Envelope) > 0 {
+		return opts.Envelope
+	} else if len(opts.Cc) == 0 && len(opts.Bcc) == 0 {
+		return to
+	}
+
+	seen := make(map[string]bool, len(to)+len(opts.Cc)+len(opts.Bcc))
+	recipients := make([]string, 0, len(to)+len(opts.Cc)+len(opts.Bcc))
+	for _, addrs := range [][]string{to, opts.Cc, opts.Bcc} {
+		for _, addr := range addrs {
+			if key := strings.ToLower(addr); !seen[key] {
+				seen[key] = true
+				recipients = append(recipients, addr)
+			}
+		}
+	}
+	return recipients
+}
+
+// WithEmailOptions returns a new context carrying the email options.
+func WithEmailOptions(ctx context.Context, opts EmailOptions) context.Context {
+	return context.WithValue(ctx, emailOptionsKey{}, opts)
+}
+
+// GetEmailOptions returns the email options carried by the context.
+//
+// It returns the zero value if the context carries no options.
+func GetEmailOptions(ctx context.Context) EmailOptions {
+	opts, _ := ctx.Value(emailOptionsKey{}).(EmailOptions)
+	return opts
+}
+
+// SMSOptions is the extra options to send the sms, which is passed to
+// SendSMS by the context, see WithSMSOptions.
+//
+// The provider should honor the options which it supports.
+type SMSOptions struct {
+	// The sender id, such as the phone number or the alphanumeric sender id,
+	// which overrides the sender configured by the provider if given.
+	SenderID string
+}
+
+type smsOptionsKey struct{}
+
+// WithSMSOptions returns a new context carrying the sms options.
+func WithSMSOptions(ctx context.Context, opts SMSOptions) context.Context {
+	return context.WithValue(ctx, smsOptionsKey{}, opts)
+}
+
+// GetSMSOptions returns the sms options carried by the context.
+//
+// It returns the zero value if the context carries no options.
+func GetSMSOptions(ctx context.Context) SMSOptions {
+	opts, _ := ctx.Value(smsOptionsKey{}).(SMSOptions)
+	return opts
+}
@@ -0,0 +1,130 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+func init() {
+	RegisterMessenger("pagerduty", new(pagerDuty))
+}
+
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDuty is the messenger provider which triggers the incidents by the
+// PagerDuty Events API v2, the configuration options of which are as follows:
+//
+//	routing_key: the default integration key of the service, which is used
+//	             if the recipient is empty.
+//	source:      the source of the events, which is "messageapi" by default.
+//	timeout:     the timeout in seconds of the request, which is 30 by default.
+//	http_proxy:  the proxy url of the requests, which is optional.
+//	socks5:      the SOCKS5 proxy, such as "host:1080", which is optional.
+//
+// The recipient is the integration key. The title, or the content if no title,
+// is the summary, and the priority is mapped to the severity.
+type pagerDuty struct {
+	sync.Mutex
+
+	routingKey string
+	source     string
+	client     *http.Client
+}
+
+func (p *pagerDuty) Load(m map[string]string) error {
+	source := m["source"]
+	if source == "" {
+		source = "messageapi"
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.routingKey = m["routing_key"]
+	p.source = source
+	p.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (p *pagerDuty) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "routing_key", Type: OptionString, Secret: true,
+			Description: "the default integration key of the service"},
+		{Name: "source", Type: OptionString, Default: "messageapi",
+			Description: "the source of the events"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+// pagerDutySeverities maps the priority from -2 to 2 to the severity.
+var pagerDutySeverities = [...]string{"info", "info", "warning", "error", "critical"}
+
+func (p *pagerDuty) SendMessage(cxt context.Context, msg Message) error {
+	p.Lock()
+	routingKey, source, client := p.routingKey, p.source, p.client
+	p.Unlock()
+
+	if msg.To != "" {
+		routingKey = msg.To
+	}
+	if routingKey == "" {
+		return NewError(ClassPermanent, "", "pagerduty: no the routing key")
+	}
+
+	priority := msg.Priority
+	if priority > 2 {
+		priority = 2
+	} else if priority < -2 {
+		priority = -2
+	}
+
+	summary := msg.Title
+	if summary == "" {
+		summary = msg.Content
+	}
+	event := map[string]interface{}{
+		"routing_key":  routingKey,
+		"event_action": "trigger",
+		"payload": map[string]interface{}{
+			"summary":        truncate(summary, 1024),
+			"source":         source,
+			"severity":       pagerDutySeverities[priority+2],
+			"custom_details": map[string]string{"content": msg.Content},
+		},
+	}
+	if msg.URL != "" {
+		event["links"] = []map[string]string{{"href": msg.URL}}
+	}
+
+	status, body, respHeader, err := doJSON(cxt, client, "POST", pagerDutyURL, nil, event)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Status   string   `json:"status"`
+		Message  string   `json:"message"`
+		DedupKey string   `json:"dedup_key"`
+		Errors   []string `json:"errors"`
+	}
+	json.Unmarshal(body, &result)
+
+	if status >= 300 {
+		return httpError(status, respHeader, "", fmt.Sprintf("pagerduty: %d %s %v",
+			status, result.Message, result.Errors))
+	}
+
+	SetResult(cxt, ResultMessageID, result.DedupKey)
+	return nil
+}
@@ -0,0 +1,161 @@
+package messageapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redacted is the placeholder of the redacted data in the payload logs.
+const Redacted = "[REDACTED]"
+
+// PayloadLog is the options to log the HTTP requests to the vendor and its
+// responses for troubleshooting, which is passed to the HTTP API providers
+// by the context, see WithPayloadLog.
+//
+// The secrets, such as the header "Authorization" and the fields named like
+// "password" or "token", are always redacted.
+type PayloadLog struct {
+	// The provider, such as "sms:twilio", which prefixes the logs.
+	Provider string
+
+	// The sensitive values to be redacted, such as the recipients and the
+	// content of the message, which are redacted wherever they appear,
+	// including in the URL-encoded or JSON-encoded forms.
+	Values []string
+
+	// The function to output the logs, such as glog.Infof.
+	Logf func(format string, args ...interface{})
+}
+
+type payloadLogKey struct{}
+
+// WithPayloadLog returns a new context carrying the options of the payload
+// logging, by which the requests sent by the clients of NewHTTPClient with
+// the context and their responses are logged with the redaction.
+func WithPayloadLog(ctx context.Context, pl PayloadLog) context.Context {
+	return context.WithValue(ctx, payloadLogKey{}, pl)
+}
+
+func getPayloadLog(ctx context.Context) (PayloadLog, bool) {
+	pl, ok := ctx.Value(payloadLogKey{}).(PayloadLog)
+	return pl, ok && pl.Logf != nil
+}
+
+// secretHeaders is the headers whose values are always redacted.
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie",
+	"Set-Cookie", "X-Api-Key", "Api-Key", "X-Auth-Token", "X-Signature"}
+
+// secretFieldRE matches the names of the secret fields of JSON or the form.
+var secretFieldRE = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|` +
+	`access_?key|private_?key|signature|credential|auth)`)
+
+// jsonFieldRE matches the string field of JSON, such as `"token": "abc"`.
+var jsonFieldRE = regexp.MustCompile(`"([^"\\]+)"(\s*:\s*)"((?:[^"\\]|\\.)*)"`)
+
+// formFieldRE matches the field of the form or the query, such as `token=abc`.
+var formFieldRE = regexp.MustCompile(`(^|[?&\s])([A-Za-z0-9_.\-\[\]]+)=([^&\s]*)`)
+
+// RedactPayload redacts the secrets and the sensitive values in the payload,
+// such as the dumped HTTP request or the JSON body, see PayloadLog.
+func RedactPayload(data []byte, values []string) []byte {
+	s := string(data)
+
+	// Redact the values first, the longer ones of which take precedence,
+	// since they may contain the shorter ones.
+	values = append([]string(nil), values...)
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+
+		forms := []string{v, url.QueryEscape(v), url.PathEscape(v)}
+		if data, err := json.Marshal(v); err == nil {
+			forms = append(forms, string(data[1:len(data)-1]))
+		}
+		for _, form := range forms {
+			s = strings.Replace(s, form, Redacted, -1)
+		}
+	}
+
+	// Redact the secret headers line by line.
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		index := strings.IndexByte(line, ':')
+		if index <= 0 || strings.ContainsAny(line[:index], " \t\"{") {
+			continue
+		}
+		for _, h := range secretHeaders {
+			if strings.EqualFold(line[:index], h) {
+				lines[i] = line[:index] + ": " + Redacted
+				if strings.HasSuffix(line, "\r") {
+					lines[i] += "\r"
+				}
+				break
+			}
+		}
+	}
+	s = strings.Join(lines, "\n")
+
+	// Redact the secret fields of JSON and the form.
+	s = jsonFieldRE.ReplaceAllStringFunc(s, func(m string) string {
+		sub := jsonFieldRE.FindStringSubmatch(m)
+		if !secretFieldRE.MatchString(sub[1]) {
+			return m
+		}
+		return `"` + sub[1] + `"` + sub[2] + `"` + Redacted + `"`
+	})
+	s = formFieldRE.ReplaceAllStringFunc(s, func(m string) string {
+		sub := formFieldRE.FindStringSubmatch(m)
+		if !secretFieldRE.MatchString(sub[2]) {
+			return m
+		}
+		return sub[1] + sub[2] + "=" + Redacted
+	})
+
+	return []byte(s)
+}
+
+// maxPayloadLog is the maximum size of the logged request or response.
+const maxPayloadLog = 64 << 10
+
+// Log logs the payload, such as "request" or "response", with the redaction.
+// The payload larger than 64KB is truncated.
+func (pl PayloadLog) Log(kind string, payload []byte) {
+	payload = bytes.TrimSpace(RedactPayload(payload, pl.Values))
+	if len(payload) > maxPayloadLog {
+		payload = append(payload[:maxPayloadLog:maxPayloadLog], "...(truncated)"...)
+	}
+	pl.Logf("%s: %s:\n%s", pl.Provider, kind, payload)
+}
+
+// logPayload sends the request by the transport, and logs the request
+// and the response with the redaction.
+func logPayload(pl PayloadLog, rt http.RoundTripper, req *http.Request) (*http.Response, error) {
+	if dump, err := httputil.DumpRequestOut(req, true); err != nil {
+		pl.Logf("%s: failed to dump the request: %s", pl.Provider, err)
+	} else {
+		pl.Log("request", dump)
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		pl.Logf("%s: failed to send the request: %s", pl.Provider, err)
+		return nil, err
+	}
+
+	// DumpResponse replaces the body by the buffered one.
+	if dump, err := httputil.DumpResponse(resp, true); err != nil {
+		pl.Logf("%s: failed to dump the response: %s", pl.Provider, err)
+	} else {
+		pl.Log("response", dump)
+	}
+	return resp, nil
+}
@@ -0,0 +1,63 @@
+package messageapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xgfone/messageapi"
+	"github.com/xgfone/messageapi/testutil"
+)
+
+func TestPlainEmail(t *testing.T) {
+	s := testutil.NewSMTPServer()
+	defer s.Close()
+	s.SetAuth("username", "password")
+
+	email := messageapi.NewEmail("plain")
+	config := s.Config("sender@example.com")
+	config["tls"] = "none"
+	if err := email.Load(config); err != nil {
+		t.Fatal(err)
+	}
+
+	to := []string{"someone@example.com"}
+	if err := email.SendEmail(context.Background(), to, "subject", "content", nil); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := s.Wait(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	} else if m := messages[0]; m.Username != "username" || m.From != "sender@example.com" ||
+		len(m.To) != 1 || m.To[0] != to[0] || m.Subject() != "subject" {
+		t.Errorf("unexpected email: %+v", m)
+	}
+
+	s.Reset()
+	s.FailNext(1, 451, "Try again later")
+	if err := email.SendEmail(context.Background(), to, "subject", "content", nil); err == nil {
+		t.Errorf("expect an error of 451")
+	} else if messageapi.IsPermanent(err) {
+		t.Errorf("expect the temporary error of 451, but got: %s", err)
+	}
+
+	s.FailNext(1, 550, "No such user")
+	if err := email.SendEmail(context.Background(), to, "subject", "content", nil); err == nil {
+		t.Errorf("expect an error of 550")
+	} else if !messageapi.IsPermanent(err) {
+		t.Errorf("expect the permanent error of 550, but got: %s", err)
+	}
+
+	if err := email.SendEmail(context.Background(), to, "subject", "content", nil); err != nil {
+		t.Errorf("failed to send after the failures: %s", err)
+	} else if messages := s.Messages(); len(messages) != 1 {
+		t.Errorf("expect 1 email, but got %d", len(messages))
+	}
+
+	config["password"] = "wrong"
+	if err := email.Load(config); err != nil {
+		t.Fatal(err)
+	} else if err := email.SendEmail(context.Background(), to, "subject", "content", nil); err == nil {
+		t.Errorf("expect an error of the wrong credentials")
+	}
+}
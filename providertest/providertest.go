@@ -0,0 +1,315 @@
+// Package providertest is the conformance suite of the HTTP providers, which
+// the authors of the third-party providers run against their implementations
+// in the tests:
+//
+//	func TestProvider(t *testing.T) {
+//	    providertest.TestMessenger(t, new(provider), providertest.Options{
+//	        Config: func(url string) map[string]string {
+//	            return map[string]string{"url": url, "token": "token"}
+//	        },
+//	    })
+//	}
+//
+// The provider is configured to send the requests to the fake vendor, the
+// URL of which is passed to Options.Config, and it is checked that:
+//
+//   - Load is idempotent, rejects the invalid configurations, and does not
+//     modify the configuration.
+//   - Load and the send method are safe to be called concurrently, which had
+//     better be run with the race detector, such as "go test -race".
+//   - The send method returns soon after the context is cancelled.
+//   - The errors are classified, that's, the 4xx responses are permanent, the
+//     5xx and 429 ones are temporary, and "Retry-After" is honored,
+//     see messageapi.Error.
+package providertest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xgfone/messageapi"
+)
+
+// Options is the options of the conformance suite.
+type Options struct {
+	// Config returns the valid configuration of the provider, which sends
+	// the requests to the fake vendor by the url. It is required.
+	Config func(url string) map[string]string
+
+	// The configurations which Load must reject. If empty and the provider
+	// implements messageapi.ConfigSchema, they are the valid configuration
+	// without each of the required options.
+	InvalidConfigs []map[string]string
+
+	// Succeed writes the successful response of the fake vendor, which
+	// responds with 200 and "{}" by default.
+	Succeed http.HandlerFunc
+
+	// Fail writes the failed response of the fake vendor with the status
+	// code, which responds with the status code and the JSON like
+	// {"error": "providertest"} by default.
+	Fail func(w http.ResponseWriter, r *http.Request, status int)
+
+	// The number of the goroutines to call Load and send concurrently,
+	// which is 8 by default.
+	Concurrency int
+
+	// The maximum duration for the send method to return after the context
+	// is cancelled, which is 2s by default.
+	CancelTimeout time.Duration
+}
+
+// TestSMS runs the conformance suite of the SMS provider.
+func TestSMS(t *testing.T, p messageapi.SMS, o Options) {
+	run(t, p, o, func(ctx context.Context) error {
+		return p.SendSMS(ctx, "+14155550100", "providertest")
+	})
+}
+
+// TestMMS runs the conformance suite of the MMS provider.
+func TestMMS(t *testing.T, p messageapi.MMS, o Options) {
+	media := []messageapi.Media{{URL: "https://example.com/image.jpg", ContentType: "image/jpeg"}}
+	run(t, p, o, func(ctx context.Context) error {
+		return p.SendMMS(ctx, "+14155550100", "providertest", media)
+	})
+}
+
+// TestEmail runs the conformance suite of the email provider by the HTTP API.
+func TestEmail(t *testing.T, p messageapi.Email, o Options) {
+	run(t, p, o, func(ctx context.Context) error {
+		return p.SendEmail(ctx, []string{"someone@example.com"}, "providertest", "providertest", nil)
+	})
+}
+
+// TestMessenger runs the conformance suite of the messenger provider.
+func TestMessenger(t *testing.T, p messageapi.Messenger, o Options) {
+	run(t, p, o, func(ctx context.Context) error {
+		return p.SendMessage(ctx, messageapi.Message{To: "providertest",
+			Title: "providertest", Content: "providertest"})
+	})
+}
+
+// The modes of the fake vendor.
+const (
+	modeSucceed int32 = iota
+	modeFail
+	modeHang
+)
+
+// vendor is the fake vendor, which succeeds, fails with the status code,
+// or hangs until the request is cancelled.
+type vendor struct {
+	*httptest.Server
+	options  Options
+	mode     int32
+	status   int32
+	requests int64
+	release  chan struct{}
+}
+
+func newVendor(o Options) *vendor {
+	v := &vendor{options: o, release: make(chan struct{})}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serve))
+	return v
+}
+
+func (v *vendor) serve(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&v.requests, 1)
+	switch atomic.LoadInt32(&v.mode) {
+	case modeHang:
+		select {
+		case <-r.Context().Done():
+		case <-v.release:
+		}
+	case modeFail:
+		status := int(atomic.LoadInt32(&v.status))
+		if v.options.Fail != nil {
+			v.options.Fail(w, r, status)
+			return
+		}
+		if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "7")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":"providertest"}`))
+	default:
+		if v.options.Succeed != nil {
+			v.options.Succeed(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}
+}
+
+func (v *vendor) setMode(mode int32, status int) {
+	atomic.StoreInt32(&v.status, int32(status))
+	atomic.StoreInt32(&v.mode, mode)
+}
+
+func (v *vendor) close() {
+	close(v.release)
+	v.Close()
+}
+
+func copyConfig(config map[string]string) map[string]string {
+	_config := make(map[string]string, len(config))
+	for k, v := range config {
+		_config[k] = v
+	}
+	return _config
+}
+
+func run(t *testing.T, p messageapi.Config, o Options, send func(context.Context) error) {
+	if o.Config == nil {
+		t.Fatal("providertest: Options.Config is required")
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 8
+	}
+	if o.CancelTimeout <= 0 {
+		o.CancelTimeout = 2 * time.Second
+	}
+
+	v := newVendor(o)
+	defer v.close()
+	config := o.Config(v.URL)
+
+	load := func(t *testing.T) {
+		if err := p.Load(copyConfig(config)); err != nil {
+			t.Fatalf("failed to load the valid configuration: %s", err)
+		}
+	}
+
+	t.Run("Load", func(t *testing.T) {
+		_config := copyConfig(config)
+		for i := 0; i < 2; i++ {
+			if err := p.Load(_config); err != nil {
+				t.Fatalf("failed to load the configuration at the %d time: %s", i+1, err)
+			}
+		}
+		if !reflect.DeepEqual(_config, config) {
+			t.Errorf("Load modifies the configuration: %v", _config)
+		}
+
+		v.setMode(modeSucceed, 0)
+		if err := send(context.Background()); err != nil {
+			t.Errorf("failed to send after loading the configuration twice: %s", err)
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		defer load(t)
+		for _, invalid := range invalidConfigs(p, o, config) {
+			if err := p.Load(invalid); err == nil {
+				t.Errorf("expect an error to load the invalid configuration: %v", invalid)
+			}
+		}
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		v.setMode(modeSucceed, 0)
+		requests := atomic.LoadInt64(&v.requests)
+		if err := send(context.Background()); err != nil {
+			t.Fatalf("failed to send: %s", err)
+		} else if atomic.LoadInt64(&v.requests) == requests {
+			t.Errorf("the fake vendor has received no request")
+		}
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		v.setMode(modeSucceed, 0)
+		wg := new(sync.WaitGroup)
+		errs := make(chan error, o.Concurrency*2)
+		for i := 0; i < o.Concurrency; i++ {
+			wg.Add(2)
+			go func() { defer wg.Done(); errs <- p.Load(copyConfig(config)) }()
+			go func() { defer wg.Done(); errs <- send(context.Background()) }()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Errorf("failed to load or send concurrently: %s", err)
+			}
+		}
+	})
+
+	t.Run("ContextCancel", func(t *testing.T) {
+		v.setMode(modeHang, 0)
+		defer v.setMode(modeSucceed, 0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := send(ctx); err == nil {
+			t.Errorf("expect an error to send by the cancelled context")
+		}
+
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		errc := make(chan error, 1)
+		go func() { errc <- send(ctx) }()
+
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		select {
+		case err := <-errc:
+			if err == nil {
+				t.Errorf("expect an error after the context is cancelled")
+			}
+		case <-time.After(o.CancelTimeout):
+			t.Errorf("not return in %s after the context is cancelled", o.CancelTimeout)
+		}
+	})
+
+	t.Run("ErrorClass", func(t *testing.T) {
+		defer v.setMode(modeSucceed, 0)
+		for _, status := range []int{400, 401, 403, 404} {
+			v.setMode(modeFail, status)
+			if err := send(context.Background()); err == nil {
+				t.Errorf("expect an error of the status code %d", status)
+			} else if !messageapi.IsPermanent(err) {
+				t.Errorf("expect the permanent error of the status code %d, but got: %s", status, err)
+			}
+		}
+
+		for _, status := range []int{429, 500, 502, 503} {
+			v.setMode(modeFail, status)
+			err := send(context.Background())
+			if err == nil {
+				t.Errorf("expect an error of the status code %d", status)
+			} else if messageapi.IsPermanent(err) {
+				t.Errorf("expect the temporary error of the status code %d, but got: %s", status, err)
+			} else if o.Fail == nil && (status == 429 || status == 503) &&
+				messageapi.GetRetryAfter(err) != 7*time.Second {
+				t.Errorf("expect Retry-After 7s of the status code %d, but got %s",
+					status, messageapi.GetRetryAfter(err))
+			}
+		}
+	})
+}
+
+// invalidConfigs returns Options.InvalidConfigs, or the valid configuration
+// without each of the required options in the schema.
+func invalidConfigs(p messageapi.Config, o Options, config map[string]string) []map[string]string {
+	if len(o.InvalidConfigs) > 0 {
+		return o.InvalidConfigs
+	}
+
+	var configs []map[string]string
+	for _, option := range messageapi.GetConfigSchema(p) {
+		if option.Required && option.Default == "" {
+			invalid := copyConfig(config)
+			delete(invalid, option.Name)
+			configs = append(configs, invalid)
+		}
+	}
+	return configs
+}
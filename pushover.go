@@ -0,0 +1,152 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterMessenger("pushover", new(pushover))
+}
+
+const pushoverURL = "https://api.pushover.net/1/messages.json"
+
+// pushover is the messenger provider by Pushover, the configuration options
+// of which are as follows:
+//
+//	token:      the API token of the application, which is required.
+//	user:       the default user or group key, which is used if the recipient
+//	            is empty.
+//	device:     the name of the device to send to, which is optional.
+//	timeout:    the timeout in seconds of the request, which is 30 by default.
+//	http_proxy: the proxy url of the requests, which is optional.
+//	socks5:     the SOCKS5 proxy, such as "host:1080", which is optional.
+//
+// The recipient is the user or group key. The emergency messages, that's,
+// the priority 2, are retried every 60 seconds for an hour until acknowledged.
+type pushover struct {
+	sync.Mutex
+
+	token  string
+	user   string
+	device string
+	client *http.Client
+}
+
+func (p *pushover) Load(m map[string]string) error {
+	token := m["token"]
+	if token == "" {
+		return fmt.Errorf("no the token configuration")
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.token = token
+	p.user = m["user"]
+	p.device = m["device"]
+	p.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (p *pushover) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "token", Type: OptionString, Required: true, Secret: true,
+			Description: "the API token of the application"},
+		{Name: "user", Type: OptionString,
+			Description: "the default user or group key"},
+		{Name: "device", Type: OptionString,
+			Description: "the name of the device to send to"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+func (p *pushover) SendMessage(cxt context.Context, msg Message) error {
+	p.Lock()
+	token, user, device, client := p.token, p.user, p.device, p.client
+	p.Unlock()
+
+	if msg.To != "" {
+		user = msg.To
+	}
+	if user == "" {
+		return NewError(ClassPermanent, "", "pushover: no the user key")
+	}
+
+	form := url.Values{"token": {token}, "user": {user}, "message": {msg.Content}}
+	if device != "" {
+		form.Set("device", device)
+	}
+	if msg.Title != "" {
+		form.Set("title", msg.Title)
+	}
+	if msg.URL != "" {
+		form.Set("url", msg.URL)
+	}
+	if msg.Priority != 0 {
+		priority := msg.Priority
+		if priority > 2 {
+			priority = 2
+		} else if priority < -2 {
+			priority = -2
+		}
+
+		form.Set("priority", strconv.Itoa(priority))
+		if priority == 2 {
+			form.Set("retry", "60")
+			form.Set("expire", "3600")
+		}
+	}
+
+	req, err := http.NewRequest("POST", pushoverURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(cxt)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status  int      `json:"status"`
+		Request string   `json:"request"`
+		Errors  []string `json:"errors"`
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	json.Unmarshal(body, &result)
+
+	if resp.StatusCode >= 300 || result.Status != 1 {
+		status := resp.StatusCode
+		if status < 300 {
+			status = http.StatusBadRequest
+		}
+		return httpError(status, resp.Header, "", fmt.Sprintf("pushover: %d %s",
+			resp.StatusCode, strings.Join(result.Errors, "; ")))
+	}
+
+	SetResult(cxt, ResultMessageID, result.Request)
+	return nil
+}
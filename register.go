@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"reflect"
 )
 
 // Config is the interface to load the configuration information.
@@ -29,6 +30,38 @@ type SMS interface {
 	SendSMS(cxt context.Context, phone, content string) error
 }
 
+// TemplateSMS is implemented optionally by the SMS provider which only sends
+// the approved templates, such as Aliyun Dysms, the content of which is the
+// parameters of the template rather than the text. So the content must not
+// be split or rewritten, such as by SplitSMS or StripNonGSM7.
+type TemplateSMS interface {
+	SMS
+	IsTemplateSMS() bool
+}
+
+// IsTemplateSMS reports whether the SMS provider only sends the templates,
+// see TemplateSMS.
+func IsTemplateSMS(sms SMS) bool {
+	t, ok := sms.(TemplateSMS)
+	return ok && t.IsTemplateSMS()
+}
+
+// Media is the media of the MMS.
+type Media struct {
+	// The public URL of the media, from which the carrier fetches it.
+	URL string
+
+	// The MIME type of the media, such as "image/jpeg" or "text/vcard",
+	// which is optional.
+	ContentType string
+}
+
+// MMS is the interface which the MMS provider implements.
+type MMS interface {
+	Config
+	SendMMS(cxt context.Context, phone, content string, media []Media) error
+}
+
 // Email is the interface which the email provider implements.
 type Email interface {
 	Config
@@ -38,6 +71,7 @@ type Email interface {
 
 var (
 	smses  = make(map[string]SMS)
+	mmses  = make(map[string]MMS)
 	emails = make(map[string]Email)
 )
 
@@ -51,6 +85,16 @@ func RegisterSMS(name string, sms SMS) {
 	smses[name] = sms
 }
 
+// RegisterMMS registers a MMS provider implementation.
+//
+// Notice: The plugin is a single instance in the global.
+func RegisterMMS(name string, mms MMS) {
+	if _, ok := mmses[name]; ok {
+		panic(fmt.Errorf("%s has been registered", name))
+	}
+	mmses[name] = mms
+}
+
 // RegisterEmail registers a Email provider implementation.
 //
 // Notice: The plugin is a single instance in the global.
@@ -71,6 +115,16 @@ func GetSMS(name string) SMS {
 	return nil
 }
 
+// GetMMS returns a named MMS provider.
+//
+// Return nil if there is no the mms provider named name.
+func GetMMS(name string) MMS {
+	if s, ok := mmses[name]; ok {
+		return s
+	}
+	return nil
+}
+
 // GetEmail returns a named Email provider.
 //
 // Return nil if there is no the email provider named name.
@@ -81,6 +135,46 @@ func GetEmail(name string) Email {
 	return nil
 }
 
+// newInstance returns a new zero instance of the type of the registered
+// provider, which is the provider itself if it is not a pointer.
+func newInstance(provider interface{}) interface{} {
+	if v := reflect.ValueOf(provider); v.Kind() == reflect.Ptr {
+		return reflect.New(v.Elem().Type()).Interface()
+	}
+	return provider
+}
+
+// NewSMS returns a new instance of the SMS provider named name, which is
+// loaded apart from the registered one, such as by the tenant.
+//
+// Return nil if there is no the sms provider named name.
+func NewSMS(name string) SMS {
+	if s := GetSMS(name); s != nil {
+		return newInstance(s).(SMS)
+	}
+	return nil
+}
+
+// NewMMS returns a new instance of the MMS provider named name, like NewSMS.
+//
+// Return nil if there is no the mms provider named name.
+func NewMMS(name string) MMS {
+	if s := GetMMS(name); s != nil {
+		return newInstance(s).(MMS)
+	}
+	return nil
+}
+
+// NewEmail returns a new instance of the Email provider named name, like NewSMS.
+//
+// Return nil if there is no the email provider named name.
+func NewEmail(name string) Email {
+	if s := GetEmail(name); s != nil {
+		return newInstance(s).(Email)
+	}
+	return nil
+}
+
 // GetAllEmails returns all the email providers.
 func GetAllEmails() map[string]Email {
 	return emails
@@ -90,3 +184,8 @@ func GetAllEmails() map[string]Email {
 func GetAllSMSs() map[string]SMS {
 	return smses
 }
+
+// GetAllMMSes returns all the mms providers.
+func GetAllMMSes() map[string]MMS {
+	return mmses
+}
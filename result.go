@@ -0,0 +1,60 @@
+package messageapi
+
+import (
+	"context"
+	"sync"
+)
+
+// The common keys of the metadata of the result.
+const (
+	// The message id returned by the provider, such as the Twilio SID
+	// or the SES MessageId.
+	ResultMessageID = "message_id"
+
+	// The reply line of the SMTP server after the message is sent,
+	// which often contains the queue id.
+	ResultServerReply = "server_reply"
+)
+
+// Result is the result of sending a message, into which the provider may set
+// the provider-specific response data, such as the message id, so that the
+// caller can use it to debug with the vendor.
+type Result struct {
+	lock     sync.Mutex
+	metadata map[string]string
+}
+
+type resultKey struct{}
+
+// WithResult returns a new context carrying a new empty result, which is
+// passed to SendSMS or SendEmail, and the provider fills it by SetResult.
+func WithResult(ctx context.Context) (context.Context, *Result) {
+	r := &Result{metadata: make(map[string]string)}
+	return context.WithValue(ctx, resultKey{}, r), r
+}
+
+// SetResult sets the metadata of the result carried by the context.
+//
+// It does nothing if the context carries no result.
+func SetResult(ctx context.Context, key, value string) {
+	if r, ok := ctx.Value(resultKey{}).(*Result); ok {
+		r.lock.Lock()
+		r.metadata[key] = value
+		r.lock.Unlock()
+	}
+}
+
+// Metadata returns a copy of the metadata of the result.
+func (r *Result) Metadata() map[string]string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.metadata) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(r.metadata))
+	for k, v := range r.metadata {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,97 @@
+package messageapi
+
+import (
+	"context"
+	"time"
+)
+
+// Rotator is implemented optionally by the provider which uses the
+// short-lived credentials, such as the OAuth access tokens or the STS
+// temporary keys, to refresh them out-of-band before they expire, so that
+// the first message after the expiry does not fail or wait for it.
+//
+// Rotate is called periodically by RunRotation, concurrently with sending
+// the messages, so it must be thread-safe. If it fails, the provider should
+// keep using the current credentials, which may be still valid.
+type Rotator interface {
+	Rotate(cxt context.Context) error
+}
+
+// The default interval and the minimum retry interval of the rotation.
+const (
+	defaultRotateInterval = 5 * time.Minute
+	minRotateRetry        = 5 * time.Second
+)
+
+// RunRotation rotates the credentials of the providers at once, then every
+// interval, which is 5m by default, until the context is done. The key of
+// providers is only used to identify the provider for onError.
+//
+// If a provider fails to rotate, onError is called if not nil, and it is
+// retried from 5s later, which is doubled by each failure until interval.
+// Each rotation is cancelled if it lasts longer than interval.
+func RunRotation(ctx context.Context, interval time.Duration,
+	providers map[string]Rotator, onError func(name string, err error)) {
+	if len(providers) == 0 {
+		return
+	} else if interval <= 0 {
+		interval = defaultRotateInterval
+	}
+
+	type state struct {
+		next     time.Time
+		failures int
+	}
+	states := make(map[string]*state, len(providers))
+	for name := range providers {
+		states[name] = new(state)
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		wake := now.Add(interval)
+		for name, provider := range providers {
+			s := states[name]
+			if now.Before(s.next) {
+				if s.next.Before(wake) {
+					wake = s.next
+				}
+				continue
+			}
+
+			cxt, cancel := context.WithTimeout(ctx, interval)
+			err := provider.Rotate(cxt)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err == nil {
+				s.failures = 0
+				s.next = time.Now().Add(interval)
+			} else {
+				retry := minRotateRetry << uint(s.failures)
+				if s.failures > 16 || retry > interval {
+					retry = interval
+				}
+				s.failures++
+				s.next = time.Now().Add(retry)
+				if onError != nil {
+					onError(name, err)
+				}
+			}
+			if s.next.Before(wake) {
+				wake = s.next
+			}
+		}
+		timer.Reset(time.Until(wake))
+	}
+}
@@ -0,0 +1,91 @@
+package messageapi
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The types of the configuration options.
+const (
+	OptionString = "string"
+	OptionInt    = "int"
+	OptionBool   = "bool"
+	OptionURL    = "url"
+)
+
+// ConfigOption describes a configuration option of the provider.
+type ConfigOption struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required,omitempty"`
+	Default     string `json:"default,omitempty"`
+	Secret      bool   `json:"secret,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// ConfigSchema is implemented optionally by the provider to describe its
+// configuration options, which are used to validate the options, fill the
+// defaults and redact the secrets automatically.
+type ConfigSchema interface {
+	ConfigSchema() []ConfigOption
+}
+
+// GetConfigSchema returns the configuration options of the provider,
+// or nil if the provider does not implement ConfigSchema.
+func GetConfigSchema(provider interface{}) []ConfigOption {
+	if s, ok := provider.(ConfigSchema); ok {
+		return s.ConfigSchema()
+	}
+	return nil
+}
+
+// timeoutOption is the common option of the timeout of the HTTP providers.
+var timeoutOption = ConfigOption{Name: "timeout", Type: OptionInt, Default: "30",
+	Description: "the timeout in seconds of the request"}
+
+// ApplySchema validates the options by the schema, and returns a copy of the
+// options, the missing ones of which are filled with the defaults.
+//
+// The options not in the schema are kept as they are.
+func ApplySchema(schema []ConfigOption, options map[string]string) (map[string]string, error) {
+	results := make(map[string]string, len(options)+len(schema))
+	for k, v := range options {
+		results[k] = v
+	}
+
+	var problems []string
+	for _, o := range schema {
+		value, ok := results[o.Name]
+		if !ok || value == "" {
+			if o.Required {
+				problems = append(problems, fmt.Sprintf("the option[%s] is required", o.Name))
+			} else if o.Default != "" {
+				results[o.Name] = o.Default
+			}
+			continue
+		}
+
+		var err error
+		switch o.Type {
+		case OptionInt:
+			_, err = strconv.Atoi(value)
+		case OptionBool:
+			_, err = strconv.ParseBool(value)
+		case OptionURL:
+			var u *url.URL
+			if u, err = url.Parse(value); err == nil && (u.Scheme == "" || u.Host == "") {
+				err = fmt.Errorf("not absolute")
+			}
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("the option[%s] is not a valid %s", o.Name, o.Type))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return results, nil
+}
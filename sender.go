@@ -0,0 +1,407 @@
+package messageapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SenderConfig is the configuration of Sender.
+type SenderConfig struct {
+	// The options of the email and sms providers. The key is the name of the
+	// registered provider, which is loaded with the options.
+	Emails map[string]map[string]string
+	SMSes  map[string]map[string]string
+
+	// The default provider, or the comma-separated providers as a chain,
+	// which is used when the provider is not given when sending.
+	// The default email provider is "plain".
+	DefaultEmail string
+	DefaultSMS   string
+
+	// Retry to send the message by the single provider for N times
+	// if failed with the non-permanent error.
+	Retry int
+
+	// The rate limits of the providers, which is the number of the messages
+	// per second. The key is "CHANNEL:PROVIDER", such as "sms:twilio".
+	RateLimits map[string]float64
+
+	// The maximum duration to wait for the rate limit, which is 10s by default.
+	//
+	// When the provider asks to retry later, such as by the header "Retry-After"
+	// of the response 429, the provider is paused until then. If it is longer
+	// than RateLimitWait, the message is sent by the next provider in the chain
+	// instead of waiting, or it fails with the temporary error.
+	RateLimitWait time.Duration
+
+	// The hooks called after each attempt to send the message.
+	Hooks []SendHook
+
+	// The interval to rotate the credentials of the providers implementing
+	// Rotator, which is 5m by default, see RunRotation. The failures of the
+	// rotation are passed to RotateError if not nil.
+	RotateInterval time.Duration
+	RotateError    func(provider string, err error)
+
+	// The number of the workers and the size of the queue to send the
+	// messages by SendAsync, which are 4 and 1024 by default.
+	Workers   int
+	QueueSize int
+}
+
+// SendEvent is the event of an attempt to send the message.
+type SendEvent struct {
+	Channel    string // "email" or "sms"
+	Provider   string
+	Recipients []string
+	Attempt    int
+	Latency    time.Duration
+	Metadata   map[string]string
+	Err        error
+}
+
+// SendHook is called after each attempt to send the message,
+// such as for logging or metrics.
+type SendHook func(SendEvent)
+
+// SendResult is the result of sending the message.
+type SendResult struct {
+	// The provider which sent the message.
+	Provider string
+
+	// The provider-specific response data, see Result.
+	Metadata map[string]string
+}
+
+// Sender is the high-level sender to send the emails and the sms by the
+// providers in the Go application directly, which supports the chains of
+// the providers, the retries, the rate limits and the hooks, like the app.
+//
+// Notice: The providers are the registered single instances, so they should
+// not be loaded with the different options by the other senders or the app.
+type Sender struct {
+	config SenderConfig
+	emails map[string]Email
+	smses  map[string]SMS
+
+	lock      sync.Mutex
+	limiters  map[string]*rateLimiter
+	throttles map[string]time.Time // The provider is paused until the time.
+
+	stopRotation context.CancelFunc
+
+	start  sync.Once
+	qlock  sync.RWMutex
+	closed bool
+	queue  chan asyncTask
+	wg     sync.WaitGroup
+}
+
+// NewSender returns a new Sender, which loads the providers by the config.
+func NewSender(c SenderConfig) (*Sender, error) {
+	s := &Sender{
+		config:   c,
+		emails:   make(map[string]Email, len(c.Emails)),
+		smses:    make(map[string]SMS, len(c.SMSes)),
+		limiters: make(map[string]*rateLimiter, len(c.RateLimits)),
+
+		throttles: make(map[string]time.Time),
+	}
+	if s.config.DefaultEmail == "" {
+		s.config.DefaultEmail = "plain"
+	}
+	if s.config.RateLimitWait <= 0 {
+		s.config.RateLimitWait = 10 * time.Second
+	}
+	if s.config.Workers <= 0 {
+		s.config.Workers = 4
+	}
+	if s.config.QueueSize <= 0 {
+		s.config.QueueSize = 1024
+	}
+
+	for name, options := range c.Emails {
+		provider := GetEmail(name)
+		if provider == nil {
+			return nil, fmt.Errorf("have no the email provider[%s]", name)
+		} else if err := loadProvider(provider, options); err != nil {
+			return nil, fmt.Errorf("failed to load the email provider[%s]: %s", name, err)
+		}
+		s.emails[name] = provider
+	}
+
+	for name, options := range c.SMSes {
+		provider := GetSMS(name)
+		if provider == nil {
+			return nil, fmt.Errorf("have no the sms provider[%s]", name)
+		} else if err := loadProvider(provider, options); err != nil {
+			return nil, fmt.Errorf("failed to load the sms provider[%s]: %s", name, err)
+		}
+		s.smses[name] = provider
+	}
+
+	for key, rate := range c.RateLimits {
+		if rate > 0 {
+			s.limiters[key] = newRateLimiter(rate)
+		}
+	}
+
+	rotators := make(map[string]Rotator)
+	for name, provider := range s.emails {
+		if r, ok := provider.(Rotator); ok {
+			rotators["email:"+name] = r
+		}
+	}
+	for name, provider := range s.smses {
+		if r, ok := provider.(Rotator); ok {
+			rotators["sms:"+name] = r
+		}
+	}
+	if len(rotators) > 0 {
+		var ctx context.Context
+		ctx, s.stopRotation = context.WithCancel(context.Background())
+		go RunRotation(ctx, c.RotateInterval, rotators, c.RotateError)
+	}
+	return s, nil
+}
+
+func loadProvider(provider Config, options map[string]string) error {
+	options, err := ApplySchema(GetConfigSchema(provider), options)
+	if err != nil {
+		return err
+	}
+	return provider.Load(options)
+}
+
+// splitProviders returns the names of the providers, which is "all",
+// a provider name, or the comma-separated names of the providers as a chain.
+func splitProviders(name string, configured []string) (names []string, chain bool) {
+	if name == "all" {
+		sort.Strings(configured)
+		return configured, true
+	}
+
+	names = strings.Split(name, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	return names, len(names) > 1
+}
+
+// SendEmail sends the email by the provider, which is "all", a provider name,
+// or the comma-separated names as a chain tried in order. If it is empty,
+// use the default provider.
+//
+// The attachments are the same as Email.SendEmail.
+func (s *Sender) SendEmail(ctx context.Context, provider string, to []string,
+	subject, content string, attachments map[string]io.Reader) (SendResult, error) {
+	files, err := readAttachments(attachments)
+	if err != nil {
+		return SendResult{}, err
+	}
+
+	if provider == "" {
+		provider = s.config.DefaultEmail
+	}
+	configured := make([]string, 0, len(s.emails))
+	for name := range s.emails {
+		configured = append(configured, name)
+	}
+
+	names, chain := splitProviders(provider, configured)
+	return s.send(ctx, "email", names, chain, to, func(cxt context.Context, name string) error {
+		email, ok := s.emails[name]
+		if !ok {
+			return NewError(ClassPermanent, "", "have no the email provider["+name+"]")
+		}
+
+		readers := make(map[string]io.Reader, len(files))
+		for name, data := range files {
+			readers[name] = bytes.NewReader(data)
+		}
+		return email.SendEmail(cxt, to, subject, content, readers)
+	})
+}
+
+// SendSMS sends the sms by the provider like SendEmail.
+func (s *Sender) SendSMS(ctx context.Context, provider, phone, content string) (SendResult, error) {
+	if provider == "" {
+		provider = s.config.DefaultSMS
+	}
+	configured := make([]string, 0, len(s.smses))
+	for name := range s.smses {
+		configured = append(configured, name)
+	}
+
+	names, chain := splitProviders(provider, configured)
+	return s.send(ctx, "sms", names, chain, []string{phone}, func(cxt context.Context, name string) error {
+		sms, ok := s.smses[name]
+		if !ok {
+			return NewError(ClassPermanent, "", "have no the sms provider["+name+"]")
+		}
+		return sms.SendSMS(cxt, phone, content)
+	})
+}
+
+// send sends the message by the providers in order for the chain,
+// or by the single provider with the retries.
+func (s *Sender) send(ctx context.Context, channel string, names []string, chain bool,
+	recipients []string, send func(context.Context, string) error) (result SendResult, err error) {
+	if len(names) == 0 || names[0] == "" {
+		return result, fmt.Errorf("have no the %s provider", channel)
+	}
+
+	retry := s.config.Retry
+	if chain {
+		retry = 0
+	}
+
+	for _, name := range names {
+		result.Provider = name
+		for attempt := 0; ; attempt++ {
+			if err = s.waitRateLimit(ctx, channel, name); err == nil {
+				cxt, r := WithResult(ctx)
+				start := time.Now()
+				err = send(cxt, name)
+				result.Metadata = r.Metadata()
+				s.runHooks(SendEvent{Channel: channel, Provider: name, Recipients: recipients,
+					Attempt: attempt, Latency: time.Since(start), Metadata: result.Metadata, Err: err})
+			}
+
+			if err == nil {
+				return
+			}
+
+			retryAfter := GetRetryAfter(err)
+			if retryAfter > 0 {
+				s.throttle(channel+":"+name, retryAfter)
+			}
+			if attempt >= retry || IsPermanent(err) || ctx.Err() != nil ||
+				retryAfter > s.config.RateLimitWait {
+				break
+			}
+
+			backoff := (500 * time.Millisecond) << uint(attempt)
+			if attempt > 3 {
+				backoff = 5 * time.Second
+			}
+			if retryAfter > 0 {
+				// waitRateLimit waits until the provider is not paused.
+				backoff = 0
+			}
+			if err = sleep(ctx, backoff); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (s *Sender) runHooks(e SendEvent) {
+	for _, hook := range s.config.Hooks {
+		hook(e)
+	}
+}
+
+// throttle pauses the provider for the duration asked by the provider.
+func (s *Sender) throttle(key string, d time.Duration) {
+	until := time.Now().Add(d)
+	s.lock.Lock()
+	if until.After(s.throttles[key]) {
+		s.throttles[key] = until
+	}
+	s.lock.Unlock()
+}
+
+// waitRateLimit waits until the provider is allowed to send by the rate limit,
+// or returns a temporary error if it needs to wait too long.
+func (s *Sender) waitRateLimit(ctx context.Context, channel, name string) error {
+	key := channel + ":" + name
+	s.lock.Lock()
+	limiter := s.limiters[key]
+	until, throttled := s.throttles[key]
+	if throttled && !time.Now().Before(until) {
+		delete(s.throttles, key)
+		throttled = false
+	}
+	s.lock.Unlock()
+
+	if throttled {
+		wait := time.Until(until)
+		if wait > s.config.RateLimitWait {
+			e := NewError(ClassTemporary, "", "the provider "+key+" is throttled")
+			e.RetryAfter = wait
+			return e
+		} else if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	if limiter == nil {
+		return nil
+	}
+
+	wait, ok := limiter.reserve(s.config.RateLimitWait)
+	if !ok {
+		return NewError(ClassTemporary, "", "the provider "+key+" is rate limited")
+	}
+	return sleep(ctx, wait)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimiter is a token bucket to limit the rate of sending the messages.
+type rateLimiter struct {
+	sync.Mutex
+	rate   float64 // The number of the tokens per second.
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := math.Max(1, math.Ceil(rate))
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes a token and returns the duration to wait until it is
+// available. If the duration exceeds maxWait, no token is taken and
+// return false.
+func (l *rateLimiter) reserve(maxWait time.Duration) (time.Duration, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	var wait time.Duration
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		if wait > maxWait {
+			return wait, false
+		}
+	}
+	l.tokens--
+	return wait, true
+}
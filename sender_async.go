@@ -0,0 +1,127 @@
+package messageapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrSenderClosed is returned by SendAsync when the sender has been closed.
+var ErrSenderClosed = errors.New("the sender has been closed")
+
+// SendMessage is the message sent by Sender.SendAsync.
+type SendMessage struct {
+	Channel  string // "email" or "sms"
+	Provider string // The same as the provider of Sender.SendEmail.
+
+	// The recipients. For "sms", it must be only one phone.
+	To []string
+
+	Subject     string // Only for "email"
+	Content     string
+	Attachments map[string]io.Reader // Only for "email"
+}
+
+// AsyncResult is the result of the message sent by Sender.SendAsync.
+type AsyncResult struct {
+	SendResult
+	Err error
+}
+
+type asyncTask struct {
+	ctx    context.Context
+	msg    SendMessage
+	result chan AsyncResult
+}
+
+// SendAsync queues the message to be sent by the worker pool, and returns
+// the channel to receive the result only once, which has the same retries
+// and chains as SendEmail and SendSMS.
+//
+// It returns a temporary error if the queue is full, and ErrSenderClosed
+// if the sender has been closed. The attachments are read by the worker,
+// so they must not be closed before the result is received.
+func (s *Sender) SendAsync(ctx context.Context, msg SendMessage) (<-chan AsyncResult, error) {
+	switch msg.Channel {
+	case "email":
+		if len(msg.To) == 0 {
+			return nil, fmt.Errorf("no the recipients")
+		}
+	case "sms":
+		if len(msg.To) != 1 {
+			return nil, fmt.Errorf("the sms must have only one phone")
+		}
+	default:
+		return nil, fmt.Errorf("unknown the channel '%s'", msg.Channel)
+	}
+
+	s.start.Do(s.startWorkers)
+
+	s.qlock.RLock()
+	defer s.qlock.RUnlock()
+	if s.closed {
+		return nil, ErrSenderClosed
+	}
+
+	task := asyncTask{ctx: ctx, msg: msg, result: make(chan AsyncResult, 1)}
+	select {
+	case s.queue <- task:
+		return task.result, nil
+	default:
+		return nil, NewError(ClassTemporary, "", "the queue of the sender is full")
+	}
+}
+
+// SendCallback is the same as SendAsync, but calls the callback with the
+// result in a new goroutine instead of returning the channel.
+func (s *Sender) SendCallback(ctx context.Context, msg SendMessage, callback func(AsyncResult)) error {
+	result, err := s.SendAsync(ctx, msg)
+	if err == nil {
+		go func() { callback(<-result) }()
+	}
+	return err
+}
+
+// Close stops accepting the messages by SendAsync and rotating the
+// credentials, and waits for the queued messages to be sent.
+func (s *Sender) Close() {
+	s.start.Do(s.startWorkers)
+	if s.stopRotation != nil {
+		s.stopRotation()
+	}
+
+	s.qlock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.qlock.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Sender) startWorkers() {
+	s.queue = make(chan asyncTask, s.config.QueueSize)
+	for i := 0; i < s.config.Workers; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
+}
+
+func (s *Sender) work() {
+	defer s.wg.Done()
+	for task := range s.queue {
+		var r AsyncResult
+		msg := task.msg
+		if task.ctx.Err() != nil {
+			r.Err = task.ctx.Err()
+		} else if msg.Channel == "email" {
+			r.SendResult, r.Err = s.SendEmail(task.ctx, msg.Provider, msg.To,
+				msg.Subject, msg.Content, msg.Attachments)
+		} else {
+			r.SendResult, r.Err = s.SendSMS(task.ctx, msg.Provider, msg.To[0], msg.Content)
+		}
+		task.result <- r
+		close(task.result)
+	}
+}
@@ -0,0 +1,134 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+func init() {
+	RegisterMessenger("serverchan", new(serverChan))
+}
+
+// serverChan is the messenger provider by ServerChan, which pushes the
+// message to WeChat, the configuration options of which are as follows:
+//
+//	send_key:   the default SendKey, which is used if the recipient is empty.
+//	timeout:    the timeout in seconds of the request, which is 30 by default.
+//	http_proxy: the proxy url of the requests, which is optional.
+//	socks5:     the SOCKS5 proxy, such as "host:1080", which is optional.
+//
+// The recipient is the SendKey. The content is in Markdown.
+type serverChan struct {
+	sync.Mutex
+
+	sendKey string
+	client  *http.Client
+}
+
+func (s *serverChan) Load(m map[string]string) error {
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.sendKey = m["send_key"]
+	s.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (s *serverChan) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "send_key", Type: OptionString, Secret: true,
+			Description: "the default SendKey"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+// serverChan3Key matches the SendKey of ServerChan3, such as "sctp123tABC",
+// the number of which is the uid.
+var serverChan3Key = regexp.MustCompile(`^sctp(\d+)t`)
+
+func serverChanURL(sendKey string) string {
+	if m := serverChan3Key.FindStringSubmatch(sendKey); m != nil {
+		return fmt.Sprintf("https://%s.push.ft07.com/send/%s.send", m[1], sendKey)
+	}
+	return "https://sctapi.ftqq.com/" + sendKey + ".send"
+}
+
+func (s *serverChan) SendMessage(cxt context.Context, msg Message) error {
+	s.Lock()
+	sendKey, client := s.sendKey, s.client
+	s.Unlock()
+
+	if msg.To != "" {
+		sendKey = msg.To
+	}
+	if sendKey == "" {
+		return NewError(ClassPermanent, "", "serverchan: no the send key")
+	}
+
+	// The title is required, so use the beginning of the content if empty.
+	title := msg.Title
+	if title == "" {
+		title = msg.Content
+		if utf8.RuneCountInString(title) > 32 {
+			title = string([]rune(title)[:32])
+		}
+	}
+
+	form := url.Values{"title": {title}, "desp": {msg.Content}}
+	req, err := http.NewRequest("POST", serverChanURL(sendKey),
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(cxt)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			PushID json.RawMessage `json:"pushid"`
+		} `json:"data"`
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	json.Unmarshal(body, &result)
+
+	if resp.StatusCode >= 300 || result.Code != 0 {
+		status := resp.StatusCode
+		if status < 300 {
+			status = http.StatusBadRequest
+		}
+		return httpError(status, resp.Header, strconv.Itoa(result.Code),
+			fmt.Sprintf("serverchan: %d %s", resp.StatusCode, result.Message))
+	}
+
+	SetResult(cxt, ResultMessageID, strings.Trim(string(result.Data.PushID), `"`))
+	return nil
+}
@@ -0,0 +1,73 @@
+package messageapi_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/xgfone/messageapi"
+	"github.com/xgfone/messageapi/providertest"
+)
+
+func TestTwilio(t *testing.T) {
+	options := providertest.Options{
+		Config: fixedURL(t, map[string]string{
+			"account_sid": "AC123",
+			"auth_token":  "token",
+			"from":        "+14155550199",
+		}),
+		InvalidConfigs: []map[string]string{
+			{"auth_token": "token", "from": "+14155550199"},
+			{"account_sid": "AC123", "from": "+14155550199"},
+			{"account_sid": "AC123", "auth_token": "token"},
+		},
+		Succeed: succeed(`{"sid":"SM123"}`),
+	}
+	providertest.TestSMS(t, messageapi.NewSMS("twilio"), options)
+	providertest.TestMMS(t, messageapi.NewMMS("twilio"), options)
+}
+
+func TestVonage(t *testing.T) {
+	providertest.TestSMS(t, messageapi.NewSMS("vonage"), providertest.Options{
+		Config: func(url string) map[string]string {
+			return map[string]string{
+				"api_key":    "key",
+				"api_secret": "secret",
+				"from":       "messageapi",
+				"endpoint":   url,
+			}
+		},
+		Succeed: succeed(`{"message_uuid":"aaaaaaaa-bbbb-cccc-dddd-0123456789ab"}`),
+	})
+}
+
+func TestAliyun(t *testing.T) {
+	providertest.TestSMS(t, messageapi.NewSMS("aliyun"), providertest.Options{
+		Config: func(url string) map[string]string {
+			return map[string]string{
+				"access_key_id":     "key",
+				"access_key_secret": "secret",
+				"sign_name":         "messageapi",
+				"template_code":     "SMS_123456789",
+				"endpoint":          url,
+			}
+		},
+		Succeed: succeed(`{"Code":"OK","BizId":"123^0"}`),
+	})
+}
+
+func TestSNS(t *testing.T) {
+	providertest.TestSMS(t, messageapi.NewSMS("sns"), providertest.Options{
+		Config: func(url string) map[string]string {
+			return map[string]string{
+				"region":            "us-east-1",
+				"access_key_id":     "key",
+				"secret_access_key": "secret",
+				"endpoint":          url,
+			}
+		},
+		Succeed: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/xml")
+			w.Write([]byte(`<PublishResponse><PublishResult><MessageId>123</MessageId></PublishResult></PublishResponse>`))
+		},
+	})
+}
@@ -0,0 +1,555 @@
+// Package smtpd implements a minimal SMTP server, which receives the emails
+// and passes them to the handler.
+//
+// It supports the commands HELO, EHLO, AUTH (PLAIN and LOGIN), STARTTLS,
+// MAIL, RCPT, DATA, RSET, NOOP, VRFY and QUIT, which are enough for the
+// applications that send the emails by SMTP.
+package smtpd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve after the server is closed.
+var ErrServerClosed = errors.New("smtpd: server closed")
+
+// errLineTooLong is returned by reading the line longer than MaxLineLength.
+var errLineTooLong = errors.New("smtpd: line too long")
+
+// Envelope is the received email.
+type Envelope struct {
+	RemoteAddr net.Addr
+	Username   string // The authenticated user, or empty.
+	From       string
+	To         []string
+	Data       []byte // The raw message, including the headers.
+}
+
+// Error is the error with the SMTP reply code returned by the handler
+// or the auth function, which is replied to the client.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e Error) Error() string { return fmt.Sprintf("%d %s", e.Code, e.Message) }
+
+// Handler handles the received email.
+//
+// If it returns an Error, the code and the message are replied to the client.
+// Or, other errors are replied by 554.
+type Handler func(*Envelope) error
+
+// Server is a SMTP server.
+type Server struct {
+	Addr     string // The default is ":25".
+	Hostname string // The default is os.Hostname().
+
+	// Handler handles the received emails, which must be set.
+	Handler Handler
+
+	// If set, the client must authenticate before sending the email.
+	Auth func(username, password string) error
+
+	// If set, STARTTLS is supported, and the authentication is allowed
+	// only after STARTTLS if AllowInsecureAuth is false.
+	TLSConfig         *tls.Config
+	AllowInsecureAuth bool
+
+	// The maximum size of the message. The default is 10MB.
+	MaxSize int64
+
+	// The maximum number of the recipients of a message. The default is 100.
+	MaxRecipients int
+
+	// The maximum length of a line, including the commands and the lines of
+	// the message, beyond which the connection is closed. The default is 64KB.
+	MaxLineLength int
+
+	// The timeout of each command, which is refreshed by each read of the
+	// message, so that the large message is not cut off by it, but the idle
+	// connection is. The default is 5m.
+	Timeout time.Duration
+
+	lock      sync.Mutex
+	listeners map[net.Listener]struct{}
+	closed    bool
+}
+
+// ListenAndServe listens on the TCP address and serves the clients.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":25"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts the connections on the listener and serves them.
+func (s *Server) Serve(l net.Listener) error {
+	if s.Handler == nil {
+		return errors.New("smtpd: no handler")
+	}
+
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.listeners, l)
+		s.lock.Unlock()
+		l.Close()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serve(c)
+	}
+}
+
+// Close closes all the listeners. The established sessions are not closed.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	return nil
+}
+
+func (s *Server) hostname() string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+	if name, err := os.Hostname(); err == nil {
+		return name
+	}
+	return "localhost"
+}
+
+type session struct {
+	s    *Server
+	conn net.Conn
+	text *textproto.Conn
+	tls  bool
+
+	helo     string
+	username string
+	from     string
+	to       []string
+	hasFrom  bool
+}
+
+// lineConn is the connection, which refreshes the read deadline before each
+// read, and fails when a line is longer than the maximum length.
+type lineConn struct {
+	net.Conn
+	s    *Server
+	line int // The length of the current line.
+	err  error
+}
+
+func (c *lineConn) Read(p []byte) (n int, err error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	c.SetReadDeadline(time.Now().Add(c.s.timeout()))
+	n, err = c.Conn.Read(p)
+
+	max := c.s.MaxLineLength
+	if max <= 0 {
+		max = 64 * 1024
+	}
+	for _, b := range p[:n] {
+		if b == '\n' {
+			c.line = 0
+		} else if c.line++; c.line > max {
+			// Discard the read data so that the line is never returned.
+			c.err = errLineTooLong
+			return 0, c.err
+		}
+	}
+	return
+}
+
+func (s *Server) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return 5 * time.Minute
+}
+
+func (s *Server) serve(c net.Conn) {
+	ss := &session{s: s, conn: c, text: textproto.NewConn(&lineConn{Conn: c, s: s})}
+	defer ss.text.Close()
+	_, ss.tls = c.(*tls.Conn)
+	ss.serve()
+}
+
+func (ss *session) reply(code int, format string, args ...interface{}) error {
+	ss.setDeadline()
+	return ss.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+func (ss *session) replyLines(code int, lines []string) error {
+	ss.setDeadline()
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		if err := ss.text.PrintfLine("%d%s%s", code, sep, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *session) replyError(err error) error {
+	if e, ok := err.(Error); ok {
+		return ss.reply(e.Code, "%s", e.Message)
+	}
+	return ss.reply(554, "Transaction failed: %s", err)
+}
+
+func (ss *session) setDeadline() {
+	ss.conn.SetDeadline(time.Now().Add(ss.s.timeout()))
+}
+
+func (ss *session) reset() {
+	ss.from = ""
+	ss.to = nil
+	ss.hasFrom = false
+}
+
+func (ss *session) serve() {
+	if ss.reply(220, "%s ESMTP ready", ss.s.hostname()) != nil {
+		return
+	}
+
+	for {
+		ss.setDeadline()
+		line, err := ss.text.ReadLine()
+		if err != nil {
+			if err == errLineTooLong {
+				ss.reply(500, "Line too long")
+			}
+			return
+		}
+
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(cmd) {
+		case "HELO":
+			ss.helo = arg
+			ss.reset()
+			err = ss.reply(250, "%s", ss.s.hostname())
+		case "EHLO":
+			ss.helo = arg
+			ss.reset()
+			err = ss.replyLines(250, ss.extensions())
+		case "STARTTLS":
+			err = ss.handleStartTLS()
+		case "AUTH":
+			err = ss.handleAuth(arg)
+		case "MAIL":
+			err = ss.handleMail(arg)
+		case "RCPT":
+			err = ss.handleRcpt(arg)
+		case "DATA":
+			err = ss.handleData()
+		case "RSET":
+			ss.reset()
+			err = ss.reply(250, "OK")
+		case "NOOP":
+			err = ss.reply(250, "OK")
+		case "VRFY":
+			err = ss.reply(252, "Cannot VRFY user")
+		case "QUIT":
+			ss.reply(221, "Bye")
+			return
+		default:
+			err = ss.reply(502, "Command not implemented")
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (ss *session) maxSize() int64 {
+	if ss.s.MaxSize > 0 {
+		return ss.s.MaxSize
+	}
+	return 10 * 1024 * 1024
+}
+
+func (ss *session) extensions() []string {
+	exts := []string{ss.s.hostname(), "PIPELINING", "8BITMIME",
+		fmt.Sprintf("SIZE %d", ss.maxSize())}
+	if ss.s.TLSConfig != nil && !ss.tls {
+		exts = append(exts, "STARTTLS")
+	}
+	if ss.s.Auth != nil && ss.canAuth() {
+		exts = append(exts, "AUTH PLAIN LOGIN")
+	}
+	return exts
+}
+
+func (ss *session) canAuth() bool {
+	return ss.tls || ss.s.TLSConfig == nil || ss.s.AllowInsecureAuth
+}
+
+func (ss *session) handleStartTLS() error {
+	if ss.s.TLSConfig == nil || ss.tls {
+		return ss.reply(502, "Command not implemented")
+	}
+	if err := ss.reply(220, "Ready to start TLS"); err != nil {
+		return err
+	}
+
+	conn := tls.Server(ss.conn, ss.s.TLSConfig)
+	ss.setDeadline()
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+
+	ss.conn = conn
+	ss.text = textproto.NewConn(&lineConn{Conn: conn, s: ss.s})
+	ss.tls = true
+	ss.helo = ""
+	ss.username = ""
+	ss.reset()
+	return nil
+}
+
+func (ss *session) readAuthLine(prompt string) (string, error) {
+	if err := ss.reply(334, "%s", prompt); err != nil {
+		return "", err
+	}
+	ss.setDeadline()
+	line, err := ss.text.ReadLine()
+	if err != nil {
+		return "", err
+	} else if line == "*" {
+		return "", Error{Code: 501, Message: "Authentication cancelled"}
+	}
+	data, err := base64.StdEncoding.DecodeString(line)
+	if err != nil {
+		return "", Error{Code: 501, Message: "Invalid base64 data"}
+	}
+	return string(data), nil
+}
+
+func (ss *session) handleAuth(arg string) error {
+	if ss.s.Auth == nil {
+		return ss.reply(502, "Command not implemented")
+	} else if !ss.canAuth() {
+		return ss.reply(538, "Encryption required for requested authentication mechanism")
+	} else if ss.username != "" {
+		return ss.reply(503, "Already authenticated")
+	} else if ss.helo == "" {
+		return ss.reply(503, "Send EHLO first")
+	}
+
+	parts := strings.Fields(arg)
+	if len(parts) == 0 {
+		return ss.reply(501, "Syntax error")
+	}
+
+	var username, password string
+	switch strings.ToUpper(parts[0]) {
+	case "PLAIN":
+		var data string
+		var err error
+		if len(parts) > 1 {
+			var buf []byte
+			if buf, err = base64.StdEncoding.DecodeString(parts[1]); err != nil {
+				return ss.reply(501, "Invalid base64 data")
+			}
+			data = string(buf)
+		} else if data, err = ss.readAuthLine(""); err != nil {
+			if _, ok := err.(Error); ok {
+				return ss.replyError(err)
+			}
+			return err
+		}
+
+		fields := strings.Split(data, "\x00")
+		if len(fields) != 3 {
+			return ss.reply(501, "Invalid PLAIN data")
+		}
+		username, password = fields[1], fields[2]
+	case "LOGIN":
+		var err error
+		if username, err = ss.readAuthLine("VXNlcm5hbWU6"); err == nil {
+			password, err = ss.readAuthLine("UGFzc3dvcmQ6")
+		}
+		if err != nil {
+			if _, ok := err.(Error); ok {
+				return ss.replyError(err)
+			}
+			return err
+		}
+	default:
+		return ss.reply(504, "Unrecognized authentication type")
+	}
+
+	if err := ss.s.Auth(username, password); err != nil {
+		if _, ok := err.(Error); ok {
+			return ss.replyError(err)
+		}
+		return ss.reply(535, "Authentication credentials invalid")
+	}
+	ss.username = username
+	return ss.reply(235, "Authentication succeeded")
+}
+
+// parsePath parses the argument like "FROM:<addr> SIZE=100".
+func parsePath(prefix, arg string) (addr string, params []string, ok bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+	return arg[1:end], strings.Fields(arg[end+1:]), true
+}
+
+func (ss *session) handleMail(arg string) error {
+	if ss.helo == "" {
+		return ss.reply(503, "Send HELO or EHLO first")
+	} else if ss.s.Auth != nil && ss.username == "" {
+		return ss.reply(530, "Authentication required")
+	} else if ss.hasFrom {
+		return ss.reply(503, "Sender already specified")
+	}
+
+	from, params, ok := parsePath("FROM:", arg)
+	if !ok {
+		return ss.reply(501, "Syntax error in MAIL command")
+	}
+	for _, param := range params {
+		if strings.HasPrefix(strings.ToUpper(param), "SIZE=") {
+			var size int64
+			if _, err := fmt.Sscanf(param[5:], "%d", &size); err == nil && size > ss.maxSize() {
+				return ss.reply(552, "Message size exceeds fixed maximum message size")
+			}
+		}
+	}
+
+	ss.from = from
+	ss.hasFrom = true
+	return ss.reply(250, "OK")
+}
+
+func (ss *session) handleRcpt(arg string) error {
+	if !ss.hasFrom {
+		return ss.reply(503, "Need MAIL command")
+	}
+
+	max := ss.s.MaxRecipients
+	if max <= 0 {
+		max = 100
+	}
+	if len(ss.to) >= max {
+		return ss.reply(452, "Too many recipients")
+	}
+
+	to, _, ok := parsePath("TO:", arg)
+	if !ok || to == "" {
+		return ss.reply(501, "Syntax error in RCPT command")
+	}
+	ss.to = append(ss.to, to)
+	return ss.reply(250, "OK")
+}
+
+func (ss *session) handleData() error {
+	if !ss.hasFrom || len(ss.to) == 0 {
+		return ss.reply(503, "Need RCPT command")
+	}
+	if err := ss.reply(354, "Start mail input; end with <CRLF>.<CRLF>"); err != nil {
+		return err
+	}
+
+	ss.setDeadline()
+	max := ss.maxSize()
+	dr := ss.text.DotReader()
+	data, err := ioutil.ReadAll(io.LimitReader(dr, max+1))
+	if err != nil {
+		if err == errLineTooLong {
+			ss.reset()
+			ss.reply(500, "Line too long")
+		}
+		return err
+	}
+	if int64(len(data)) > max {
+		// Discard the rest of the message.
+		io.Copy(ioutil.Discard, dr)
+		ss.reset()
+		return ss.reply(552, "Message size exceeds fixed maximum message size")
+	}
+
+	env := &Envelope{
+		RemoteAddr: ss.conn.RemoteAddr(),
+		Username:   ss.username,
+		From:       ss.from,
+		To:         ss.to,
+		Data:       bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1),
+	}
+	ss.reset()
+
+	if err = ss.s.Handler(env); err != nil {
+		return ss.replyError(err)
+	}
+	return ss.reply(250, "OK: queued")
+}
@@ -0,0 +1,240 @@
+package smtpd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	*Server
+	addr string
+
+	lock sync.Mutex
+	envs []*Envelope
+}
+
+func newTestServer(t *testing.T, s *Server) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testServer{Server: s, addr: ln.Addr().String()}
+	if s.Hostname == "" {
+		s.Hostname = "mx.example.com"
+	}
+	if s.Handler == nil {
+		s.Handler = func(env *Envelope) error {
+			ts.lock.Lock()
+			ts.envs = append(ts.envs, env)
+			ts.lock.Unlock()
+			return nil
+		}
+	}
+
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return ts
+}
+
+func (ts *testServer) envelopes() []*Envelope {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	return ts.envs
+}
+
+func (ts *testServer) dial(t *testing.T) *textproto.Conn {
+	c, err := textproto.Dial("tcp", ts.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	if _, _, err = c.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// cmd sends the command and checks the reply code.
+func cmd(t *testing.T, c *textproto.Conn, code int, format string, args ...interface{}) {
+	t.Helper()
+	if err := c.PrintfLine(format, args...); err != nil {
+		t.Fatal(err)
+	} else if _, msg, err := c.ReadResponse(code); err != nil {
+		t.Errorf("%s: expect the code %d, but got '%s'", format, code, msg)
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	ts := newTestServer(t, &Server{Auth: func(username, password string) error {
+		if username != "user" || password != "pass" {
+			return Error{Code: 535, Message: "invalid"}
+		}
+		return nil
+	}})
+
+	msg := "Subject: test\r\n\r\nline 1\r\n.line 2\r\n..\r\n"
+	auth := smtp.PlainAuth("", "user", "pass", "127.0.0.1")
+	err := smtp.SendMail(ts.addr, auth, "alice@example.com",
+		[]string{"bob@example.com", "carol@example.com"}, []byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envs := ts.envelopes()
+	if len(envs) != 1 {
+		t.Fatalf("expect 1 email, but got %d", len(envs))
+	}
+
+	env := envs[0]
+	if env.Username != "user" || env.From != "alice@example.com" ||
+		strings.Join(env.To, ",") != "bob@example.com,carol@example.com" {
+		t.Errorf("unexpected envelope: %+v", env)
+	} else if !bytes.Equal(env.Data, []byte(msg)) {
+		t.Errorf("expect the message '%q', but got '%q'", msg, env.Data)
+	}
+
+	auth = smtp.PlainAuth("", "user", "wrong", "127.0.0.1")
+	if err = smtp.SendMail(ts.addr, auth, "alice@example.com",
+		[]string{"bob@example.com"}, []byte(msg)); err == nil {
+		t.Error("expect the error of the wrong password, but got nil")
+	}
+}
+
+func TestAuthLogin(t *testing.T) {
+	ts := newTestServer(t, &Server{Auth: func(username, password string) error {
+		if username != "user" || password != "pass" {
+			return Error{Code: 535, Message: "invalid"}
+		}
+		return nil
+	}})
+
+	c := ts.dial(t)
+	cmd(t, c, 503, "AUTH LOGIN")
+	cmd(t, c, 250, "EHLO client")
+	cmd(t, c, 530, "MAIL FROM:<alice@example.com>")
+	cmd(t, c, 504, "AUTH CRAM-MD5")
+	cmd(t, c, 334, "AUTH LOGIN")
+	cmd(t, c, 334, base64.StdEncoding.EncodeToString([]byte("user")))
+	cmd(t, c, 235, base64.StdEncoding.EncodeToString([]byte("pass")))
+	cmd(t, c, 503, "AUTH PLAIN")
+	cmd(t, c, 250, "MAIL FROM:<alice@example.com>")
+}
+
+func TestCommandSequence(t *testing.T) {
+	ts := newTestServer(t, &Server{MaxRecipients: 2})
+
+	c := ts.dial(t)
+	cmd(t, c, 503, "MAIL FROM:<alice@example.com>")
+	cmd(t, c, 250, "HELO client")
+	cmd(t, c, 503, "RCPT TO:<bob@example.com>")
+	cmd(t, c, 503, "DATA")
+	cmd(t, c, 501, "MAIL alice@example.com")
+	cmd(t, c, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, c, 503, "MAIL FROM:<alice@example.com>")
+	cmd(t, c, 501, "RCPT TO:<>")
+	cmd(t, c, 250, "RCPT TO:<bob@example.com>")
+	cmd(t, c, 250, "RCPT TO:<carol@example.com>")
+	cmd(t, c, 452, "RCPT TO:<dave@example.com>")
+	cmd(t, c, 250, "RSET")
+	cmd(t, c, 503, "RCPT TO:<bob@example.com>")
+	cmd(t, c, 250, "NOOP")
+	cmd(t, c, 502, "TURN")
+	cmd(t, c, 502, "STARTTLS")
+	cmd(t, c, 221, "QUIT")
+
+	if envs := ts.envelopes(); len(envs) != 0 {
+		t.Errorf("expect no email, but got %d", len(envs))
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	ts := newTestServer(t, &Server{Handler: func(env *Envelope) error {
+		return Error{Code: 550, Message: "mailbox unavailable"}
+	}})
+
+	c := ts.dial(t)
+	cmd(t, c, 250, "HELO client")
+	cmd(t, c, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, c, 250, "RCPT TO:<bob@example.com>")
+	cmd(t, c, 354, "DATA")
+	cmd(t, c, 550, "Subject: test\r\n\r\nbody\r\n.")
+
+	// The session is reset after the message.
+	cmd(t, c, 503, "RCPT TO:<bob@example.com>")
+}
+
+func TestMaxSize(t *testing.T) {
+	ts := newTestServer(t, &Server{MaxSize: 100})
+
+	c := ts.dial(t)
+	cmd(t, c, 250, "EHLO client")
+	cmd(t, c, 552, "MAIL FROM:<alice@example.com> SIZE=101")
+	cmd(t, c, 250, "MAIL FROM:<alice@example.com> SIZE=100")
+	cmd(t, c, 250, "RCPT TO:<bob@example.com>")
+	cmd(t, c, 354, "DATA")
+	cmd(t, c, 552, "%s\r\n.", strings.Repeat("x", 200))
+
+	// The session is still usable.
+	cmd(t, c, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, c, 250, "RCPT TO:<bob@example.com>")
+	cmd(t, c, 354, "DATA")
+	cmd(t, c, 250, "small\r\n.")
+
+	if envs := ts.envelopes(); len(envs) != 1 {
+		t.Errorf("expect 1 email, but got %d", len(envs))
+	}
+}
+
+func TestMaxLineLength(t *testing.T) {
+	ts := newTestServer(t, &Server{MaxLineLength: 100})
+
+	c := ts.dial(t)
+	cmd(t, c, 500, "HELO %s", strings.Repeat("x", 200))
+	if _, err := c.ReadLine(); err == nil {
+		t.Error("expect the closed connection, but got not")
+	}
+
+	c = ts.dial(t)
+	cmd(t, c, 250, "HELO client")
+	cmd(t, c, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, c, 250, "RCPT TO:<bob@example.com>")
+	cmd(t, c, 354, "DATA")
+	cmd(t, c, 500, "%s\r\n.", strings.Repeat("x", 200))
+
+	if envs := ts.envelopes(); len(envs) != 0 {
+		t.Errorf("expect no email, but got %d", len(envs))
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	ts := newTestServer(t, &Server{Timeout: 300 * time.Millisecond})
+
+	// The deadline is refreshed by each read of the message.
+	c := ts.dial(t)
+	cmd(t, c, 250, "HELO client")
+	cmd(t, c, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, c, 250, "RCPT TO:<bob@example.com>")
+	cmd(t, c, 354, "DATA")
+	for i := 0; i < 5; i++ {
+		time.Sleep(150 * time.Millisecond)
+		c.PrintfLine("line %d", i)
+	}
+	cmd(t, c, 250, ".")
+
+	// The idle connection is closed.
+	time.Sleep(500 * time.Millisecond)
+	if err := c.PrintfLine("NOOP"); err == nil {
+		if _, _, err = c.ReadResponse(250); err == nil {
+			t.Error("expect the closed connection, but got not")
+		}
+	}
+}
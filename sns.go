@@ -0,0 +1,193 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xgfone/messageapi/internal/sigv4"
+)
+
+func init() {
+	RegisterSMS("sns", new(sns))
+}
+
+// sns is the SMS provider by AWS SNS Publish, the configuration options of
+// which are as follows:
+//
+//	region:             the region of SNS, such as "us-east-1", which is required.
+//	access_key_id:      the access key id, which is required.
+//	secret_access_key:  the secret access key, which is required.
+//	session_token:      the session token of the temporary credentials, which is optional.
+//	sender_id:          the default alphanumeric sender id, which is optional.
+//	origination_number: the phone number to send from, which is optional.
+//	sms_type:           "Transactional" or "Promotional", which is optional.
+//	endpoint:           the endpoint of SNS, which is "https://sns.REGION.amazonaws.com"
+//	                    by default, such as the VPC endpoint.
+//	timeout:            the timeout in seconds of the request, which is 30 by default.
+//	http_proxy:         the proxy url of the requests, which is optional.
+//	socks5:             the SOCKS5 proxy, such as "host:1080", which is optional.
+type sns struct {
+	sync.Mutex
+
+	region      string
+	endpoint    string
+	credentials sigv4.Credentials
+	senderID    string
+	origination string
+	smsType     string
+	client      *http.Client
+}
+
+func (s *sns) Load(m map[string]string) error {
+	region := m["region"]
+	if region == "" {
+		return fmt.Errorf("no the region configuration")
+	}
+
+	credentials := sigv4.Credentials{
+		AccessKeyID:     m["access_key_id"],
+		SecretAccessKey: m["secret_access_key"],
+		SessionToken:    m["session_token"],
+	}
+	if credentials.AccessKeyID == "" {
+		return fmt.Errorf("no the access_key_id configuration")
+	} else if credentials.SecretAccessKey == "" {
+		return fmt.Errorf("no the secret_access_key configuration")
+	}
+
+	switch m["sms_type"] {
+	case "", "Transactional", "Promotional":
+	default:
+		return fmt.Errorf("invalid sms_type %s", m["sms_type"])
+	}
+
+	endpoint := strings.TrimSuffix(m["endpoint"], "/")
+	if endpoint == "" {
+		endpoint = "https://sns." + region + ".amazonaws.com"
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.region = region
+	s.endpoint = endpoint
+	s.credentials = credentials
+	s.senderID = m["sender_id"]
+	s.origination = m["origination_number"]
+	s.smsType = m["sms_type"]
+	s.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (s *sns) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "region", Type: OptionString, Required: true,
+			Description: "the region of SNS, such as us-east-1"},
+		{Name: "access_key_id", Type: OptionString, Required: true,
+			Description: "the access key id"},
+		{Name: "secret_access_key", Type: OptionString, Required: true, Secret: true,
+			Description: "the secret access key"},
+		{Name: "session_token", Type: OptionString, Secret: true,
+			Description: "the session token of the temporary credentials"},
+		{Name: "sender_id", Type: OptionString,
+			Description: "the default alphanumeric sender id"},
+		{Name: "origination_number", Type: OptionString,
+			Description: "the phone number to send from"},
+		{Name: "sms_type", Type: OptionString,
+			Description: "Transactional or Promotional"},
+		{Name: "endpoint", Type: OptionURL,
+			Description: "the endpoint of SNS, such as the VPC endpoint"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+func (s *sns) SendSMS(cxt context.Context, phone, content string) error {
+	s.Lock()
+	region, endpoint, credentials, senderID, origination, smsType, client := s.region,
+		s.endpoint, s.credentials, s.senderID, s.origination, s.smsType, s.client
+	s.Unlock()
+
+	if opts := GetSMSOptions(cxt); opts.SenderID != "" {
+		senderID = opts.SenderID
+	}
+
+	form := url.Values{
+		"Action":      {"Publish"},
+		"Version":     {"2010-03-31"},
+		"PhoneNumber": {phone},
+		"Message":     {content},
+	}
+	var attrs int
+	setAttr := func(name, value string) {
+		if value != "" {
+			attrs++
+			prefix := fmt.Sprintf("MessageAttributes.entry.%d.", attrs)
+			form.Set(prefix+"Name", name)
+			form.Set(prefix+"Value.DataType", "String")
+			form.Set(prefix+"Value.StringValue", value)
+		}
+	}
+	setAttr("AWS.SNS.SMS.SenderID", senderID)
+	setAttr("AWS.MM.SMS.OriginationNumber", origination)
+	setAttr("AWS.SNS.SMS.SMSType", smsType)
+
+	body := form.Encode()
+	req, err := http.NewRequest("POST", endpoint+"/", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(cxt)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	sigv4.Sign(req, credentials, region, "sns", sigv4.HashPayload([]byte(body)), time.Now())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var result struct {
+			Error struct {
+				Code    string `xml:"Code"`
+				Message string `xml:"Message"`
+			} `xml:"Error"`
+		}
+		xml.Unmarshal(data, &result)
+
+		e := httpError(resp.StatusCode, resp.Header, result.Error.Code,
+			fmt.Sprintf("sns: %d %s", resp.StatusCode, result.Error.Message))
+		return TranslateError("sns", e)
+	}
+
+	var result struct {
+		MessageID string `xml:"PublishResult>MessageId"`
+	}
+	if err = xml.Unmarshal(data, &result); err != nil {
+		return err
+	}
+
+	SetResult(cxt, ResultMessageID, result.MessageID)
+	return nil
+}
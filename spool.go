@@ -0,0 +1,108 @@
+package messageapi
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// defaultSpoolMemory is the default maximum bytes of Spool kept in memory.
+const defaultSpoolMemory = 1 << 20
+
+// ErrTooLarge is returned when the data is larger than the limit.
+var ErrTooLarge = errors.New("the data is too large")
+
+// Spool is the buffer of the data written once and read more than once,
+// such as the MIME message sent to the SMTP servers one by one, which keeps
+// the data in memory up to the limit and spills the whole to a temporary
+// file beyond it, so that the large attachments don't pin the memory.
+//
+// Spool is not safe for the concurrent writes, but the readers returned by
+// Reader may be used concurrently after the data is written.
+type Spool struct {
+	maxMemory int64
+	maxSize   int64
+	size      int64
+	buf       bytes.Buffer
+	file      *os.File
+}
+
+// NewSpool returns a new Spool, which keeps at most maxMemory bytes in memory,
+// 1MB if 0 and nothing if negative, and accepts at most maxSize bytes
+// in total, or no limit if maxSize is 0.
+func NewSpool(maxMemory, maxSize int64) *Spool {
+	if maxMemory == 0 {
+		maxMemory = defaultSpoolMemory
+	}
+	return &Spool{maxMemory: maxMemory, maxSize: maxSize}
+}
+
+// Size returns the number of the bytes written.
+func (s *Spool) Size() int64 { return s.size }
+
+// Write implements the interface io.Writer.
+//
+// If the data exceeds the maximum size, it returns ErrTooLarge.
+func (s *Spool) Write(p []byte) (n int, err error) {
+	if s.maxSize > 0 && s.size+int64(len(p)) > s.maxSize {
+		return 0, ErrTooLarge
+	}
+
+	if s.file == nil && int64(s.buf.Len()+len(p)) > s.maxMemory {
+		if err = s.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	if s.file == nil {
+		n, err = s.buf.Write(p)
+	} else {
+		n, err = s.file.Write(p)
+	}
+	s.size += int64(n)
+	return
+}
+
+// spill moves the data in memory to the temporary file.
+func (s *Spool) spill() error {
+	file, err := ioutil.TempFile("", "messageapi-spool-")
+	if err != nil {
+		return err
+	}
+
+	// Remove the file at once, so that it is gone when closed, even if Close
+	// is not called. It fails on Windows, where Close removes it instead.
+	os.Remove(file.Name())
+	s.file = file
+
+	if _, err = s.file.Write(s.buf.Bytes()); err != nil {
+		s.Close()
+		return err
+	}
+	s.buf = bytes.Buffer{}
+	return nil
+}
+
+// Reader returns a new reader of the data from the beginning.
+func (s *Spool) Reader() io.Reader {
+	if s.file != nil {
+		return io.NewSectionReader(s.file, 0, s.size)
+	}
+	return bytes.NewReader(s.buf.Bytes())
+}
+
+// Close releases the memory or the temporary file of the data.
+func (s *Spool) Close() error {
+	s.buf, s.size = bytes.Buffer{}, 0
+	if s.file == nil {
+		return nil
+	}
+
+	file := s.file
+	s.file = nil
+	err := file.Close()
+	os.Remove(file.Name())
+	return err
+}
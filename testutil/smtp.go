@@ -0,0 +1,233 @@
+// Package testutil provides the utilities to test the providers and the
+// applications without the network access, such as the SMTP server in the
+// process.
+package testutil
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"sync"
+	"time"
+
+	"github.com/xgfone/messageapi/smtpd"
+)
+
+// Message is the email received by SMTPServer.
+type Message struct {
+	Username string // The authenticated user, or empty.
+	From     string
+	To       []string
+	Data     []byte // The raw message, including the headers.
+}
+
+// Parse parses the raw message.
+func (m Message) Parse() (*mail.Message, error) {
+	return mail.ReadMessage(bytes.NewReader(m.Data))
+}
+
+// Subject returns the decoded subject of the message.
+func (m Message) Subject() string {
+	msg, err := m.Parse()
+	if err != nil {
+		return ""
+	}
+
+	subject := msg.Header.Get("Subject")
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+		return decoded
+	}
+	return subject
+}
+
+// SMTPServer is the SMTP server listening on the loopback, which records
+// the received emails, and fails them or delays the replies on demand,
+// such as to test the plain provider and the retries:
+//
+//	s := testutil.NewSMTPServer()
+//	defer s.Close()
+//
+//	s.FailNext(1, 451, "Try again later")
+//	provider.Load(s.Config("sender@example.com"))
+//	...
+//	messages, err := s.Wait(1, time.Second)
+type SMTPServer struct {
+	// The address of the server, such as "127.0.0.1:12345".
+	Addr string
+
+	server *smtpd.Server
+	closed chan struct{}
+
+	lock     sync.Mutex
+	username string
+	password string
+	messages []Message
+	fails    int
+	code     int
+	reply    string
+	delay    time.Duration
+	notify   chan struct{}
+}
+
+// NewSMTPServer starts and returns a new SMTP server listening on the
+// loopback, which accepts any credentials by default, see SetAuth.
+//
+// The caller should call Close when finished.
+func NewSMTPServer() *SMTPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		if ln, err = net.Listen("tcp6", "[::1]:0"); err != nil {
+			panic(fmt.Sprintf("testutil: failed to listen on a port: %v", err))
+		}
+	}
+
+	s := &SMTPServer{
+		Addr:   ln.Addr().String(),
+		closed: make(chan struct{}),
+		notify: make(chan struct{}, 1),
+	}
+	s.server = &smtpd.Server{
+		Hostname:          "localhost",
+		Handler:           s.handle,
+		Auth:              s.auth,
+		AllowInsecureAuth: true,
+		Timeout:           time.Minute,
+	}
+	go s.server.Serve(ln)
+	return s
+}
+
+// Close closes the server, and releases the delayed replies.
+func (s *SMTPServer) Close() error {
+	s.lock.Lock()
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	s.lock.Unlock()
+	return s.server.Close()
+}
+
+// Config returns the configuration of the plain provider to send the
+// emails from the address to the server.
+func (s *SMTPServer) Config(from string) map[string]string {
+	s.lock.Lock()
+	username, password := s.username, s.password
+	s.lock.Unlock()
+
+	if username == "" {
+		username, password = "testutil", "testutil"
+	}
+	return map[string]string{
+		"host":     s.Addr,
+		"username": username,
+		"password": password,
+		"from":     from,
+		"timeout":  "5",
+	}
+}
+
+// SetAuth sets the credentials which the clients must authenticate by.
+// If the username is empty, any credentials are accepted.
+func (s *SMTPServer) SetAuth(username, password string) {
+	s.lock.Lock()
+	s.username, s.password = username, password
+	s.lock.Unlock()
+}
+
+// FailNext rejects the next n emails with the reply code and message,
+// such as 451 for the temporary failure or 550 for the permanent one.
+// If n is negative, all the emails are rejected until Reset.
+func (s *SMTPServer) FailNext(n, code int, message string) {
+	s.lock.Lock()
+	s.fails, s.code, s.reply = n, code, message
+	s.lock.Unlock()
+}
+
+// SetDelay delays the reply to each email for the duration, such as
+// longer than the timeout of the client to test the time-outs.
+func (s *SMTPServer) SetDelay(delay time.Duration) {
+	s.lock.Lock()
+	s.delay = delay
+	s.lock.Unlock()
+}
+
+// Reset clears the received emails, the failures and the delay.
+func (s *SMTPServer) Reset() {
+	s.lock.Lock()
+	s.messages, s.fails, s.delay = nil, 0, 0
+	s.lock.Unlock()
+}
+
+// Messages returns the received emails.
+func (s *SMTPServer) Messages() []Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Wait waits until at least n emails are received, and returns them.
+func (s *SMTPServer) Wait(n int, timeout time.Duration) ([]Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		if messages := s.Messages(); len(messages) >= n {
+			return messages, nil
+		}
+
+		select {
+		case <-s.notify:
+		case <-deadline.C:
+			return s.Messages(), fmt.Errorf("received %d emails, but expect %d",
+				len(s.Messages()), n)
+		}
+	}
+}
+
+func (s *SMTPServer) auth(username, password string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.username != "" && (username != s.username || password != s.password) {
+		return smtpd.Error{Code: 535, Message: "Authentication credentials invalid"}
+	}
+	return nil
+}
+
+func (s *SMTPServer) handle(e *smtpd.Envelope) error {
+	s.lock.Lock()
+	delay := s.delay
+	s.lock.Unlock()
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-s.closed:
+			timer.Stop()
+		}
+	}
+
+	s.lock.Lock()
+	if s.fails != 0 {
+		if s.fails > 0 {
+			s.fails--
+		}
+		code, reply := s.code, s.reply
+		s.lock.Unlock()
+		return smtpd.Error{Code: code, Message: reply}
+	}
+
+	s.messages = append(s.messages, Message{Username: e.Username, From: e.From,
+		To: append([]string(nil), e.To...), Data: e.Data})
+	s.lock.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
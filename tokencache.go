@@ -0,0 +1,265 @@
+package messageapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xgfone/messageapi/internal/redis"
+)
+
+// TokenCache caches the access tokens of the providers, such as the OAuth
+// access tokens of WeChat Work, Microsoft Graph or FCM, so that they are
+// reused until they expire.
+//
+// If there are more than one instances of the gateway, use the shared
+// cache, such as NewRedisTokenCache, so that they don't race to refresh
+// the tokens and exhaust the quotas of the vendors.
+type TokenCache interface {
+	// Get returns the cached token of the key, or "" if missing or expired.
+	Get(cxt context.Context, key string) (string, error)
+
+	// Set caches the token of the key, which expires after ttl.
+	Set(cxt context.Context, key, token string, ttl time.Duration) error
+
+	// Delete removes the token of the key, such as when it is revoked.
+	Delete(cxt context.Context, key string) error
+
+	// TryLock acquires the lock to refresh the token of the key, which is
+	// released automatically after ttl. It returns the function to release
+	// the lock if acquired, or nil if the lock is held by the other.
+	TryLock(cxt context.Context, key string, ttl time.Duration) (unlock func(), err error)
+}
+
+// TokenFetcher fetches a new access token and its lifetime from the vendor.
+type TokenFetcher func(cxt context.Context) (token string, ttl time.Duration, err error)
+
+// The options of GetToken.
+const (
+	// The token is cached for its lifetime minus the margin, so that it is
+	// refreshed before expiring. The margin is at most 1/10 of the lifetime.
+	tokenExpiryMargin = 5 * time.Minute
+
+	// The maximum duration to refresh the token, that's, the lock timeout.
+	tokenLockTimeout = 30 * time.Second
+
+	// The interval to check whether the other has refreshed the token.
+	tokenPollInterval = 100 * time.Millisecond
+)
+
+var (
+	tokenLocker = new(sync.RWMutex)
+	tokenCache  = NewMemoryTokenCache()
+)
+
+// SetTokenCache sets the cache of the access tokens used by GetToken,
+// which is in memory by default.
+func SetTokenCache(c TokenCache) {
+	if c == nil {
+		panic("the token cache must not be nil")
+	}
+
+	tokenLocker.Lock()
+	tokenCache = c
+	tokenLocker.Unlock()
+}
+
+func getTokenCache() TokenCache {
+	tokenLocker.RLock()
+	c := tokenCache
+	tokenLocker.RUnlock()
+	return c
+}
+
+// GetToken returns the access token of the key, such as "wecom:CORPID:AGENTID",
+// from the cache set by SetTokenCache. If missing, only one of the instances
+// sharing the cache fetches a new one by fetch and caches it, and the others
+// wait for it.
+func GetToken(cxt context.Context, key string, fetch TokenFetcher) (string, error) {
+	cache := getTokenCache()
+	if token, err := cache.Get(cxt, key); err != nil || token != "" {
+		return token, err
+	}
+
+	deadline := time.Now().Add(tokenLockTimeout)
+	for {
+		unlock, err := cache.TryLock(cxt, key, tokenLockTimeout)
+		if err != nil {
+			return "", err
+		} else if unlock != nil {
+			defer unlock()
+			break
+		}
+
+		// Another is refreshing the token. If it lasts too long, such as
+		// the other has crashed, fetch the token by itself.
+		if time.Now().After(deadline) {
+			break
+		} else if err = sleep(cxt, tokenPollInterval); err != nil {
+			return "", err
+		} else if token, err := cache.Get(cxt, key); err != nil || token != "" {
+			return token, err
+		}
+	}
+
+	// Check it again, which may be refreshed by the other meanwhile.
+	if token, err := cache.Get(cxt, key); err != nil || token != "" {
+		return token, err
+	}
+	return RefreshToken(cxt, key, fetch)
+}
+
+// RefreshToken fetches a new access token of the key by fetch and caches it,
+// such as by Rotator before it expires, or after it's rejected by the vendor.
+func RefreshToken(cxt context.Context, key string, fetch TokenFetcher) (string, error) {
+	token, ttl, err := fetch(cxt)
+	if err != nil {
+		return "", err
+	}
+
+	margin := ttl / 10
+	if margin > tokenExpiryMargin {
+		margin = tokenExpiryMargin
+	}
+	if ttl -= margin; ttl > 0 {
+		err = getTokenCache().Set(cxt, key, token, ttl)
+	}
+	return token, err
+}
+
+// InvalidateToken removes the cached access token of the key,
+// such as after it's rejected by the vendor.
+func InvalidateToken(cxt context.Context, key string) error {
+	return getTokenCache().Delete(cxt, key)
+}
+
+type memoryToken struct {
+	token  string
+	expire time.Time
+}
+
+type memoryTokenCache struct {
+	lock   sync.Mutex
+	tokens map[string]memoryToken
+	locks  map[string]time.Time
+}
+
+// NewMemoryTokenCache returns a new TokenCache in memory, which is only
+// shared by the providers in the same process.
+func NewMemoryTokenCache() TokenCache {
+	return &memoryTokenCache{
+		tokens: make(map[string]memoryToken),
+		locks:  make(map[string]time.Time),
+	}
+}
+
+func (c *memoryTokenCache) Get(cxt context.Context, key string) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t, ok := c.tokens[key]
+	if ok && time.Now().Before(t.expire) {
+		return t.token, nil
+	}
+	delete(c.tokens, key)
+	return "", nil
+}
+
+func (c *memoryTokenCache) Set(cxt context.Context, key, token string, ttl time.Duration) error {
+	c.lock.Lock()
+	c.tokens[key] = memoryToken{token: token, expire: time.Now().Add(ttl)}
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *memoryTokenCache) Delete(cxt context.Context, key string) error {
+	c.lock.Lock()
+	delete(c.tokens, key)
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *memoryTokenCache) TryLock(cxt context.Context, key string, ttl time.Duration) (func(), error) {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if expire, ok := c.locks[key]; ok && now.Before(expire) {
+		return nil, nil
+	}
+
+	expire := now.Add(ttl)
+	c.locks[key] = expire
+	return func() {
+		c.lock.Lock()
+		if c.locks[key] == expire {
+			delete(c.locks, key)
+		}
+		c.lock.Unlock()
+	}, nil
+}
+
+// unlockScript deletes the lock only if it is still held by the owner.
+const unlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+type redisTokenCache struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisTokenCache returns a new TokenCache based on redis, which can be
+// shared by more than one instances.
+//
+// All the keys of the tokens have the prefix, such as "messageapi:token:",
+// and the locks have the suffix ":lock".
+func NewRedisTokenCache(addr, password string, db int, prefix string) TokenCache {
+	client := redis.NewClient(redis.Option{Addr: addr, Password: password, DB: db})
+	return redisTokenCache{client: client, prefix: prefix}
+}
+
+func (c redisTokenCache) Get(cxt context.Context, key string) (string, error) {
+	token, err := redis.String(c.client.Do("GET", c.prefix+key))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return token, err
+}
+
+func (c redisTokenCache) Set(cxt context.Context, key, token string, ttl time.Duration) error {
+	_, err := c.client.Do("SET", c.prefix+key, token, "PX", formatMillis(ttl))
+	return err
+}
+
+func (c redisTokenCache) Delete(cxt context.Context, key string) error {
+	_, err := c.client.Do("DEL", c.prefix+key)
+	return err
+}
+
+func (c redisTokenCache) TryLock(cxt context.Context, key string, ttl time.Duration) (func(), error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+	owner := hex.EncodeToString(b[:])
+
+	lock := c.prefix + key + ":lock"
+	_, err := redis.String(c.client.Do("SET", lock, owner, "NX", "PX", formatMillis(ttl)))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return func() { c.client.Do("EVAL", unlockScript, "1", lock, owner) }, nil
+}
+
+func formatMillis(d time.Duration) string {
+	ms := int64(d / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
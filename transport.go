@@ -0,0 +1,242 @@
+package messageapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HTTPOptions is the options of the HTTP transport shared by all the HTTP API
+// providers, so that the connections to the vendors are reused.
+//
+// The timeouts are in seconds, and 0 is the default.
+type HTTPOptions struct {
+	// The proxy URL, such as "http://proxy.example.com:3128". If empty, use the
+	// environment variables HTTP_PROXY, HTTPS_PROXY and NO_PROXY. It may be
+	// overridden per provider by the option "http_proxy".
+	Proxy string `json:"proxy,omitempty"`
+
+	// The PEM file of the extra CA certificates to verify the vendors,
+	// such as the CA of the corporate TLS-inspecting proxy.
+	CAFile string `json:"ca_file,omitempty"`
+
+	// If true, don't verify the certificates of the vendors. Only for testing.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+
+	DialTimeout         int `json:"dial_timeout,omitempty"`          // Default: 30
+	TLSHandshakeTimeout int `json:"tls_handshake_timeout,omitempty"` // Default: 10
+	IdleConnTimeout     int `json:"idle_conn_timeout,omitempty"`     // Default: 90
+
+	MaxIdleConns        int `json:"max_idle_conns,omitempty"`          // Default: 100
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"` // Default: 10
+	MaxConnsPerHost     int `json:"max_conns_per_host,omitempty"`      // Default: no limit
+
+	// The options to dial the vendors, such as the IP preference.
+	DialOptions
+}
+
+// loadCAFile returns the system certificate pool with the extra CA
+// certificates in the PEM file.
+func loadCAFile(filename string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no the certificates in %s", filename)
+	}
+	return pool, nil
+}
+
+func (o HTTPOptions) newTransport() (*http.Transport, error) {
+	seconds := func(n, _default int) time.Duration {
+		if n <= 0 {
+			n = _default
+		}
+		return time.Duration(n) * time.Second
+	}
+	positive := func(n, _default int) int {
+		if n <= 0 {
+			return _default
+		}
+		return n
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if o.Proxy != "" {
+		u, err := parseProxyURL(o.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	if err := o.DialOptions.validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if o.CAFile != "" {
+		pool, err := loadCAFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	dialer := newDialer(net.Dialer{Timeout: seconds(o.DialTimeout, 30),
+		KeepAlive: 30 * time.Second}, o.DialOptions)
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   seconds(o.TLSHandshakeTimeout, 10),
+		IdleConnTimeout:       seconds(o.IdleConnTimeout, 90),
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          positive(o.MaxIdleConns, 100),
+		MaxIdleConnsPerHost:   positive(o.MaxIdleConnsPerHost, 10),
+		MaxConnsPerHost:       o.MaxConnsPerHost,
+	}, nil
+}
+
+func parseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("the proxy '%s' is not a valid url", proxy)
+	}
+	return u, nil
+}
+
+var (
+	transportLocker sync.RWMutex
+	httpTransport   http.RoundTripper
+	proxyTransports = make(map[string]http.RoundTripper)
+)
+
+func init() {
+	transport, _ := HTTPOptions{}.newTransport()
+	httpTransport = transport
+}
+
+// SetHTTPOptions resets the HTTP transport shared by all the HTTP API
+// providers, which takes effect at once, including the loaded providers.
+func SetHTTPOptions(o HTTPOptions) error {
+	transport, err := o.newTransport()
+	if err != nil {
+		return err
+	}
+	SetHTTPTransport(transport)
+	return nil
+}
+
+// SetHTTPTransport sets the HTTP transport shared by all the HTTP API
+// providers, such as the one instrumented by the tracing.
+//
+// The option "http_proxy" or "socks5" of the provider is only supported
+// if the transport is *http.Transport.
+func SetHTTPTransport(rt http.RoundTripper) {
+	transportLocker.Lock()
+	old := httpTransport
+	httpTransport = rt
+	proxies := proxyTransports
+	proxyTransports = make(map[string]http.RoundTripper)
+	transportLocker.Unlock()
+
+	if t, ok := old.(*http.Transport); ok && t != rt {
+		t.CloseIdleConnections()
+	}
+	for _, rt := range proxies {
+		if t, ok := rt.(*http.Transport); ok {
+			t.CloseIdleConnections()
+		}
+	}
+}
+
+// getTransport returns the shared transport, or the one by the proxy,
+// which is cloned from the shared transport and cached.
+func getTransport(proxy string) (http.RoundTripper, error) {
+	transportLocker.RLock()
+	transport, rt := httpTransport, proxyTransports[proxy]
+	transportLocker.RUnlock()
+	if proxy == "" {
+		return transport, nil
+	} else if rt != nil {
+		return rt, nil
+	}
+
+	t, ok := transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("the http transport does not support the proxy")
+	}
+	u, err := parseProxyURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	t = t.Clone()
+	t.Proxy = http.ProxyURL(u)
+
+	transportLocker.Lock()
+	defer transportLocker.Unlock()
+	if httpTransport != transport {
+		return t, nil // The shared transport has been reset, so don't cache it.
+	} else if rt = proxyTransports[proxy]; rt == nil {
+		rt = t
+		proxyTransports[proxy] = rt
+	}
+	return rt, nil
+}
+
+// sharedTransport is the transport of the HTTP API providers, which delegates
+// to the shared transport at the time of each request.
+type sharedTransport struct {
+	proxy string
+}
+
+func (t sharedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt, err := getTransport(t.proxy)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	if pl, ok := getPayloadLog(req.Context()); ok {
+		return logPayload(pl, rt, req)
+	}
+	return rt.RoundTrip(req)
+}
+
+// proxyOption is the common option of the proxy of the HTTP providers.
+var proxyOption = ConfigOption{Name: "http_proxy", Type: OptionURL,
+	Description: "the proxy url of the requests, which overrides the shared one"}
+
+// NewHTTPClient returns the client of the HTTP API provider by its options
+// "timeout", and "http_proxy" or "socks5", which uses the shared transport,
+// see HTTPOptions.
+//
+// The third-party providers should also use it to reuse the connections.
+func NewHTTPClient(m map[string]string) (*http.Client, error) {
+	timeout, err := parseTimeout(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var proxy string
+	if u, err := getProxyURL(m); err != nil {
+		return nil, err
+	} else if u != nil {
+		proxy = u.String()
+	}
+	return &http.Client{Timeout: timeout, Transport: sharedTransport{proxy: proxy}}, nil
+}
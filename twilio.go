@@ -0,0 +1,151 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterSMS("twilio", new(twilio))
+	RegisterMMS("twilio", new(twilio))
+}
+
+const twilioBaseURL = "https://api.twilio.com/2010-04-01/Accounts/"
+
+// twilio is the SMS and MMS provider by the Twilio Programmable Messaging,
+// the configuration options of which are as follows:
+//
+//	account_sid:           the account sid, which is required.
+//	auth_token:            the auth token, which is required.
+//	from:                  the phone number or the alphanumeric sender id.
+//	messaging_service_sid: the messaging service, which is used instead of
+//	                       from if given. One of them is required.
+//	timeout:               the timeout in seconds of the request, which is
+//	                       30 by default.
+//	http_proxy:            the proxy url of the requests, which is optional.
+//	socks5:                the SOCKS5 proxy, such as "host:1080", which is optional.
+type twilio struct {
+	sync.Mutex
+
+	accountSID string
+	authToken  string
+	from       string
+	serviceSID string
+	client     *http.Client
+}
+
+func (t *twilio) Load(m map[string]string) error {
+	accountSID, authToken := m["account_sid"], m["auth_token"]
+	if accountSID == "" {
+		return fmt.Errorf("no the account_sid configuration")
+	} else if authToken == "" {
+		return fmt.Errorf("no the auth_token configuration")
+	}
+
+	from, serviceSID := m["from"], m["messaging_service_sid"]
+	if from == "" && serviceSID == "" {
+		return fmt.Errorf("no the from or messaging_service_sid configuration")
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.accountSID = accountSID
+	t.authToken = authToken
+	t.from = from
+	t.serviceSID = serviceSID
+	t.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (t *twilio) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "account_sid", Type: OptionString, Required: true,
+			Description: "the account sid"},
+		{Name: "auth_token", Type: OptionString, Required: true, Secret: true,
+			Description: "the auth token"},
+		{Name: "from", Type: OptionString,
+			Description: "the phone number or the alphanumeric sender id"},
+		{Name: "messaging_service_sid", Type: OptionString,
+			Description: "the messaging service, which is used instead of from"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+func (t *twilio) SendSMS(cxt context.Context, phone, content string) error {
+	return t.SendMMS(cxt, phone, content, nil)
+}
+
+func (t *twilio) SendMMS(cxt context.Context, phone, content string, media []Media) error {
+	t.Lock()
+	accountSID, authToken, from, serviceSID, client := t.accountSID,
+		t.authToken, t.from, t.serviceSID, t.client
+	t.Unlock()
+
+	form := url.Values{"To": {phone}, "Body": {content}}
+	if opts := GetSMSOptions(cxt); opts.SenderID != "" {
+		form.Set("From", opts.SenderID)
+	} else if serviceSID != "" {
+		form.Set("MessagingServiceSid", serviceSID)
+	} else {
+		form.Set("From", from)
+	}
+	for _, m := range media {
+		form.Add("MediaUrl", m.URL)
+	}
+
+	req, err := http.NewRequest("POST", twilioBaseURL+accountSID+"/Messages.json",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(cxt)
+	req.SetBasicAuth(accountSID, authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		SID     string `json:"sid"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	} else if err = json.Unmarshal(body, &result); err != nil && resp.StatusCode < 300 {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var code string
+		if result.Code != 0 {
+			code = strconv.Itoa(result.Code)
+		}
+		return TranslateError("twilio", httpError(resp.StatusCode, resp.Header, code,
+			fmt.Sprintf("twilio: %d %s", resp.StatusCode, result.Message)))
+	}
+
+	SetResult(cxt, ResultMessageID, result.SID)
+	return nil
+}
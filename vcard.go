@@ -0,0 +1,64 @@
+package messageapi
+
+import (
+	"bytes"
+	"strings"
+)
+
+// VCard is the contact card, which is used to build the vCard 3.0 object
+// defined by RFC 2426, since it's supported by most of the phones.
+type VCard struct {
+	// The formatted name, which is built from the first and last names
+	// if empty.
+	Name      string `json:"name,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+
+	Organization string   `json:"organization,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Phones       []string `json:"phones,omitempty"`
+	Emails       []string `json:"emails,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Address      string   `json:"address,omitempty"`
+	Note         string   `json:"note,omitempty"`
+}
+
+// ContentTypeVCard is the MIME type of the vCard.
+const ContentTypeVCard = "text/vcard"
+
+// Bytes returns the vCard object.
+func (c VCard) Bytes() []byte {
+	name := c.Name
+	if name == "" {
+		name = strings.TrimSpace(c.FirstName + " " + c.LastName)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	writeContentLine(buf, "BEGIN:VCARD")
+	writeContentLine(buf, "VERSION:3.0")
+	writeContentLine(buf, "FN:"+escapeText(name))
+	writeContentLine(buf, "N:"+escapeText(c.LastName)+";"+escapeText(c.FirstName)+";;;")
+	if c.Organization != "" {
+		writeContentLine(buf, "ORG:"+escapeText(c.Organization))
+	}
+	if c.Title != "" {
+		writeContentLine(buf, "TITLE:"+escapeText(c.Title))
+	}
+	for _, phone := range c.Phones {
+		writeContentLine(buf, "TEL;TYPE=CELL:"+escapeText(phone))
+	}
+	for _, email := range c.Emails {
+		writeContentLine(buf, "EMAIL;TYPE=INTERNET:"+escapeText(email))
+	}
+	if c.URL != "" {
+		writeContentLine(buf, "URL:"+c.URL)
+	}
+	if c.Address != "" {
+		writeContentLine(buf, "ADR;TYPE=WORK:;;"+escapeText(c.Address)+";;;;")
+	}
+	if c.Note != "" {
+		writeContentLine(buf, "NOTE:"+escapeText(c.Note))
+	}
+	writeContentLine(buf, "END:VCARD")
+	return buf.Bytes()
+}
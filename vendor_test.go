@@ -0,0 +1,55 @@
+package messageapi_test
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/xgfone/messageapi"
+)
+
+// vendorTransport sends the requests of the HTTP providers, the URLs of
+// which are fixed, such as Twilio, to the fake vendor of providertest.
+type vendorTransport struct {
+	sync.Mutex
+	url *url.URL
+}
+
+func (t *vendorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.Lock()
+	u := t.url
+	t.Unlock()
+
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host, req.Host = u.Scheme, u.Host, u.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// fixedURL returns Options.Config of providertest for the provider with the
+// fixed URL, which redirects all the requests to the fake vendor.
+func fixedURL(t *testing.T, config map[string]string) func(string) map[string]string {
+	transport := new(vendorTransport)
+	messageapi.SetHTTPTransport(transport)
+	t.Cleanup(func() { messageapi.SetHTTPOptions(messageapi.HTTPOptions{}) })
+
+	return func(_url string) map[string]string {
+		u, err := url.Parse(_url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		transport.Lock()
+		transport.url = u
+		transport.Unlock()
+		return config
+	}
+}
+
+// succeed returns Options.Succeed of providertest, which responds with the body.
+func succeed(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}
+}
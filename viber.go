@@ -0,0 +1,129 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+func init() {
+	RegisterMessenger("viber", new(viber))
+}
+
+const viberSendURL = "https://chatapi.viber.com/pa/send_message"
+
+// The status codes of the Viber REST API.
+const (
+	viberOK                    = 0
+	viberReceiverNotRegistered = 5
+	viberReceiverNotSubscribed = 6
+	viberAPIRateLimit          = 12
+)
+
+// viber is the messenger provider by the Viber REST API of the business
+// account, the configuration options of which are as follows:
+//
+//	auth_token:  the authentication token of the account, which is required.
+//	sender_name: the name of the sender shown to the recipient, which is required.
+//	timeout:     the timeout in seconds of the request, which is 30 by default.
+//	http_proxy:  the proxy url of the requests, which is optional.
+//	socks5:      the SOCKS5 proxy, such as "host:1080", which is optional.
+//
+// The recipient is the Viber user id of the subscriber.
+type viber struct {
+	sync.Mutex
+
+	token  string
+	sender string
+	client *http.Client
+}
+
+func (v *viber) Load(m map[string]string) error {
+	token, sender := m["auth_token"], m["sender_name"]
+	if token == "" {
+		return fmt.Errorf("no the auth_token configuration")
+	} else if sender == "" {
+		return fmt.Errorf("no the sender_name configuration")
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	v.token = token
+	v.sender = sender
+	v.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (v *viber) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "auth_token", Type: OptionString, Required: true, Secret: true,
+			Description: "the authentication token of the account"},
+		{Name: "sender_name", Type: OptionString, Required: true,
+			Description: "the name of the sender shown to the recipient"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+func (v *viber) SendMessage(cxt context.Context, msg Message) error {
+	v.Lock()
+	token, sender, client := v.token, v.sender, v.client
+	v.Unlock()
+
+	content := msg.Content
+	if msg.Title != "" {
+		content = msg.Title + "\n" + content
+	}
+
+	payload := map[string]interface{}{
+		"receiver": msg.To,
+		"type":     "text",
+		"sender":   map[string]string{"name": sender},
+		"text":     content,
+	}
+	header := http.Header{"X-Viber-Auth-Token": {token}}
+	status, body, respHeader, err := doJSON(cxt, client, "POST", viberSendURL, header, payload)
+	if err != nil {
+		return err
+	} else if status >= 300 {
+		return httpError(status, respHeader, "", fmt.Sprintf("viber: %d %s",
+			status, body))
+	}
+
+	var result struct {
+		Status        int    `json:"status"`
+		StatusMessage string `json:"status_message"`
+		MessageToken  int64  `json:"message_token"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+
+	switch result.Status {
+	case viberOK:
+		SetResult(cxt, ResultMessageID, strconv.FormatInt(result.MessageToken, 10))
+		return nil
+	case viberReceiverNotRegistered, viberReceiverNotSubscribed:
+		e := NewError(ClassPermanent, strconv.Itoa(result.Status),
+			"viber: "+result.StatusMessage)
+		e.Err = ErrUnreachable
+		return e
+	case viberAPIRateLimit:
+		return NewError(ClassTemporary, strconv.Itoa(result.Status),
+			"viber: "+result.StatusMessage)
+	default:
+		return NewError(ClassPermanent, strconv.Itoa(result.Status),
+			"viber: "+result.StatusMessage)
+	}
+}
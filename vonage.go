@@ -0,0 +1,160 @@
+package messageapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterSMS("vonage", new(vonage))
+}
+
+const vonageEndpoint = "https://api.nexmo.com/v1/messages"
+
+// vonage is the SMS provider by the Vonage (Nexmo) Messages API, the
+// configuration options of which are as follows:
+//
+//	api_key:    the api key, which is required.
+//	api_secret: the api secret, which is required.
+//	from:       the phone number or the alphanumeric sender id, which is required.
+//	endpoint:   the url of the Messages API, which is
+//	            "https://api.nexmo.com/v1/messages" by default, or the regional
+//	            one, such as "https://api-eu.vonage.com/v1/messages".
+//	timeout:    the timeout in seconds of the request, which is 30 by default.
+//	http_proxy: the proxy url of the requests, which is optional.
+//	socks5:     the SOCKS5 proxy, such as "host:1080", which is optional.
+type vonage struct {
+	sync.Mutex
+
+	apiKey    string
+	apiSecret string
+	from      string
+	endpoint  string
+	client    *http.Client
+}
+
+func (v *vonage) Load(m map[string]string) error {
+	apiKey, apiSecret, from := m["api_key"], m["api_secret"], m["from"]
+	if apiKey == "" {
+		return fmt.Errorf("no the api_key configuration")
+	} else if apiSecret == "" {
+		return fmt.Errorf("no the api_secret configuration")
+	} else if from == "" {
+		return fmt.Errorf("no the from configuration")
+	}
+
+	endpoint := m["endpoint"]
+	if endpoint == "" {
+		endpoint = vonageEndpoint
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	v.apiKey = apiKey
+	v.apiSecret = apiSecret
+	v.from = from
+	v.endpoint = endpoint
+	v.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (v *vonage) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "api_key", Type: OptionString, Required: true,
+			Description: "the api key"},
+		{Name: "api_secret", Type: OptionString, Required: true, Secret: true,
+			Description: "the api secret"},
+		{Name: "from", Type: OptionString, Required: true,
+			Description: "the phone number or the alphanumeric sender id"},
+		{Name: "endpoint", Type: OptionURL, Default: vonageEndpoint,
+			Description: "the url of the Messages API"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+	}
+}
+
+func (v *vonage) SendSMS(cxt context.Context, phone, content string) error {
+	v.Lock()
+	apiKey, apiSecret, from, endpoint, client := v.apiKey, v.apiSecret,
+		v.from, v.endpoint, v.client
+	v.Unlock()
+
+	if opts := GetSMSOptions(cxt); opts.SenderID != "" {
+		from = opts.SenderID
+	}
+
+	// Vonage uses the international number without the leading "+".
+	data, err := json.Marshal(map[string]string{
+		"message_type": "text",
+		"channel":      "sms",
+		"to":           strings.TrimPrefix(strings.TrimSpace(phone), "+"),
+		"from":         strings.TrimPrefix(from, "+"),
+		"text":         content,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(cxt)
+	req.SetBasicAuth(apiKey, apiSecret)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		MessageUUID string `json:"message_uuid"`
+
+		// The error of RFC 7807, the type of which is the url of the error
+		// code, such as "https://developer.nexmo.com/api-errors/messages-olympus#1120".
+		Type   string `json:"type"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	} else if err = json.Unmarshal(body, &result); err != nil && resp.StatusCode < 300 {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var code string
+		if i := strings.LastIndexByte(result.Type, '#'); i >= 0 {
+			code = result.Type[i+1:]
+		}
+
+		message := result.Title
+		if result.Detail != "" {
+			message += ": " + result.Detail
+		}
+		return TranslateError("vonage", httpError(resp.StatusCode, resp.Header, code,
+			fmt.Sprintf("vonage: %d %s", resp.StatusCode, message)))
+	}
+
+	SetResult(cxt, ResultMessageID, result.MessageUUID)
+	return nil
+}
@@ -0,0 +1,172 @@
+package messageapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterMessenger("whatsapp", new(whatsApp))
+}
+
+// whatsAppUndeliverable is the error code when the message cannot be delivered,
+// such as the recipient is not on WhatsApp.
+const whatsAppUndeliverable = 131026
+
+// whatsApp is the messenger provider by the WhatsApp Business Cloud API,
+// the configuration options of which are as follows:
+//
+//	phone_number_id: the id of the business phone number, which is required.
+//	access_token:    the access token of the system user, which is required.
+//	api_version:     the version of the Graph API, which is "v17.0" by default.
+//	language:        the default language of the templates, which is "en_US"
+//	                 by default.
+//	timeout:         the timeout in seconds of the request, which is 30 by default.
+//	http_proxy:      the proxy url of the requests, which is optional.
+//	socks5:          the SOCKS5 proxy, such as "host:1080", which is optional.
+//	verify_token:    the token to verify the webhook of the delivery reports,
+//	                 which is not used by the provider but by the app.
+//
+// The recipient is the phone number in the international format. Only the
+// approved templates can be sent outside the 24-hour customer service window,
+// so the template of the message should be given for the notifications.
+type whatsApp struct {
+	sync.Mutex
+
+	url      string
+	token    string
+	language string
+	client   *http.Client
+}
+
+func (w *whatsApp) Load(m map[string]string) error {
+	phoneNumberID, token := m["phone_number_id"], m["access_token"]
+	if phoneNumberID == "" {
+		return fmt.Errorf("no the phone_number_id configuration")
+	} else if token == "" {
+		return fmt.Errorf("no the access_token configuration")
+	}
+
+	version := m["api_version"]
+	if version == "" {
+		version = "v17.0"
+	}
+	language := m["language"]
+	if language == "" {
+		language = "en_US"
+	}
+
+	client, err := NewHTTPClient(m)
+	if err != nil {
+		return err
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.url = fmt.Sprintf("https://graph.facebook.com/%s/%s/messages", version, phoneNumberID)
+	w.token = token
+	w.language = language
+	w.client = client
+	return nil
+}
+
+// ConfigSchema implements the interface ConfigSchema.
+func (w *whatsApp) ConfigSchema() []ConfigOption {
+	return []ConfigOption{
+		{Name: "phone_number_id", Type: OptionString, Required: true,
+			Description: "the id of the business phone number"},
+		{Name: "access_token", Type: OptionString, Required: true, Secret: true,
+			Description: "the access token of the system user"},
+		{Name: "api_version", Type: OptionString, Default: "v17.0",
+			Description: "the version of the Graph API"},
+		{Name: "language", Type: OptionString, Default: "en_US",
+			Description: "the default language of the templates"},
+		timeoutOption,
+		proxyOption,
+		socks5Option,
+		{Name: "verify_token", Type: OptionString, Secret: true,
+			Description: "the token to verify the webhook of the delivery reports"},
+	}
+}
+
+func (w *whatsApp) SendMessage(cxt context.Context, msg Message) error {
+	w.Lock()
+	url, token, language, client := w.url, w.token, w.language, w.client
+	w.Unlock()
+
+	payload := map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"recipient_type":    "individual",
+		"to":                strings.TrimPrefix(msg.To, "+"),
+	}
+
+	if t := msg.Template; t != nil {
+		lang := t.Language
+		if lang == "" {
+			lang = language
+		}
+
+		template := map[string]interface{}{
+			"name":     t.Name,
+			"language": map[string]string{"code": lang},
+		}
+		if len(t.Components) > 0 {
+			template["components"] = t.Components
+		} else if len(t.Params) > 0 {
+			params := make([]map[string]string, len(t.Params))
+			for i, p := range t.Params {
+				params[i] = map[string]string{"type": "text", "text": p}
+			}
+			template["components"] = []interface{}{
+				map[string]interface{}{"type": "body", "parameters": params},
+			}
+		}
+
+		payload["type"] = "template"
+		payload["template"] = template
+	} else {
+		content := msg.Content
+		if msg.Title != "" {
+			content = "*" + msg.Title + "*\n" + content
+		}
+		payload["type"] = "text"
+		payload["text"] = map[string]string{"body": content}
+	}
+
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	status, body, respHeader, err := doJSON(cxt, client, "POST", url, header, payload)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+		Error struct {
+			Message string `json:"message"`
+			Code    int    `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &result)
+
+	if status >= 300 {
+		e := httpError(status, respHeader, strconv.Itoa(result.Error.Code),
+			fmt.Sprintf("whatsapp: %d %s", status, result.Error.Message))
+		if result.Error.Code == whatsAppUndeliverable {
+			e.Err = ErrUnreachable
+		}
+		return e
+	}
+
+	if len(result.Messages) > 0 {
+		SetResult(cxt, ResultMessageID, result.Messages[0].ID)
+	}
+	return nil
+}